@@ -245,6 +245,17 @@ type Batch struct {
 	// memtable.
 	flushable *flushableBatch
 
+	// onPublish, if non-nil, is invoked by the commit pipeline once the batch
+	// has been published, before the commit returns. Transactions use it to
+	// release their locks as soon as their writes are visible.
+	onPublish func()
+
+	// validate, if non-nil, is invoked by the commit pipeline before the batch
+	// is sequenced, once the writes of every batch sequenced before it are
+	// visible. If it returns an error, the batch is not committed and the
+	// commit returns the error. Transactions use it to check for conflicts.
+	validate func() error
+
 	commit    sync.WaitGroup
 	commitErr error
 	applied   uint32 // updated atomically
@@ -969,6 +980,8 @@ func (b *Batch) Reset() {
 	b.tombstones = nil
 	b.rangeKeys = nil
 	b.flushable = nil
+	b.onPublish = nil
+	b.validate = nil
 	b.commit = sync.WaitGroup{}
 	b.commitErr = nil
 	atomic.StoreUint32(&b.applied, 0)
@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"unsafe"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/record"
 )
 
@@ -253,6 +254,9 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
 	mem, err := p.prepare(b, syncWAL)
 	if err != nil {
 		b.db = nil // prevent batch reuse on error
+		if errors.As(err, new(*batchRejectedError)) {
+			<-p.sem
+		}
 		return err
 	}
 
@@ -353,9 +357,6 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 	if syncWAL {
 		count++
 	}
-	// count represents the waiting needed for publish, and optionally the
-	// waiting needed for the WAL sync.
-	b.commit.Add(count)
 
 	var syncWG *sync.WaitGroup
 	var syncErr *error
@@ -365,6 +366,25 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 
 	p.mu.Lock()
 
+	if b.validate != nil {
+		// Wait for the batches sequenced before this one to become visible, so
+		// that the validation observes their writes. The spin loop mirrors the
+		// one in AllocateSeqNum. Batches sequenced after this one wait for the
+		// validation, as it runs with commitPipeline.mu held.
+		logSeqNum := atomic.LoadUint64(p.env.logSeqNum)
+		for atomic.LoadUint64(p.env.visibleSeqNum) != logSeqNum {
+			runtime.Gosched()
+		}
+		if err := b.validate(); err != nil {
+			p.mu.Unlock()
+			return nil, &batchRejectedError{err: err}
+		}
+	}
+
+	// count represents the waiting needed for publish, and optionally the
+	// waiting needed for the WAL sync.
+	b.commit.Add(count)
+
 	// Enqueue the batch in the pending queue. Note that while the pending queue
 	// is lock-free, we want the order of batches to be the same as the sequence
 	// number order.
@@ -423,10 +443,24 @@ func (p *commitPipeline) publish(b *Batch) {
 			}
 		}
 
+		if t.onPublish != nil {
+			t.onPublish()
+		}
 		t.commit.Done()
 	}
 }
 
+// batchRejectedError is returned by commitPipeline.Commit when a batch is
+// rejected by its Batch.validate function. The rejected batch was not
+// sequenced, and the commit pipeline remains usable.
+type batchRejectedError struct {
+	err error
+}
+
+func (e *batchRejectedError) Error() string { return e.err.Error() }
+
+func (e *batchRejectedError) Unwrap() error { return e.err }
+
 // ratchetSeqNum allocates and marks visible all sequence numbers less than
 // but excluding `nextSeqNum`.
 func (p *commitPipeline) ratchetSeqNum(nextSeqNum uint64) {
@@ -13,6 +13,7 @@ import (
 	"runtime/pprof"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -287,6 +288,8 @@ const (
 	compactionKindElisionOnly
 	compactionKindRead
 	compactionKindRewrite
+	compactionKindTombstoneDensity
+	compactionKindPeriodic
 )
 
 func (k compactionKind) String() string {
@@ -305,6 +308,10 @@ func (k compactionKind) String() string {
 		return "read"
 	case compactionKindRewrite:
 		return "rewrite"
+	case compactionKindTombstoneDensity:
+		return "tombstone-density"
+	case compactionKindPeriodic:
+		return "periodic"
 	}
 	return "?"
 }
@@ -312,6 +319,38 @@ func (k compactionKind) String() string {
 // compaction is a table compaction from one level to the next, starting from a
 // given version.
 type compaction struct {
+	// The atomic fields must be the first fields in the struct to guarantee
+	// 64-bit alignment on 32-bit platforms.
+	atomic struct {
+		// bytesIterated is the number of input bytes consumed by the
+		// compaction, summed over its subcompactions. It is updated as the
+		// input is consumed, and reported by DB.Compactions.
+		bytesIterated uint64
+		// cancelled is set to 1 by DB.CancelCompaction to request that the
+		// compaction stop at the next key.
+		cancelled uint32
+	}
+
+	// parent is the compaction that this compaction is a subcompaction of, or
+	// nil if this compaction is not a subcompaction. See newSubcompactions.
+	parent *compaction
+	// bounds are the user key bounds [lower, upper) of a subcompaction. A nil
+	// bound is unbounded.
+	bounds struct {
+		lower, upper []byte
+	}
+
+	// id identifies the compaction within DB.Compactions. It is assigned when
+	// the compaction is added to the set of in-progress compactions, unless it
+	// was inherited from a queued manual compaction.
+	id uint64
+	// manual is true if the compaction was requested through DB.Compact.
+	manual bool
+	// manualPriority is the priority of a manual compaction.
+	manualPriority CompactionPriority
+	// startTime is the time at which the compaction was scheduled.
+	startTime time.Time
+
 	kind      compactionKind
 	cmp       Compare
 	equal     Equal
@@ -349,7 +388,8 @@ type compaction struct {
 	// bytesWritten contains the number of bytes that have been written to outputs.
 	bytesWritten int64
 	// atomicBytesIterated points to the variable to increment during iteration.
-	// atomicBytesIterated must be read/written atomically. Flushing will increment
+	// atomicBytesIterated must be read/written atomically, and is incremented
+	// by deltas, as it is shared by the subcompactions of a compaction. Flushing will increment
 	// the shared variable which compaction will read. This allows for the
 	// compaction routine to know how many bytes have been flushed before the flush
 	// is applied.
@@ -438,6 +478,9 @@ func (c *compaction) makeInfo(jobID int) CompactionInfo {
 }
 
 func newCompaction(pc *pickedCompaction, opts *Options, bytesCompacted *uint64) *compaction {
+	if pc.kind == compactionKindDeleteOnly {
+		return newDeleteOnlyCompaction(opts, pc.version, pc.inputs)
+	}
 	c := &compaction{
 		kind:                    compactionKindDefault,
 		cmp:                     pc.cmp,
@@ -1132,6 +1175,14 @@ func (c *compaction) String() string {
 }
 
 type manualCompaction struct {
+	// id identifies the manual compaction to DB.CancelCompaction while it is
+	// queued. The compaction scheduled for it inherits the ID.
+	id uint64
+	// priority is the priority of the manual compaction relative to automatic
+	// compactions. See CompactOptions.Priority.
+	priority CompactionPriority
+	// queuedTime is the time at which the manual compaction was queued.
+	queuedTime time.Time
 	// Count of the retries either due to too many concurrent compactions, or a
 	// concurrent compaction to overlapping levels.
 	retries     int
@@ -1141,6 +1192,22 @@ type manualCompaction struct {
 	start       []byte
 	end         []byte
 	split       bool
+	// rewrite is true if the tables of level are rewritten in place, rather
+	// than compacted into the next level. See CompactOptions.RewriteBottommost.
+	rewrite bool
+	// compaction is the compaction scheduled for the manual compaction, once
+	// it has been scheduled. It is used to cancel the compaction if the
+	// context passed to DB.CompactWithOptions is done.
+	compaction *compaction
+}
+
+// outputLevelFor returns the level into which the manual compaction compacts
+// its tables, given the current base level.
+func (m *manualCompaction) outputLevelFor(baseLevel int) int {
+	if m.rewrite {
+		return m.level
+	}
+	return defaultOutputLevel(m.level, baseLevel)
 }
 
 type readCompaction struct {
@@ -1156,6 +1223,11 @@ type readCompaction struct {
 }
 
 func (d *DB) addInProgressCompaction(c *compaction) {
+	if c.id == 0 {
+		d.mu.compact.nextID++
+		c.id = d.mu.compact.nextID
+	}
+	c.startTime = d.timeNow()
 	d.mu.compact.inProgress[c] = struct{}{}
 	var isBase, isIntraL0 bool
 	for _, cl := range c.inputs {
@@ -1507,6 +1579,10 @@ func (d *DB) flush1() error {
 			err = d.applyFlushedRangeKeys(flushed)
 		})
 		d.updateTableStatsLocked(ve.NewFiles)
+		// The memtables are scanned without holding DB.mu.
+		d.mu.Unlock()
+		d.maybeTransitionFileOnlySnapshots()
+		d.mu.Lock()
 	}
 	// Signal FlushEnd after installing the new readState. This helps for unit
 	// tests that use the callback to trigger a read using an iterator with
@@ -1553,6 +1629,32 @@ func (d *DB) maybeScheduleCompaction() {
 	d.maybeScheduleCompactionPicker(pickAuto)
 }
 
+// periodicCompactionLoop periodically schedules compactions while
+// Options.Experimental.PeriodicCompactionAge is enabled. Compactions are
+// otherwise only scheduled in response to writes, so the tables of a DB which
+// receives no writes would never age out.
+func (d *DB) periodicCompactionLoop() {
+	interval := d.opts.Experimental.PeriodicCompactionAge / 10
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Hour {
+		interval = time.Hour
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+		d.mu.Lock()
+		d.maybeScheduleCompaction()
+		d.mu.Unlock()
+	}
+}
+
 func pickAuto(picker compactionPicker, env compactionEnv) *pickedCompaction {
 	return picker.pickAuto(env)
 }
@@ -1571,7 +1673,8 @@ func (d *DB) maybeScheduleCompactionPicker(
 	if d.closed.Load() != nil || d.opts.ReadOnly {
 		return
 	}
-	if d.mu.compact.compactingCount >= d.opts.MaxConcurrentCompactions {
+	d.maybeUpdateCompactionControllerLocked()
+	if d.mu.compact.compactingCount >= d.maxConcurrentCompactions() {
 		if len(d.mu.compact.manual) > 0 {
 			// Inability to run head blocks later manual compactions.
 			d.mu.compact.manual[0].retries++
@@ -1596,16 +1699,24 @@ func (d *DB) maybeScheduleCompactionPicker(
 		bytesCompacted:          &d.atomic.bytesCompacted,
 		earliestSnapshotSeqNum:  d.mu.snapshots.earliest(),
 		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
+		now:                     d.timeNow(),
+	}
+	// Versions newer than the retention horizon are retained as if a snapshot
+	// were open at the horizon.
+	retainSeqNum := d.versionRetentionHorizonLocked()
+	if retainSeqNum != 0 && retainSeqNum < env.earliestSnapshotSeqNum {
+		env.earliestSnapshotSeqNum = retainSeqNum
 	}
 
 	// Check for delete-only compactions first, because they're expected to be
 	// cheap and reduce future compaction work.
 	if len(d.mu.compact.deletionHints) > 0 &&
-		d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions &&
-		!d.opts.DisableAutomaticCompactions {
+		d.mu.compact.compactingCount < d.maxConcurrentCompactions() &&
+		d.automaticCompactionsEnabledLocked() {
 		v := d.mu.versions.currentVersion()
 		snapshots := d.mu.snapshots.toSlice()
-		inputs, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, d.mu.compact.deletionHints, snapshots)
+		inputs, unresolvedHints := checkDeleteCompactionHints(
+			d.cmp, v, d.mu.compact.deletionHints, snapshots, retainSeqNum)
 		d.mu.compact.deletionHints = unresolvedHints
 
 		if len(inputs) > 0 {
@@ -1616,19 +1727,39 @@ func (d *DB) maybeScheduleCompactionPicker(
 		}
 	}
 
-	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
-		manual := d.mu.compact.manual[0]
+	// Manual compactions are scheduled ahead of automatic compactions, and
+	// after them if their priority is CompactionPriorityLow.
+	d.scheduleManualCompactions(&env, CompactionPriorityHigh)
+	d.scheduleAutoCompactions(&env, pickFunc)
+	d.scheduleManualCompactions(&env, CompactionPriorityLow)
+}
+
+// scheduleManualCompactions schedules queued manual compactions of the
+// provided priority, in queue order, up to the compaction concurrency limit.
+//
+// d.mu must be held when calling this.
+func (d *DB) scheduleManualCompactions(env *compactionEnv, priority CompactionPriority) {
+	for d.mu.compact.compactingCount < d.maxConcurrentCompactions() {
+		i := d.nextManualCompactionLocked(priority)
+		if i < 0 {
+			break
+		}
+		manual := d.mu.compact.manual[i]
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
-		pc, retryLater := d.mu.versions.picker.pickManual(env, manual)
+		pc, retryLater := d.mu.versions.picker.pickManual(*env, manual)
 		if pc != nil {
 			c := newCompaction(pc, d.opts, env.bytesCompacted)
-			d.mu.compact.manual = d.mu.compact.manual[1:]
+			c.id = manual.id
+			c.manual = true
+			c.manualPriority = manual.priority
+			manual.compaction = c
+			d.removeManualCompactionLocked(i)
 			d.mu.compact.compactingCount++
 			d.addInProgressCompaction(c)
 			go d.compact(c, manual.done)
 		} else if !retryLater {
 			// Noop
-			d.mu.compact.manual = d.mu.compact.manual[1:]
+			d.removeManualCompactionLocked(i)
 			manual.done <- nil
 		} else {
 			// Inability to run head blocks later manual compactions.
@@ -1636,15 +1767,70 @@ func (d *DB) maybeScheduleCompactionPicker(
 			break
 		}
 	}
+}
+
+// automaticCompactionsEnabledLocked returns true unless automatic compactions
+// are disabled by Options.DisableAutomaticCompactions, or held off by an
+// exclusive manual compaction. See CompactOptions.Exclusive.
+//
+// d.mu must be held when calling this.
+func (d *DB) automaticCompactionsEnabledLocked() bool {
+	return !d.opts.DisableAutomaticCompactions && d.mu.compact.exclusiveManual == 0
+}
+
+// queueManualCompactionsLocked appends the provided manual compactions to the
+// queue of manual compactions, assigning each an ID.
+//
+// d.mu must be held when calling this.
+func (d *DB) queueManualCompactionsLocked(compactions ...*manualCompaction) {
+	now := d.timeNow()
+	for _, m := range compactions {
+		d.mu.compact.nextID++
+		m.id = d.mu.compact.nextID
+		m.queuedTime = now
+		d.mu.compact.manual = append(d.mu.compact.manual, m)
+	}
+}
+
+// nextManualCompactionLocked returns the index of the first queued manual
+// compaction of the provided priority, or -1 if there is none.
+//
+// d.mu must be held when calling this.
+func (d *DB) nextManualCompactionLocked(priority CompactionPriority) int {
+	for i, m := range d.mu.compact.manual {
+		if m.priority == priority {
+			return i
+		}
+	}
+	return -1
+}
 
-	for !d.opts.DisableAutomaticCompactions && d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
+// removeManualCompactionLocked removes the i-th manual compaction from the
+// queue of manual compactions.
+//
+// d.mu must be held when calling this.
+func (d *DB) removeManualCompactionLocked(i int) {
+	queue := d.mu.compact.manual
+	copy(queue[i:], queue[i+1:])
+	queue[len(queue)-1] = nil
+	d.mu.compact.manual = queue[:len(queue)-1]
+}
+
+// scheduleAutoCompactions schedules automatic compactions picked by
+// `pickFunc`, up to the compaction concurrency limit.
+//
+// d.mu must be held when calling this.
+func (d *DB) scheduleAutoCompactions(
+	env *compactionEnv, pickFunc func(compactionPicker, compactionEnv) *pickedCompaction,
+) {
+	for d.automaticCompactionsEnabledLocked() && d.mu.compact.compactingCount < d.maxConcurrentCompactions() {
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		env.readCompactionEnv = readCompactionEnv{
 			readCompactions:          &d.mu.compact.readCompactions,
 			flushing:                 d.mu.compact.flushing || d.passedFlushThreshold(),
 			rescheduleReadCompaction: &d.mu.compact.rescheduleReadCompaction,
 		}
-		pc := pickFunc(d.mu.versions.picker, env)
+		pc := pickFunc(d.mu.versions.picker, *env)
 		if pc == nil {
 			break
 		}
@@ -1766,7 +1952,7 @@ func (d *DB) maybeUpdateDeleteCompactionHints(c *compaction) {
 }
 
 func checkDeleteCompactionHints(
-	cmp Compare, v *version, hints []deleteCompactionHint, snapshots []uint64,
+	cmp Compare, v *version, hints []deleteCompactionHint, snapshots []uint64, retainSeqNum uint64,
 ) ([]compactionLevel, []deleteCompactionHint) {
 	var files map[*fileMetadata]bool
 	var byLevel [numLevels][]*fileMetadata
@@ -1820,6 +2006,12 @@ func checkDeleteCompactionHints(
 			unresolvedHints = append(unresolvedHints, h)
 			continue
 		}
+		if retainSeqNum != 0 && h.tombstoneLargestSeqNum >= retainSeqNum {
+			// The keys beneath the tombstones remain readable at the sequence
+			// numbers retained by Options.Experimental.VersionRetention.
+			unresolvedHints = append(unresolvedHints, h)
+			continue
+		}
 
 		// The hint h will be resolved and dropped, regardless of whether
 		// there are any tables that can be deleted.
@@ -1860,7 +2052,7 @@ func (d *DB) compact(c *compaction, errChannel chan error) {
 	pprof.Do(context.Background(), compactLabels, func(context.Context) {
 		d.mu.Lock()
 		defer d.mu.Unlock()
-		if err := d.compact1(c, errChannel); err != nil {
+		if err := d.compact1(c, errChannel); err != nil && !errors.Is(err, ErrCancelledCompaction) {
 			// TODO(peter): count consecutive compaction errors and backoff.
 			d.opts.EventListener.BackgroundError(err)
 		}
@@ -1890,7 +2082,9 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	startTime := d.timeNow()
 
 	compactionPacer := (pacer)(nilPacer)
-	if d.opts.private.enablePacing {
+	if d.compactionController != nil {
+		compactionPacer = newAdaptiveCompactionPacer(d.compactionController, d.maybeUpdateCompactionController)
+	} else if d.opts.private.enablePacing {
 		// TODO(peter): Compaction pacing is disabled until we figure out why it
 		// impacts throughput.
 		compactionPacer = newCompactionPacer(compactionPacerEnv{
@@ -2024,6 +2218,7 @@ func (d *DB) runCompaction(
 	}()
 
 	snapshots := d.mu.snapshots.toSlice()
+	retainSeqNum := d.versionRetentionHorizonLocked()
 	formatVers := d.mu.formatVers.vers
 	// The table is written at the maximum allowable format implied by the current
 	// format major version of the DB.
@@ -2034,13 +2229,164 @@ func (d *DB) runCompaction(
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
-	iiter, err := c.newInputIter(d.newIters)
+	ve = &versionEdit{
+		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
+	}
+	// If the compaction fails after its outputs have been written, remove
+	// them. A failed subcompaction has already removed its own outputs.
+	defer func() {
+		if retErr != nil {
+			for _, meta := range pendingOutputs {
+				d.opts.FS.Remove(base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, meta.FileNum))
+			}
+		}
+	}()
+
+	outputMetrics := &LevelMetrics{
+		BytesIn:   c.startLevel.files.SizeSum(),
+		BytesRead: c.outputLevel.files.SizeSum(),
+	}
+	outputMetrics.BytesRead += outputMetrics.BytesIn
+	c.metrics = map[int]*LevelMetrics{
+		c.outputLevel.level: outputMetrics,
+	}
+	if len(c.flushing) == 0 && c.metrics[c.startLevel.level] == nil {
+		c.metrics[c.startLevel.level] = &LevelMetrics{}
+	}
+
+	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
+	if formatVers < FormatBlockPropertyCollector {
+		// Cannot yet write block properties.
+		writerOpts.BlockPropertyCollectors = nil
+	}
+	if d.compressionPool != nil {
+		// Compress data blocks using the DB's compression pool, concurrently
+		// with the compaction loop.
+		writerOpts.Parallelism = true
+		writerOpts.CompressionPool = d.compressionPool
+	}
+
+	c.allowedZeroSeqNum = c.allowZeroSeqNum()
+
+	// Partition the compaction into subcompactions over disjoint key ranges,
+	// if permitted. A pacer tracks the progress of a single goroutine, so each
+	// subcompaction is paced by its own pacer, sharing the rate limiter of
+	// the compaction's pacer.
+	subs := []*compaction{c}
+	if splitKeys := c.subcompactionSplitKeys(d.opts.Experimental.MaxSubcompactions); len(splitKeys) > 0 {
+		subs = c.newSubcompactions(splitKeys)
+	}
+	results := make([]subcompactionResult, len(subs))
+	if len(subs) == 1 {
+		r := &results[0]
+		r.newFiles, r.pendingOutputs, r.metrics, r.err = d.runSubcompaction(jobID, c, pacer, snapshots, retainSeqNum, writerOpts)
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(len(subs))
+		for i := range subs {
+			subPacer := newSubcompactionPacer(pacer)
+			go func(i int) {
+				defer wg.Done()
+				r := &results[i]
+				r.newFiles, r.pendingOutputs, r.metrics, r.err = d.runSubcompaction(jobID, subs[i], subPacer, snapshots, retainSeqNum, writerOpts)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	var err error
+	for i := range results {
+		r := &results[i]
+		pendingOutputs = append(pendingOutputs, r.pendingOutputs...)
+		if r.err != nil {
+			err = firstError(err, r.err)
+			continue
+		}
+		ve.NewFiles = append(ve.NewFiles, r.newFiles...)
+		outputMetrics.Add(r.metrics)
+		if subs[i] != c {
+			c.bytesIterated += subs[i].bytesIterated
+			c.bytesWritten += subs[i].bytesWritten
+		}
+	}
 	if err != nil {
 		return nil, pendingOutputs, err
 	}
-	c.allowedZeroSeqNum = c.allowZeroSeqNum()
+	if len(subs) > 1 {
+		// Verify that no user key was split across the outputs of adjacent
+		// subcompactions.
+		for i := 1; i < len(ve.NewFiles); i++ {
+			if err := c.errorOnUserKeyOverlap(&versionEdit{NewFiles: ve.NewFiles[i-1 : i+1]}); err != nil {
+				return nil, pendingOutputs, err
+			}
+		}
+	}
+
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			c.metrics[cl.level].NumFiles--
+			c.metrics[cl.level].Size -= int64(f.Size)
+			ve.DeletedFiles[deletedFileEntry{
+				Level:   cl.level,
+				FileNum: f.FileNum,
+			}] = f
+		}
+	}
+
+	if err := d.dataDir.Sync(); err != nil {
+		return nil, pendingOutputs, err
+	}
+
+	// Refresh the disk available statistic whenever a compaction/flush
+	// completes, before re-acquiring the mutex.
+	_ = d.calculateDiskAvailableBytes()
+
+	return ve, pendingOutputs, nil
+}
+
+// subcompactionResult holds the outputs of a single subcompaction. See
+// runSubcompaction.
+type subcompactionResult struct {
+	newFiles       []newFileEntry
+	pendingOutputs []*fileMetadata
+	metrics        *LevelMetrics
+	err            error
+}
+
+// runSubcompaction runs the compaction loop of c, which is either an entire
+// compaction or one of its subcompactions, writing the compacted keys to new
+// tables.
+//
+// d.mu must not be held when calling this.
+func (d *DB) runSubcompaction(
+	jobID int,
+	c *compaction,
+	pacer pacer,
+	snapshots []uint64,
+	retainSeqNum uint64,
+	writerOpts sstable.WriterOptions,
+) (newFiles []newFileEntry, pendingOutputs []*fileMetadata, outputMetrics *LevelMetrics, retErr error) {
+	outputMetrics = &LevelMetrics{}
+	// The new tables are accumulated in a versionEdit of their own, so that
+	// the outputs of concurrent subcompactions remain separate until they're
+	// combined by runCompaction.
+	ve := &versionEdit{}
+
+	iiter, err := c.newInputIter(d.newIters)
+	if err != nil {
+		return nil, pendingOutputs, nil, err
+	}
+	if c.parent != nil {
+		iiter = &subcompactionIter{
+			internalIterator: iiter,
+			cmp:              c.cmp,
+			lower:            c.bounds.lower,
+			upper:            c.bounds.upper,
+		}
+	}
 	iter := newCompactionIter(c.cmp, c.equal, c.formatKey, d.merge, iiter, snapshots,
-		&c.rangeDelFrag, c.allowedZeroSeqNum, c.elideTombstone,
+		retainSeqNum, &c.rangeDelFrag, c.allowedZeroSeqNum, c.elideTombstone,
 		c.elideRangeTombstone, d.FormatMajorVersion())
 
 	var (
@@ -2064,28 +2410,6 @@ func (d *DB) runCompaction(
 		}
 	}()
 
-	ve = &versionEdit{
-		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
-	}
-
-	outputMetrics := &LevelMetrics{
-		BytesIn:   c.startLevel.files.SizeSum(),
-		BytesRead: c.outputLevel.files.SizeSum(),
-	}
-	outputMetrics.BytesRead += outputMetrics.BytesIn
-	c.metrics = map[int]*LevelMetrics{
-		c.outputLevel.level: outputMetrics,
-	}
-	if len(c.flushing) == 0 && c.metrics[c.startLevel.level] == nil {
-		c.metrics[c.startLevel.level] = &LevelMetrics{}
-	}
-
-	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
-	if formatVers < FormatBlockPropertyCollector {
-		// Cannot yet write block properties.
-		writerOpts.BlockPropertyCollectors = nil
-	}
-
 	// prevPointKey is a sstable.WriterOption that provides access to
 	// the last point key written to a writer's sstable. When a new
 	// output begins in newOutput, prevPointKey is updated to point to
@@ -2119,9 +2443,11 @@ func (d *DB) runCompaction(
 			Path:    filename,
 			FileNum: fileNum,
 		})
-		file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
-			BytesPerSync: d.opts.BytesPerSync,
-		})
+		syncOpts := vfs.SyncingFileOptions{BytesPerSync: d.opts.BytesPerSync}
+		if d.compactionController != nil {
+			syncOpts.OnSync = d.compactionController.recordSync
+		}
+		file = vfs.NewSyncingFile(file, syncOpts)
 		file = &compactionFile{
 			File:     file,
 			versions: d.mu.versions,
@@ -2132,7 +2458,7 @@ func (d *DB) runCompaction(
 		internalTableOpt := private.SSTableInternalTableOpt.(sstable.WriterOption)
 		tw = sstable.NewWriter(file, writerOpts, cacheOpts, internalTableOpt, &prevPointKey)
 
-		fileMeta.CreationTime = time.Now().Unix()
+		fileMeta.CreationTime = d.timeNow().Unix()
 		ve.NewFiles = append(ve.NewFiles, newFileEntry{
 			Level: c.outputLevel.level,
 			Meta:  fileMeta,
@@ -2372,6 +2698,12 @@ func (d *DB) runCompaction(
 	// to a grandparent file largest key, or nil. Taken together, these
 	// progress guarantees ensure that eventually the input iterator will be
 	// exhausted and the range tombstone fragments will all be flushed.
+	//
+	// The bytes iterated are reported to the root compaction, and to
+	// c.atomicBytesIterated, as deltas whenever they change, since the
+	// subcompactions of a compaction run concurrently and report to the same
+	// counters.
+	var reportedBytesIterated uint64
 	for key, val := iter.First(); key != nil || !c.rangeDelFrag.Empty(); {
 		splitterSuggestion := splitter.onNewOutput(key)
 
@@ -2381,10 +2713,16 @@ func (d *DB) runCompaction(
 				break
 			}
 
-			atomic.StoreUint64(c.atomicBytesIterated, c.bytesIterated)
+			if c.bytesIterated != reportedBytesIterated {
+				c.reportBytesIterated(d, c.bytesIterated-reportedBytesIterated)
+				reportedBytesIterated = c.bytesIterated
+			}
+			if atomic.LoadUint32(&c.root().atomic.cancelled) != 0 {
+				return nil, pendingOutputs, nil, ErrCancelledCompaction
+			}
 			if !isNilPacer {
 				if err := pacer.maybeThrottle(c.bytesIterated); err != nil {
-					return nil, pendingOutputs, err
+					return nil, pendingOutputs, nil, err
 				}
 			}
 			if key.Kind() == InternalKeyKindRangeDelete {
@@ -2406,17 +2744,17 @@ func (d *DB) runCompaction(
 					// keys and values point directly into the range deletion
 					// block which does NOT use prefix compression. This
 					// provides key stability.
-					c.rangeDelFrag.Add(s.ShallowClone())
+					c.rangeDelFrag.Add(c.truncateToBounds(s.ShallowClone()))
 				}
 				continue
 			}
 			if tw == nil {
 				if err := newOutput(); err != nil {
-					return nil, pendingOutputs, err
+					return nil, pendingOutputs, nil, err
 				}
 			}
 			if err := tw.Add(*key, val); err != nil {
-				return nil, pendingOutputs, err
+				return nil, pendingOutputs, nil, err
 			}
 		}
 
@@ -2444,31 +2782,11 @@ func (d *DB) runCompaction(
 			splitKey = key.UserKey
 		}
 		if err := finishOutput(splitKey); err != nil {
-			return nil, pendingOutputs, err
+			return nil, pendingOutputs, nil, err
 		}
 	}
-
-	for _, cl := range c.inputs {
-		iter := cl.files.Iter()
-		for f := iter.First(); f != nil; f = iter.Next() {
-			c.metrics[cl.level].NumFiles--
-			c.metrics[cl.level].Size -= int64(f.Size)
-			ve.DeletedFiles[deletedFileEntry{
-				Level:   cl.level,
-				FileNum: f.FileNum,
-			}] = f
-		}
-	}
-
-	if err := d.dataDir.Sync(); err != nil {
-		return nil, pendingOutputs, err
-	}
-
-	// Refresh the disk available statistic whenever a compaction/flush
-	// completes, before re-acquiring the mutex.
-	_ = d.calculateDiskAvailableBytes()
-
-	return ve, pendingOutputs, nil
+	c.reportBytesIterated(d, c.bytesIterated-reportedBytesIterated)
+	return ve.NewFiles, pendingOutputs, outputMetrics, nil
 }
 
 // validateVersionEdit validates that start and end keys across new and deleted
@@ -2673,6 +2991,7 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	defer func() {
 		for _, tbl := range obsoleteTables {
 			delete(d.mu.versions.zombieTables, tbl.fileNum)
+			delete(d.mu.scrub.corrupt, tbl.fileNum)
 		}
 	}()
 
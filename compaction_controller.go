@@ -0,0 +1,252 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// compactionControllerInterval is the interval at which the compaction
+// controller samples its inputs and revises its decisions.
+const compactionControllerInterval = time.Second
+
+// compactionControllerInputs are the signals sampled by the compaction
+// controller. The cumulative counters are differenced between consecutive
+// samples to compute rates.
+type compactionControllerInputs struct {
+	now time.Time
+	// diskWrites are the cumulative statistics of the disk writes performed
+	// through the DB's FS. They are only valid if diskWritesOK is set. Only
+	// the latency of writes is considered, not that of syncs, which is
+	// dominated by the frequent syncs of the WAL rather than by the writes of
+	// compactions.
+	diskWrites   vfs.DiskWriteStats
+	diskWritesOK bool
+	// tableSyncs and tableSyncLatency are the cumulative number and latency
+	// of the syncs of tables written by flushes and compactions.
+	tableSyncs       uint64
+	tableSyncLatency time.Duration
+	// bytesIn is the cumulative number of bytes written by batches to the WAL.
+	bytesIn uint64
+	// compactionBytes is the cumulative number of input bytes iterated by
+	// compactions.
+	compactionBytes uint64
+	// compactionDebt is the estimated compaction debt.
+	compactionDebt uint64
+	// l0ReadAmp is the number of L0 sublevels.
+	l0ReadAmp int
+}
+
+// compactionController adjusts the compaction concurrency limit and the
+// compaction byte rate limit in response to disk write and sync latency, the
+// rate of foreground writes and the compaction debt. See
+// Options.Experimental.AdaptiveCompactions.
+//
+// The controller backs off multiplicatively when the disk is congested and
+// recovers gradually, similar to TCP congestion control. Compactions are
+// never throttled below the foreground write rate, since doing so would let
+// the compaction debt grow without bound, and are never throttled at all once
+// the compaction debt threatens write stalls.
+type compactionController struct {
+	opts    *Options
+	limiter *rate.Limiter
+
+	atomic struct {
+		// rateLimit is the compaction byte rate limit in bytes per second, or
+		// zero if compactions are not rate limited. It is read by compaction
+		// pacers without DB.mu held.
+		rateLimit uint64
+		// syncCount and syncNanos are the cumulative number and latency of the
+		// syncs of tables written by flushes and compactions. They are
+		// updated by the writers of the tables without DB.mu held.
+		syncCount uint64
+		syncNanos int64
+	}
+
+	// The fields below are protected by DB.mu.
+
+	// concurrency is the maximum number of concurrent compactions.
+	concurrency int
+	// diskWriteLatency is the average latency of disk writes during the last
+	// interval.
+	diskWriteLatency time.Duration
+	// diskSyncLatency is the average latency of the syncs of tables during
+	// the last interval.
+	diskSyncLatency time.Duration
+	// writeRate is the rate of foreground writes during the last interval, in
+	// bytes per second.
+	writeRate uint64
+	// prev holds the inputs of the last update.
+	prev compactionControllerInputs
+}
+
+func newCompactionController(opts *Options) *compactionController {
+	burst := opts.private.minCompactionRate
+	return &compactionController{
+		opts:        opts,
+		limiter:     rate.NewLimiter(rate.Limit(burst), burst),
+		concurrency: opts.MaxConcurrentCompactions,
+	}
+}
+
+// rateLimit returns the current compaction byte rate limit in bytes per
+// second, or zero if compactions are not rate limited.
+func (c *compactionController) rateLimit() uint64 {
+	return atomic.LoadUint64(&c.atomic.rateLimit)
+}
+
+func (c *compactionController) setRateLimit(limit uint64) {
+	if limit != 0 {
+		c.limiter.SetLimit(rate.Limit(limit))
+	}
+	atomic.StoreUint64(&c.atomic.rateLimit, limit)
+}
+
+// recordSync records the latency of a sync of a table written by a flush or
+// compaction.
+func (c *compactionController) recordSync(latency time.Duration) {
+	atomic.AddUint64(&c.atomic.syncCount, 1)
+	atomic.AddInt64(&c.atomic.syncNanos, int64(latency))
+}
+
+// due returns true if an update is due at the provided time.
+func (c *compactionController) due(now time.Time) bool {
+	return c.prev.now.IsZero() || now.Sub(c.prev.now) >= compactionControllerInterval
+}
+
+// update revises the controller's decisions given a new sample of its
+// inputs. The first sample only establishes a baseline.
+func (c *compactionController) update(in compactionControllerInputs) {
+	prev := c.prev
+	c.prev = in
+	if prev.now.IsZero() {
+		return
+	}
+	secs := in.now.Sub(prev.now).Seconds()
+	if secs <= 0 {
+		return
+	}
+	c.writeRate = uint64(float64(in.bytesIn-prev.bytesIn) / secs)
+	compactionRate := uint64(float64(in.compactionBytes-prev.compactionBytes) / secs)
+	c.diskWriteLatency = 0
+	if in.diskWritesOK && prev.diskWritesOK && in.diskWrites.Count > prev.diskWrites.Count {
+		c.diskWriteLatency = (in.diskWrites.TotalLatency - prev.diskWrites.TotalLatency) /
+			time.Duration(in.diskWrites.Count-prev.diskWrites.Count)
+	}
+	c.diskSyncLatency = 0
+	if in.tableSyncs > prev.tableSyncs {
+		c.diskSyncLatency = (in.tableSyncLatency - prev.tableSyncLatency) /
+			time.Duration(in.tableSyncs-prev.tableSyncs)
+	}
+
+	rateLimit := c.rateLimit()
+	switch {
+	case c.urgent(in):
+		// Write stalls hurt foreground traffic more than slow disk writes, so
+		// compactions run without limits until the debt is under control.
+		c.concurrency = c.opts.MaxConcurrentCompactions
+		rateLimit = 0
+
+	case c.diskWriteLatency > c.opts.Experimental.TargetDiskWriteLatency ||
+		c.diskSyncLatency > c.opts.Experimental.TargetDiskSyncLatency:
+		// The disk is congested. Shed a compaction and halve the byte rate of
+		// compactions, starting from the rate observed during the interval if
+		// compactions were not already limited to a lower rate.
+		if c.concurrency > 1 {
+			c.concurrency--
+		}
+		if rateLimit == 0 || compactionRate < rateLimit {
+			rateLimit = compactionRate
+		}
+		rateLimit /= 2
+		if min := c.minRateLimit(); rateLimit < min {
+			rateLimit = min
+		}
+
+	default:
+		// The disk is keeping up. Admit another compaction, and double the
+		// byte rate limit. Once compactions no longer consume half of the
+		// limit, it is lifted.
+		if c.concurrency < c.opts.MaxConcurrentCompactions {
+			c.concurrency++
+		}
+		if rateLimit != 0 {
+			if compactionRate < rateLimit/2 {
+				rateLimit = 0
+			} else {
+				rateLimit *= 2
+			}
+		}
+	}
+	c.setRateLimit(rateLimit)
+}
+
+// urgent returns true if the compaction debt is high enough that compactions
+// must not be limited: L0 has reached half of the L0StopWritesThreshold, or
+// the compaction debt warrants every concurrent compaction slot (see
+// Options.Experimental.CompactionDebtConcurrency).
+func (c *compactionController) urgent(in compactionControllerInputs) bool {
+	return in.l0ReadAmp*2 >= c.opts.L0StopWritesThreshold ||
+		in.compactionDebt >= uint64(c.opts.Experimental.CompactionDebtConcurrency)*
+			uint64(c.opts.MaxConcurrentCompactions)
+}
+
+// minRateLimit returns the lowest byte rate limit the controller will impose:
+// the higher of the rate of foreground writes and the minimum compaction rate.
+func (c *compactionController) minRateLimit() uint64 {
+	min := uint64(c.opts.private.minCompactionRate)
+	if c.writeRate > min {
+		min = c.writeRate
+	}
+	return min
+}
+
+// maxConcurrentCompactions returns the maximum number of concurrent
+// compactions, which is chosen by the compaction controller if
+// Options.Experimental.AdaptiveCompactions is enabled.
+//
+// d.mu must be held when calling this.
+func (d *DB) maxConcurrentCompactions() int {
+	if d.compactionController != nil {
+		return d.compactionController.concurrency
+	}
+	return d.opts.MaxConcurrentCompactions
+}
+
+// maybeUpdateCompactionControllerLocked samples the inputs of the compaction
+// controller and updates its decisions if an update is due.
+//
+// d.mu must be held when calling this.
+func (d *DB) maybeUpdateCompactionControllerLocked() {
+	c := d.compactionController
+	if c == nil {
+		return
+	}
+	now := d.timeNow()
+	if !c.due(now) {
+		return
+	}
+	in := compactionControllerInputs{
+		now:             now,
+		bytesIn:         d.mu.log.bytesIn,
+		compactionBytes: atomic.LoadUint64(&d.atomic.compactionBytesIterated),
+		compactionDebt:  d.mu.versions.picker.estimatedCompactionDebt(0),
+		l0ReadAmp:       d.mu.versions.currentVersion().L0Sublevels.ReadAmplification(),
+		tableSyncs:      atomic.LoadUint64(&c.atomic.syncCount),
+	}
+	in.tableSyncLatency = time.Duration(atomic.LoadInt64(&c.atomic.syncNanos))
+	in.diskWrites, in.diskWritesOK = vfs.GetDiskWriteStats(d.opts.FS)
+	c.update(in)
+}
+
+func (d *DB) maybeUpdateCompactionController() {
+	d.mu.Lock()
+	d.maybeUpdateCompactionControllerLocked()
+	d.mu.Unlock()
+}
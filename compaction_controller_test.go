@@ -0,0 +1,197 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionController(t *testing.T) {
+	var c *compactionController
+	var in compactionControllerInputs
+	datadriven.RunTest(t, "testdata/compaction_controller",
+		func(td *datadriven.TestData) string {
+			switch td.Cmd {
+			case "init":
+				opts := &Options{MaxConcurrentCompactions: 4}
+				for _, arg := range td.CmdArgs {
+					switch arg.Key {
+					case "max-concurrency":
+						opts.MaxConcurrentCompactions, _ = strconv.Atoi(arg.Vals[0])
+					case "target-latency":
+						opts.Experimental.TargetDiskWriteLatency, _ = time.ParseDuration(arg.Vals[0])
+					case "target-sync-latency":
+						opts.Experimental.TargetDiskSyncLatency, _ = time.ParseDuration(arg.Vals[0])
+					default:
+						return fmt.Sprintf("unknown arg: %s", arg.Key)
+					}
+				}
+				opts.EnsureDefaults()
+				c = newCompactionController(opts)
+				in = compactionControllerInputs{now: time.Unix(0, 0), diskWritesOK: true}
+				c.update(in)
+				return fmt.Sprintf("concurrency=%d rate-limit=%d\n", c.concurrency, c.rateLimit())
+
+			case "sample":
+				// The arguments of a sample are the changes in the cumulative
+				// inputs over one second, and the current compaction debt and L0
+				// read amplification.
+				in.now = in.now.Add(time.Second)
+				in.compactionDebt = 0
+				in.l0ReadAmp = 0
+				var latency, syncLatency time.Duration
+				var syncOps uint64
+				for _, arg := range td.CmdArgs {
+					v := arg.Vals[0]
+					switch arg.Key {
+					case "bytes-in":
+						n, _ := strconv.ParseUint(v, 10, 64)
+						in.bytesIn += n
+					case "compaction-bytes":
+						n, _ := strconv.ParseUint(v, 10, 64)
+						in.compactionBytes += n
+					case "disk-ops":
+						n, _ := strconv.ParseUint(v, 10, 64)
+						in.diskWrites.Count += n
+					case "disk-latency":
+						latency, _ = time.ParseDuration(v)
+					case "sync-ops":
+						syncOps, _ = strconv.ParseUint(v, 10, 64)
+					case "sync-latency":
+						syncLatency, _ = time.ParseDuration(v)
+					case "debt":
+						in.compactionDebt, _ = strconv.ParseUint(v, 10, 64)
+					case "l0":
+						in.l0ReadAmp, _ = strconv.Atoi(v)
+					default:
+						return fmt.Sprintf("unknown arg: %s", arg.Key)
+					}
+				}
+				// disk-latency is the average latency of the sample's disk ops.
+				in.diskWrites.TotalLatency += latency * time.Duration(in.diskWrites.Count-c.prev.diskWrites.Count)
+				// sync-latency is the average latency of the sample's syncs of
+				// tables.
+				in.tableSyncs += syncOps
+				in.tableSyncLatency += syncLatency * time.Duration(syncOps)
+				c.update(in)
+				return fmt.Sprintf("concurrency=%d rate-limit=%d latency=%s sync-latency=%s write-rate=%d\n",
+					c.concurrency, c.rateLimit(), c.diskWriteLatency, c.diskSyncLatency, c.writeRate)
+
+			default:
+				return fmt.Sprintf("unknown command: %s", td.Cmd)
+			}
+		})
+}
+
+func TestAdaptiveCompactionsMetrics(t *testing.T) {
+	fs := vfs.WithDiskHealthChecks(vfs.NewMem(), time.Second, func(string, time.Duration) {})
+	opts := &Options{
+		FS:                       fs,
+		MaxConcurrentCompactions: 3,
+	}
+	opts.Experimental.AdaptiveCompactions = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	now := time.Now()
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return now }
+	d.mu.Unlock()
+
+	// The controller starts out with the configured concurrency and no rate
+	// limit.
+	m := d.Metrics()
+	require.Equal(t, 3, m.Compact.ConcurrencyLimit)
+	require.Zero(t, m.Compact.RateLimit)
+
+	// Writes to the in-memory FS are fast, so the controller leaves the
+	// limits in place, while observing the foreground write rate.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprint(i)), make([]byte, 1000), nil))
+		require.NoError(t, d.Flush())
+		d.mu.Lock()
+		now = now.Add(time.Second)
+		d.maybeUpdateCompactionControllerLocked()
+		d.mu.Unlock()
+	}
+	m = d.Metrics()
+	require.Equal(t, 3, m.Compact.ConcurrencyLimit)
+	require.Zero(t, m.Compact.RateLimit)
+	require.NotZero(t, m.Compact.ForegroundWriteRate)
+	require.True(t, m.Compact.DiskWriteLatency < d.opts.Experimental.TargetDiskWriteLatency)
+
+	// Without adaptive compactions, the concurrency limit is fixed.
+	d2, err := Open("", &Options{FS: vfs.NewMem(), MaxConcurrentCompactions: 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, d2.Metrics().Compact.ConcurrencyLimit)
+	require.NoError(t, d2.Close())
+}
+
+// slowSyncFS is a vfs.FS whose created files are slow to sync.
+type slowSyncFS struct {
+	vfs.FS
+	delay time.Duration
+}
+
+func (fs slowSyncFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return slowSyncFile{File: f, delay: fs.delay}, nil
+}
+
+type slowSyncFile struct {
+	vfs.File
+	delay time.Duration
+}
+
+func (f slowSyncFile) Sync() error {
+	time.Sleep(f.delay)
+	return f.File.Sync()
+}
+
+func TestAdaptiveCompactionsSlowSync(t *testing.T) {
+	// Buffered writes to the in-memory FS are fast, but syncs are slow.
+	fs := vfs.WithDiskHealthChecks(slowSyncFS{FS: vfs.NewMem(), delay: 20 * time.Millisecond},
+		time.Second, func(string, time.Duration) {})
+	opts := &Options{
+		FS:                       fs,
+		MaxConcurrentCompactions: 3,
+	}
+	opts.Experimental.AdaptiveCompactions = true
+	opts.Experimental.TargetDiskSyncLatency = 10 * time.Millisecond
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	now := time.Now()
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return now }
+	d.maybeUpdateCompactionControllerLocked()
+	d.mu.Unlock()
+
+	// The syncs of the flushed tables make the controller back off.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprint(i)), make([]byte, 1000), NoSync))
+		require.NoError(t, d.Flush())
+		d.mu.Lock()
+		now = now.Add(time.Second)
+		d.maybeUpdateCompactionControllerLocked()
+		d.mu.Unlock()
+	}
+	m := d.Metrics()
+	require.Equal(t, 1, m.Compact.ConcurrencyLimit)
+	require.True(t, m.Compact.DiskWriteLatency < d.opts.Experimental.TargetDiskWriteLatency)
+	require.True(t, m.Compact.DiskSyncLatency >= 20*time.Millisecond)
+}
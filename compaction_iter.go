@@ -194,6 +194,10 @@ type compactionIter struct {
 	// numbers define the snapshot stripes (see the Snapshots description
 	// above). The sequence numbers are in ascending order.
 	snapshots []uint64
+	// If non-zero, every version with a sequence number of at least
+	// retainSeqNum is retained, as if a snapshot were open at every sequence
+	// number above retainSeqNum. See Options.Experimental.VersionRetention.
+	retainSeqNum uint64
 	// Reference to the range deletion tombstone fragmenter (e.g.,
 	// `compaction.rangeDelFrag`).
 	rangeDelFrag *keyspan.Fragmenter
@@ -214,6 +218,7 @@ func newCompactionIter(
 	merge Merge,
 	iter internalIterator,
 	snapshots []uint64,
+	retainSeqNum uint64,
 	rangeDelFrag *keyspan.Fragmenter,
 	allowZeroSeqNum bool,
 	elideTombstone func(key []byte) bool,
@@ -225,6 +230,7 @@ func newCompactionIter(
 		merge:               merge,
 		iter:                iter,
 		snapshots:           snapshots,
+		retainSeqNum:        retainSeqNum,
 		rangeDelFrag:        rangeDelFrag,
 		allowZeroSeqNum:     allowZeroSeqNum,
 		elideTombstone:      elideTombstone,
@@ -243,7 +249,7 @@ func (i *compactionIter) First() (*InternalKey, []byte) {
 	}
 	i.iterKey, i.iterValue = i.iter.First()
 	if i.iterKey != nil {
-		i.curSnapshotIdx, i.curSnapshotSeqNum = snapshotIndex(i.iterKey.SeqNum(), i.snapshots)
+		i.curSnapshotIdx, i.curSnapshotSeqNum = i.stripeIndex(i.iterKey.SeqNum())
 	}
 	i.pos = iterPosNext
 	return i.Next()
@@ -421,6 +427,24 @@ func (i *compactionIter) closeValueCloser() error {
 	return i.err
 }
 
+// stripeIndex returns the index and the upper bound sequence number of the
+// snapshot stripe of the given sequence number. Stripes are defined by the
+// snapshots, and above the retention horizon by every sequence number, each
+// of which is given an index greater than any snapshot's.
+func (i *compactionIter) stripeIndex(seq uint64) (int, uint64) {
+	if i.retainSeqNum == 0 {
+		return snapshotIndex(seq, i.snapshots)
+	}
+	if seq >= i.retainSeqNum {
+		return len(i.snapshots) + 1 + int(seq-i.retainSeqNum), seq + 1
+	}
+	idx, snapshot := snapshotIndex(seq, i.snapshots)
+	if snapshot > i.retainSeqNum {
+		snapshot = i.retainSeqNum
+	}
+	return idx, snapshot
+}
+
 // snapshotIndex returns the index of the first sequence number in snapshots
 // which is greater than or equal to seq.
 func snapshotIndex(seq uint64, snapshots []uint64) (int, uint64) {
@@ -485,11 +509,11 @@ func (i *compactionIter) nextInStripe() stripeChangeType {
 	}
 	key := i.iterKey
 	if !i.equal(i.key.UserKey, key.UserKey) {
-		i.curSnapshotIdx, i.curSnapshotSeqNum = snapshotIndex(key.SeqNum(), i.snapshots)
+		i.curSnapshotIdx, i.curSnapshotSeqNum = i.stripeIndex(key.SeqNum())
 		return newStripe
 	}
 	origSnapshotIdx := i.curSnapshotIdx
-	i.curSnapshotIdx, i.curSnapshotSeqNum = snapshotIndex(key.SeqNum(), i.snapshots)
+	i.curSnapshotIdx, i.curSnapshotSeqNum = i.stripeIndex(key.SeqNum())
 	switch key.Kind() {
 	case InternalKeyKindRangeDelete:
 		// Range tombstones need to be exposed by the compactionIter to the upper level
@@ -781,7 +805,7 @@ func (i *compactionIter) emitRangeDelChunk(fragmented keyspan.Span) {
 	currentIdx := -1
 	keys := fragmented.Keys[:0]
 	for _, k := range fragmented.Keys {
-		idx, _ := snapshotIndex(k.SeqNum(), i.snapshots)
+		idx, _ := i.stripeIndex(k.SeqNum())
 		if currentIdx == idx {
 			continue
 		}
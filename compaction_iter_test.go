@@ -77,6 +77,7 @@ func TestCompactionIter(t *testing.T) {
 	var keys []InternalKey
 	var vals [][]byte
 	var snapshots []uint64
+	var retainSeqNum uint64
 	var elideTombstones bool
 	var allowZeroSeqnum bool
 
@@ -111,6 +112,7 @@ func TestCompactionIter(t *testing.T) {
 			merge,
 			iter,
 			snapshots,
+			retainSeqNum,
 			&keyspan.Fragmenter{},
 			allowZeroSeqnum,
 			func([]byte) bool {
@@ -143,6 +145,7 @@ func TestCompactionIter(t *testing.T) {
 
 			case "iter":
 				snapshots = snapshots[:0]
+				retainSeqNum = 0
 				elideTombstones = false
 				allowZeroSeqnum = false
 				for _, arg := range d.CmdArgs {
@@ -155,6 +158,12 @@ func TestCompactionIter(t *testing.T) {
 							}
 							snapshots = append(snapshots, uint64(seqNum))
 						}
+					case "retain":
+						var err error
+						retainSeqNum, err = strconv.ParseUint(arg.Vals[0], 10, 64)
+						if err != nil {
+							return err.Error()
+						}
 					case "elide-tombstones":
 						var err error
 						elideTombstones, err = strconv.ParseBool(arg.Vals[0])
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/humanize"
@@ -27,6 +28,7 @@ type compactionEnv struct {
 	earliestSnapshotSeqNum  uint64
 	inProgressCompactions   []compactionInfo
 	readCompactionEnv       readCompactionEnv
+	now                     time.Time
 }
 
 type compactionPicker interface {
@@ -465,6 +467,12 @@ func newCompactionPicker(
 		diskAvailBytes: diskAvailBytes,
 	}
 	p.initLevelMaxBytes(inProgressCompactions)
+	if opts.Experimental.CompactionPicker != nil {
+		return &externalCompactionPicker{
+			compactionPickerByScore: p,
+			picker:                  opts.Experimental.CompactionPicker,
+		}
+	}
 	return p
 }
 
@@ -1043,6 +1051,23 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		}
 	}
 
+	return p.pickLowPriorityCompaction(env)
+}
+
+// pickLowPriorityCompaction picks a compaction which doesn't help keep up with
+// writes, if any: a tombstone-density compaction, an elision-only compaction,
+// a read-triggered compaction, a rewrite of a file marked for compaction or a
+// periodic compaction.
+func (p *compactionPickerByScore) pickLowPriorityCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	// Check for files dense with tombstones, which slow down iteration over
+	// the regions they cover. These compactions are prioritized over the
+	// others below since they improve read performance, not just disk space.
+	if pc := p.pickTombstoneDensityCompaction(env); pc != nil {
+		return pc
+	}
+
 	// Check for L6 files with tombstones that may be elided. These files may
 	// exist if a snapshot prevented the elision of a tombstone or because of
 	// a move compaction. These are low-priority compactions because they
@@ -1092,7 +1117,9 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		}
 	}
 
-	return nil
+	// Finally, look for tables which have not been compacted for longer than
+	// Options.Experimental.PeriodicCompactionAge.
+	return p.pickPeriodicCompaction(env)
 }
 
 // elisionOnlyAnnotator implements the manifest.Annotator interface,
@@ -1230,6 +1257,229 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	return nil
 }
 
+// tombstoneDensityMinDeletions is the minimum number of deletion tombstones in
+// a table for the table to be considered for a tombstone-density compaction
+// through Options.Experimental.TombstoneDensityThreshold. Iterating over a
+// small number of tombstones is cheap, and compacting many small tables is
+// not.
+const tombstoneDensityMinDeletions = 1000
+
+// tombstoneDensityAnnotator implements the manifest.Annotator interface,
+// annotating B-Tree nodes with the *fileMetadata of a file whose tombstones
+// exceed the thresholds of Options.Experimental.TombstoneDensityThreshold or
+// Options.Experimental.RangeDeletionCoverageThreshold. If multiple files meet
+// the criteria, it chooses whichever file has the lowest LargestSeqNum.
+type tombstoneDensityAnnotator struct {
+	densityThreshold  float64
+	coverageThreshold float64
+}
+
+var _ manifest.Annotator = tombstoneDensityAnnotator{}
+
+func newTombstoneDensityAnnotator(opts *Options) tombstoneDensityAnnotator {
+	return tombstoneDensityAnnotator{
+		densityThreshold:  opts.Experimental.TombstoneDensityThreshold,
+		coverageThreshold: opts.Experimental.RangeDeletionCoverageThreshold,
+	}
+}
+
+func (a tombstoneDensityAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a tombstoneDensityAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (interface{}, bool) {
+	if !f.Stats.Valid {
+		return dst, false
+	}
+	if !a.dense(f) {
+		return dst, true
+	}
+	if dst == nil {
+		return f, true
+	} else if dstV := dst.(*fileMetadata); dstV.LargestSeqNum > f.LargestSeqNum {
+		return f, true
+	}
+	return dst, true
+}
+
+func (a tombstoneDensityAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	if accum == nil {
+		return v
+	}
+	f := v.(*fileMetadata)
+	if accumV := accum.(*fileMetadata); accumV.LargestSeqNum > f.LargestSeqNum {
+		return f
+	}
+	return accum
+}
+
+// dense returns true if the point deletions of f make up at least
+// densityThreshold of its entries, or if its range deletions are estimated to
+// delete at least coverageThreshold times f's size from the levels beneath
+// it.
+func (a tombstoneDensityAnnotator) dense(f *fileMetadata) bool {
+	if a.densityThreshold > 0 && f.Stats.NumDeletions >= tombstoneDensityMinDeletions &&
+		float64(f.Stats.NumDeletions) >= a.densityThreshold*float64(f.Stats.NumEntries) {
+		return true
+	}
+	return a.coverageThreshold > 0 && f.Stats.RangeDeletionsBytesEstimate > 0 &&
+		float64(f.Stats.RangeDeletionsBytesEstimate) >= a.coverageThreshold*float64(f.Size)
+}
+
+// pickTombstoneDensityCompaction looks for a table below L0 that is dense
+// with tombstones (see Options.Experimental.TombstoneDensityThreshold and
+// Options.Experimental.RangeDeletionCoverageThreshold), regardless of the
+// score of its level. The table is compacted into the next level, where its
+// tombstones drop the keys they delete, or, in the bottommost level, is
+// rewritten in place to elide its tombstones.
+func (p *compactionPickerByScore) pickTombstoneDensityCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	a := newTombstoneDensityAnnotator(p.opts)
+	if a.densityThreshold <= 0 && a.coverageThreshold <= 0 {
+		return nil
+	}
+	for l := p.baseLevel; l < numLevels; l++ {
+		v := p.vers.Levels[l].Annotation(a)
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		if candidate.Compacting {
+			continue
+		}
+		// Tombstones in the bottommost level may only be elided once no open
+		// snapshot requires them.
+		if l == numLevels-1 && candidate.LargestSeqNum >= env.earliestSnapshotSeqNum {
+			continue
+		}
+		pc = p.pickFileCompaction(env, l, candidate)
+		// Fail-safe to protect against compacting the same sstable concurrently.
+		if pc != nil && !inputRangeAlreadyCompacting(env, pc) {
+			pc.kind = compactionKindTombstoneDensity
+			return pc
+		}
+	}
+	return nil
+}
+
+// oldestFileAnnotator implements the manifest.Annotator interface,
+// annotating B-Tree nodes with the *fileMetadata of the file with the
+// earliest creation time within the subtree. Files without a recorded
+// creation time are ignored.
+type oldestFileAnnotator struct{}
+
+var _ manifest.Annotator = oldestFileAnnotator{}
+
+func (a oldestFileAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a oldestFileAnnotator) Accumulate(f *fileMetadata, dst interface{}) (interface{}, bool) {
+	if f.CreationTime == 0 {
+		return dst, true
+	}
+	if dst == nil {
+		return f, true
+	} else if dstV := dst.(*fileMetadata); dstV.CreationTime > f.CreationTime {
+		return f, true
+	}
+	return dst, true
+}
+
+func (a oldestFileAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	if accum == nil {
+		return v
+	}
+	f := v.(*fileMetadata)
+	if accumV := accum.(*fileMetadata); accumV.CreationTime > f.CreationTime {
+		return f
+	}
+	return accum
+}
+
+// pickPeriodicCompaction looks for a table created more than
+// Options.Experimental.PeriodicCompactionAge ago, regardless of the score of
+// its level. Levels are searched from the top of the LSM down, so that old
+// data is pushed towards the bottommost level. The old table is compacted as
+// described by pickFileCompaction.
+func (p *compactionPickerByScore) pickPeriodicCompaction(env compactionEnv) (pc *pickedCompaction) {
+	if p.opts.Experimental.PeriodicCompactionAge <= 0 || env.now.IsZero() {
+		return nil
+	}
+	cutoff := env.now.Add(-p.opts.Experimental.PeriodicCompactionAge).Unix()
+	for l := 0; l < numLevels; l++ {
+		if l > 0 && l < p.baseLevel {
+			continue
+		}
+		v := p.vers.Levels[l].Annotation(oldestFileAnnotator{})
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		if candidate.Compacting || candidate.CreationTime > cutoff {
+			continue
+		}
+
+		pc = p.pickFileCompaction(env, l, candidate)
+		// Fail-safe to protect against compacting the same sstable concurrently.
+		if pc != nil && !inputRangeAlreadyCompacting(env, pc) {
+			pc.kind = compactionKindPeriodic
+			return pc
+		}
+	}
+	return nil
+}
+
+// pickFileCompaction picks a compaction of the candidate file in level l,
+// regardless of the score of the level. A file in L0 is compacted into the
+// base level together with the L0 files which overlap it, a file in a level
+// beneath L0 is compacted into the next level, and a file in the bottommost
+// level is rewritten in place, together with the rest of its atomic
+// compaction unit. It returns nil if the compaction would include files which
+// are already being compacted.
+func (p *compactionPickerByScore) pickFileCompaction(
+	env compactionEnv, l int, candidate *fileMetadata,
+) (pc *pickedCompaction) {
+	cmp := p.opts.Comparer.Compare
+	if l == 0 {
+		pc = newPickedCompaction(p.opts, p.vers, 0, p.baseLevel, p.baseLevel)
+		pc.startLevel.files = p.vers.Overlaps(0, cmp, candidate.Smallest.UserKey,
+			candidate.Largest.UserKey, candidate.Largest.IsExclusiveSentinel())
+		if !pc.setupInputs(p.opts, p.diskAvailBytes()) {
+			return nil
+		}
+		return pc
+	}
+	lf := p.vers.Levels[l].Find(cmp, candidate)
+	if lf == nil {
+		panic(fmt.Sprintf("file %s not found in level %d as expected", candidate.FileNum, l))
+	}
+	if l < numLevels-1 {
+		return pickAutoLPositive(env, p.opts, p.vers, candidateLevelInfo{
+			level:       l,
+			outputLevel: defaultOutputLevel(l, p.baseLevel),
+			file:        *lf,
+		}, p.baseLevel, p.diskAvailBytes)
+	}
+	pc = newPickedCompaction(p.opts, p.vers, l, l, p.baseLevel)
+	var isCompacting bool
+	pc.startLevel.files, isCompacting = expandToAtomicUnit(cmp, lf.Slice(), false /* disableIsCompacting */)
+	if isCompacting {
+		return nil
+	}
+	pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
+	return pc
+}
+
 // pickRewriteCompaction attempts to construct a compaction that
 // rewrites a file marked for compaction. pickRewriteCompaction will
 // pull in adjacent files in the file's atomic compaction unit if
@@ -1390,10 +1640,8 @@ func (p *compactionPickerByScore) pickManual(
 		return nil, false
 	}
 
-	outputLevel := manual.level + 1
-	if manual.level == 0 {
-		outputLevel = p.baseLevel
-	} else if manual.level < p.baseLevel {
+	outputLevel := manual.outputLevelFor(p.baseLevel)
+	if manual.level > 0 && manual.level < p.baseLevel {
 		// The start level for a compaction must be >= Lbase. A manual
 		// compaction could have been created adhering to that condition, and
 		// then an automatic compaction came in and compacted all of the
@@ -1431,7 +1679,7 @@ func pickManualHelper(
 	baseLevel int,
 	diskAvailBytes func() uint64,
 ) (pc *pickedCompaction) {
-	pc = newPickedCompaction(opts, vers, manual.level, defaultOutputLevel(manual.level, baseLevel), baseLevel)
+	pc = newPickedCompaction(opts, vers, manual.level, manual.outputLevelFor(baseLevel), baseLevel)
 	manual.outputLevel = pc.outputLevel.level
 	cmp := opts.Comparer.Compare
 	pc.startLevel.files = vers.Overlaps(manual.level, cmp, manual.start, manual.end, false)
@@ -1439,6 +1687,18 @@ func pickManualHelper(
 		// Nothing to do
 		return nil
 	}
+	if manual.rewrite {
+		// Rewrite the tables in place, pulling in the rest of their atomic
+		// compaction units. The output level contributes no further inputs.
+		pc.kind = compactionKindRewrite
+		var isCompacting bool
+		pc.startLevel.files, isCompacting = expandToAtomicUnit(cmp, pc.startLevel.files, false /* disableIsCompacting */)
+		if isCompacting {
+			return nil
+		}
+		pc.smallest, pc.largest = manifest.KeyRange(cmp, pc.startLevel.files.Iter())
+		return pc
+	}
 	if !pc.setupInputs(opts, diskAvailBytes()) {
 		return nil
 	}
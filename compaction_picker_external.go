@@ -0,0 +1,282 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// NumLevels is the number of levels in the LSM.
+const NumLevels = manifest.NumLevels
+
+// Version exports the manifest.Version type.
+type Version = manifest.Version
+
+// CompactionPicker picks automatic compactions, replacing the default
+// score-based leveled compaction strategy. It is configured through
+// Options.Experimental.CompactionPicker.
+//
+// A CompactionPicker only chooses which files to compact. Every pick is
+// validated, and expanded as necessary, by the DB before a compaction is
+// started, so a CompactionPicker cannot construct a compaction which would
+// violate the invariants of the LSM.
+type CompactionPicker interface {
+	// Name returns the name of the compaction picker.
+	Name() string
+
+	// PickAuto returns the next automatic compaction to run, or nil if no
+	// compaction should be run. PickAuto is called with DB.mu held whenever the
+	// DB has capacity to run another compaction, as bounded by
+	// Options.MaxConcurrentCompactions, and must not block or call into the
+	// DB. A pick which cannot be run is ignored.
+	//
+	// When PickAuto returns nil, the DB may still run low-priority
+	// compactions: tombstone-density compactions, elision-only compactions,
+	// read-triggered compactions, rewrites of files marked for compaction and
+	// periodic compactions.
+	PickAuto(env CompactionPickerEnv) *CompactionPick
+}
+
+// CompactionPickerEnv holds the state of the LSM provided to
+// CompactionPicker.PickAuto.
+type CompactionPickerEnv struct {
+	// Version is the current version of the LSM. It must be treated as
+	// read-only, and must not be retained after PickAuto returns.
+	Version *Version
+	// BaseLevel is the level which L0 compacts into. Levels 1 through
+	// BaseLevel-1 are empty.
+	BaseLevel int
+	// Scores holds the per-level compaction scores computed by the default
+	// leveled compaction strategy, adjusted for in-progress compactions. A
+	// score of 1 or greater indicates the default strategy would compact the
+	// level.
+	Scores [NumLevels]float64
+	// InProgress describes the compactions which are currently running,
+	// excluding flushes.
+	InProgress []InProgressCompaction
+	// EarliestSnapshotSeqNum is the sequence number of the earliest open
+	// snapshot, or math.MaxUint64 if there are no open snapshots.
+	EarliestSnapshotSeqNum uint64
+}
+
+// InProgressCompaction describes a running compaction.
+type InProgressCompaction struct {
+	// InputLevels holds the levels read by the compaction.
+	InputLevels []int
+	// OutputLevel is the level written by the compaction, or -1 if the
+	// compaction writes no output.
+	OutputLevel int
+	// Smallest and Largest are the bounds of the compaction's inputs.
+	Smallest InternalKey
+	Largest  InternalKey
+}
+
+// CompactionPick describes a compaction chosen by a CompactionPicker.
+type CompactionPick struct {
+	// StartLevel is the level which is compacted. StartLevel must be L0 or a
+	// level in [BaseLevel, NumLevels-1).
+	StartLevel int
+	// OutputLevel is the level which receives the compaction's output. It
+	// must be BaseLevel if StartLevel is L0, and StartLevel+1 otherwise. The
+	// single exception is an intra-L0 compaction, which has both StartLevel
+	// and OutputLevel set to L0.
+	OutputLevel int
+	// Files holds the file numbers of the StartLevel files to compact. The
+	// compaction includes every StartLevel file overlapping the key range
+	// spanned by Files, and every OutputLevel file overlapping the start
+	// level inputs. All files must be present in StartLevel of the Version
+	// provided to PickAuto.
+	Files []FileNum
+	// ExactFiles restricts an intra-L0 compaction to exactly Files, rather
+	// than every L0 file overlapping them. The CompactionPicker must guarantee
+	// that every L0 file excluded from the compaction which shares a user key
+	// with Files has sequence numbers entirely older or entirely newer than
+	// those of Files. ExactFiles may only be set for intra-L0 compactions.
+	ExactFiles bool
+	// DeleteOnly requests that Files be removed without being rewritten. The
+	// Files of a delete-only pick may belong to any level, and StartLevel and
+	// OutputLevel are ignored. Deleting a file drops its keys and may expose
+	// older versions of those keys in other files, and is visible to open
+	// snapshots and iterators created after the compaction completes. A
+	// CompactionPicker must only delete files whose keys are no longer needed
+	// by the application, together with every file whose keys they shadow.
+	DeleteOnly bool
+}
+
+// externalCompactionPicker is a compactionPicker which picks automatic
+// compactions using a CompactionPicker. All other compaction decisions,
+// including manual compactions, are made by the default score-based picker.
+type externalCompactionPicker struct {
+	*compactionPickerByScore
+	picker CompactionPicker
+}
+
+var _ compactionPicker = (*externalCompactionPicker)(nil)
+
+func (p *externalCompactionPicker) pickAuto(env compactionEnv) (pc *pickedCompaction) {
+	pickerEnv := CompactionPickerEnv{
+		Version:                p.vers,
+		BaseLevel:              p.baseLevel,
+		Scores:                 p.getScores(env.inProgressCompactions),
+		EarliestSnapshotSeqNum: env.earliestSnapshotSeqNum,
+	}
+	if n := len(env.inProgressCompactions); n > 0 {
+		pickerEnv.InProgress = make([]InProgressCompaction, n)
+		for i := range env.inProgressCompactions {
+			info := &env.inProgressCompactions[i]
+			c := &pickerEnv.InProgress[i]
+			c.InputLevels = make([]int, len(info.inputs))
+			for j := range info.inputs {
+				c.InputLevels[j] = info.inputs[j].level
+			}
+			c.OutputLevel = info.outputLevel
+			c.Smallest = info.smallest
+			c.Largest = info.largest
+		}
+	}
+	if pick := p.picker.PickAuto(pickerEnv); pick != nil {
+		if pc = p.newPickedCompactionFromPick(env, pick); pc != nil {
+			return pc
+		}
+	}
+	return p.pickLowPriorityCompaction(env)
+}
+
+// newPickedCompactionFromPick constructs a pickedCompaction from a
+// CompactionPick, returning nil if the pick is invalid or conflicts with an
+// in-progress compaction.
+func (p *externalCompactionPicker) newPickedCompactionFromPick(
+	env compactionEnv, pick *CompactionPick,
+) *pickedCompaction {
+	if pick.DeleteOnly {
+		if pick.ExactFiles {
+			return nil
+		}
+		return p.newDeleteOnlyCompactionFromPick(pick)
+	}
+	startLevel, outputLevel := pick.StartLevel, pick.OutputLevel
+	if startLevel < 0 || startLevel >= numLevels || len(pick.Files) == 0 {
+		return nil
+	}
+	if startLevel == numLevels-1 || (startLevel > 0 && startLevel < p.baseLevel) {
+		return nil
+	}
+	intraL0 := startLevel == 0 && outputLevel == 0
+	if !intraL0 && outputLevel != defaultOutputLevel(startLevel, p.baseLevel) {
+		return nil
+	}
+	if pick.ExactFiles && !intraL0 {
+		return nil
+	}
+
+	// Resolve the picked file numbers.
+	fileNums := make(map[FileNum]struct{}, len(pick.Files))
+	for _, fileNum := range pick.Files {
+		fileNums[fileNum] = struct{}{}
+	}
+	files := make([]*fileMetadata, 0, len(fileNums))
+	iter := p.vers.Levels[startLevel].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if _, ok := fileNums[f.FileNum]; ok {
+			files = append(files, f)
+		}
+	}
+	if len(files) != len(fileNums) {
+		return nil
+	}
+
+	cmp := p.opts.Comparer.Compare
+	var picked manifest.LevelSlice
+	if startLevel == 0 {
+		picked = manifest.NewLevelSliceSeqSorted(files)
+	} else {
+		picked = manifest.NewLevelSliceKeySorted(cmp, files)
+	}
+	// Unless ExactFiles is set, expand the start level inputs to every file
+	// overlapping the picked files. For L0 this guarantees that no file
+	// remaining in L0 holds an older version of a key than the compaction's
+	// output.
+	pc := newPickedCompaction(p.opts, p.vers, startLevel, outputLevel, p.baseLevel)
+	if pick.ExactFiles {
+		pc.startLevel.files = picked
+	} else {
+		smallest, largest := manifest.KeyRange(cmp, picked.Iter())
+		pc.startLevel.files = p.vers.Overlaps(startLevel, cmp, smallest.UserKey,
+			largest.UserKey, largest.IsExclusiveSentinel())
+	}
+	if intraL0 {
+		// An intra-L0 compaction must not include files with sequence numbers
+		// at or above the earliest unflushed sequence number, since a later
+		// flush may produce an overlapping file with older sequence numbers
+		// than the compaction's output.
+		iter := pc.startLevel.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.LargestSeqNum >= env.earliestUnflushedSeqNum {
+				return nil
+			}
+		}
+	}
+	if !pc.setupInputs(p.opts, p.diskAvailBytes()) {
+		return nil
+	}
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil
+	}
+	return pc
+}
+
+// newDeleteOnlyCompactionFromPick constructs a delete-only pickedCompaction
+// from a CompactionPick, returning nil if the pick is invalid or any of its
+// files are being compacted. The picked files may belong to any level.
+func (p *externalCompactionPicker) newDeleteOnlyCompactionFromPick(
+	pick *CompactionPick,
+) *pickedCompaction {
+	if len(pick.Files) == 0 {
+		return nil
+	}
+	fileNums := make(map[FileNum]struct{}, len(pick.Files))
+	for _, fileNum := range pick.Files {
+		fileNums[fileNum] = struct{}{}
+	}
+	cmp := p.opts.Comparer.Compare
+	pc := &pickedCompaction{
+		cmp:     cmp,
+		kind:    compactionKindDeleteOnly,
+		version: p.vers,
+	}
+	var found int
+	var levelIters []manifest.LevelIterator
+	for level := 0; level < numLevels; level++ {
+		var files []*fileMetadata
+		iter := p.vers.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if _, ok := fileNums[f.FileNum]; !ok {
+				continue
+			}
+			if f.Compacting {
+				return nil
+			}
+			files = append(files, f)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		found += len(files)
+		var picked manifest.LevelSlice
+		if level == 0 {
+			picked = manifest.NewLevelSliceSeqSorted(files)
+		} else {
+			picked = manifest.NewLevelSliceKeySorted(cmp, files)
+		}
+		pc.inputs = append(pc.inputs, compactionLevel{level: level, files: picked})
+		levelIters = append(levelIters, picked.Iter())
+	}
+	if found != len(fileNums) {
+		return nil
+	}
+	pc.smallest, pc.largest = manifest.KeyRange(cmp, levelIters...)
+	return pc
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type testCompactionPicker struct {
+	// envs records the environments passed to PickAuto. Protected by DB.mu.
+	envs []CompactionPickerEnv
+	pick func(env CompactionPickerEnv) *CompactionPick
+}
+
+func (p *testCompactionPicker) Name() string { return "test" }
+
+func (p *testCompactionPicker) PickAuto(env CompactionPickerEnv) *CompactionPick {
+	p.envs = append(p.envs, env)
+	return p.pick(env)
+}
+
+func TestExternalCompactionPicker(t *testing.T) {
+	// pickL0 picks the first L0 file once L0 holds at least two files. Since
+	// the flushed files overlap, the compaction includes every L0 file.
+	pickL0 := func(outputLevel func(env CompactionPickerEnv) int) func(CompactionPickerEnv) *CompactionPick {
+		return func(env CompactionPickerEnv) *CompactionPick {
+			if env.Version.Levels[0].Len() < 2 {
+				return nil
+			}
+			iter := env.Version.Levels[0].Iter()
+			return &CompactionPick{
+				StartLevel:  0,
+				OutputLevel: outputLevel(env),
+				Files:       []FileNum{iter.First().FileNum},
+			}
+		}
+	}
+
+	testCases := []struct {
+		name string
+		pick func(env CompactionPickerEnv) *CompactionPick
+		// The expected number of files in L0 and L6 after the flushes. The
+		// third flush leaves a single file in L0 when the first two are
+		// compacted into L6.
+		l0, l6 int
+	}{
+		{
+			name: "base",
+			pick: pickL0(func(env CompactionPickerEnv) int { return env.BaseLevel }),
+			l0:   1,
+			l6:   1,
+		},
+		{
+			name: "intra-l0",
+			pick: pickL0(func(env CompactionPickerEnv) int { return 0 }),
+			l0:   1,
+			l6:   0,
+		},
+		{
+			name: "invalid-output-level",
+			pick: pickL0(func(env CompactionPickerEnv) int { return env.BaseLevel - 1 }),
+			l0:   3,
+			l6:   0,
+		},
+		{
+			name: "unknown-file",
+			pick: func(env CompactionPickerEnv) *CompactionPick {
+				return &CompactionPick{StartLevel: 0, OutputLevel: env.BaseLevel, Files: []FileNum{1000}}
+			},
+			l0: 3,
+			l6: 0,
+		},
+		{
+			name: "none",
+			pick: func(env CompactionPickerEnv) *CompactionPick { return nil },
+			l0:   3,
+			l6:   0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			picker := &testCompactionPicker{pick: tc.pick}
+			opts := &Options{FS: vfs.NewMem()}
+			opts.Experimental.CompactionPicker = picker
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, d.Close())
+			}()
+
+			// Flush three overlapping L0 files.
+			for i := 0; i < 3; i++ {
+				for _, k := range []string{"a", "m", "z"} {
+					require.NoError(t, d.Set([]byte(k), []byte(fmt.Sprint(i)), nil))
+				}
+				require.NoError(t, d.Flush())
+			}
+
+			d.mu.Lock()
+			for d.mu.compact.compactingCount > 0 {
+				d.mu.compact.cond.Wait()
+			}
+			v := d.mu.versions.currentVersion()
+			l0, l6 := v.Levels[0].Len(), v.Levels[6].Len()
+			envs := picker.envs
+			d.mu.Unlock()
+
+			require.Equal(t, tc.l0, l0)
+			require.Equal(t, tc.l6, l6)
+			require.NotEmpty(t, envs)
+			for _, env := range envs {
+				require.NotNil(t, env.Version)
+				require.Equal(t, numLevels-1, env.BaseLevel)
+				require.Equal(t, uint64(math.MaxUint64), env.EarliestSnapshotSeqNum)
+			}
+
+			for _, k := range []string{"a", "m", "z"} {
+				v, closer, err := d.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, "2", string(v))
+				require.NoError(t, closer.Close())
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// TieredCompactionOptions configures the tiered compaction strategy. See
+// NewTieredCompactionPicker.
+type TieredCompactionOptions struct {
+	// MaxSpaceAmplificationPercent bounds the space amplification of the LSM.
+	// When the total size of all sorted runs other than the oldest exceeds
+	// this percentage of the size of the oldest sorted run, the newer runs are
+	// compacted, one level at a time, into the oldest run.
+	//
+	// The default value is 200.
+	MaxSpaceAmplificationPercent int
+
+	// MinMergeWidth is the minimum number of sorted runs merged by a size
+	// ratio compaction.
+	//
+	// The default value is 2.
+	MinMergeWidth int
+
+	// SizeRatio is the percentage by which a sorted run may be larger than the
+	// total size of the newer runs preceding it and still be merged with them.
+	//
+	// The default value is 1.
+	SizeRatio int
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (o *TieredCompactionOptions) EnsureDefaults() *TieredCompactionOptions {
+	if o == nil {
+		o = &TieredCompactionOptions{}
+	}
+	if o.MaxSpaceAmplificationPercent <= 0 {
+		o.MaxSpaceAmplificationPercent = 200
+	}
+	if o.MinMergeWidth < 2 {
+		o.MinMergeWidth = 2
+	}
+	if o.SizeRatio <= 0 {
+		o.SizeRatio = 1
+	}
+	return o
+}
+
+// NewTieredCompactionPicker returns a CompactionPicker implementing a tiered
+// compaction strategy, similar to RocksDB's universal compaction. It may be
+// configured through Options.Experimental.CompactionPicker.
+//
+// The tiered strategy views the LSM as a sequence of sorted runs, ordered
+// from newest to oldest: each L0 sublevel, followed by each non-empty level
+// below L0. Rather than compacting a level once it exceeds a target size, as
+// the default leveled strategy does, the tiered strategy merges adjacent runs
+// of similar size, and otherwise leaves runs in place. This reduces write
+// amplification at the expense of read amplification, since L0 accumulates
+// several sublevels before they're merged, and of space amplification, which
+// is bounded by TieredCompactionOptions.MaxSpaceAmplificationPercent.
+//
+// Runs below L0 are merged by compacting an entire level into the next
+// level, so a single compaction may be large.
+func NewTieredCompactionPicker(opts TieredCompactionOptions) CompactionPicker {
+	return &tieredCompactionPicker{opts: *opts.EnsureDefaults()}
+}
+
+type tieredCompactionPicker struct {
+	opts TieredCompactionOptions
+}
+
+var _ CompactionPicker = (*tieredCompactionPicker)(nil)
+
+// sortedRun describes an L0 sublevel, or a level below L0.
+type sortedRun struct {
+	level int
+	files []FileNum
+	size  uint64
+	// compacting is true if any file within the run is being compacted.
+	compacting bool
+}
+
+// sortedRuns returns the sorted runs of the version, ordered from newest to
+// oldest.
+func sortedRuns(v *Version) []sortedRun {
+	var runs []sortedRun
+	add := func(level int, iter func(func(*fileMetadata))) {
+		r := sortedRun{level: level}
+		iter(func(f *fileMetadata) {
+			r.files = append(r.files, f.FileNum)
+			r.size += f.Size
+			r.compacting = r.compacting || f.Compacting
+		})
+		if len(r.files) > 0 {
+			runs = append(runs, r)
+		}
+	}
+	// L0SublevelFiles is ordered from the oldest sublevel to the newest.
+	for i := len(v.L0SublevelFiles) - 1; i >= 0; i-- {
+		add(0, v.L0SublevelFiles[i].Each)
+	}
+	for level := 1; level < numLevels; level++ {
+		add(level, v.Levels[level].Slice().Each)
+	}
+	return runs
+}
+
+// Name implements the CompactionPicker interface.
+func (p *tieredCompactionPicker) Name() string {
+	return "pebble.tiered"
+}
+
+// PickAuto implements the CompactionPicker interface.
+func (p *tieredCompactionPicker) PickAuto(env CompactionPickerEnv) *CompactionPick {
+	runs := sortedRuns(env.Version)
+	if len(runs) < 2 {
+		return nil
+	}
+	if pick := p.pickSpaceAmp(env, runs); pick != nil {
+		return pick
+	}
+	return p.pickSizeRatio(env, runs)
+}
+
+// pickSpaceAmp returns a compaction moving data towards the oldest sorted run
+// if the space amplification of the LSM exceeds
+// MaxSpaceAmplificationPercent.
+func (p *tieredCompactionPicker) pickSpaceAmp(
+	env CompactionPickerEnv, runs []sortedRun,
+) *CompactionPick {
+	last := &runs[len(runs)-1]
+	var newerSize uint64
+	for i := range runs[:len(runs)-1] {
+		newerSize += runs[i].size
+	}
+	if newerSize*100 <= last.size*uint64(p.opts.MaxSpaceAmplificationPercent) {
+		return nil
+	}
+	// The next-to-last run is compacted into the next level down. Repeated
+	// compactions eventually merge all of the newer runs into the oldest run.
+	if last.compacting {
+		return nil
+	}
+	return p.pickMerge(env, runs, len(runs)-2, len(runs)-1)
+}
+
+// pickSizeRatio returns a compaction merging the newest sequence of at least
+// MinMergeWidth sorted runs in which each run is no more than SizeRatio
+// percent larger than the total size of the runs preceding it.
+func (p *tieredCompactionPicker) pickSizeRatio(
+	env CompactionPickerEnv, runs []sortedRun,
+) *CompactionPick {
+	for i := range runs {
+		if runs[i].compacting {
+			continue
+		}
+		size := runs[i].size
+		j := i + 1
+		for ; j < len(runs); j++ {
+			if runs[j].compacting || runs[j].size*100 > size*uint64(100+p.opts.SizeRatio) {
+				break
+			}
+			size += runs[j].size
+		}
+		if j-i >= p.opts.MinMergeWidth {
+			if pick := p.pickMerge(env, runs, i, j-1); pick != nil {
+				return pick
+			}
+		}
+	}
+	return nil
+}
+
+// pickMerge returns a compaction merging runs[i] through runs[j]. Runs within
+// L0 are merged by an intra-L0 compaction, unless they include the oldest run
+// of the LSM. Otherwise, the newest run is compacted into the next level
+// down: a merge of runs spanning several levels is performed by a sequence of
+// compactions.
+func (p *tieredCompactionPicker) pickMerge(
+	env CompactionPickerEnv, runs []sortedRun, i, j int,
+) *CompactionPick {
+	switch {
+	case runs[j].level == 0 && j < len(runs)-1:
+		pick := &CompactionPick{StartLevel: 0, OutputLevel: 0}
+		for k := i; k <= j; k++ {
+			pick.Files = append(pick.Files, runs[k].files...)
+		}
+		return pick
+	case runs[i].level == 0:
+		// A compaction out of L0 includes every L0 sublevel.
+		pick := &CompactionPick{StartLevel: 0, OutputLevel: env.BaseLevel}
+		for k := 0; k < len(runs) && runs[k].level == 0; k++ {
+			if runs[k].compacting {
+				return nil
+			}
+			pick.Files = append(pick.Files, runs[k].files...)
+		}
+		return pick
+	default:
+		if runs[i].level == numLevels-1 {
+			return nil
+		}
+		return &CompactionPick{
+			StartLevel:  runs[i].level,
+			OutputLevel: runs[i].level + 1,
+			Files:       runs[i].files,
+		}
+	}
+}
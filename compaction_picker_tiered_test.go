@@ -0,0 +1,169 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredCompactionPicker(t *testing.T) {
+	opts := (*Options)(nil).EnsureDefaults()
+
+	// parseMeta parses a table spec of the form:
+	//   000100:a.SET.1-b.SET.2 size=10 [compacting]
+	parseMeta := func(s string) (*fileMetadata, error) {
+		parts := strings.Split(s, ":")
+		fileNum, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(parts[1])
+		bounds := strings.Split(fields[0], "-")
+		if len(bounds) != 2 {
+			return nil, errors.Errorf("malformed table spec: %s", s)
+		}
+		m := (&fileMetadata{
+			FileNum: base.FileNum(fileNum),
+		}).ExtendPointKeyBounds(
+			opts.Comparer.Compare,
+			base.ParseInternalKey(strings.TrimSpace(bounds[0])),
+			base.ParseInternalKey(strings.TrimSpace(bounds[1])),
+		)
+		m.SmallestSeqNum = m.Smallest.SeqNum()
+		m.LargestSeqNum = m.Largest.SeqNum()
+		for _, field := range fields[1:] {
+			switch {
+			case strings.HasPrefix(field, "size="):
+				m.Size, err = strconv.ParseUint(strings.TrimPrefix(field, "size="), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+			case field == "compacting":
+				m.Compacting = true
+			default:
+				return nil, errors.Errorf("unknown field: %s", field)
+			}
+		}
+		return m, nil
+	}
+
+	var env CompactionPickerEnv
+	datadriven.RunTest(t, "testdata/compaction_picker_tiered", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "define":
+			var files [numLevels][]*fileMetadata
+			baseLevel := numLevels - 1
+			level := 0
+			for _, line := range strings.Split(td.Input, "\n") {
+				line = strings.TrimSpace(line)
+				switch line {
+				case "L0", "L1", "L2", "L3", "L4", "L5", "L6":
+					level = int(line[1] - '0')
+				default:
+					m, err := parseMeta(line)
+					if err != nil {
+						return err.Error()
+					}
+					if level != 0 && level < baseLevel {
+						baseLevel = level
+					}
+					files[level] = append(files[level], m)
+				}
+			}
+			env = CompactionPickerEnv{
+				Version:   newVersion(opts, files),
+				BaseLevel: baseLevel,
+			}
+			var buf bytes.Buffer
+			for _, r := range sortedRuns(env.Version) {
+				fmt.Fprintf(&buf, "L%d: size=%d", r.level, r.size)
+				if r.compacting {
+					fmt.Fprintf(&buf, " compacting")
+				}
+				fmt.Fprintln(&buf)
+			}
+			return buf.String()
+
+		case "pick":
+			var tieredOpts TieredCompactionOptions
+			for _, arg := range td.CmdArgs {
+				v, err := strconv.Atoi(arg.Vals[0])
+				if err != nil {
+					return err.Error()
+				}
+				switch arg.Key {
+				case "max-space-amp":
+					tieredOpts.MaxSpaceAmplificationPercent = v
+				case "min-merge-width":
+					tieredOpts.MinMergeWidth = v
+				case "size-ratio":
+					tieredOpts.SizeRatio = v
+				default:
+					return fmt.Sprintf("unknown argument: %s", arg.Key)
+				}
+			}
+			pick := NewTieredCompactionPicker(tieredOpts).PickAuto(env)
+			if pick == nil {
+				return "nil"
+			}
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "L%d -> L%d:", pick.StartLevel, pick.OutputLevel)
+			for _, fileNum := range pick.Files {
+				fmt.Fprintf(&buf, " %s", fileNum)
+			}
+			return buf.String()
+
+		default:
+			return fmt.Sprintf("unknown command: %s", td.Cmd)
+		}
+	})
+}
+
+func TestTieredCompactionPickerDB(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.CompactionPicker = NewTieredCompactionPicker(TieredCompactionOptions{})
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	const flushes = 20
+	for i := 0; i < flushes; i++ {
+		for j := 0; j < 100; j++ {
+			k := []byte(fmt.Sprintf("%03d", j))
+			require.NoError(t, d.Set(k, []byte(fmt.Sprint(i)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	v := d.mu.versions.currentVersion()
+	sublevels := len(v.L0SublevelFiles)
+	d.mu.Unlock()
+	// Each flush produced a sorted run of the same size, so the tiered
+	// strategy must have merged them.
+	require.Less(t, sublevels, flushes)
+
+	iter := d.NewIter(nil)
+	var n int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		require.Equal(t, fmt.Sprint(flushes-1), string(iter.Value()))
+		n++
+	}
+	require.Equal(t, 100, n)
+	require.NoError(t, iter.Close())
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,310 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// timeWindowPropertyName is the name of the table property recording the
+// range of time windows spanned by a table's point keys.
+const timeWindowPropertyName = "pebble.time-window"
+
+// TimeWindowCompactionOptions configures the time-window compaction strategy.
+// See NewTimeWindowCompactionPicker.
+type TimeWindowCompactionOptions struct {
+	// Split is used to extract the suffix of a user key, which is passed to
+	// Window. It should be the Split function of the DB's Comparer.
+	//
+	// The default value uses DefaultComparer.Split, which treats the entire
+	// key as the prefix and so always produces an empty suffix.
+	Split Split
+
+	// Window returns the time window containing a key with the given suffix,
+	// or false if the key does not belong to a time window. Windows are
+	// ordered: a larger window holds more recent keys. Every key with the
+	// same user key must belong to the same window. Tables containing a key
+	// outside of any window, or a range deletion, are never compacted or
+	// dropped by the time-window strategy.
+	Window func(suffix []byte) (window uint64, ok bool)
+
+	// Expired returns true if every key within the window may be dropped.
+	// Once a window has expired, it must remain expired, and every older
+	// window must also have expired. A table is dropped once the newest
+	// window it contains has expired. Expired is called with DB.mu held, and
+	// must not block.
+	//
+	// The default value of nil never expires windows.
+	Expired func(window uint64) bool
+
+	// MinMergeWidth is the minimum number of L0 tables of the newest window
+	// merged by a compaction. Tables of older windows are merged as soon as a
+	// window holds two tables.
+	//
+	// The default value is 4.
+	MinMergeWidth int
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (o *TimeWindowCompactionOptions) EnsureDefaults() *TimeWindowCompactionOptions {
+	if o == nil {
+		o = &TimeWindowCompactionOptions{}
+	}
+	if o.Split == nil {
+		o.Split = DefaultComparer.Split
+	}
+	if o.Expired == nil {
+		o.Expired = func(uint64) bool { return false }
+	}
+	if o.MinMergeWidth < 2 {
+		o.MinMergeWidth = 4
+	}
+	return o
+}
+
+// TimeWindowCompactionPicker is a CompactionPicker implementing a time-window
+// compaction strategy for keyspaces whose keys carry a timestamp in their
+// suffix, such as time-series data with a bounded retention period.
+//
+// Tables are grouped by the newest time window containing their keys, as
+// determined by TimeWindowCompactionOptions.Window. Tables remain in L0, and
+// only tables within the same window are compacted together, so all of the
+// keys of a window are held by a small number of tables. A table is dropped
+// by a delete-only compaction, without reading or rewriting any data, once
+// its newest window has expired.
+//
+// The time windows of a table are recorded by a table property, which
+// requires the collector returned by TablePropertyCollector to be included in
+// Options.TablePropertyCollectors. Tables written without the property are
+// neither compacted nor dropped by the time-window strategy.
+//
+// Since every retained window occupies at least one L0 sublevel,
+// Options.L0StopWritesThreshold must exceed the number of retained windows.
+// Read-triggered compactions, which may compact L0 tables into lower levels,
+// should be disabled by setting Options.Experimental.ReadSamplingMultiplier
+// to -1.
+type TimeWindowCompactionPicker struct {
+	opts TimeWindowCompactionOptions
+}
+
+var _ CompactionPicker = (*TimeWindowCompactionPicker)(nil)
+
+// NewTimeWindowCompactionPicker returns a TimeWindowCompactionPicker
+// configured with the provided options.
+func NewTimeWindowCompactionPicker(opts TimeWindowCompactionOptions) *TimeWindowCompactionPicker {
+	return &TimeWindowCompactionPicker{opts: *opts.EnsureDefaults()}
+}
+
+// Name implements the CompactionPicker interface.
+func (p *TimeWindowCompactionPicker) Name() string {
+	return "pebble.time-window"
+}
+
+// TablePropertyCollector returns a TablePropertyCollector which records the
+// time windows spanned by a table's keys. It must be included in
+// Options.TablePropertyCollectors.
+func (p *TimeWindowCompactionPicker) TablePropertyCollector() TablePropertyCollector {
+	return &timeWindowCollector{split: p.opts.Split, window: p.opts.Window}
+}
+
+// PickAuto implements the CompactionPicker interface.
+func (p *TimeWindowCompactionPicker) PickAuto(env CompactionPickerEnv) *CompactionPick {
+	if pick := p.pickExpired(env); pick != nil {
+		return pick
+	}
+	return p.pickWindow(env)
+}
+
+// hasTimeWindow returns true if the time windows of f are known, and f may
+// be compacted or dropped by the time-window strategy.
+func hasTimeWindow(f *fileMetadata) bool {
+	return f.Stats.Valid && f.Stats.HasTimeWindow && !f.HasRangeKeys
+}
+
+// pickExpired returns a single delete-only compaction dropping every table,
+// across all levels, whose windows have all expired. Dropping only some of the
+// expired tables could expose keys shadowed by the dropped tables, such as an
+// older expired value beneath a newer expired tombstone, so nothing is dropped
+// while any expired table is being compacted.
+func (p *TimeWindowCompactionPicker) pickExpired(env CompactionPickerEnv) *CompactionPick {
+	var pick *CompactionPick
+	for level := 0; level < numLevels; level++ {
+		iter := env.Version.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if !hasTimeWindow(f) || !p.opts.Expired(f.Stats.MaxTimeWindow) {
+				continue
+			}
+			if f.Compacting {
+				return nil
+			}
+			if pick == nil {
+				pick = &CompactionPick{DeleteOnly: true}
+			}
+			pick.Files = append(pick.Files, f.FileNum)
+		}
+	}
+	return pick
+}
+
+// pickWindow returns an intra-L0 compaction merging the L0 tables of a single
+// window, preferring older windows.
+func (p *TimeWindowCompactionPicker) pickWindow(env CompactionPickerEnv) *CompactionPick {
+	windows := make(map[uint64][]*fileMetadata)
+	iter := env.Version.Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if !f.Compacting && hasTimeWindow(f) {
+			windows[f.Stats.MaxTimeWindow] = append(windows[f.Stats.MaxTimeWindow], f)
+		}
+	}
+	if len(windows) == 0 {
+		return nil
+	}
+	sorted := make([]uint64, 0, len(windows))
+	for w := range windows {
+		sorted = append(sorted, w)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	newest := sorted[len(sorted)-1]
+	for _, w := range sorted {
+		files := windows[w]
+		minWidth := 2
+		if w == newest {
+			minWidth = p.opts.MinMergeWidth
+		}
+		if len(files) < minWidth || !canMergeExactL0(env.Version, files) {
+			continue
+		}
+		pick := &CompactionPick{StartLevel: 0, OutputLevel: 0, ExactFiles: true}
+		for _, f := range files {
+			pick.Files = append(pick.Files, f.FileNum)
+		}
+		return pick
+	}
+	return nil
+}
+
+// canMergeExactL0 returns true if an intra-L0 compaction may be restricted to
+// exactly files. This requires that every other L0 table which may share a
+// user key with files, because its time windows overlap those of files or
+// are unknown, has sequence numbers entirely older or entirely newer than
+// those of files.
+func canMergeExactL0(v *Version, files []*fileMetadata) bool {
+	included := make(map[*fileMetadata]struct{}, len(files))
+	minWindow, maxWindow := files[0].Stats.MinTimeWindow, files[0].Stats.MaxTimeWindow
+	minSeqNum, maxSeqNum := files[0].SmallestSeqNum, files[0].LargestSeqNum
+	for _, f := range files {
+		included[f] = struct{}{}
+		if f.Stats.MinTimeWindow < minWindow {
+			minWindow = f.Stats.MinTimeWindow
+		}
+		if f.Stats.MaxTimeWindow > maxWindow {
+			maxWindow = f.Stats.MaxTimeWindow
+		}
+		if f.SmallestSeqNum < minSeqNum {
+			minSeqNum = f.SmallestSeqNum
+		}
+		if f.LargestSeqNum > maxSeqNum {
+			maxSeqNum = f.LargestSeqNum
+		}
+	}
+	iter := v.Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if _, ok := included[f]; ok {
+			continue
+		}
+		if hasTimeWindow(f) && (f.Stats.MaxTimeWindow < minWindow || f.Stats.MinTimeWindow > maxWindow) {
+			continue
+		}
+		if f.LargestSeqNum >= minSeqNum && f.SmallestSeqNum <= maxSeqNum {
+			return false
+		}
+	}
+	return true
+}
+
+// timeWindowCollector is a TablePropertyCollector which records the range of
+// time windows spanned by a table's point keys in the pebble.time-window
+// table property. The property is omitted if any key does not belong to a
+// time window, or the table contains a range deletion.
+type timeWindowCollector struct {
+	split    Split
+	window   func(suffix []byte) (uint64, bool)
+	min, max uint64
+	// any is true once a key has been added to the window range.
+	any bool
+	// unbounded is true if the table contains a key which does not belong to
+	// a time window.
+	unbounded bool
+}
+
+var _ TablePropertyCollector = (*timeWindowCollector)(nil)
+
+// Add implements the TablePropertyCollector interface.
+func (c *timeWindowCollector) Add(key InternalKey, value []byte) error {
+	if c.unbounded {
+		return nil
+	}
+	if key.Kind() == InternalKeyKindRangeDelete {
+		c.unbounded = true
+		return nil
+	}
+	w, ok := c.window(key.UserKey[c.split(key.UserKey):])
+	if !ok {
+		c.unbounded = true
+		return nil
+	}
+	if !c.any || w < c.min {
+		c.min = w
+	}
+	if !c.any || w > c.max {
+		c.max = w
+	}
+	c.any = true
+	return nil
+}
+
+// Finish implements the TablePropertyCollector interface.
+func (c *timeWindowCollector) Finish(userProps map[string]string) error {
+	if c.unbounded || !c.any {
+		return nil
+	}
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, c.min)
+	n += binary.PutUvarint(buf[n:], c.max)
+	userProps[timeWindowPropertyName] = string(buf[:n])
+	return nil
+}
+
+// Name implements the TablePropertyCollector interface.
+func (c *timeWindowCollector) Name() string {
+	return timeWindowPropertyName
+}
+
+// setTimeWindowStats populates the time window fields of stats from the
+// table's pebble.time-window property, if present.
+func setTimeWindowStats(stats *manifest.TableStats, props *sstable.Properties) {
+	v, ok := props.UserProperties[timeWindowPropertyName]
+	if !ok {
+		return
+	}
+	min, n := binary.Uvarint([]byte(v))
+	if n <= 0 {
+		return
+	}
+	max, m := binary.Uvarint([]byte(v[n:]))
+	if m <= 0 || max < min {
+		return
+	}
+	stats.HasTimeWindow = true
+	stats.MinTimeWindow = min
+	stats.MaxTimeWindow = max
+}
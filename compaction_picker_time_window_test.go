@@ -0,0 +1,289 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// testkeysWindow returns the timestamp of a testkeys suffix as its time
+// window.
+func testkeysWindow(suffix []byte) (uint64, bool) {
+	if len(suffix) == 0 {
+		return 0, false
+	}
+	ts, err := strconv.ParseUint(string(suffix[1:]), 10, 64)
+	return ts, err == nil
+}
+
+func TestTimeWindowCollector(t *testing.T) {
+	p := NewTimeWindowCompactionPicker(TimeWindowCompactionOptions{
+		Split:  testkeys.Comparer.Split,
+		Window: testkeysWindow,
+	})
+	collect := func(keys ...InternalKey) manifest.TableStats {
+		c := p.TablePropertyCollector()
+		for _, k := range keys {
+			require.NoError(t, c.Add(k, nil))
+		}
+		props := &sstable.Properties{UserProperties: map[string]string{}}
+		require.NoError(t, c.Finish(props.UserProperties))
+		var stats manifest.TableStats
+		setTimeWindowStats(&stats, props)
+		return stats
+	}
+	set := func(k string) InternalKey {
+		return base.MakeInternalKey([]byte(k), 1, InternalKeyKindSet)
+	}
+
+	stats := collect(set("a@7"), set("b@3"), set("c@300"))
+	require.True(t, stats.HasTimeWindow)
+	require.Equal(t, uint64(3), stats.MinTimeWindow)
+	require.Equal(t, uint64(300), stats.MaxTimeWindow)
+
+	// A key outside of any window, or a range deletion, omits the property.
+	require.False(t, collect(set("a@7"), set("b")).HasTimeWindow)
+	require.False(t, collect(set("a@7"),
+		base.MakeInternalKey([]byte("b@3"), 1, InternalKeyKindRangeDelete)).HasTimeWindow)
+	require.False(t, collect().HasTimeWindow)
+}
+
+func TestCanMergeExactL0(t *testing.T) {
+	opts := (*Options)(nil).EnsureDefaults()
+	newFile := func(fileNum FileNum, smallestSeqNum, largestSeqNum, minWindow, maxWindow uint64) *fileMetadata {
+		m := (&fileMetadata{FileNum: fileNum}).ExtendPointKeyBounds(opts.Comparer.Compare,
+			base.MakeInternalKey([]byte("a"), largestSeqNum, InternalKeyKindSet),
+			base.MakeInternalKey([]byte("z"), smallestSeqNum, InternalKeyKindSet))
+		m.SmallestSeqNum, m.LargestSeqNum = smallestSeqNum, largestSeqNum
+		m.Stats = manifest.TableStats{
+			Valid:         true,
+			HasTimeWindow: maxWindow > 0,
+			MinTimeWindow: minWindow,
+			MaxTimeWindow: maxWindow,
+		}
+		return m
+	}
+
+	testCases := []struct {
+		name     string
+		excluded *fileMetadata
+		expected bool
+	}{
+		{"disjoint-windows", newFile(3, 15, 16, 1, 1), true},
+		{"older", newFile(3, 1, 2, 2, 2), true},
+		{"newer", newFile(3, 30, 31, 2, 2), true},
+		{"interleaved", newFile(3, 15, 16, 2, 3), false},
+		{"unknown-windows", newFile(3, 15, 16, 0, 0), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			files := []*fileMetadata{newFile(1, 10, 11, 2, 2), newFile(2, 20, 21, 2, 2)}
+			var levels [numLevels][]*fileMetadata
+			levels[0] = append([]*fileMetadata{tc.excluded}, files...)
+			v := newVersion(opts, levels)
+			require.Equal(t, tc.expected, canMergeExactL0(v, files))
+		})
+	}
+}
+
+func TestTimeWindowCompactionPicker(t *testing.T) {
+	var expiredThrough uint64
+	p := NewTimeWindowCompactionPicker(TimeWindowCompactionOptions{
+		Split:  testkeys.Comparer.Split,
+		Window: testkeysWindow,
+		Expired: func(window uint64) bool {
+			return window <= atomic.LoadUint64(&expiredThrough)
+		},
+	})
+
+	var deleteOnly int
+	opts := &Options{
+		Comparer:              testkeys.Comparer,
+		FS:                    vfs.NewMem(),
+		L0StopWritesThreshold: 100,
+		EventListener: EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				if info.Reason == "delete-only" {
+					deleteOnly++
+				}
+			},
+		},
+		TablePropertyCollectors: []func() TablePropertyCollector{p.TablePropertyCollector},
+	}
+	opts.Experimental.CompactionPicker = p
+	opts.Experimental.ReadSamplingMultiplier = -1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	waitForCompactions := func() {
+		d.mu.Lock()
+		d.maybeScheduleCompaction()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		d.mu.Unlock()
+	}
+	windowFiles := func() map[uint64]int {
+		d.mu.Lock()
+		v := d.mu.versions.currentVersion()
+		d.mu.Unlock()
+		for level := 1; level < numLevels; level++ {
+			require.True(t, v.Levels[level].Empty())
+		}
+		m := map[uint64]int{}
+		iter := v.Levels[0].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			require.True(t, f.Stats.HasTimeWindow)
+			require.Equal(t, f.Stats.MinTimeWindow, f.Stats.MaxTimeWindow)
+			m[f.Stats.MaxTimeWindow]++
+		}
+		return m
+	}
+
+	// Write two tables for each of three windows. Every key is written to
+	// every window.
+	ks := testkeys.Alpha(1)
+	for w := 1; w <= 3; w++ {
+		for i := 0; i < 2; i++ {
+			for j := 0; j < ks.Count(); j++ {
+				require.NoError(t, d.Set(testkeys.KeyAt(ks, j, w), []byte("v"), nil))
+			}
+			require.NoError(t, d.Flush())
+			waitForCompactions()
+		}
+	}
+	// The tables of the older windows were merged. The tables of the newest
+	// window are left until there are MinMergeWidth of them.
+	require.Equal(t, map[uint64]int{1: 1, 2: 1, 3: 2}, windowFiles())
+
+	countKeys := func() map[int]int {
+		m := map[int]int{}
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			w, ok := testkeysWindow(iter.Key()[testkeys.Comparer.Split(iter.Key()):])
+			require.True(t, ok)
+			m[int(w)]++
+		}
+		require.NoError(t, iter.Close())
+		return m
+	}
+	require.Equal(t, map[int]int{1: ks.Count(), 2: ks.Count(), 3: ks.Count()}, countKeys())
+
+	// Expiring the first window drops its table with a delete-only
+	// compaction.
+	atomic.StoreUint64(&expiredThrough, 1)
+	waitForCompactions()
+	require.Equal(t, map[uint64]int{2: 1, 3: 2}, windowFiles())
+	require.Equal(t, map[int]int{2: ks.Count(), 3: ks.Count()}, countKeys())
+	d.mu.Lock()
+	n := deleteOnly
+	d.mu.Unlock()
+	require.Equal(t, 1, n)
+}
+
+func TestTimeWindowPickExpired(t *testing.T) {
+	opts := (*Options)(nil).EnsureDefaults()
+	newFile := func(fileNum FileNum, key string, seqNum, window uint64) *fileMetadata {
+		m := (&fileMetadata{FileNum: fileNum}).ExtendPointKeyBounds(opts.Comparer.Compare,
+			base.MakeInternalKey([]byte(key), seqNum, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(key), seqNum, InternalKeyKindSet))
+		m.SmallestSeqNum, m.LargestSeqNum = seqNum, seqNum
+		m.Stats = manifest.TableStats{
+			Valid:         true,
+			HasTimeWindow: true,
+			MinTimeWindow: window,
+			MaxTimeWindow: window,
+		}
+		return m
+	}
+	p := NewTimeWindowCompactionPicker(TimeWindowCompactionOptions{
+		Expired: func(window uint64) bool { return window <= 1 },
+	})
+
+	// The expired tables of every level are dropped by a single pick.
+	var levels [numLevels][]*fileMetadata
+	levels[0] = []*fileMetadata{newFile(3, "a", 30, 1), newFile(4, "b", 40, 2)}
+	levels[6] = []*fileMetadata{newFile(1, "a", 10, 1), newFile(2, "b", 20, 1)}
+	v := newVersion(opts, levels)
+	pick := p.pickExpired(CompactionPickerEnv{Version: v})
+	require.NotNil(t, pick)
+	require.True(t, pick.DeleteOnly)
+	require.Equal(t, []FileNum{3, 1, 2}, pick.Files)
+
+	// Nothing is dropped while an expired table is compacting.
+	levels[6][0].Compacting = true
+	require.Nil(t, p.pickExpired(CompactionPickerEnv{Version: v}))
+}
+
+func TestTimeWindowCompactionPickerShadowed(t *testing.T) {
+	var expiredThrough uint64
+	p := NewTimeWindowCompactionPicker(TimeWindowCompactionOptions{
+		Split:  testkeys.Comparer.Split,
+		Window: testkeysWindow,
+		Expired: func(window uint64) bool {
+			return window <= atomic.LoadUint64(&expiredThrough)
+		},
+	})
+
+	var deleteOnly int
+	opts := &Options{
+		Comparer: testkeys.Comparer,
+		FS:       vfs.NewMem(),
+		EventListener: EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				if info.Reason == "delete-only" {
+					deleteOnly++
+				}
+			},
+		},
+		TablePropertyCollectors: []func() TablePropertyCollector{p.TablePropertyCollector},
+	}
+	opts.Experimental.CompactionPicker = p
+	opts.Experimental.ReadSamplingMultiplier = -1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// An older value of the first window is compacted into L6, and is
+	// shadowed by a newer tombstone of the same window in L0.
+	require.NoError(t, d.Set([]byte("a@1"), []byte("v"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false /* parallelize */))
+	require.NoError(t, d.Delete([]byte("a@1"), nil))
+	require.NoError(t, d.Flush())
+	levelCounts := func() (counts [numLevels]int) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		v := d.mu.versions.currentVersion()
+		for level := range counts {
+			counts[level] = v.Levels[level].Len()
+		}
+		return counts
+	}
+	require.Equal(t, [numLevels]int{0: 1, 6: 1}, levelCounts())
+
+	// Expiring the window drops the tombstone and the value it shadows with
+	// a single delete-only compaction, so the value is never exposed.
+	atomic.StoreUint64(&expiredThrough, 1)
+	d.mu.Lock()
+	d.waitTableStats()
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	n := deleteOnly
+	d.mu.Unlock()
+	require.Equal(t, 1, n)
+	require.Equal(t, [numLevels]int{}, levelCounts())
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrCancelledCompaction is returned by a compaction cancelled through
+// DB.CancelCompaction. DB.Compact returns it if one of the compactions it
+// requested is cancelled, whether running or queued.
+var ErrCancelledCompaction = errors.New("pebble: compaction cancelled")
+
+// CompactionPriority is the priority of a manual compaction relative to
+// automatic compactions. See CompactOptions.Priority.
+type CompactionPriority int8
+
+const (
+	// CompactionPriorityHigh schedules a manual compaction ahead of automatic
+	// compactions. It is the default.
+	CompactionPriorityHigh CompactionPriority = iota
+	// CompactionPriorityLow schedules a manual compaction only when no
+	// automatic compaction, such as an L0 -> Lbase compaction, may be
+	// scheduled within the compaction concurrency limit. A stream of
+	// automatic compactions may delay it indefinitely. Queued manual
+	// compactions of high priority are scheduled ahead of it.
+	CompactionPriorityLow
+)
+
+// String implements fmt.Stringer.
+func (p CompactionPriority) String() string {
+	switch p {
+	case CompactionPriorityHigh:
+		return "high"
+	case CompactionPriorityLow:
+		return "low"
+	default:
+		return "unknown"
+	}
+}
+
+// CompactionStatus describes a running compaction, or a manual compaction
+// which is queued. See DB.Compactions.
+type CompactionStatus struct {
+	// ID identifies the compaction to DB.CancelCompaction. IDs are unique
+	// within a DB instance, and are not reused. A queued manual compaction
+	// keeps its ID once it is running.
+	ID uint64
+	// Kind is the kind of compaction, such as "default", "move",
+	// "delete-only" or "read". It matches CompactionInfo.Reason. The kind of
+	// a queued manual compaction is "default", or "rewrite" if it rewrites
+	// its tables in place, as it is not known until the compaction is picked.
+	Kind string
+	// Manual is true if the compaction was requested through DB.Compact.
+	Manual bool
+	// Queued is true if the manual compaction is waiting to be scheduled. The
+	// input tables of a queued compaction are not known until it is picked,
+	// so its InputBytes and BytesIterated are zero.
+	Queued bool
+	// Priority is the priority of a manual compaction. See
+	// CompactOptions.Priority.
+	Priority CompactionPriority
+	// InputLevels are the levels of the compaction's input tables.
+	InputLevels []int
+	// OutputLevel is the level to which the compaction writes its output.
+	// It is set to the bottommost level for delete-only compactions, which
+	// produce no output.
+	OutputLevel int
+	// InputBytes is the total size of the compaction's input tables.
+	InputBytes uint64
+	// BytesIterated is the number of input bytes consumed so far, summed over
+	// the subcompactions of the compaction.
+	BytesIterated uint64
+	// Duration is the time elapsed since the compaction was scheduled, or
+	// queued if it is queued.
+	Duration time.Duration
+}
+
+// Compactions returns the compactions currently running, and the manual
+// compactions queued, ordered by ID. Flushes are not included.
+func (d *DB) Compactions() []CompactionStatus {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.timeNow()
+	var statuses []CompactionStatus
+	for c := range d.mu.compact.inProgress {
+		if c.kind == compactionKindFlush {
+			continue
+		}
+		s := CompactionStatus{
+			ID:            c.id,
+			Kind:          c.kind.String(),
+			Manual:        c.manual,
+			OutputLevel:   numLevels - 1,
+			BytesIterated: atomic.LoadUint64(&c.atomic.bytesIterated),
+			Duration:      now.Sub(c.startTime),
+		}
+		if c.outputLevel != nil {
+			s.OutputLevel = c.outputLevel.level
+		}
+		for _, cl := range c.inputs {
+			if cl.files.Empty() {
+				continue
+			}
+			s.InputLevels = append(s.InputLevels, cl.level)
+			s.InputBytes += cl.files.SizeSum()
+		}
+		if c.manual {
+			s.Priority = c.manualPriority
+		}
+		statuses = append(statuses, s)
+	}
+	baseLevel := d.mu.versions.picker.getBaseLevel()
+	for _, m := range d.mu.compact.manual {
+		kind := compactionKindDefault
+		if m.rewrite {
+			kind = compactionKindRewrite
+		}
+		statuses = append(statuses, CompactionStatus{
+			ID:          m.id,
+			Kind:        kind.String(),
+			Manual:      true,
+			Queued:      true,
+			Priority:    m.priority,
+			InputLevels: []int{m.level},
+			OutputLevel: m.outputLevelFor(baseLevel),
+			Duration:    now.Sub(m.queuedTime),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// CancelCompaction requests that the running compaction with the provided ID
+// stop. The compaction stops at the next key it processes, discards its
+// output, and leaves its input tables in place; its CompactionEnd event
+// reports ErrCancelledCompaction. Cancelling a compaction does not prevent
+// the same tables from being picked again, so an automatic compaction may be
+// rescheduled immediately. A queued manual compaction with the ID is removed
+// from the queue, and the DB.Compact call which requested it returns
+// ErrCancelledCompaction.
+//
+// CancelCompaction returns false if no compaction with the ID is running or
+// queued. Compactions which do not read their inputs, such as move and
+// delete-only compactions, complete without checking for cancellation.
+func (d *DB) CancelCompaction(id uint64) bool {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for c := range d.mu.compact.inProgress {
+		if c.id == id && c.kind != compactionKindFlush {
+			atomic.StoreUint32(&c.atomic.cancelled, 1)
+			return true
+		}
+	}
+	for i, m := range d.mu.compact.manual {
+		if m.id == id {
+			d.removeManualCompactionLocked(i)
+			m.done <- ErrCancelledCompaction
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelCompaction(t *testing.T) {
+	var d *DB
+	var cancel bool
+	var statuses []CompactionStatus
+	var cancelled []bool
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		EventListener: EventListener{
+			// TableCreated is called without DB.mu held, after the compaction
+			// has written its first key.
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason != "compacting" || !cancel {
+					return
+				}
+				for _, s := range d.Compactions() {
+					statuses = append(statuses, s)
+					cancelled = append(cancelled, d.CancelCompaction(s.ID))
+				}
+			},
+		},
+	}
+	var err error
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", j)), []byte(fmt.Sprint(i)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	cancel = true
+	err = d.Compact([]byte("000"), []byte("100"), false)
+	require.True(t, errors.Is(err, ErrCancelledCompaction), "unexpected error: %v", err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, []bool{true}, cancelled)
+	s := statuses[0]
+	require.True(t, s.Manual)
+	require.Equal(t, "default", s.Kind)
+	require.Equal(t, []int{0}, s.InputLevels)
+	require.Equal(t, numLevels-1, s.OutputLevel)
+	require.NotZero(t, s.InputBytes)
+
+	// The cancelled compaction left its inputs in place.
+	require.Empty(t, d.Compactions())
+	require.False(t, d.CancelCompaction(s.ID))
+	d.mu.Lock()
+	l0 := d.mu.versions.currentVersion().Levels[0].Len()
+	d.mu.Unlock()
+	require.Equal(t, 2, l0)
+
+	cancel = false
+	require.NoError(t, d.Compact([]byte("000"), []byte("100"), false))
+	d.mu.Lock()
+	l0 = d.mu.versions.currentVersion().Levels[0].Len()
+	d.mu.Unlock()
+	require.Equal(t, 0, l0)
+
+	iter := d.NewIter(nil)
+	var n int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		require.Equal(t, "1", string(iter.Value()))
+		n++
+	}
+	require.Equal(t, 100, n)
+	require.NoError(t, iter.Close())
+}
+
+func TestManualCompactionPriority(t *testing.T) {
+	for _, priority := range []CompactionPriority{CompactionPriorityHigh, CompactionPriorityLow} {
+		t.Run(priority.String(), func(t *testing.T) {
+			opts := &Options{
+				FS:                       vfs.NewMem(),
+				L0CompactionThreshold:    100,
+				L0StopWritesThreshold:    100,
+				MaxConcurrentCompactions: 1,
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			for i := 0; i < 2; i++ {
+				for _, k := range []string{"a", "m", "z"} {
+					require.NoError(t, d.Set([]byte(k), []byte(fmt.Sprint(i)), nil))
+				}
+				require.NoError(t, d.Flush())
+			}
+
+			// Queue a manual compaction while an automatic L0 -> Lbase
+			// compaction is also eligible. With a concurrency limit of one,
+			// only the compaction with the higher priority is scheduled.
+			manual := &manualCompaction{
+				level:    0,
+				priority: priority,
+				done:     make(chan error, 1),
+				start:    []byte("a"),
+				end:      []byte("z"),
+			}
+			d.mu.Lock()
+			d.opts.L0CompactionThreshold = 1
+			d.queueManualCompactionsLocked(manual)
+			d.maybeScheduleCompaction()
+			var scheduled []bool
+			for c := range d.mu.compact.inProgress {
+				scheduled = append(scheduled, c.manual)
+			}
+			d.mu.Unlock()
+
+			require.Equal(t, []bool{priority == CompactionPriorityHigh}, scheduled)
+			require.NoError(t, <-manual.done)
+		})
+	}
+}
+
+func TestCancelQueuedCompaction(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                       vfs.NewMem(),
+		MaxConcurrentCompactions: 1,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("z"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+
+	// Occupy the only compaction slot, so that the manual compaction remains
+	// queued.
+	d.mu.Lock()
+	d.mu.compact.compactingCount++
+	d.mu.Unlock()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.CompactWithOptions(context.Background(), []byte("a"), []byte("z"),
+			CompactOptions{Priority: CompactionPriorityLow})
+	}()
+
+	var queued CompactionStatus
+	require.Eventually(t, func() bool {
+		for _, s := range d.Compactions() {
+			if s.Queued {
+				queued = s
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, time.Millisecond)
+	require.True(t, queued.Manual)
+	require.Equal(t, "default", queued.Kind)
+	require.Equal(t, CompactionPriorityLow, queued.Priority)
+	require.Equal(t, []int{0}, queued.InputLevels)
+
+	require.True(t, d.CancelCompaction(queued.ID))
+	require.True(t, errors.Is(<-errCh, ErrCancelledCompaction))
+	require.False(t, d.CancelCompaction(queued.ID))
+	require.Empty(t, d.Compactions())
+
+	d.mu.Lock()
+	d.mu.compact.compactingCount--
+	d.mu.Unlock()
+}
@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -28,6 +29,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -1398,7 +1400,9 @@ func TestManualCompaction(t *testing.T) {
 		{
 			testData:   "testdata/manual_compaction_set_with_del",
 			minVersion: FormatSetWithDelete,
-			maxVersion: FormatNewest,
+			// The table stats in the test data depend on the size of the
+			// sstables, which differs for columnar data blocks.
+			maxVersion: FormatColumnarBlocks - 1,
 		},
 		{
 			testData:   "testdata/singledel_manual_compaction",
@@ -3066,6 +3070,42 @@ func TestFlushError(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestCompactionCompressionConcurrency(t *testing.T) {
+	// Verify that flushes and compactions using the DB's compression pool
+	// produce sstables with the expected contents.
+	opts := testingRandomized(&Options{
+		FS:     vfs.NewMem(),
+		Levels: []LevelOptions{{BlockSize: 256, Compression: ZstdCompression, TargetFileSize: 16 << 10}},
+	})
+	opts.Experimental.CompressionConcurrency = 2
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NotNil(t, d.compressionPool)
+	require.Equal(t, 2, d.compressionPool.Workers())
+
+	ks := testkeys.Alpha(3)
+	for i := 0; i < 4; i++ {
+		for j := i; j < ks.Count(); j += 4 {
+			k := testkeys.Key(ks, j)
+			require.NoError(t, d.Set(k, k, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("zzz"), false /* parallelize */))
+
+	iter := d.NewIter(nil)
+	var j int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		k := testkeys.Key(ks, j)
+		require.Equal(t, k, iter.Key())
+		require.Equal(t, k, iter.Value())
+		j++
+	}
+	require.Equal(t, ks.Count(), j)
+	require.NoError(t, iter.Close())
+	require.NoError(t, d.Close())
+}
+
 func TestAdjustGrandparentOverlapBytesForFlush(t *testing.T) {
 	// 500MB in Lbase
 	var lbaseFiles []*manifest.FileMetadata
@@ -3447,3 +3487,507 @@ func TestMarkedForCompaction(t *testing.T) {
 		}
 	})
 }
+
+func TestTombstoneDensityCompaction(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+	const n = 2000
+
+	writeDeletes := func(every int) func(w *sstable.Writer) error {
+		return func(w *sstable.Writer) error {
+			for i := 0; i < n; i++ {
+				var err error
+				if i%every == 0 {
+					err = w.Delete(key(i))
+				} else {
+					err = w.Set(key(i), []byte("b"))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	writeDeleteRange := func(w *sstable.Writer) error {
+		return w.DeleteRange(key(0), key(n))
+	}
+
+	testCases := []struct {
+		name string
+		// configure sets the thresholds on the options.
+		configure func(opts *Options)
+		// data, if set, writes n keys which are compacted into L6 before the
+		// tombstones are ingested. The ingested sstable then lands in L5,
+		// and otherwise in L6.
+		data bool
+		// write writes the tombstones into the ingested sstable.
+		write     func(w *sstable.Writer) error
+		compacted bool
+	}{
+		{
+			name:      "point-disabled",
+			configure: func(opts *Options) {},
+			write:     writeDeletes(1),
+			compacted: false,
+		},
+		{
+			name: "point",
+			configure: func(opts *Options) {
+				opts.Experimental.TombstoneDensityThreshold = 0.5
+			},
+			write:     writeDeletes(1),
+			compacted: true,
+		},
+		{
+			name: "point-below-threshold",
+			configure: func(opts *Options) {
+				opts.Experimental.TombstoneDensityThreshold = 0.5
+			},
+			// Only a third of the entries are deletions.
+			write:     writeDeletes(3),
+			compacted: false,
+		},
+		{
+			name:      "range-disabled",
+			configure: func(opts *Options) {},
+			data:      true,
+			write:     writeDeleteRange,
+			compacted: false,
+		},
+		{
+			name: "range",
+			configure: func(opts *Options) {
+				opts.Experimental.RangeDeletionCoverageThreshold = 4
+			},
+			data:      true,
+			write:     writeDeleteRange,
+			compacted: true,
+		},
+		{
+			name: "range-below-threshold",
+			configure: func(opts *Options) {
+				opts.Experimental.RangeDeletionCoverageThreshold = 1e9
+			},
+			data:      true,
+			write:     writeDeleteRange,
+			compacted: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := vfs.NewMem()
+			opts := &Options{
+				FS: mem,
+				// A small LBaseMaxBytes makes L5 the base level once L6 holds
+				// the data.
+				LBaseMaxBytes: 64 << 10,
+			}
+			tc.configure(opts)
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			ingestLevel := 6
+			if tc.data {
+				rng := rand.New(rand.NewSource(1))
+				value := make([]byte, 100)
+				for i := 0; i < n; i++ {
+					rng.Read(value)
+					require.NoError(t, d.Set(key(i), value, nil))
+				}
+				require.NoError(t, d.Compact(key(0), key(n), false))
+				ingestLevel = 5
+			}
+
+			f, err := mem.Create("ext")
+			require.NoError(t, err)
+			w := sstable.NewWriter(f, sstable.WriterOptions{})
+			require.NoError(t, tc.write(w))
+			require.NoError(t, w.Close())
+			require.NoError(t, d.Ingest([]string{"ext"}))
+
+			// Wait for the ingested table's stats to be loaded, and for any
+			// compaction they trigger to complete.
+			d.mu.Lock()
+			d.waitTableStats()
+			for d.mu.compact.compactingCount > 0 {
+				d.mu.compact.cond.Wait()
+			}
+			v := d.mu.versions.currentVersion()
+			remaining := v.Levels[ingestLevel].Len()
+			d.mu.Unlock()
+
+			m := d.Metrics()
+			if tc.compacted {
+				require.Equal(t, int64(1), m.Compact.TombstoneDensityCount)
+				require.Equal(t, 0, remaining, "%s", v)
+			} else {
+				require.Zero(t, m.Compact.TombstoneDensityCount)
+				// A range deletion inflates the compensated size of its file,
+				// which may independently trigger a default compaction.
+				if !tc.data {
+					require.Equal(t, 1, remaining, "%s", v)
+				}
+			}
+		})
+	}
+}
+
+func TestPeriodicCompaction(t *testing.T) {
+	const age = time.Hour
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			opts := &Options{FS: vfs.NewMem()}
+			if enabled {
+				opts.Experimental.PeriodicCompactionAge = age
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			var now int64 // atomic; nanoseconds since the Unix epoch.
+			atomic.StoreInt64(&now, time.Now().UnixNano())
+			d.mu.Lock()
+			d.timeNow = func() time.Time { return time.Unix(0, atomic.LoadInt64(&now)) }
+			d.mu.Unlock()
+			advance := func(dur time.Duration) { atomic.AddInt64(&now, int64(dur)) }
+
+			scheduleAndWait := func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				d.maybeScheduleCompaction()
+				for d.mu.compact.compactingCount > 0 {
+					d.mu.compact.cond.Wait()
+				}
+			}
+			levelFiles := func() (l0, l6 int, creationTimes []int64) {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				v := d.mu.versions.currentVersion()
+				for _, l := range []int{0, 6} {
+					iter := v.Levels[l].Iter()
+					for f := iter.First(); f != nil; f = iter.Next() {
+						creationTimes = append(creationTimes, f.CreationTime)
+					}
+				}
+				return v.Levels[0].Len(), v.Levels[6].Len(), creationTimes
+			}
+
+			// Write a table to L6, and a non-overlapping table to L0.
+			require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+			require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+			require.NoError(t, d.Set([]byte("x"), []byte("x"), nil))
+			require.NoError(t, d.Flush())
+			l0, l6, _ := levelFiles()
+			require.Equal(t, 1, l0)
+			require.Equal(t, 1, l6)
+
+			// The tables are not yet old enough to be compacted.
+			advance(age / 2)
+			scheduleAndWait()
+			require.Zero(t, d.Metrics().Compact.PeriodicCount)
+
+			// Once they are, the L0 table is compacted into L6, and the L6 table
+			// is rewritten in place.
+			advance(age)
+			scheduleAndWait()
+			l0, l6, creationTimes := levelFiles()
+			if !enabled {
+				require.Zero(t, d.Metrics().Compact.PeriodicCount)
+				require.Equal(t, 1, l0)
+				require.Equal(t, 1, l6)
+				return
+			}
+			require.Equal(t, int64(2), d.Metrics().Compact.PeriodicCount)
+			require.Equal(t, 0, l0)
+			require.Equal(t, 2, l6)
+			for _, ct := range creationTimes {
+				require.Equal(t, time.Unix(0, atomic.LoadInt64(&now)).Unix(), ct)
+			}
+		})
+	}
+}
+
+func TestPeriodicCompactionL0(t *testing.T) {
+	const age = time.Hour
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.PeriodicCompactionAge = age
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	now := time.Now()
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return now }
+	d.mu.Unlock()
+
+	// Flush an old table to L0, followed by a stack of newer tables which
+	// overlap each other but not the old table. A score-based L0 compaction
+	// would pick the stack, as it contributes the most read amplification.
+	require.NoError(t, d.Set([]byte("m"), []byte("m"), nil))
+	require.NoError(t, d.Flush())
+	now = now.Add(age / 2)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.Set([]byte("x"), []byte(fmt.Sprint(i)), nil))
+		require.NoError(t, d.Flush())
+	}
+	now = now.Add(age/2 + time.Second)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := d.mu.versions.currentVersion()
+	require.Equal(t, 4, v.Levels[0].Len())
+	iter := v.Levels[0].Iter()
+	old := iter.First()
+	require.Equal(t, "m", string(old.Smallest.UserKey))
+
+	// The periodic compaction is built from the old table.
+	p := d.mu.versions.picker.(*compactionPickerByScore)
+	pc := p.pickPeriodicCompaction(compactionEnv{
+		earliestSnapshotSeqNum:  InternalKeySeqNumMax,
+		earliestUnflushedSeqNum: InternalKeySeqNumMax,
+		now:                     now,
+	})
+	require.NotNil(t, pc)
+	require.Equal(t, compactionKindPeriodic, pc.kind)
+	require.Equal(t, 0, pc.startLevel.level)
+	require.Equal(t, 1, pc.startLevel.files.Len())
+	iter = pc.startLevel.files.Iter()
+	require.Equal(t, old, iter.First())
+}
+
+func TestCompactWithOptionsMaxConcurrency(t *testing.T) {
+	// The first table written by a compaction blocks until release is closed.
+	var created, begun int32
+	release := make(chan struct{})
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		MaxConcurrentCompactions:    4,
+		EventListener: EventListener{
+			CompactionBegin: func(CompactionInfo) { atomic.AddInt32(&begun, 1) },
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason == "compacting" && atomic.AddInt32(&created, 1) == 1 {
+					<-release
+				}
+			},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write three non-overlapping tables to L6.
+	for _, k := range []string{"a", "m", "x"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Compact([]byte(k), []byte(k+"\x00"), false))
+	}
+	d.mu.Lock()
+	require.Equal(t, 3, d.mu.versions.currentVersion().Levels[numLevels-1].Len())
+	d.mu.Unlock()
+	atomic.StoreInt32(&created, 0)
+	atomic.StoreInt32(&begun, 0)
+
+	// Rewrite the tables with at most two compactions at once. While the
+	// first compaction is blocked, the third is queued as soon as the second
+	// completes.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.CompactWithOptions(context.Background(), []byte("a"), []byte("z"), CompactOptions{
+			RewriteBottommost: true,
+			Parallelize:       true,
+			MaxConcurrency:    2,
+		})
+	}()
+	err = try(100*time.Microsecond, 20*time.Second, func() error {
+		if n := atomic.LoadInt32(&begun); n < 3 {
+			return errors.Errorf("%d compactions begun", n)
+		}
+		return nil
+	})
+	close(release)
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+}
+
+func TestCompactWithOptionsSplitError(t *testing.T) {
+	// Once enabled, the first table created by a compaction blocks until
+	// release is closed, and the creation of the second fails.
+	var enabled, creates int32
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	fs := errorfs.Wrap(vfs.NewMem(), errorfs.InjectorFunc(func(op errorfs.Op, path string) error {
+		if op == errorfs.OpCreate && filepath.Ext(path) == ".sst" &&
+			atomic.LoadInt32(&enabled) == 1 && atomic.AddInt32(&creates, 1) == 2 {
+			return errorfs.ErrInjected
+		}
+		return nil
+	}))
+	opts := &Options{
+		FS:                          fs,
+		DisableAutomaticCompactions: true,
+		MaxConcurrentCompactions:    2,
+		EventListener: EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason == "compacting" && atomic.LoadInt32(&enabled) == 1 &&
+					atomic.LoadInt32(&creates) == 1 {
+					close(blocked)
+					<-release
+				}
+			},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write three non-overlapping tables to L6.
+	for _, k := range []string{"a", "m", "x"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Compact([]byte(k), []byte(k+"\x00"), false))
+	}
+	atomic.StoreInt32(&enabled, 1)
+
+	// Rewrite the tables with two compactions at once. The failure of the
+	// second cancels the others, and the error is only returned once the
+	// blocked first compaction has completed.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.CompactWithOptions(context.Background(), []byte("a"), []byte("z"), CompactOptions{
+			RewriteBottommost: true,
+			Parallelize:       true,
+		})
+	}()
+	<-blocked
+	select {
+	case err := <-errCh:
+		close(release)
+		t.Fatalf("returned %v while a compaction was running", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.True(t, errors.Is(<-errCh, errorfs.ErrInjected))
+	d.mu.Lock()
+	require.Equal(t, 0, d.mu.compact.compactingCount)
+	require.Empty(t, d.mu.compact.manual)
+	d.mu.Unlock()
+}
+
+func TestCompactWithOptionsExclusive(t *testing.T) {
+	var block int32 // atomic
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	opts := &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 1,
+		EventListener: EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason == "compacting" && atomic.CompareAndSwapInt32(&block, 1, 0) {
+					close(blocked)
+					<-release
+				}
+			},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	waitForCompactions := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	l0Files := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.versions.currentVersion().Levels[0].Len()
+	}
+
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	waitForCompactions()
+
+	// Block an exclusive rewrite of the table in its compaction.
+	atomic.StoreInt32(&block, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.CompactWithOptions(context.Background(), []byte("a"), []byte("b"), CompactOptions{
+			RewriteBottommost: true,
+			Exclusive:         true,
+		})
+	}()
+	<-blocked
+
+	// A flush to L0 does not trigger an automatic compaction until the
+	// exclusive compaction completes.
+	require.NoError(t, d.Set([]byte("x"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 1, l0Files())
+	for _, s := range d.Compactions() {
+		require.True(t, s.Manual, "unexpected automatic compaction %+v", s)
+	}
+
+	close(release)
+	require.NoError(t, <-errCh)
+	waitForCompactions()
+	require.Equal(t, 0, l0Files())
+}
+
+func TestCompactWithOptionsCancel(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Flush())
+
+	// Prevent the manual compaction from being scheduled, so that it remains
+	// queued until the context is cancelled.
+	d.mu.Lock()
+	d.opts.MaxConcurrentCompactions = 0
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.CompactWithOptions(ctx, []byte("a"), []byte("c"), CompactOptions{})
+	}()
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, func() error {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if len(d.mu.compact.manual) == 0 {
+			return errors.New("no manual compaction queued")
+		}
+		return nil
+	}))
+	cancel()
+	require.True(t, errors.Is(<-errCh, context.Canceled))
+
+	// The queued manual compaction was dropped, and the table remains in L0.
+	d.mu.Lock()
+	d.opts.MaxConcurrentCompactions = 1
+	d.maybeScheduleCompaction()
+	queued := len(d.mu.compact.manual)
+	l0 := d.mu.versions.currentVersion().Levels[0].Len()
+	d.mu.Unlock()
+	require.Zero(t, queued)
+	require.Equal(t, 1, l0)
+
+	// A context which is not cancelled compacts the table.
+	require.NoError(t, d.CompactWithOptions(context.Background(), []byte("a"), []byte("c"), CompactOptions{}))
+	d.mu.Lock()
+	l0 = d.mu.versions.currentVersion().Levels[0].Len()
+	d.mu.Unlock()
+	require.Zero(t, l0)
+}
@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
@@ -93,6 +94,11 @@ func runIterCmd(d *datadriven.TestData, iter *Iterator, closeIter bool) string {
 				return "seek-prefix-ge <key>\n"
 			}
 			valid = iter.SeekPrefixGE([]byte(parts[1]))
+		case "seek-prefix-lt":
+			if len(parts) != 2 {
+				return "seek-prefix-lt <key>\n"
+			}
+			valid = iter.SeekPrefixLT([]byte(parts[1]))
 		case "seek-lt":
 			if len(parts) != 2 {
 				return "seek-lt <key>\n"
@@ -514,14 +520,38 @@ func runBuildCmd(td *datadriven.TestData, d *DB, fs vfs.FS) error {
 }
 
 func runCompactCmd(td *datadriven.TestData, d *DB) error {
-	if len(td.CmdArgs) > 4 {
-		return errors.Errorf("%s expects at most four arguments", td.Cmd)
+	if len(td.CmdArgs) > 6 {
+		return errors.Errorf("%s expects at most six arguments", td.Cmd)
 	}
 	parts := strings.Split(td.CmdArgs[0].Key, "-")
 	if len(parts) != 2 {
 		return errors.Errorf("expected <begin>-<end>: %s", td.Input)
 	}
 	parallelize := td.HasArg("parallel")
+	if td.HasArg("target") || td.HasArg("rewrite") || td.HasArg("max-concurrency") ||
+		td.HasArg("exclusive") {
+		opts := CompactOptions{Parallelize: parallelize}
+		for _, arg := range td.CmdArgs[1:] {
+			var err error
+			switch arg.Key {
+			case "target":
+				if !strings.HasPrefix(arg.Vals[0], "L") {
+					return errors.Errorf("expected L<n>: %s", arg.Vals[0])
+				}
+				opts.TargetLevel, err = strconv.Atoi(arg.Vals[0][1:])
+			case "rewrite":
+				opts.RewriteBottommost = true
+			case "max-concurrency":
+				opts.MaxConcurrency, err = strconv.Atoi(arg.Vals[0])
+			case "exclusive":
+				opts.Exclusive = true
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return d.CompactWithOptions(context.Background(), []byte(parts[0]), []byte(parts[1]), opts)
+	}
 	if len(td.CmdArgs) >= 2 && strings.HasPrefix(td.CmdArgs[1].Key, "L") {
 		levelString := td.CmdArgs[1].String()
 		iStart := base.MakeInternalKey([]byte(parts[0]), InternalKeySeqNumMax, InternalKeyKindMax)
@@ -533,7 +563,11 @@ func runCompactCmd(td *datadriven.TestData, d *DB) error {
 		if err != nil {
 			return err
 		}
-		return d.manualCompact(iStart.UserKey, iEnd.UserKey, level, parallelize)
+		return d.manualCompact(context.Background(), manualCompaction{
+			level: level,
+			start: iStart.UserKey,
+			end:   iEnd.UserKey,
+		}, parallelize, 0 /* maxConcurrency */)
 	}
 	return d.Compact([]byte(parts[0]), []byte(parts[1]), parallelize)
 }
@@ -6,8 +6,10 @@
 package pebble // import "github.com/cockroachdb/pebble"
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -232,6 +234,11 @@ type DB struct {
 		// value is not used anywhere.
 		bytesCompacted uint64
 
+		// compactionBytesIterated is the cumulative number of input bytes
+		// iterated by compactions, updated as each output table is started. It
+		// is sampled by the compaction controller.
+		compactionBytesIterated uint64
+
 		// The size of the current log file (i.e. db.mu.log.queue[len(queue)-1].
 		logSize uint64
 
@@ -266,6 +273,9 @@ type DB struct {
 
 	commit *commitPipeline
 
+	// locks holds the locks acquired by transactions. See Txn.Lock.
+	locks lockTable
+
 	// readState provides access to the state needed for reading without needing
 	// to acquire DB.mu.
 	readState struct {
@@ -284,6 +294,17 @@ type DB struct {
 	compactionLimiter limiter
 	flushLimiter      limiter
 	deletionLimiter   limiter
+	scrubLimiter      limiter
+
+	// compactionController adjusts compaction concurrency and the compaction
+	// byte rate. It is nil unless Options.Experimental.AdaptiveCompactions is
+	// enabled.
+	compactionController *compactionController
+
+	// compressionPool bounds the number of sstable data blocks compressed
+	// concurrently by flushes and compactions. It is nil if parallel
+	// compression is disabled.
+	compressionPool *sstable.CompressionPool
 
 	// Async deletion jobs spawned by cleaners increment this WaitGroup, and
 	// call Done when completed. Once `d.mu.cleaning` is false, the db.Close()
@@ -376,6 +397,9 @@ type DB struct {
 			// footprint of memtables when lots of DB instances are used concurrently
 			// in test environments.
 			nextSize int
+			// stalledWriters is the number of writers waiting in
+			// DB.makeRoomForWrite for a write stall to clear.
+			stalledWriters int
 		}
 
 		compact struct {
@@ -395,6 +419,13 @@ type DB struct {
 			manual []*manualCompaction
 			// inProgress is the set of in-progress flushes and compactions.
 			inProgress map[*compaction]struct{}
+			// nextID is the ID assigned to the most recently started flush or
+			// compaction, or queued manual compaction. See DB.Compactions.
+			nextID uint64
+			// exclusiveManual is the number of DB.CompactWithOptions calls in
+			// progress which hold off automatic compactions. See
+			// CompactOptions.Exclusive.
+			exclusiveManual int
 
 			// rescheduleReadCompaction indicates to an iterator that a read compaction
 			// should be scheduled.
@@ -423,6 +454,15 @@ type DB struct {
 		// The list of active snapshots.
 		snapshots snapshotList
 
+		// fileOnlySnapshots holds the eventually file-only snapshots which have
+		// not yet transitioned to file-only snapshots. Their snapshots are
+		// also held in the snapshots list until they transition.
+		fileOnlySnapshots []*EventuallyFileOnlySnapshot
+
+		// seqNumTimeline maps points in time to visible sequence numbers for
+		// Options.Experimental.VersionRetention.
+		seqNumTimeline seqNumTimeline
+
 		tableStats struct {
 			// Condition variable used to signal the completion of a
 			// job to collect table stats.
@@ -451,6 +491,19 @@ type DB struct {
 			// validating is set to true when validation is running.
 			validating bool
 		}
+
+		scrub struct {
+			// cond is a condition variable used to signal the completion of a
+			// pass of the background scrubber.
+			cond sync.Cond
+			// scrubbing is set to true when a pass of the scrubber is running.
+			scrubbing bool
+			// corrupt holds the sstables that the scrubber found to be corrupt,
+			// which are not scrubbed again. An sstable is removed from corrupt
+			// when it is deleted. It is not persisted, so a corrupt sstable is
+			// reported again after the DB is reopened.
+			corrupt map[FileNum]struct{}
+		}
 	}
 
 	// rangeKeys is a temporary field so that Pebble can provide a non-durable
@@ -493,10 +546,11 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		panic(err)
 	}
 
-	// Grab and reference the current readState. This prevents the underlying
-	// files in the associated version from being deleted if there is a current
-	// compaction. The readState is unref'd by Iterator.Close().
-	readState := d.loadReadState()
+	// Grab and reference the current readState, or the snapshot's pinned
+	// readState. This prevents the underlying files in the associated version
+	// from being deleted if there is a current compaction. The readState is
+	// unref'd by Iterator.Close().
+	readState := d.loadSnapshotReadState(s)
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
@@ -747,6 +801,12 @@ func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
 		batch.flushable = newFlushableBatch(batch, d.opts.Comparer)
 	}
 	if err := d.commit.Commit(batch, sync); err != nil {
+		// A batch rejected by its validation was not committed, and is the
+		// only error from which the commit pipeline recovers.
+		var rejected *batchRejectedError
+		if errors.As(err, &rejected) {
+			return rejected.err
+		}
 		// There isn't much we can do on an error here. The commit pipeline will be
 		// horked at this point.
 		d.opts.Logger.Fatalf("%v", err)
@@ -882,7 +942,7 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 			))
 		}
 	}
-	if o != nil && o.RangeKeyMasking.Suffix != nil && o.KeyTypes != IterKeyTypePointsAndRanges {
+	if o != nil && o.RangeKeyMasking.enabled() && o.KeyTypes != IterKeyTypePointsAndRanges {
 		panic("pebble: range key masking requires IterKeyTypePointsAndRanges")
 	}
 	if (batch != nil || s != nil) && (o != nil && o.OnlyReadGuaranteedDurable) {
@@ -892,10 +952,14 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 		// DB.mem.queue[0].logSeqNum.
 		panic("OnlyReadGuaranteedDurable is not supported for batches or snapshots")
 	}
-	// Grab and reference the current readState. This prevents the underlying
-	// files in the associated version from being deleted if there is a current
-	// compaction. The readState is unref'd by Iterator.Close().
-	readState := d.loadReadState()
+	if (batch != nil || s != nil) && o.readAtSeqNum() != 0 {
+		panic("ReadAtSeqNum is not supported for batches or snapshots")
+	}
+	// Grab and reference the current readState, or the snapshot's pinned
+	// readState. This prevents the underlying files in the associated version
+	// from being deleted if there is a current compaction. The readState is
+	// unref'd by Iterator.Close().
+	readState := d.loadSnapshotReadState(s)
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
@@ -904,6 +968,13 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 		seqNum = s.seqNum
 	} else {
 		seqNum = atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+		if readAt := o.readAtSeqNum(); readAt != 0 {
+			if readAt > seqNum {
+				readState.unref()
+				panic(fmt.Sprintf("pebble: ReadAtSeqNum %d is not yet visible (visible: %d)", readAt, seqNum))
+			}
+			seqNum = readAt
+		}
 	}
 
 	// Bundle various structures under a single umbrella in order to allocate
@@ -977,8 +1048,9 @@ func finishInitializingIter(buf *iterAlloc) *Iterator {
 		//
 		// NB: The interleaving iterator is always reinitialized, even if
 		// dbi already had an initialized range key iterator, in case the point
-		// iterator changed or the range key masking suffix changed.
-		dbi.rangeKey.iter.Init(dbi.cmp, dbi.split, dbi.iter, dbi.rangeKey.rangeKeyIter, dbi.opts.RangeKeyMasking.Suffix)
+		// iterator changed or the range key masking options changed.
+		mask := dbi.rangeKey.masking.init(dbi.cmp, dbi.split, &dbi.opts.RangeKeyMasking)
+		dbi.rangeKey.iter.Init(dbi.cmp, dbi.iter, dbi.rangeKey.rangeKeyIter, mask)
 		dbi.iter = &dbi.rangeKey.iter
 		dbi.iter.SetBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
 	}
@@ -1172,6 +1244,9 @@ func (d *DB) Close() error {
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	for d.mu.scrub.scrubbing {
+		d.mu.scrub.cond.Wait()
+	}
 
 	var err error
 	if n := len(d.mu.compact.inProgress); n > 0 {
@@ -1236,8 +1311,72 @@ func (d *DB) Close() error {
 	return err
 }
 
+// CompactOptions configures a manual compaction requested through
+// DB.CompactWithOptions. The zero value compacts the key range as DB.Compact
+// does without parallelization.
+type CompactOptions struct {
+	// TargetLevel is the level into which the key range is compacted. Tables
+	// overlapping the key range in the levels above TargetLevel are compacted
+	// level by level into TargetLevel, and tables in the levels beneath
+	// TargetLevel are left in place. L0 is always compacted into the base
+	// level, since the levels above it are empty by construction, so a
+	// TargetLevel above the base level leaves the key range in the base level.
+	//
+	// The default value of 0 compacts the key range into the level beneath the
+	// lowest level which contains tables overlapping it, or into the bottommost
+	// level.
+	TargetLevel int
+
+	// RewriteBottommost additionally rewrites the tables overlapping the key
+	// range in the level into which it was compacted. Compacting the levels
+	// above does not necessarily rewrite every table in the key range, since
+	// tables which do not overlap tables in the level beneath them are moved
+	// rather than rewritten, and the tables of the target level are never
+	// inputs. Rewriting them applies the current options, such as
+	// LevelOptions.Compression, and the current table format.
+	RewriteBottommost bool
+
+	// Parallelize splits the compaction of each level beneath L0 into
+	// compactions of non-overlapping key ranges, which may run concurrently.
+	Parallelize bool
+
+	// MaxConcurrency, if positive, limits the number of the compactions split
+	// by Parallelize which are queued or running at once. Another is queued
+	// as each completes. All manual compactions are also subject to
+	// Options.MaxConcurrentCompactions.
+	MaxConcurrency int
+
+	// Priority is the priority of the requested compactions relative to
+	// automatic compactions. The default, CompactionPriorityHigh, schedules
+	// them ahead of automatic compactions.
+	Priority CompactionPriority
+
+	// Exclusive holds off the scheduling of automatic compactions, including
+	// delete-only compactions, until the call returns, so that the requested
+	// compactions neither wait for nor compete with them. Automatic
+	// compactions which are already running are not interrupted, and flushes
+	// proceed as usual. As L0 is not compacted in the meantime, a long
+	// exclusive compaction under a heavy write load may lead to write stalls.
+	Exclusive bool
+}
+
 // Compact the specified range of keys in the database.
 func (d *DB) Compact(start, end []byte, parallelize bool) error {
+	return d.CompactWithOptions(context.Background(), start, end, CompactOptions{
+		Parallelize: parallelize,
+	})
+}
+
+// CompactWithOptions compacts the specified range of keys in the database as
+// configured by opts. See CompactOptions.
+//
+// If ctx is done before the compaction completes, the compactions queued by
+// CompactWithOptions are dropped, those already running are cancelled (see
+// DB.CancelCompaction) and ctx.Err() is returned. Compactions which have
+// completed are not undone.
+func (d *DB) CompactWithOptions(
+	ctx context.Context, start, end []byte, opts CompactOptions,
+) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -1248,6 +1387,20 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		return errors.Errorf("Compact start %s is not less than end %s",
 			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
 	}
+	if opts.TargetLevel < 0 || opts.TargetLevel >= numLevels {
+		return errors.Errorf("pebble: invalid compaction target level %d", opts.TargetLevel)
+	}
+	if opts.Exclusive {
+		d.mu.Lock()
+		d.mu.compact.exclusiveManual++
+		d.mu.Unlock()
+		defer func() {
+			d.mu.Lock()
+			d.mu.compact.exclusiveManual--
+			d.maybeScheduleCompaction()
+			d.mu.Unlock()
+		}()
+	}
 	iStart := base.MakeInternalKey(start, InternalKeySeqNumMax, InternalKeyKindMax)
 	iEnd := base.MakeInternalKey(end, 0, 0)
 	m := (&fileMetadata{}).ExtendPointKeyBounds(d.cmp, iStart, iEnd)
@@ -1301,11 +1454,19 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		return err
 	}
 	if mem != nil {
-		<-mem.flushed
+		select {
+		case <-mem.flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	for level := 0; level < maxLevelWithFiles; {
-		par := parallelize
+	endLevel := maxLevelWithFiles
+	if opts.TargetLevel > 0 {
+		endLevel = opts.TargetLevel
+	}
+	for level := 0; level < endLevel; {
+		par := opts.Parallelize
 		if level == 0 {
 			// TODO(bananabrick): Get rid of this special casing once
 			// we start to always add sublevels to the merging iter for
@@ -1318,7 +1479,10 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 			// in a faster compaction.
 			par = false
 		}
-		if err := d.manualCompact(iStart.UserKey, iEnd.UserKey, level, par); err != nil {
+		proto := manualCompaction{
+			level: level, start: iStart.UserKey, end: iEnd.UserKey, priority: opts.Priority,
+		}
+		if err := d.manualCompact(ctx, proto, par, opts.MaxConcurrency); err != nil {
 			return err
 		}
 		level++
@@ -1328,13 +1492,50 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 			break
 		}
 	}
-	return nil
+
+	if !opts.RewriteBottommost {
+		return nil
+	}
+	// Rewrite the tables of the lowest level, at or above the target level,
+	// which contains tables overlapping the key range. Once the levels above
+	// have been compacted, the key range is contained in that level, barring
+	// concurrent writes.
+	rewriteLevel := opts.TargetLevel
+	if rewriteLevel == 0 {
+		rewriteLevel = numLevels - 1
+	}
+	d.mu.Lock()
+	cur = d.mu.versions.currentVersion()
+	for ; rewriteLevel > 0; rewriteLevel-- {
+		overlaps := cur.Overlaps(rewriteLevel, d.cmp, start, end, iEnd.IsExclusiveSentinel())
+		if !overlaps.Empty() {
+			break
+		}
+	}
+	d.mu.Unlock()
+	if rewriteLevel == 0 {
+		return nil
+	}
+	proto := manualCompaction{
+		level: rewriteLevel, start: iStart.UserKey, end: iEnd.UserKey, rewrite: true,
+		priority: opts.Priority,
+	}
+	return d.manualCompact(ctx, proto, opts.Parallelize, opts.MaxConcurrency)
 }
 
-func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error {
+// manualCompact queues manual compactions of the tables overlapping
+// [proto.start, proto.end] in proto.level, and waits for them to complete. If
+// parallelize is set, the compaction is split into compactions of
+// non-overlapping key ranges. If maxConcurrency is positive, at most
+// maxConcurrency of them are queued or running at once: another is queued as
+// each completes. If one of them fails, the others are cancelled, and the
+// first error is returned once those which are running have completed.
+func (d *DB) manualCompact(
+	ctx context.Context, proto manualCompaction, parallelize bool, maxConcurrency int,
+) error {
 	d.mu.Lock()
 	curr := d.mu.versions.currentVersion()
-	files := curr.Overlaps(level, d.cmp, start, end, false)
+	files := curr.Overlaps(proto.level, d.cmp, proto.start, proto.end, false)
 	if files.Empty() {
 		d.mu.Unlock()
 		return nil
@@ -1342,54 +1543,105 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 
 	var compactions []*manualCompaction
 	if parallelize {
-		compactions = append(compactions, d.splitManualCompaction(start, end, level)...)
+		compactions = append(compactions, d.splitManualCompaction(proto)...)
 	} else {
-		compactions = append(compactions, &manualCompaction{
-			level: level,
-			done:  make(chan error, 1),
-			start: start,
-			end:   end,
-		})
+		m := proto
+		compactions = append(compactions, &m)
+	}
+	d.mu.Unlock()
+
+	// The compactions share a done channel, buffered so that each may send to
+	// it without blocking. Each of them is guaranteed to eventually send to it
+	// once it is queued. After a compaction is possibly picked in
+	// d.maybeScheduleCompaction(), either the compaction is dropped, executed
+	// after being scheduled, or retried later. Assuming eventual progress when
+	// a compaction is retried, all outcomes send a value to the done channel.
+	// Since the channel is buffered, it is not necessary to read every value,
+	// and so we can exit early in the event of an error.
+	done := make(chan error, len(compactions))
+	for _, m := range compactions {
+		m.done = done
+	}
+	window := len(compactions)
+	if maxConcurrency > 0 && maxConcurrency < window {
+		window = maxConcurrency
 	}
-	d.mu.compact.manual = append(d.mu.compact.manual, compactions...)
+	d.mu.Lock()
+	d.queueManualCompactionsLocked(compactions[:window]...)
 	d.maybeScheduleCompaction()
 	d.mu.Unlock()
 
-	// Each of the channels is guaranteed to be eventually sent to once. After a
-	// compaction is possibly picked in d.maybeScheduleCompaction(), either the
-	// compaction is dropped, executed after being scheduled, or retried later.
-	// Assuming eventual progress when a compaction is retried, all outcomes send
-	// a value to the done channel. Since the channels are buffered, it is not
-	// necessary to read from each channel, and so we can exit early in the event
-	// of an error.
-	for _, compaction := range compactions {
-		if err := <-compaction.done; err != nil {
-			return err
+	for queued, completed := window, 0; completed < len(compactions); completed++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				// Every compaction that is running, or that was picked from
+				// the queue, sends to done once it completes. Those removed
+				// from the queue by the cancellation never do.
+				removed := d.cancelManualCompactions(compactions[:queued])
+				for running := queued - completed - 1 - removed; running > 0; running-- {
+					<-done
+				}
+				return err
+			}
+		case <-ctx.Done():
+			d.cancelManualCompactions(compactions[:queued])
+			return ctx.Err()
+		}
+		if queued < len(compactions) {
+			d.mu.Lock()
+			d.queueManualCompactionsLocked(compactions[queued])
+			d.maybeScheduleCompaction()
+			d.mu.Unlock()
+			queued++
 		}
 	}
 	return nil
 }
 
+// cancelManualCompactions removes the provided manual compactions from the
+// queue of manual compactions, and cancels those which are running. It returns
+// the number of compactions removed from the queue.
+func (d *DB) cancelManualCompactions(compactions []*manualCompaction) (removed int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cancel := make(map[*manualCompaction]struct{}, len(compactions))
+	for _, m := range compactions {
+		cancel[m] = struct{}{}
+		if m.compaction == nil {
+			continue
+		}
+		if _, ok := d.mu.compact.inProgress[m.compaction]; ok {
+			atomic.StoreUint32(&m.compaction.atomic.cancelled, 1)
+		}
+	}
+	queue := d.mu.compact.manual[:0]
+	for _, m := range d.mu.compact.manual {
+		if _, ok := cancel[m]; !ok {
+			queue = append(queue, m)
+		}
+	}
+	removed = len(d.mu.compact.manual) - len(queue)
+	for i := len(queue); i < len(d.mu.compact.manual); i++ {
+		d.mu.compact.manual[i] = nil
+	}
+	d.mu.compact.manual = queue
+	return removed
+}
+
 // splitManualCompaction splits a manual compaction over [start,end] on level
 // such that the resulting compactions have no key overlap.
-func (d *DB) splitManualCompaction(
-	start, end []byte, level int,
-) (splitCompactions []*manualCompaction) {
+func (d *DB) splitManualCompaction(proto manualCompaction) (splitCompactions []*manualCompaction) {
 	curr := d.mu.versions.currentVersion()
-	endLevel := level + 1
 	baseLevel := d.mu.versions.picker.getBaseLevel()
-	if level == 0 {
-		endLevel = baseLevel
-	}
-	keyRanges := calculateInuseKeyRanges(curr, d.cmp, level, endLevel, start, end)
+	endLevel := proto.outputLevelFor(baseLevel)
+	keyRanges := calculateInuseKeyRanges(curr, d.cmp, proto.level, endLevel, proto.start, proto.end)
 	for _, keyRange := range keyRanges {
-		splitCompactions = append(splitCompactions, &manualCompaction{
-			level: level,
-			done:  make(chan error, 1),
-			start: keyRange.Start,
-			end:   keyRange.End,
-			split: true,
-		})
+		m := proto
+		m.start = keyRange.Start
+		m.end = keyRange.End
+		m.split = true
+		splitCompactions = append(splitCompactions, &m)
 	}
 	return splitCompactions
 }
@@ -1439,6 +1691,13 @@ func (d *DB) Metrics() *Metrics {
 	metrics.Compact.InProgressBytes = atomic.LoadInt64(&d.mu.versions.atomic.atomicInProgressBytes)
 	metrics.Compact.NumInProgress = int64(d.mu.compact.compactingCount)
 	metrics.Compact.MarkedFiles = d.mu.versions.currentVersion().Stats.MarkedForCompaction
+	metrics.Compact.ConcurrencyLimit = d.maxConcurrentCompactions()
+	if c := d.compactionController; c != nil {
+		metrics.Compact.RateLimit = c.rateLimit()
+		metrics.Compact.DiskWriteLatency = c.diskWriteLatency
+		metrics.Compact.DiskSyncLatency = c.diskSyncLatency
+		metrics.Compact.ForegroundWriteRate = c.writeRate
+	}
 	for _, m := range d.mu.mem.queue {
 		metrics.MemTable.Size += m.totalBytes()
 	}
@@ -1477,6 +1736,7 @@ func (d *DB) Metrics() *Metrics {
 		}
 	}
 	metrics.Table.ZombieCount = int64(len(d.mu.versions.zombieTables))
+	metrics.Table.CorruptCount = int64(len(d.mu.scrub.corrupt))
 	for _, size := range d.mu.versions.zombieTables {
 		metrics.Table.ZombieSize += size
 	}
@@ -1624,6 +1884,186 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	return totalSize, nil
 }
 
+// ScanCost is an estimate of the work required to scan a key range, returned
+// by DB.EstimateScanCost.
+type ScanCost struct {
+	// Keys is the estimated number of point keys other than deletions within
+	// the range in sstables. Every version of a key is counted.
+	Keys uint64
+	// Tombstones is the estimated number of point and range deletions within
+	// the range in sstables.
+	Tombstones uint64
+	// Bytes is the estimated filesystem space used by sstables for storing the
+	// range, as returned by DB.EstimateDiskUsage.
+	Bytes uint64
+	// Tables is the number of sstables overlapping the range.
+	Tables int
+	// Levels is the number of levels a scan of the range reads from: the
+	// memtables holding keys within the range, the L0 sublevels and the levels
+	// beneath L0 with sstables overlapping the range. It is the read
+	// amplification of the scan.
+	Levels int
+}
+
+// EstimateScanCost returns an estimate of the work required to scan the range
+// `[start, end]`, without scanning it. The estimates of keys and bytes are
+// computed from the sstables overlapping the range: the counts of the table
+// stats (or, if the stats of a table have not been loaded yet, of its
+// properties) are scaled by the fraction of the table's data blocks within
+// the range, computed as by EstimateDiskUsage from the table's index. The
+// estimates exclude the keys in memtables, and count the keys deleted by
+// tombstones and the obsolete versions of keys which a scan would skip.
+func (d *DB) EstimateScanCost(start, end []byte) (ScanCost, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.cmp(start, end) > 0 {
+		return ScanCost{}, errors.New("invalid key-range specified (start > end)")
+	}
+
+	// Grab and reference the current readState. This prevents the underlying
+	// files in the associated version from being deleted if there is a
+	// concurrent compaction.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	var cost ScanCost
+	for _, mem := range readState.memtables {
+		if flushableOverlapsInclusive(d.cmp, mem, start, end) {
+			cost.Levels++
+		}
+	}
+
+	tables, levels, err := d.estimateOverlappingTables(readState.current, start, end)
+	if err != nil {
+		return ScanCost{}, err
+	}
+	cost.Levels += levels
+	cost.Tables = len(tables)
+	var keys, tombstones float64
+	for _, t := range tables {
+		cost.Bytes += t.bytes
+		keys += t.keys
+		tombstones += t.tombstones
+	}
+	cost.Keys = uint64(math.Round(keys))
+	cost.Tombstones = uint64(math.Round(tombstones))
+	return cost, nil
+}
+
+// ApproximateKeyCount returns an estimate of the number of point keys within
+// the range `[start, end]`, other than deletions. It is the ScanCost.Keys
+// returned by EstimateScanCost, and so is computed from the counts of entries
+// of the sstables overlapping the range, excluding the keys in memtables.
+func (d *DB) ApproximateKeyCount(start, end []byte) (uint64, error) {
+	cost, err := d.EstimateScanCost(start, end)
+	if err != nil {
+		return 0, err
+	}
+	return cost.Keys, nil
+}
+
+// tableEstimate is the estimated contents of an sstable within a key range.
+type tableEstimate struct {
+	file *fileMetadata
+	// bytes is the size of the table's data within the range, or the whole
+	// size of the table if it is contained within the range.
+	bytes uint64
+	// keys and tombstones are the estimated counts of point keys other than
+	// deletions, and of point and range deletions, within the range.
+	keys, tombstones float64
+}
+
+// estimateOverlappingTables returns the estimated contents within the range
+// `[start, end]` of the sstables of the version overlapping the range, ordered
+// by level from the youngest L0 sublevel, along with the number of L0
+// sublevels and levels with overlapping tables. The counts of keys are those of
+// the table stats, or, if the stats of a table have not been loaded yet, of its
+// properties, scaled by the fraction of the table's data blocks within the
+// range.
+func (d *DB) estimateOverlappingTables(
+	v *version, start, end []byte,
+) (tables []tableEstimate, levels int, _ error) {
+	// Collect the overlapping tables and their stats, which are protected by
+	// DB.mu.
+	var stats []manifest.TableStats
+	addOverlapping := func(iter manifest.LevelIterator) {
+		n := len(tables)
+		for f := iter.SeekGE(d.cmp, start); f != nil && d.cmp(f.Smallest.UserKey, end) <= 0; f = iter.Next() {
+			tables = append(tables, tableEstimate{file: f})
+			stats = append(stats, f.Stats)
+		}
+		if len(tables) > n {
+			levels++
+		}
+	}
+	d.mu.Lock()
+	for i := len(v.L0Sublevels.Levels) - 1; i >= 0; i-- {
+		addOverlapping(v.L0Sublevels.Levels[i].Iter())
+	}
+	for level := 1; level < numLevels; level++ {
+		addOverlapping(v.Levels[level].Iter())
+	}
+	d.mu.Unlock()
+
+	for i := range tables {
+		t, s := &tables[i], &stats[i]
+		f := t.file
+		contained := d.cmp(start, f.Smallest.UserKey) <= 0 && d.cmp(f.Largest.UserKey, end) <= 0
+		t.bytes = f.Size
+		fraction := 1.0
+		numEntries, numDeletions := s.NumEntries, s.NumDeletions
+		if !contained || !s.Valid {
+			err := d.tableCache.withReader(f, func(r *sstable.Reader) (err error) {
+				if !contained {
+					t.bytes, err = r.EstimateDiskUsage(start, end)
+					// The estimate of a partial range only covers data blocks,
+					// so scale the keys by the fraction of the table's data.
+					if dataSize := r.Properties.DataSize; dataSize > 0 && t.bytes < dataSize {
+						fraction = float64(t.bytes) / float64(dataSize)
+					}
+				}
+				if !s.Valid {
+					numEntries, numDeletions = r.Properties.NumEntries, r.Properties.NumDeletions
+				}
+				return err
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		if numDeletions > numEntries {
+			numDeletions = numEntries
+		}
+		t.keys = fraction * float64(numEntries-numDeletions)
+		t.tombstones = fraction * float64(numDeletions)
+	}
+	return tables, levels, nil
+}
+
+// flushableOverlapsInclusive returns true if the flushable holds a point key
+// or range deletion within the range [start, end].
+func flushableOverlapsInclusive(cmp Compare, mem flushable, start, end []byte) bool {
+	iter := mem.newIter(nil)
+	k, _ := iter.SeekGE(start, false /* trySeekUsingNext */)
+	overlaps := k != nil && cmp(k.UserKey, end) <= 0
+	_ = iter.Close()
+	if overlaps {
+		return true
+	}
+	rangeDelIter := mem.newRangeDelIter(nil)
+	if rangeDelIter == nil {
+		return false
+	}
+	defer rangeDelIter.Close()
+	for s := rangeDelIter.First(); s.Valid() && cmp(s.Start, end) <= 0; s = rangeDelIter.Next() {
+		if !s.Empty() && cmp(s.End, start) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *DB) walPreallocateSize() int {
 	// Set the WAL preallocate size to 110% of the memtable size. Note that there
 	// is a bit of apples and oranges in units here as the memtabls size
@@ -1702,12 +2142,14 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			err := d.mu.mem.mutable.prepare(b)
 			if err != arenaskl.ErrArenaFull {
 				if stalled {
+					d.mu.mem.stalledWriters--
 					d.opts.EventListener.WriteStallEnd()
 				}
 				return err
 			}
 		} else if !force {
 			if stalled {
+				d.mu.mem.stalledWriters--
 				d.opts.EventListener.WriteStallEnd()
 			}
 			return nil
@@ -1723,6 +2165,7 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 				// are still flushing, so we wait.
 				if !stalled {
 					stalled = true
+					d.mu.mem.stalledWriters++
 					d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
 						Reason: "memtable count limit reached",
 					})
@@ -1736,6 +2179,7 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			// There are too many level-0 files, so we wait.
 			if !stalled {
 				stalled = true
+				d.mu.mem.stalledWriters++
 				d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
 					Reason: "L0 file count limit exceeded",
 				})
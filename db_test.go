@@ -1010,6 +1010,8 @@ func TestDBClosed(t *testing.T) {
 
 	require.True(t, errors.Is(catch(func() { _, _, _ = d.Get(nil) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.Delete(nil, nil) }), ErrClosed))
+	require.True(t, errors.Is(catch(func() { _, _ = d.EstimateScanCost(nil, nil) }), ErrClosed))
+	require.True(t, errors.Is(catch(func() { _, _ = d.NewKeySampleIter(nil, nil, 1) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.DeleteRange(nil, nil, nil) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.Ingest(nil) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.LogData(nil, nil) }), ErrClosed))
@@ -1025,6 +1027,150 @@ func TestDBClosed(t *testing.T) {
 	require.True(t, errors.Is(catch(func() { _ = b.NewIter(nil) }), ErrClosed))
 }
 
+func TestEstimateScanCost(t *testing.T) {
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		// Use small blocks so that the estimates for partial ranges are
+		// computed at a fine granularity.
+		Levels: []LevelOptions{{BlockSize: 256}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const n = 1000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set(key(i), bytes.Repeat([]byte("v"), 32), nil))
+	}
+	require.NoError(t, d.Compact(key(0), key(n), false /* parallelize */))
+
+	// Delete every fourth key, and flush the deletions to L0.
+	for i := 0; i < n; i += 4 {
+		require.NoError(t, d.Delete(key(i), nil))
+	}
+	require.NoError(t, d.Flush())
+	// Leave a key in the memtable.
+	require.NoError(t, d.Set(key(n/2), nil, nil))
+
+	within := func(t *testing.T, expected, actual, tolerance uint64) {
+		t.Helper()
+		diff := int64(expected) - int64(actual)
+		if diff < 0 {
+			diff = -diff
+		}
+		require.LessOrEqualf(t, uint64(diff), tolerance, "expected %d, got %d", expected, actual)
+	}
+
+	t.Run("full", func(t *testing.T) {
+		cost, err := d.EstimateScanCost(key(0), key(n-1))
+		require.NoError(t, err)
+		require.Equal(t, uint64(n), cost.Keys)
+		require.Equal(t, uint64(n/4), cost.Tombstones)
+		require.Equal(t, 2, cost.Tables)
+		// The memtable, the L0 sublevel and L6.
+		require.Equal(t, 3, cost.Levels)
+		size, err := d.EstimateDiskUsage(key(0), key(n-1))
+		require.NoError(t, err)
+		require.Equal(t, size, cost.Bytes)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		// The estimate includes the whole of the data blocks at the range's
+		// boundaries.
+		cost, err := d.EstimateScanCost(key(100), key(199))
+		require.NoError(t, err)
+		within(t, 100, cost.Keys, 25)
+		within(t, 25, cost.Tombstones, 15)
+		require.Equal(t, 2, cost.Tables)
+		// The memtable's only key is outside of the range.
+		require.Equal(t, 2, cost.Levels)
+		size, err := d.EstimateDiskUsage(key(100), key(199))
+		require.NoError(t, err)
+		require.Equal(t, size, cost.Bytes)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		cost, err := d.EstimateScanCost([]byte("z"), []byte("zz"))
+		require.NoError(t, err)
+		require.Equal(t, ScanCost{}, cost)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := d.EstimateScanCost([]byte("b"), []byte("a"))
+		require.Error(t, err)
+	})
+}
+
+func TestApproximateKeyCountAndSampling(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:     vfs.NewMem(),
+		Levels: []LevelOptions{{BlockSize: 256}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const n = 10000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	// Write the even keys to L6 and the odd keys to L0, so that samples are
+	// drawn from tables overlapping each other.
+	for i := 0; i < n; i += 2 {
+		require.NoError(t, d.Set(key(i), bytes.Repeat([]byte("v"), 32), nil))
+	}
+	require.NoError(t, d.Compact(key(0), key(n), false /* parallelize */))
+	for i := 1; i < n; i += 2 {
+		require.NoError(t, d.Set(key(i), bytes.Repeat([]byte("v"), 32), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	count, err := d.ApproximateKeyCount(key(0), key(n-1))
+	require.NoError(t, err)
+	require.Equal(t, uint64(n), count)
+	count, err = d.ApproximateKeyCount(key(2000), key(2999))
+	require.NoError(t, err)
+	require.InDelta(t, 1000, float64(count), 100)
+
+	keyIndex := func(k []byte) int {
+		// Separators may be shortened or follow the last key, so parse only
+		// the digits present.
+		digits := strings.TrimPrefix(string(k), "key")
+		digits += strings.Repeat("0", 5-len(digits))
+		v, err := strconv.Atoi(digits)
+		require.NoError(t, err)
+		return v
+	}
+	for _, tc := range []struct{ start, end int }{{0, n - 1}, {2000, 2999}} {
+		t.Run(fmt.Sprintf("%d-%d", tc.start, tc.end), func(t *testing.T) {
+			iter, err := d.NewKeySampleIter(key(tc.start), key(tc.end), 1001)
+			require.NoError(t, err)
+			require.Equal(t, 1001, iter.Len())
+			var keys [][]byte
+			for valid := iter.First(); valid; valid = iter.Next() {
+				keys = append(keys, iter.Key())
+			}
+			require.Len(t, keys, 1001)
+			require.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+				return bytes.Compare(keys[i], keys[j]) < 0
+			}))
+			require.LessOrEqual(t, string(key(tc.start)), string(keys[0]))
+			require.LessOrEqual(t, string(keys[len(keys)-1]), string(key(tc.end)))
+			// The sampled median is close to the median key of the range.
+			median := (tc.start + tc.end) / 2
+			require.InDelta(t, median, keyIndex(keys[len(keys)/2]), float64(tc.end-tc.start)/10)
+		})
+	}
+
+	iter, err := d.NewKeySampleIter([]byte("z"), []byte("zz"), 10)
+	require.NoError(t, err)
+	require.False(t, iter.First())
+	iter, err = d.NewKeySampleIter(key(0), key(n-1), 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, iter.Len())
+	_, err = d.NewKeySampleIter([]byte("b"), []byte("a"), 10)
+	require.Error(t, err)
+	_, err = d.ApproximateKeyCount([]byte("b"), []byte("a"))
+	require.Error(t, err)
+}
+
 func TestDBConcurrentCommitCompactFlush(t *testing.T) {
 	d, err := Open("", testingRandomized(&Options{
 		FS: vfs.NewMem(),
@@ -20,6 +20,12 @@ func newErrorIter(err error) *errorIter {
 	return &errorIter{err: err}
 }
 
+// newErrorIterator returns an Iterator which is never valid, and whose Error
+// and Close return err.
+func (d *DB) newErrorIterator(err error) *Iterator {
+	return &Iterator{iter: newErrorIter(err), err: err, cmp: d.cmp, split: d.split}
+}
+
 func (c *errorIter) SeekGE(key []byte, trySeekUsingNext bool) (*InternalKey, []byte) {
 	return nil, nil
 }
@@ -250,6 +250,27 @@ func (i TableCreateInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(i.JobID), redact.Safe(i.Reason), redact.Safe(i.FileNum))
 }
 
+// TableCorruptionInfo contains the info for a table corruption event.
+type TableCorruptionInfo struct {
+	// JobID is the ID of the scrubber job that detected the corruption.
+	JobID   int
+	Level   int
+	Path    string
+	FileNum FileNum
+	// Err describes the corruption.
+	Err error
+}
+
+func (i TableCorruptionInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i TableCorruptionInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] sstable corruption detected in L%d:%s: %s",
+		redact.Safe(i.JobID), redact.Safe(i.Level), redact.Safe(i.FileNum), i.Err)
+}
+
 // TableDeleteInfo contains the info for a table deletion event.
 type TableDeleteInfo struct {
 	JobID   int
@@ -450,6 +471,13 @@ type EventListener struct {
 	// ManifestDeleted is invoked after a manifest has been deleted.
 	ManifestDeleted func(ManifestDeleteInfo)
 
+	// TableCorrupted is invoked when the background scrubber finds a block of
+	// a live table with a checksum mismatch. The table is not scrubbed again
+	// until the DB is reopened, and it is reported by DB.CorruptTables until
+	// it is deleted. Reads and compactions of the table are not affected, so
+	// it is up to the application to act upon the corruption.
+	TableCorrupted func(TableCorruptionInfo)
+
 	// TableCreated is invoked when a table has been created.
 	TableCreated func(TableCreateInfo)
 
@@ -518,6 +546,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.ManifestDeleted == nil {
 		l.ManifestDeleted = func(info ManifestDeleteInfo) {}
 	}
+	if l.TableCorrupted == nil {
+		l.TableCorrupted = func(info TableCorruptionInfo) {}
+	}
 	if l.TableCreated == nil {
 		l.TableCreated = func(info TableCreateInfo) {}
 	}
@@ -582,6 +613,9 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		ManifestDeleted: func(info ManifestDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		TableCorrupted: func(info TableCorruptionInfo) {
+			logger.Infof("%s", info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			logger.Infof("%s", info)
 		},
@@ -653,6 +687,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.ManifestDeleted(info)
 			b.ManifestDeleted(info)
 		},
+		TableCorrupted: func(info TableCorruptionInfo) {
+			a.TableCorrupted(info)
+			b.TableCorrupted(info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			a.TableCreated(info)
 			b.TableCreated(info)
@@ -138,12 +138,14 @@ func NewExternalIter(
 		dbi.rangeKey.rangeKeyIter = rangekey.InitUserIteration(
 			o.Comparer.Compare,
 			base.InternalKeySeqNumMax,
+			newRangeKeyFilter(dbi.opts.RangeKeyFilters, o.BlockPropertyCollectors),
 			&dbi.rangeKey.alloc.merging,
 			&dbi.rangeKey.alloc.defraging,
 			rangeKeyIters...,
 		)
 
-		dbi.rangeKey.iter.Init(dbi.cmp, dbi.split, &buf.merging, dbi.rangeKey.rangeKeyIter, dbi.opts.RangeKeyMasking.Suffix)
+		mask := dbi.rangeKey.masking.init(dbi.cmp, dbi.split, &dbi.opts.RangeKeyMasking)
+		dbi.rangeKey.iter.Init(dbi.cmp, &buf.merging, dbi.rangeKey.rangeKeyIter, mask)
 		dbi.iter = &dbi.rangeKey.iter
 		dbi.iter.SetBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
 	}
@@ -87,11 +87,16 @@ const (
 	FormatMarkedCompacted
 	// FormatRangeKeys is a format major version that introduces range keys.
 	FormatRangeKeys
+	// FormatColumnarBlocks is a format major version that introduces sstables
+	// with columnar data blocks, which store key prefixes, key suffixes and
+	// values in separate columns, and optional data block hash indexes
+	// (sstable.TableFormatPebblev3).
+	FormatColumnarBlocks
 	// FormatNewest always contains the most recent format major version.
 	// NB: When adding new versions, the MaxTableFormat method should also be
 	// updated to return the maximum allowable version for the new
 	// FormatMajorVersion.
-	FormatNewest FormatMajorVersion = FormatRangeKeys
+	FormatNewest FormatMajorVersion = FormatColumnarBlocks
 )
 
 // MaxTableFormat returns the maximum sstable.TableFormat that can be used at
@@ -105,6 +110,8 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 		return sstable.TableFormatPebblev1
 	case FormatRangeKeys:
 		return sstable.TableFormatPebblev2
+	case FormatColumnarBlocks:
+		return sstable.TableFormatPebblev3
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
 	}
@@ -206,6 +213,9 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 	FormatRangeKeys: func(d *DB) error {
 		return d.finalizeFormatVersUpgrade(FormatRangeKeys)
 	},
+	FormatColumnarBlocks: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatColumnarBlocks)
+	},
 }
 
 const formatVersionMarkerName = `format-version`
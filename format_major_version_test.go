@@ -43,6 +43,8 @@ func TestRatchetFormat(t *testing.T) {
 	require.Equal(t, FormatBlockPropertyCollector, d.FormatMajorVersion())
 	require.NoError(t, d.RatchetFormatMajorVersion(FormatRangeKeys))
 	require.Equal(t, FormatRangeKeys, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatColumnarBlocks))
+	require.Equal(t, FormatColumnarBlocks, d.FormatMajorVersion())
 	require.NoError(t, d.Close())
 
 	// If we Open the database again, leaving the default format, the
@@ -199,6 +201,7 @@ func TestFormatMajorVersions_TableFormat(t *testing.T) {
 		FormatSplitUserKeysMarked:     sstable.TableFormatPebblev1,
 		FormatMarkedCompacted:         sstable.TableFormatPebblev1,
 		FormatRangeKeys:               sstable.TableFormatPebblev2,
+		FormatColumnarBlocks:          sstable.TableFormatPebblev3,
 	}
 
 	// Valid versions.
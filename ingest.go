@@ -828,23 +828,12 @@ func (d *DB) validateSSTables() {
 	// TODO(travers): Add some form of pacing to avoid IO starvation.
 	for _, f := range pending {
 		// The file may have been moved or deleted since it was ingested, in
-		// which case we skip.
-		if !rs.current.Contains(f.Level, d.cmp, f.Meta) {
-			// Assume the file was moved to a lower level. It is rare enough
-			// that a table is moved or deleted between the time it was ingested
-			// and the time the validation routine runs that the overall cost of
-			// this inner loop is tolerably low, when amortized over all
-			// ingested tables.
-			found := false
-			for i := f.Level + 1; i < numLevels; i++ {
-				if rs.current.Contains(i, d.cmp, f.Meta) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+		// which case we skip. It is rare enough that a table is moved or
+		// deleted between the time it was ingested and the time the validation
+		// routine runs that the overall cost of searching the lower levels is
+		// tolerably low, when amortized over all ingested tables.
+		if !versionContainsFile(rs.current, d.cmp, f) {
+			continue
 		}
 
 		err := d.tableCache.withReader(f.Meta, func(r *sstable.Reader) error {
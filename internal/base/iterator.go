@@ -214,6 +214,36 @@ type InternalIterator interface {
 	fmt.Stringer
 }
 
+// PrefixLTSeeker is implemented by InternalIterators which can use the prefix
+// of a reverse seek to avoid expensive operations, as SeekPrefixGE does for a
+// forward seek (e.g. sstable iterators consult the table's prefix bloom
+// filter, and levelIter skips the tables which cannot hold the prefix).
+type PrefixLTSeeker interface {
+	// SeekPrefixLT moves the iterator to the last key/value pair whose key is
+	// less than the given key, or to the last key/value pair if key is nil.
+	// Returns the key and value if the iterator is pointing at a valid entry,
+	// and (nil, nil) otherwise. As with SeekPrefixGE, the returned key need not
+	// have the given prefix, and the iterator may return (nil, nil) without
+	// positioning itself if it holds no keys with the prefix, in which case it
+	// is not valid to call Next or Prev until the iterator is repositioned
+	// with an absolute positioning method. Note that SeekPrefixLT only checks
+	// the lower bound. It is up to the caller to ensure that key is less than
+	// the upper bound.
+	SeekPrefixLT(prefix, key []byte) (*InternalKey, []byte)
+}
+
+// SeekPrefixLT positions iter through PrefixLTSeeker.SeekPrefixLT if it is
+// implemented, and otherwise through SeekLT, or Last if key is nil.
+func SeekPrefixLT(iter InternalIterator, prefix, key []byte) (*InternalKey, []byte) {
+	if s, ok := iter.(PrefixLTSeeker); ok {
+		return s.SeekPrefixLT(prefix, key)
+	}
+	if key == nil {
+		return iter.Last()
+	}
+	return iter.SeekLT(key)
+}
+
 // InternalIteratorWithStats extends InternalIterator to expose stats.
 type InternalIteratorWithStats interface {
 	InternalIterator
@@ -13,9 +13,6 @@ import (
 // TODO(jackson): The interleaving iterator has various invariants that it
 // asserts. We should eventually gate these behind `invariants.Enabled`.
 
-// TODO(jackson): Genercize 'masking' so the particulars of range-key masking
-// aren't leaking into the keyspan package.
-
 // InterleavingIter combines an iterator over point keys with an iterator over
 // key spans.
 //
@@ -68,50 +65,20 @@ import (
 //
 // Masking
 //
-// An InterleavingIter may be configured to treat some spans as masks. If a
-// non-nil maskingThresholdSuffix is passed to Init, masking is enabled. Masking
-// hides point keys, transparently skipping over the keys. Whether or not a
-// point key is masked is determined by comparing the point key's suffix, the
-// overlapping span's keys' suffixes, and the maskingTreshresholdSuffix. When
-// configured with a masking threshold _t_, and there exists a span
-// with suffix _r_ covering a point key with suffix _p_, and
-//
-//     _t_ ≤ _r_ < _p_
-//
-// then the point key is elided. Consider the following rendering, where
-// suffixes with higher integers sort before suffixes with lower integers:
-//
-//          ^
-//       @9 |        •―――――――――――――――○ [e,m)@9
-//     s  8 |                      • l@8
-//     u  7 |------------------------------------ @7 masking
-//     f  6 |      [h,q)@6 •―――――――――――――――――○     threshold
-//     f  5 |              • h@5
-//     f  4 |                          • n@4
-//     i  3 |          •―――――――――――○ [f,l)@3
-//     x  2 |  • b@2
-//        1 |
-//        0 |___________________________________
-//           a b c d e f g h i j k l m n o p q
-//
-// An iterator scanning the entire keyspace with the masking threshold set to @7
-// will observe point keys b@2 and l@8. The span keys [h,q)@6 and [f,l)@3 serve
-// as masks, because cmp(@6,@7) ≥ 0 and cmp(@3,@7) ≥ 0. The span key [e,m)@9
-// does not serve as a mask, because cmp(@9,@7) < 0.
-//
-// Although point l@8 falls within the user key bounds of [e,m)@9, [e,m)@9 is
-// non-masking due to its suffix. The point key l@8 also falls within the user
-// key bounds of [h,q)@6, but since cmp(@6,@8) ≥ 0, l@8 is unmasked.
+// An InterleavingIter may be configured to treat some spans as masks, by
+// passing a non-nil SpanMask to Init. Masking hides point keys, transparently
+// skipping over the keys. The SpanMask is informed of the current span whenever
+// it changes, and decides whether each point key covered by the span is
+// masked. SuffixMask implements the masking of point keys by the suffixes of
+// the keys of the spans covering them, as used by range-key masking.
 //
 // All spans containing keys, including those acting as masks, are exposed
 // during iteration.
 type InterleavingIter struct {
-	cmp                    base.Compare
-	split                  base.Split
-	pointIter              base.InternalIteratorWithStats
-	keyspanIter            FragmentIterator
-	maskingThresholdSuffix []byte
-	maskSuffix             []byte
+	cmp         base.Compare
+	pointIter   base.InternalIteratorWithStats
+	keyspanIter FragmentIterator
+	mask        SpanMask
 
 	// lower and upper hold the iteration bounds set through SetBounds.
 	lower, upper []byte
@@ -165,29 +132,113 @@ type InterleavingIter struct {
 	dir int8
 }
 
+// SpanMask may be used to configure an InterleavingIter to mask point keys
+// covered by spans.
+type SpanMask interface {
+	// SpanChanged is invoked by an interleaving iterator whenever the current
+	// span changes. As the iterator moves, it may move between spans or cease
+	// to be positioned within any span, in which case s is the zero Span. The
+	// Span's Keys remain valid until the next call to SpanChanged.
+	SpanChanged(s Span)
+	// SkipPoint is invoked by the interleaving iterator whenever it encounters
+	// a point key covered by the current span, and returns true if the point
+	// key should be skipped (masked).
+	SkipPoint(userKey []byte) bool
+}
+
+// SuffixMask is a SpanMask that masks point keys by the suffixes of the keys
+// of the span covering them. Only span keys with suffixes, and with suffixes ≥
+// the threshold if it's non-nil, may mask point keys, and point keys without
+// suffixes are never masked. Without a mask predicate, a point key is masked
+// if its suffix is greater than the suffix of a span key that may mask point
+// keys. With one, a point key is masked if the predicate returns true for any
+// span key that may mask point keys.
+type SuffixMask struct {
+	cmp       base.Compare
+	split     base.Split
+	threshold []byte
+	mask      func(pointSuffix []byte, k *Key) bool
+	// maskSuffix is the smallest suffix of the current span's keys that may
+	// mask point keys, if mask is nil.
+	maskSuffix []byte
+	// keys holds the current span's keys that may mask point keys, if mask is
+	// non-nil.
+	keys []Key
+}
+
+// Assert that *SuffixMask implements the SpanMask interface.
+var _ SpanMask = &SuffixMask{}
+
+// Init initializes the SuffixMask to mask point keys by span keys with
+// suffixes ≥ threshold, if threshold is non-nil, and with the predicate mask,
+// if mask is non-nil.
+func (m *SuffixMask) Init(
+	cmp base.Compare, split base.Split, threshold []byte, mask func(pointSuffix []byte, k *Key) bool,
+) {
+	*m = SuffixMask{
+		cmp:       cmp,
+		split:     split,
+		threshold: threshold,
+		mask:      mask,
+		keys:      m.keys[:0],
+	}
+}
+
+// SpanChanged implements SpanMask.
+func (m *SuffixMask) SpanChanged(s Span) {
+	m.maskSuffix = nil
+	m.keys = m.keys[:0]
+	for i := range s.Keys {
+		if s.Keys[i].Suffix == nil {
+			continue
+		}
+		if m.threshold != nil && m.cmp(s.Keys[i].Suffix, m.threshold) < 0 {
+			continue
+		}
+		if m.mask != nil {
+			m.keys = append(m.keys, s.Keys[i])
+		} else if m.maskSuffix == nil || m.cmp(m.maskSuffix, s.Keys[i].Suffix) > 0 {
+			m.maskSuffix = s.Keys[i].Suffix
+		}
+	}
+}
+
+// SkipPoint implements SpanMask.
+func (m *SuffixMask) SkipPoint(userKey []byte) bool {
+	pointSuffix := userKey[m.split(userKey):]
+	if len(pointSuffix) == 0 {
+		return false
+	}
+	if m.mask == nil {
+		return m.maskSuffix != nil && m.cmp(m.maskSuffix, pointSuffix) < 0
+	}
+	for i := range m.keys {
+		if m.mask(pointSuffix, &m.keys[i]) {
+			return true
+		}
+	}
+	return false
+}
+
 // Assert that *InterleavingIter implements the InternalIterator interface.
 var _ base.InternalIterator = &InterleavingIter{}
 
 // Init initializes the InterleavingIter to interleave point keys from pointIter
 // with key spans from keyspanIter.
 //
-// If maskingThresholdSuffix is non-nil, masking of point keys by span keys is
-// enabled. Any spans containing keys with a suffix > maskingThresholdSuffix
-// will act as a mask, hiding all point keys with suffixes > the span key's
-// suffix within their user key bounds.
+// If mask is non-nil, masking of point keys by span keys is enabled: any point
+// key covered by a span for which mask.SkipPoint returns true is skipped.
 func (i *InterleavingIter) Init(
 	cmp base.Compare,
-	split base.Split,
 	pointIter base.InternalIteratorWithStats,
 	keyspanIter FragmentIterator,
-	maskingThresholdSuffix []byte,
+	mask SpanMask,
 ) {
 	*i = InterleavingIter{
-		cmp:                    cmp,
-		split:                  split,
-		pointIter:              pointIter,
-		keyspanIter:            keyspanIter,
-		maskingThresholdSuffix: maskingThresholdSuffix,
+		cmp:         cmp,
+		pointIter:   pointIter,
+		keyspanIter: keyspanIter,
+		mask:        mask,
 	}
 }
 
@@ -238,6 +289,20 @@ func (i *InterleavingIter) SeekLT(key []byte) (*base.InternalKey, []byte) {
 	return i.interleaveBackward()
 }
 
+// SeekPrefixLT implements base.PrefixLTSeeker, passing the prefix to the
+// point iterator. A nil key positions the iterator as Last.
+func (i *InterleavingIter) SeekPrefixLT(prefix, key []byte) (*base.InternalKey, []byte) {
+	i.pointKey, i.pointVal = base.SeekPrefixLT(i.pointIter, prefix, key)
+	i.pointKeyInterleaved = false
+	if key == nil {
+		i.checkBackwardBound(i.keyspanIter.Last())
+	} else {
+		i.keyspanSeekLT(key)
+	}
+	i.dir = -1
+	return i.interleaveBackward()
+}
+
 // First implements (base.InternalIterator).First.
 func (i *InterleavingIter) First() (*base.InternalKey, []byte) {
 	i.pointKey, i.pointVal = i.pointIter.First()
@@ -480,20 +545,17 @@ func (i *InterleavingIter) interleaveForward(lowerBound []byte) (*base.InternalK
 
 				// The span covers the point key. The point key might be masked
 				// too if masking is enabled.
-				if i.maskSuffix != nil {
-					pointSuffix := i.pointKey.UserKey[i.split(i.pointKey.UserKey):]
-					if len(pointSuffix) > 0 && i.cmp(i.maskSuffix, pointSuffix) < 0 {
-						// A key in the current span masks this point key. Skip
-						// the point key.
-						i.pointKey, i.pointVal = i.pointIter.Next()
-						// We may have just invalidated the invariant that
-						// ensures the span's End is > the point key, so
-						// reestablish it before the next iteration.
-						if i.pointKey != nil && i.cmp(i.pointKey.UserKey, i.span.End) >= 0 {
-							i.checkForwardBound(i.keyspanIter.Next())
-						}
-						continue
+				if i.mask != nil && i.mask.SkipPoint(i.pointKey.UserKey) {
+					// A key in the current span masks this point key. Skip the
+					// point key.
+					i.pointKey, i.pointVal = i.pointIter.Next()
+					// We may have just invalidated the invariant that ensures
+					// the span's End is > the point key, so reestablish it
+					// before the next iteration.
+					if i.pointKey != nil && i.cmp(i.pointKey.UserKey, i.span.End) >= 0 {
+						i.checkForwardBound(i.keyspanIter.Next())
 					}
+					continue
 				}
 
 				// Point key is unmasked but covered.
@@ -540,14 +602,11 @@ func (i *InterleavingIter) interleaveBackward() (*base.InternalKey, []byte) {
 
 				// Since this point key is covered by the span, it might be
 				// masked by the if masking is enabled.
-				if i.maskSuffix != nil {
-					pointSuffix := i.pointKey.UserKey[i.split(i.pointKey.UserKey):]
-					if len(pointSuffix) > 0 && i.cmp(i.maskSuffix, pointSuffix) < 0 {
-						// A key in the current span masks this point key. Skip
-						// the point key.
-						i.pointKey, i.pointVal = i.pointIter.Prev()
-						continue
-					}
+				if i.mask != nil && i.mask.SkipPoint(i.pointKey.UserKey) {
+					// A key in the current span masks this point key. Skip the
+					// point key.
+					i.pointKey, i.pointVal = i.pointIter.Prev()
+					continue
 				}
 
 				// Point key is unmasked but covered.
@@ -669,10 +728,9 @@ func (i *InterleavingIter) verify(k *base.InternalKey, v []byte) (*base.Internal
 		panic("pebble: invariant violation: key < lower bound")
 	case k != nil && i.upper != nil && i.cmp(k.UserKey, i.upper) >= 0:
 		panic("pebble: invariant violation: key ≥ upper bound")
-	case i.span.Valid() && k != nil && i.maskSuffix != nil && i.pointKeyInterleaved &&
-		i.split(k.UserKey) != len(k.UserKey) &&
-		i.cmp(i.maskSuffix, k.UserKey[i.split(k.UserKey):]) < 0 &&
-		i.cmp(k.UserKey, i.spanStart) >= 0 && i.cmp(k.UserKey, i.spanEnd) < 0:
+	case i.span.Valid() && k != nil && i.mask != nil && i.pointKeyInterleaved &&
+		i.cmp(k.UserKey, i.spanStart) >= 0 && i.cmp(k.UserKey, i.spanEnd) < 0 &&
+		i.mask.SkipPoint(k.UserKey):
 		panic("pebble: invariant violation: point key eligible for masking returned")
 	}
 
@@ -683,13 +741,14 @@ func (i *InterleavingIter) saveKeyspan(s Span) {
 	i.keyspanInterleaved = false
 	i.spanMarkerTruncated = false
 	i.span = s
-	i.maskSuffix = nil
+	if i.mask != nil {
+		i.mask.SpanChanged(s)
+	}
 	if !s.Valid() {
 		i.spanStart = nil
 		i.spanEnd = nil
 		return
 	}
-	i.maskSuffix = smallestSetSuffix(i.cmp, i.maskingThresholdSuffix, s)
 	i.spanStart = s.Start
 	i.spanEnd = s.End
 	// TODO(jackson): The key comparisons below truncate bounds whenever the
@@ -708,28 +767,6 @@ func (i *InterleavingIter) saveKeyspan(s Span) {
 	}
 }
 
-// smallestSetSuffix returns the smallest suffix of a key contained within a
-// Span, that's also greater than or equal to (as defined by cmp) than the
-// suffixThreshold argument. smallestSetSuffix returns nil if suffixThreshold is
-// nil.
-func smallestSetSuffix(cmp base.Compare, suffixThreshold []byte, s Span) (smallest []byte) {
-	if suffixThreshold == nil || len(s.Keys) == 0 {
-		return nil
-	}
-	for i := range s.Keys {
-		if s.Keys[i].Suffix == nil {
-			continue
-		}
-		if cmp(s.Keys[i].Suffix, suffixThreshold) < 0 {
-			continue
-		}
-		if smallest == nil || cmp(smallest, s.Keys[i].Suffix) > 0 {
-			smallest = s.Keys[i].Suffix
-		}
-	}
-	return smallest
-}
-
 // Span returns the span covering the last key returned, if any. A span key is
 // considered to 'cover' a key if the key falls within the span's user key
 // bounds.
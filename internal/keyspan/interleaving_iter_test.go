@@ -31,7 +31,15 @@ func runInterleavingIterTest(t *testing.T, filename string) {
 	var pointIter pointIterator
 	var iter InterleavingIter
 	var buf bytes.Buffer
+	var masking SuffixMask
 	var maskingThreshold []byte
+	mask := func() SpanMask {
+		if maskingThreshold == nil {
+			return nil
+		}
+		masking.Init(cmp, testkeys.Comparer.Split, maskingThreshold, nil /* mask */)
+		return &masking
+	}
 
 	formatKey := func(k *base.InternalKey, v []byte) {
 		if k == nil {
@@ -46,8 +54,7 @@ func runInterleavingIterTest(t *testing.T, filename string) {
 		switch td.Cmd {
 		case "set-masking-threshold":
 			maskingThreshold = []byte(strings.TrimSpace(td.Input))
-			iter.Init(cmp, testkeys.Comparer.Split, base.WrapIterWithStats(&pointIter), &keyspanIter,
-				maskingThreshold)
+			iter.Init(cmp, base.WrapIterWithStats(&pointIter), &keyspanIter, mask())
 			return "OK"
 		case "define-rangekeys":
 			var spans []Span
@@ -56,8 +63,7 @@ func runInterleavingIterTest(t *testing.T, filename string) {
 				spans = append(spans, ParseSpan(line))
 			}
 			keyspanIter.Init(cmp, noopTransform, NewIter(cmp, spans))
-			iter.Init(cmp, testkeys.Comparer.Split, base.WrapIterWithStats(&pointIter), &keyspanIter,
-				maskingThreshold)
+			iter.Init(cmp, base.WrapIterWithStats(&pointIter), &keyspanIter, mask())
 			return "OK"
 		case "define-pointkeys":
 			var points []base.InternalKey
@@ -66,8 +72,7 @@ func runInterleavingIterTest(t *testing.T, filename string) {
 				points = append(points, base.ParseInternalKey(line))
 			}
 			pointIter = pointIterator{cmp: cmp, keys: points}
-			iter.Init(cmp, testkeys.Comparer.Split, base.WrapIterWithStats(&pointIter), &keyspanIter,
-				maskingThreshold)
+			iter.Init(cmp, base.WrapIterWithStats(&pointIter), &keyspanIter, mask())
 			return "OK"
 		case "iter":
 			buf.Reset()
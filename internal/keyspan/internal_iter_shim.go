@@ -15,6 +15,7 @@ import "github.com/cockroachdb/pebble/internal/base"
 // an InterleavingIterator type that interleaves keyspan.Spans from a
 // keyspan.FragmentIterator with point keys.
 type InternalIteratorShim struct {
+	cmp     base.Compare
 	miter   MergingIter
 	span    Span
 	iterKey base.InternalKey
@@ -26,6 +27,7 @@ var _ base.InternalIterator = &InternalIteratorShim{}
 // Init initializes the internal iterator shim to merge the provided fragment
 // iterators.
 func (i *InternalIteratorShim) Init(cmp base.Compare, iters ...FragmentIterator) {
+	i.cmp = cmp
 	i.miter.Init(cmp, noopTransform, iters...)
 }
 
@@ -35,11 +37,23 @@ func (i *InternalIteratorShim) Span() Span {
 	return i.span
 }
 
-// SeekGE implements (base.InternalIterator).SeekGE.
+// SeekGE implements (base.InternalIterator).SeekGE. Unlike MergingIter.SeekGE,
+// SeekGE positions the iterator at the span containing key if one exists,
+// even if the span begins before key.
 func (i *InternalIteratorShim) SeekGE(
 	key []byte, trySeekUsingNext bool,
 ) (*base.InternalKey, []byte) {
-	panic("unimplemented")
+	i.span = i.miter.SeekLT(key)
+	if i.span.Empty() {
+		i.span = i.miter.SeekGE(key)
+	} else if i.cmp(i.span.End, key) <= 0 {
+		i.span = i.miter.Next()
+	}
+	if i.span.Empty() {
+		return nil, nil
+	}
+	i.iterKey = base.InternalKey{UserKey: i.span.Start, Trailer: i.span.Keys[0].Trailer}
+	return &i.iterKey, i.span.End
 }
 
 // SeekPrefixGE implements (base.InternalIterator).SeekPrefixGE.
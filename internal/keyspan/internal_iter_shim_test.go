@@ -0,0 +1,54 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package keyspan
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalIteratorShimSeekGE(t *testing.T) {
+	cmp := base.DefaultComparer.Compare
+	var shim InternalIteratorShim
+	shim.Init(cmp, NewIter(cmp, []Span{
+		ParseSpan("b-d:{(#5,RANGEDEL)}"),
+		ParseSpan("f-h:{(#3,RANGEDEL)}"),
+	}))
+
+	testCases := []struct {
+		key           string
+		expectedStart string
+		expectedEnd   string
+	}{
+		// Seeking to a key covered by a span returns the span, even though it
+		// begins before the key.
+		{"c", "b", "d"},
+		{"b", "b", "d"},
+		// Seeking to a key between spans returns the next span.
+		{"a", "b", "d"},
+		{"d", "f", "h"},
+		{"e", "f", "h"},
+		{"g", "f", "h"},
+		// Seeking beyond the last span exhausts the iterator.
+		{"h", "", ""},
+		{"z", "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			key, value := shim.SeekGE([]byte(tc.key), false /* trySeekUsingNext */)
+			if tc.expectedStart == "" {
+				require.Nil(t, key)
+				return
+			}
+			require.NotNil(t, key)
+			require.Equal(t, tc.expectedStart, string(key.UserKey))
+			require.Equal(t, base.InternalKeyKindRangeDelete, key.Kind())
+			require.Equal(t, tc.expectedEnd, string(value))
+		})
+	}
+	require.NoError(t, shim.Close())
+}
@@ -64,6 +64,12 @@ type TableStats struct {
 	// if snapshots or move compactions prevented the elision of their range
 	// tombstones.
 	RangeDeletionsBytesEstimate uint64
+	// HasTimeWindow is true if the table records the range of time windows
+	// spanned by its point keys in the pebble.time-window table property. The
+	// range is [MinTimeWindow, MaxTimeWindow].
+	HasTimeWindow bool
+	MinTimeWindow uint64
+	MaxTimeWindow uint64
 }
 
 // boundType represents the type of key (point or range) present as the smallest
@@ -209,8 +209,13 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	if n > 0 {
 		opts.FormatMajorVersion += pebble.FormatMajorVersion(rng.Intn(n))
 	}
+	opts.Experimental.AdaptiveCompactions = rng.Intn(2) != 0
+	opts.Experimental.CompressionConcurrency = rng.Intn(3)         // 0-2
 	opts.Experimental.L0CompactionConcurrency = 1 + rng.Intn(4)    // 1-4
+	opts.Experimental.MaxSubcompactions = rng.Intn(4)              // 0-3
 	opts.Experimental.MinDeletionRate = 1 << uint(20+rng.Intn(10)) // 1MB - 1GB
+	opts.Experimental.RangeDeletionCoverageThreshold = float64(rng.Intn(5))
+	opts.Experimental.TombstoneDensityThreshold = rng.Float64()
 	opts.Experimental.ValidateOnIngest = rng.Intn(2) != 0
 	opts.L0CompactionThreshold = 1 + rng.Intn(100) // 1 - 100
 	opts.L0StopWritesThreshold = 1 + rng.Intn(100) // 1 - 100
@@ -229,6 +234,7 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	lopts.BlockRestartInterval = 1 + rng.Intn(64)  // 1 - 64
 	lopts.BlockSize = 1 << uint(rng.Intn(24))      // 1 - 16MB
 	lopts.BlockSizeThreshold = 50 + rng.Intn(50)   // 50 - 100
+	lopts.DataBlockHashIndex = rng.Intn(2) == 0    // 50%
 	lopts.IndexBlockSize = 1 << uint(rng.Intn(24)) // 1 - 16MB
 	lopts.TargetFileSize = 1 << uint(rng.Intn(28)) // 1 - 256MB
 	opts.Levels = []pebble.LevelOptions{lopts}
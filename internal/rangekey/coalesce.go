@@ -19,27 +19,37 @@ import (
 // Keys other than RangeKeySets describing the current state of range keys.
 //
 // The snapshot sequence number parameter determines which keys are visible. Any
-// keys not visible at the provided snapshot are ignored.
+// keys not visible at the provided snapshot are ignored. If filter is non-nil,
+// RangeKeySets for which it returns false are removed too.
 func InitUserIteration(
 	cmp base.Compare,
 	snapshot uint64,
+	filter Filter,
 	miter *keyspan.MergingIter,
 	diter *keyspan.DefragmentingIter,
 	levelIters ...keyspan.FragmentIterator,
 ) keyspan.FragmentIterator {
-	miter.Init(cmp, userIterationTransform(snapshot), levelIters...)
+	miter.Init(cmp, userIterationTransform(snapshot, filter), levelIters...)
 	diter.Init(cmp, miter, userIterationDefragmenter())
 	return diter
 }
 
+// Filter is used during user iteration to filter individual range keys. It's
+// called with the bounds of a span and one of the span's RangeKeySets, and
+// returns true if the RangeKeySet should be surfaced. A filter is applied after
+// shadowing is resolved, so filtering out a RangeKeySet never reveals the keys
+// it shadows.
+type Filter func(start, end []byte, k keyspan.Key) (bool, error)
+
 // userIterationTransform returns a keyspan.Transform for use with a
 // keyspan.MergingIter that transforms spans by resolving range keys at the
 // provided snapshot sequence number. Shadowing of keys is resolved (eg, removal
 // of unset keys, removal of keys overwritten by a set at the same suffix, etc)
 // and then non-RangeKeySet keys are removed. The resulting transformed spans
 // only contain RangeKeySets describing the state visible at the provided
-// sequence number.
-func userIterationTransform(snapshot uint64) keyspan.Transform {
+// sequence number. If filter is non-nil, only the RangeKeySets it accepts are
+// retained.
+func userIterationTransform(snapshot uint64, filter Filter) keyspan.Transform {
 	return func(cmp base.Compare, s keyspan.Span, dst *keyspan.Span) error {
 		// Apply shadowing of keys.
 		if err := Coalesce(cmp, s.Visible(snapshot), dst); err != nil {
@@ -54,6 +64,13 @@ func userIterationTransform(snapshot uint64) keyspan.Transform {
 		for i := range keys {
 			switch keys[i].Kind() {
 			case base.InternalKeyKindRangeKeySet:
+				if filter != nil {
+					if ok, err := filter(dst.Start, dst.End, keys[i]); err != nil {
+						return err
+					} else if !ok {
+						continue
+					}
+				}
 				dst.Keys = append(dst.Keys, keys[i])
 			case base.InternalKeyKindRangeKeyUnset:
 				// Skip.
@@ -130,7 +130,7 @@ func TestDefragmenting(t *testing.T) {
 		case "iter":
 			var miter keyspan.MergingIter
 			var diter keyspan.DefragmentingIter
-			InitUserIteration(cmp, base.InternalKeySeqNumMax, &miter, &diter, keyspan.NewIter(cmp, spans))
+			InitUserIteration(cmp, base.InternalKeySeqNumMax, nil /* filter */, &miter, &diter, keyspan.NewIter(cmp, spans))
 			for _, line := range strings.Split(td.Input, "\n") {
 				runIterOp(&buf, &diter, line)
 			}
@@ -210,8 +210,8 @@ func testDefragmentingIteRandomizedOnce(t *testing.T, seed int64) {
 
 	var omiter, fmiter keyspan.MergingIter
 	var referenceIter, fragmentedIter keyspan.DefragmentingIter
-	InitUserIteration(cmp, base.InternalKeySeqNumMax, &omiter, &referenceIter, keyspan.NewIter(cmp, original))
-	InitUserIteration(cmp, base.InternalKeySeqNumMax, &fmiter, &fragmentedIter, keyspan.NewIter(cmp, fragmented))
+	InitUserIteration(cmp, base.InternalKeySeqNumMax, nil /* filter */, &omiter, &referenceIter, keyspan.NewIter(cmp, original))
+	InitUserIteration(cmp, base.InternalKeySeqNumMax, nil /* filter */, &fmiter, &fragmentedIter, keyspan.NewIter(cmp, fragmented))
 
 	// Generate 100 random operations and run them against both iterators.
 	const numIterOps = 100
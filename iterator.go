@@ -81,8 +81,6 @@ const (
 // https://github.com/cockroachdb/pebble/issues/29#issuecomment-494477985
 const readBytesPeriod uint64 = 1 << 16
 
-var errReversePrefixIteration = errors.New("pebble: unsupported reverse prefix iteration")
-
 // IteratorMetrics holds per-iterator metrics. These do not change over the
 // lifetime of the iterator.
 type IteratorMetrics struct {
@@ -214,6 +212,8 @@ type iteratorRangeKeyState struct {
 	// range key iterator.
 	rangeKeyIter keyspan.FragmentIterator
 	iter         keyspan.InterleavingIter
+	// masking implements IterOptions.RangeKeyMasking for iter.
+	masking rangeKeyMasking
 	// rangeKeyOnly is set to true if at the current iterator position there is
 	// no point key, only a range key start boundary.
 	rangeKeyOnly bool
@@ -690,6 +690,20 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			}
 		}
 
+		if i.hasPrefix {
+			n := i.split(key.UserKey)
+			if c := i.cmp(key.UserKey[:n], i.prefixOrFullSeekKey); c < 0 {
+				return
+			} else if c > 0 {
+				// Keys beyond the prefix are only encountered when switching
+				// from forward prefix iteration, which may have skipped over
+				// them. See reversePrefixIteration.
+				i.iterKey, i.iterValue = i.iter.Prev()
+				i.stats.ReverseStepCount[InternalIterCall]++
+				continue
+			}
+		}
+
 		switch key.Kind() {
 		case InternalKeyKindRangeKeySet:
 			// Range key start boundary markers are interleaved with the maximum
@@ -985,9 +999,9 @@ func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
 // "prefix" of the search key. Calling SeekPrefixGE puts the iterator in prefix
 // iteration mode. The iterator remains in prefix iteration until a subsequent
 // call to another absolute positioning method (SeekGE, SeekLT, First,
-// Last). Reverse iteration (Prev) is not supported when an iterator is in
-// prefix iteration mode. Returns true if the iterator is pointing at a valid
-// entry and false otherwise.
+// Last). Reverse iteration (Prev) in prefix iteration mode is bounded to the
+// prefix as well. Returns true if the iterator is pointing at a valid entry
+// and false otherwise.
 //
 // The semantics of SeekPrefixGE are slightly unusual and designed for
 // iteration to be able to take advantage of bloom filters that have been
@@ -1110,6 +1124,124 @@ func disableSeekOpt(key []byte, ptr uintptr) bool {
 	return key != nil && key[0]&byte(1) == 0 && simpleHash == 0
 }
 
+// SeekPrefixLT moves the iterator to the last key/value pair whose key is less
+// than the given key and which has the same "prefix" as the given key. It is
+// the reverse counterpart of SeekPrefixGE: it puts the iterator in prefix
+// iteration mode, in which the iterator will not observe keys not matching the
+// prefix of the search key in either direction, until a subsequent call to
+// another absolute positioning method. Returns true if the iterator is
+// pointing at a valid entry and false otherwise.
+//
+// As with SeekPrefixGE, the levels whose prefix bloom filters exclude the
+// prefix are not positioned, so that a search for a prefix held by few
+// sstables does not seek every level. Consider the keys "a@1", "a@2", "aa@3", "aa@4", with a Split
+// function separating the "@" suffix:
+//
+//   SeekPrefixLT("a@3") -> "a@2"
+//   Prev()              -> "a@1"
+//   Prev()              -> EOF
+//   SeekPrefixLT("a@1") -> EOF
+func (i *Iterator) SeekPrefixLT(key []byte) bool {
+	i.lastPositioningOp = unknownLastPositionOp
+	i.err = nil // clear cached iteration error
+	i.stats.ReverseSeekCount[InterfaceCall]++
+
+	if i.split == nil {
+		panic("pebble: split must be provided for SeekPrefixLT")
+	}
+
+	// Make a copy of the prefix so that modifications to the key after
+	// SeekPrefixLT returns does not affect the stored prefix.
+	prefixLen := i.split(key)
+	i.prefixOrFullSeekKey = append(i.prefixOrFullSeekKey[:0], key[:prefixLen]...)
+	i.hasPrefix = true
+
+	if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) > 0 {
+		if n := i.split(upperBound); !bytes.Equal(i.prefixOrFullSeekKey, upperBound[:n]) {
+			i.err = errors.New("pebble: SeekPrefixLT supplied with key outside of upper bound")
+			i.iterValidityState = IterExhausted
+			return false
+		}
+		key = upperBound
+	} else if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
+		key = lowerBound
+	}
+
+	i.iterKey, i.iterValue = i.seekPrefixLT(key)
+	i.findPrevEntry(nil)
+	i.maybeSampleRead()
+	return i.iterValidityState == IterValid
+}
+
+// seekPrefixLT positions the internal iterator at the last key less than the
+// given key, or the last key if key is nil, through SeekPrefixLT if the
+// internal iterator implements it, so that the levels whose prefix bloom
+// filters exclude the iterator's prefix are not positioned.
+func (i *Iterator) seekPrefixLT(key []byte) (*InternalKey, []byte) {
+	i.stats.ReverseSeekCount[InternalIterCall]++
+	return base.SeekPrefixLT(i.iter, i.prefixOrFullSeekKey, key)
+}
+
+// seekPrefixStart positions the internal iterator at the first key with the
+// iterator's prefix, through SeekPrefixGE, so that the prefix bloom filters
+// are consulted.
+func (i *Iterator) seekPrefixStart() (*InternalKey, []byte) {
+	key := i.prefixOrFullSeekKey
+	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
+		key = lowerBound
+	}
+	i.stats.ForwardSeekCount[InternalIterCall]++
+	return i.iter.SeekPrefixGE(i.prefixOrFullSeekKey, key, false /* trySeekUsingNext */)
+}
+
+// reversePrefixIteration repositions the internal iterator for reverse
+// iteration in prefix iteration mode, when it was last positioned for forward
+// iteration. The internal iterator may have been positioned by SeekPrefixGE,
+// which does not position levels whose bloom filters exclude the prefix, so it
+// is sought rather than stepped backward, through SeekPrefixLT, which leaves
+// those levels unpositioned. If the iterator is exhausted, it is sought to the
+// key which ended forward iteration, which succeeds every key with the prefix.
+// If there is no such key, the levels positioned by SeekPrefixGE hold no keys
+// after the prefix, and it is sought to the upper bound or the last key.
+func (i *Iterator) reversePrefixIteration() {
+	var seekKey []byte
+	if i.iterValidityState == IterValid {
+		seekKey = i.key
+	} else if i.iterKey != nil {
+		seekKey = i.iterKey.UserKey
+	} else {
+		seekKey = i.opts.GetUpperBound()
+	}
+	i.iterValidityState = IterExhausted
+	if seekKey != nil {
+		// Copy the key, which may point into the internal iterator's memory.
+		i.keyBuf = append(i.keyBuf[:0], seekKey...)
+		seekKey = i.keyBuf
+	}
+	i.iterKey, i.iterValue = i.seekPrefixLT(seekKey)
+}
+
+// forwardPrefixIteration repositions the internal iterator for forward
+// iteration in prefix iteration mode, when it was last positioned for reverse
+// iteration. As in reversePrefixIteration, the levels left unpositioned by
+// SeekPrefixLT prevent stepping the internal iterator forward, so it is sought
+// through SeekPrefixGE: past the current key, or to the first key with the
+// prefix if reverse iteration was exhausted.
+func (i *Iterator) forwardPrefixIteration() {
+	if i.iterValidityState != IterValid {
+		i.iterKey, i.iterValue = i.seekPrefixStart()
+		return
+	}
+	i.keyBuf = append(i.keyBuf[:0], i.key...)
+	i.key = i.keyBuf
+	i.iterKey, i.iterValue = i.iter.SeekPrefixGE(i.prefixOrFullSeekKey, i.key, false /* trySeekUsingNext */)
+	i.stats.ForwardSeekCount[InternalIterCall]++
+	for i.iterKey != nil && i.equal(i.key, i.iterKey.UserKey) {
+		i.iterKey, i.iterValue = i.iter.Next()
+		i.stats.ForwardStepCount[InternalIterCall]++
+	}
+}
+
 // SeekLT moves the iterator to the last key/value pair whose key is less than
 // the given key. Returns true if the iterator is pointing at a valid entry and
 // false otherwise.
@@ -1250,6 +1382,12 @@ func (i *Iterator) NextWithLimit(limit []byte) IterValidityState {
 		return i.iterValidityState
 	}
 	i.lastPositioningOp = unknownLastPositionOp
+	if i.hasPrefix && (i.pos == iterPosCurReverse || i.pos == iterPosCurReversePaused || i.pos == iterPosPrev) {
+		i.forwardPrefixIteration()
+		i.findNextEntry(nil)
+		i.maybeSampleRead()
+		return i.iterValidityState
+	}
 	switch i.pos {
 	case iterPosCurForward:
 		i.nextUserKey()
@@ -1334,9 +1472,22 @@ func (i *Iterator) PrevWithLimit(limit []byte) IterValidityState {
 	}
 	i.lastPositioningOp = unknownLastPositionOp
 	if i.hasPrefix {
-		i.err = errReversePrefixIteration
-		i.iterValidityState = IterExhausted
-		return i.iterValidityState
+		if limit != nil {
+			i.err = errors.New("cannot use limit with prefix iteration")
+			i.iterValidityState = IterExhausted
+			return i.iterValidityState
+		}
+		if i.pos == iterPosCurReverse && i.iterValidityState == IterExhausted {
+			// Reverse iteration already exhausted the prefix. A subsequent
+			// Next seeks to the prefix's first key.
+			return i.iterValidityState
+		}
+		if i.pos == iterPosCurForward || i.pos == iterPosNext || i.pos == iterPosCurForwardPaused {
+			i.reversePrefixIteration()
+			i.findPrevEntry(nil)
+			i.maybeSampleRead()
+			return i.iterValidityState
+		}
 	}
 	switch i.pos {
 	case iterPosCurForward:
@@ -1720,6 +1871,10 @@ func (i *Iterator) SetBounds(lower, upper []byte) {
 //
 // If only lower and upper bounds need to be modified, prefer SetBounds.
 func (i *Iterator) SetOptions(o *IterOptions) {
+	if i.opts.ReadAtSeqNum != o.ReadAtSeqNum {
+		panic("pebble: ReadAtSeqNum cannot be changed by SetOptions")
+	}
+
 	// Even though this is not a positioning operation, the alteration of the
 	// bounds means we cannot optimize Seeks by using Next.
 	i.lastPositioningOp = unknownLastPositionOp
@@ -1740,12 +1895,28 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	i.iterValidityState = IterExhausted
 
 	// If OnlyReadGuaranteedDurable changed, the iterator stacks are incorrect,
-	// improperly including or excluding memtables. Invalidate them so that
-	// finishInitializingIter will reconstruct them.
-	if i.opts.OnlyReadGuaranteedDurable != o.OnlyReadGuaranteedDurable {
+	// improperly including or excluding memtables. If PrefetchBlocks or
+	// ConcurrentLevelSeeks changed, the sstable and merging iterators are
+	// configured incorrectly. Invalidate them so that finishInitializingIter
+	// will reconstruct them.
+	if i.opts.OnlyReadGuaranteedDurable != o.OnlyReadGuaranteedDurable ||
+		i.opts.PrefetchBlocks != o.PrefetchBlocks ||
+		i.opts.ConcurrentLevelSeeks != o.ConcurrentLevelSeeks {
+		if i.pointIter != nil {
+			i.err = firstError(i.err, i.pointIter.Close())
+		}
+		if i.rangeKey != nil {
+			i.err = firstError(i.err, i.rangeKey.rangeKeyIter.Close())
+		}
 		i.pointIter = nil
 		i.rangeKey = nil
 	}
+	// If range keys are filtered, the range key iterator's filter may be stale.
+	if i.rangeKey != nil && i.newRangeKeyIter != nil &&
+		(len(i.opts.RangeKeyFilters) > 0 || len(o.RangeKeyFilters) > 0) {
+		i.err = firstError(i.err, i.rangeKey.rangeKeyIter.Close())
+		i.rangeKey = nil
+	}
 
 	i.opts = *o
 	finishInitializingIter(i.alloc)
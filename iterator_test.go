@@ -6,9 +6,11 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -16,10 +18,12 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -1427,6 +1431,110 @@ func TestIteratorRandomizedBlockIntervalFilter(t *testing.T) {
 	require.Equal(t, 0, len(matchingKeyValues))
 }
 
+func TestIteratorRangeFilter(t *testing.T) {
+	seed := *seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+		fmt.Printf("seed: %d\n", seed)
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	// Open two DBs, identical except that one of them writes range filters,
+	// and verify that bounded iteration produces the same results in both.
+	open := func(prefixLength int) *DB {
+		opts := &Options{
+			FS:                          vfs.NewMem(),
+			DisableAutomaticCompactions: true,
+			Levels:                      []LevelOptions{{RangeFilterPrefixLength: prefixLength}},
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		return d
+	}
+	dbs := []*DB{open(0), open(2)}
+	defer func() {
+		for _, d := range dbs {
+			require.NoError(t, d.Close())
+		}
+	}()
+
+	randKey := func() []byte {
+		const letters = "abcdefghij"
+		k := make([]byte, 1+rng.Intn(4))
+		for i := range k {
+			k[i] = letters[rng.Intn(len(letters))]
+		}
+		return k
+	}
+	for i := 0; i < 10; i++ {
+		b := []*Batch{dbs[0].NewBatch(), dbs[1].NewBatch()}
+		for j := 0; j < 20; j++ {
+			k := randKey()
+			if rng.Intn(20) == 0 {
+				end := randKey()
+				if bytes.Compare(k, end) > 0 {
+					k, end = end, k
+				}
+				for _, b := range b {
+					require.NoError(t, b.DeleteRange(k, end, nil))
+				}
+				continue
+			}
+			for _, b := range b {
+				require.NoError(t, b.Set(k, k, nil))
+			}
+		}
+		for j, d := range dbs {
+			require.NoError(t, b[j].Commit(nil))
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	readAll := func(iter *Iterator) string {
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		return buf.String()
+	}
+	iters := []*Iterator{dbs[0].NewIter(nil), dbs[1].NewIter(nil)}
+	for i := 0; i < 500; i++ {
+		lower, upper := randKey(), randKey()
+		if bytes.Compare(lower, upper) > 0 {
+			lower, upper = upper, lower
+		}
+		// Alternate between creating new iterators and reusing iterators with
+		// SetBounds, which must discard tables excluded under previous bounds.
+		for j, d := range dbs {
+			if rng.Intn(2) == 0 {
+				require.NoError(t, iters[j].Close())
+				iters[j] = d.NewIter(&IterOptions{LowerBound: lower, UpperBound: upper})
+			} else {
+				iters[j].SetBounds(lower, upper)
+			}
+		}
+		require.Equalf(t, readAll(iters[0]), readAll(iters[1]), "[%s, %s)", lower, upper)
+	}
+	for _, iter := range iters {
+		require.NoError(t, iter.Close())
+	}
+
+	// An iterator whose bounds fall within a gap of a table's keys must not
+	// read any blocks from that table.
+	d := dbs[1]
+	require.NoError(t, d.Set([]byte("ka"), nil, nil))
+	require.NoError(t, d.Set([]byte("kz"), nil, nil))
+	require.NoError(t, d.Flush())
+	iter := d.NewIter(&IterOptions{LowerBound: []byte("kb"), UpperBound: []byte("ky")})
+	iter.ResetStats()
+	require.False(t, iter.SeekGE([]byte("kb")))
+	require.Zero(t, iter.Stats().InternalStats.BlockBytes)
+	iter.SetBounds([]byte("ka"), []byte("kb"))
+	require.True(t, iter.SeekGE([]byte("ka")))
+	require.Equal(t, []byte("ka"), iter.Key())
+	require.NoError(t, iter.Close())
+}
+
 func TestIteratorGuaranteedDurable(t *testing.T) {
 	mem := vfs.NewMem()
 	opts := &Options{FS: mem}
@@ -1761,3 +1869,298 @@ func BenchmarkBlockPropertyFilter(b *testing.B) {
 		})
 	}
 }
+
+func TestIteratorReversePrefixIteration(t *testing.T) {
+	seed := *seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+		fmt.Printf("seed: %d\n", seed)
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+		Levels:             []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+		MemTableSize:       2 << 10,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Write keys with random suffixes across memtables and sstables in
+	// several levels, tracking the live keys.
+	ks := testkeys.Alpha(2)
+	live := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		key := testkeys.KeyAt(ks, rng.Intn(ks.Count()/2), rng.Intn(10))
+		if rng.Intn(4) == 0 {
+			require.NoError(t, d.Delete(key, nil))
+			delete(live, string(key))
+		} else {
+			require.NoError(t, d.Set(key, key, nil))
+			live[string(key)] = true
+		}
+		if i%500 == 499 {
+			require.NoError(t, d.Flush())
+		}
+	}
+	var keys []string
+	for k := range live {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return testkeys.Comparer.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+
+	iter := d.NewIter(&IterOptions{ConcurrentLevelSeeks: rng.Intn(2) == 0})
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	for i := 0; i < 1000; i++ {
+		// Use a prefix from the upper half of the keyspace, which was never
+		// written, a fifth of the time.
+		prefixIdx := rng.Intn(ks.Count() / 2)
+		if rng.Intn(5) == 0 {
+			prefixIdx += ks.Count() / 2
+		}
+		prefix := testkeys.Key(ks, prefixIdx)
+		var prefixKeys []string
+		for _, k := range keys {
+			if bytes.Equal(prefix, []byte(k)[:testkeys.Comparer.Split([]byte(k))]) {
+				prefixKeys = append(prefixKeys, k)
+			}
+		}
+
+		// pos is the index of the expected key within prefixKeys, -1 if the
+		// iterator is exhausted before the prefix and len(prefixKeys) if after.
+		seekKey := testkeys.KeyAt(ks, prefixIdx, rng.Intn(12))
+		var pos int
+		var valid bool
+		if rng.Intn(2) == 0 {
+			pos = sort.Search(len(prefixKeys), func(i int) bool {
+				return testkeys.Comparer.Compare([]byte(prefixKeys[i]), seekKey) >= 0
+			}) - 1
+			valid = iter.SeekPrefixLT(seekKey)
+		} else {
+			pos = sort.Search(len(prefixKeys), func(i int) bool {
+				return testkeys.Comparer.Compare([]byte(prefixKeys[i]), seekKey) >= 0
+			})
+			valid = iter.SeekPrefixGE(seekKey)
+		}
+		ops := fmt.Sprintf("seek %s", seekKey)
+		for j := 0; ; j++ {
+			require.NoError(t, iter.Error(), "%s", ops)
+			if pos < 0 || pos >= len(prefixKeys) {
+				require.False(t, valid, "%s: found %s", ops, iter.Key())
+			} else {
+				require.True(t, valid, "%s: expected %s", ops, prefixKeys[pos])
+				require.Equal(t, prefixKeys[pos], string(iter.Key()), "%s", ops)
+			}
+			if j == 5 {
+				break
+			}
+			if rng.Intn(2) == 0 {
+				ops += ", next"
+				if pos < len(prefixKeys) {
+					pos++
+				}
+				valid = iter.Next()
+			} else {
+				ops += ", prev"
+				if pos >= 0 {
+					pos--
+				}
+				valid = iter.Prev()
+			}
+		}
+	}
+}
+
+func TestIteratorSeekPrefixLTFilters(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+		Levels:             []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	d.mu.Lock()
+	d.opts.DisableAutomaticCompactions = true
+	d.mu.Unlock()
+
+	// Flush three overlapping sstables into separate L0 sublevels, only one of
+	// which holds keys with the prefix "m".
+	for _, keys := range [][]string{{"a@1", "m@1", "m@2", "z@1"}, {"b@1", "y@1"}, {"c@1", "x@1"}} {
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	blockBytes := func(seek func(iter *Iterator) bool) uint64 {
+		iter := d.NewIter(nil)
+		defer func() {
+			require.NoError(t, iter.Close())
+		}()
+		iter.ResetStats()
+		require.True(t, seek(iter))
+		require.Equal(t, "m@2", string(iter.Key()))
+		return iter.Stats().InternalStats.BlockBytes
+	}
+	seekLT := blockBytes(func(iter *Iterator) bool {
+		return iter.SeekLT([]byte("m@1"))
+	})
+	seekPrefixLT := blockBytes(func(iter *Iterator) bool {
+		return iter.SeekPrefixLT([]byte("m@1"))
+	})
+	seekPrefixGE := blockBytes(func(iter *Iterator) bool {
+		return iter.SeekPrefixGE([]byte("m@2"))
+	})
+	// As SeekPrefixGE, SeekPrefixLT only reads the data block of the sstable
+	// whose bloom filter does not exclude the prefix.
+	require.Equal(t, seekPrefixGE, seekPrefixLT)
+	require.Less(t, seekPrefixLT, seekLT)
+}
+
+func TestIteratorReversePrefixIterationExhausted(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+		Levels:             []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	d.mu.Lock()
+	d.opts.DisableAutomaticCompactions = true
+	d.mu.Unlock()
+
+	// The prefix "m" is held by an sstable with no keys after it, beneath an
+	// overlapping sstable with many keys after it, whose bloom filter excludes
+	// the prefix.
+	require.NoError(t, d.Set([]byte("m@1"), nil, nil))
+	require.NoError(t, d.Set([]byte("m@2"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("a@1"), nil, nil))
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("n%04d@1", i)), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+
+	iter := d.NewIter(nil)
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	require.True(t, iter.SeekPrefixGE([]byte("m@2")))
+	require.True(t, iter.Next())
+	require.False(t, iter.Next())
+	iter.ResetStats()
+
+	// Reverse iteration from the exhausted forward prefix iteration does not
+	// step back over the keys after the prefix.
+	require.True(t, iter.Prev())
+	require.Equal(t, "m@1", string(iter.Key()))
+	require.True(t, iter.Prev())
+	require.Equal(t, "m@2", string(iter.Key()))
+	require.False(t, iter.Prev())
+	require.Less(t, iter.Stats().ReverseStepCount[InternalIterCall], 10)
+}
+
+func TestIteratorConcurrentLevelSeeks(t *testing.T) {
+	seed := *seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+		fmt.Printf("seed: %d\n", seed)
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatNewest,
+		LBaseMaxBytes:      1,
+		MemTableSize:       16 << 10,
+	}
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Populate every level with point keys and range deletions, compacting
+	// each batch of writes into progressively higher levels, and leave the
+	// last batch in the memtable.
+	key := func() []byte {
+		return []byte(fmt.Sprintf("%04d", rng.Intn(1000)))
+	}
+	for level := numLevels - 1; level >= -1; level-- {
+		for i := 0; i < 200; i++ {
+			if rng.Intn(20) == 0 {
+				start, end := key(), key()
+				if bytes.Compare(start, end) > 0 {
+					start, end = end, start
+				}
+				require.NoError(t, d.DeleteRange(start, end, nil))
+			} else {
+				k := key()
+				require.NoError(t, d.Set(k, k, nil))
+			}
+		}
+		if level >= 0 {
+			require.NoError(t, d.Flush())
+		}
+		if level > 0 {
+			require.NoError(t, d.CompactWithOptions(context.Background(),
+				[]byte("0000"), []byte("9999"), CompactOptions{TargetLevel: level}))
+		}
+	}
+
+	iter := d.NewIter(nil)
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	concurrentIter := d.NewIter(&IterOptions{ConcurrentLevelSeeks: true})
+	defer func() {
+		require.NoError(t, concurrentIter.Close())
+	}()
+	var ops []string
+	for i := 0; i < 2000; i++ {
+		var valid, concurrentValid bool
+		switch rng.Intn(6) {
+		case 0:
+			ops = append(ops, "first")
+			valid, concurrentValid = iter.First(), concurrentIter.First()
+		case 1:
+			ops = append(ops, "last")
+			valid, concurrentValid = iter.Last(), concurrentIter.Last()
+		case 2:
+			k := key()
+			ops = append(ops, fmt.Sprintf("seek-ge %s", k))
+			valid, concurrentValid = iter.SeekGE(k), concurrentIter.SeekGE(k)
+		case 3:
+			k := key()
+			ops = append(ops, fmt.Sprintf("seek-lt %s", k))
+			valid, concurrentValid = iter.SeekLT(k), concurrentIter.SeekLT(k)
+		case 4:
+			ops = append(ops, "next")
+			valid, concurrentValid = iter.Next(), concurrentIter.Next()
+		case 5:
+			ops = append(ops, "prev")
+			valid, concurrentValid = iter.Prev(), concurrentIter.Prev()
+		}
+		require.Equal(t, valid, concurrentValid, "%s", ops)
+		if valid {
+			require.Equal(t, string(iter.Key()), string(concurrentIter.Key()), "%s", ops)
+		}
+		require.NoError(t, concurrentIter.Error())
+	}
+}
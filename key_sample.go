@@ -0,0 +1,142 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/fastrand"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// KeySampleIter iterates, in key order, over keys drawn approximately
+// uniformly from the point keys of a key range. It is returned by
+// DB.NewKeySampleIter.
+//
+// The sampled keys are the index separators of the sstables' data blocks, and
+// so are not necessarily keys present in the DB: a separator is greater than or
+// equal to every key of its data block, and less than every key of the next
+// one. A key is sampled more than once if it is drawn more than once.
+type KeySampleIter struct {
+	keys [][]byte
+	pos  int
+}
+
+// First moves the iterator to the smallest sampled key, returning true if the
+// sample is not empty.
+func (i *KeySampleIter) First() bool {
+	i.pos = 0
+	return i.Valid()
+}
+
+// Next moves the iterator to the next sampled key, returning true if the
+// iterator is pointing at a valid key.
+func (i *KeySampleIter) Next() bool {
+	if i.pos < len(i.keys) {
+		i.pos++
+	}
+	return i.Valid()
+}
+
+// Valid returns true if the iterator is positioned at a valid key.
+func (i *KeySampleIter) Valid() bool {
+	return i.pos < len(i.keys)
+}
+
+// Key returns the sampled key at the current iterator position. The caller
+// may retain the key.
+func (i *KeySampleIter) Key() []byte {
+	return i.keys[i.pos]
+}
+
+// Len returns the number of sampled keys.
+func (i *KeySampleIter) Len() int {
+	return len(i.keys)
+}
+
+// NewKeySampleIter returns an iterator over n keys drawn approximately
+// uniformly, with replacement, from the point keys within the range
+// `[start, end]`. The sample is computed when the iterator is created, without
+// scanning the range: each key is drawn from an sstable overlapping the range,
+// chosen in proportion to its estimated count of keys within the range as for
+// ApproximateKeyCount, and within that sstable from a data block chosen in
+// proportion to its size. Keys in memtables are not sampled, and neither are
+// keys deleted by tombstones nor the obsolete versions of keys distinguished.
+//
+// Sampling is cheap relative to a scan, as it only reads the index blocks of
+// the overlapping sstables, which makes it suitable for picking the split points
+// of a range, e.g. the median of its keys.
+func (d *DB) NewKeySampleIter(start, end []byte, n int) (*KeySampleIter, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.cmp(start, end) > 0 {
+		return nil, errors.New("invalid key-range specified (start > end)")
+	}
+	if n < 0 {
+		return nil, errors.Errorf("invalid sample size %d", n)
+	}
+
+	readState := d.loadReadState()
+	defer readState.unref()
+	tables, _, err := d.estimateOverlappingTables(readState.current, start, end)
+	if err != nil {
+		return nil, err
+	}
+	var total float64
+	for _, t := range tables {
+		total += t.keys
+	}
+	iter := &KeySampleIter{}
+	if total == 0 || n == 0 {
+		return iter, nil
+	}
+
+	// Draw the positions of the sampled keys within the range's keys, ordered
+	// so that the positions falling within each table are adjacent.
+	positions := make([]float64, n)
+	for i := range positions {
+		positions[i] = float64(fastrand.Uint32()) / (1 << 32) * total
+	}
+	sort.Float64s(positions)
+
+	iter.keys = make([][]byte, 0, n)
+	var tableStart float64
+	for i, t := range tables {
+		if len(positions) == 0 {
+			break
+		}
+		// Map the positions within the table to fractions of the table's data
+		// within the range. The last table takes any positions left over by
+		// rounding.
+		last := i == len(tables)-1
+		var fractions []float64
+		for len(positions) > 0 && (last || positions[0] < tableStart+t.keys) {
+			var fraction float64
+			if t.keys > 0 {
+				fraction = (positions[0] - tableStart) / t.keys
+			}
+			fractions = append(fractions, fraction)
+			positions = positions[1:]
+		}
+		tableStart += t.keys
+		if len(fractions) == 0 {
+			continue
+		}
+		err := d.tableCache.withReader(t.file, func(r *sstable.Reader) error {
+			keys, err := r.SampleKeys(start, end, fractions)
+			iter.keys = append(iter.keys, keys...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(iter.keys, func(i, j int) bool {
+		return d.cmp(iter.keys[i], iter.keys[j]) < 0
+	})
+	return iter, nil
+}
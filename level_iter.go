@@ -73,7 +73,11 @@ type levelIter struct {
 	//   be relevant to the iteration.
 	iter     internalIteratorWithStats
 	iterFile *fileMetadata
-	newIters tableNewIters
+	// iterRangeFiltered is true if iter is a placeholder for a table that was
+	// excluded by its range filter. Such an iterator must be discarded when
+	// the bounds change.
+	iterRangeFiltered bool
+	newIters          tableNewIters
 	// When rangeDelIterPtr != nil, the caller requires that *rangeDelIterPtr must
 	// point to a range del iterator corresponding to the current file. When this
 	// iterator returns nil, *rangeDelIterPtr should also be set to nil. Whenever
@@ -156,6 +160,7 @@ type levelIter struct {
 
 // levelIter implements the base.InternalIterator interface.
 var _ base.InternalIterator = (*levelIter)(nil)
+var _ base.PrefixLTSeeker = (*levelIter)(nil)
 
 // newLevelIter returns a levelIter. It is permissible to pass a nil split
 // parameter if the caller is never going to call SeekPrefixGE.
@@ -189,6 +194,7 @@ func (l *levelIter) init(
 	l.upper = opts.UpperBound
 	l.tableOpts.TableFilter = opts.TableFilter
 	l.tableOpts.PointKeyFilters = opts.PointKeyFilters
+	l.tableOpts.PrefetchBlocks = opts.PrefetchBlocks
 	l.cmp = cmp
 	l.split = split
 	l.iterFile = nil
@@ -341,6 +347,7 @@ func (l *levelIter) loadFile(file *fileMetadata, dir int) loadFileReturnIndicato
 		var rangeDelIter keyspan.FragmentIterator
 		var iter internalIterator
 		iter, rangeDelIter, l.err = l.newIters(l.files.Current(), &l.tableOpts, l.bytesIterated)
+		l.iterRangeFiltered = iter == rangeFilteredIter
 		l.iter = base.WrapIterWithStats(iter)
 		if l.err != nil {
 			return noFileLoaded
@@ -484,6 +491,83 @@ func (l *levelIter) SeekLT(key []byte) (*InternalKey, []byte) {
 	return l.verify(l.skipEmptyFileBackward())
 }
 
+// SeekPrefixLT implements base.PrefixLTSeeker. Only the tables which may hold
+// keys with the prefix are loaded, and of those, the tables whose bloom
+// filters exclude the prefix are skipped.
+func (l *levelIter) SeekPrefixLT(prefix, key []byte) (*base.InternalKey, []byte) {
+	l.err = nil // clear cached iteration error
+	if l.isSyntheticIterBoundsKey != nil {
+		*l.isSyntheticIterBoundsKey = false
+	}
+
+	// NB: the top-level Iterator has already adjusted key based on
+	// IterOptions.UpperBound.
+	var f *fileMetadata
+	if key != nil {
+		f = l.findFileLT(key)
+	} else {
+		f = l.files.Last()
+	}
+	if f != nil && l.cmpPrefix(f.Smallest.UserKey, prefix) > 0 {
+		// The file lies after the prefix. Rather than stepping backward
+		// through the files, find the last file which may hold the prefix.
+		for f = l.files.SeekGE(l.cmp, prefix); f != nil; {
+			next := l.files.Next()
+			if next == nil {
+				f = l.files.Last()
+				break
+			}
+			if l.cmpPrefix(next.Smallest.UserKey, prefix) > 0 {
+				f = l.files.Prev()
+				break
+			}
+			f = next
+		}
+		if f != nil && l.cmpPrefix(f.Smallest.UserKey, prefix) > 0 {
+			f = nil
+		}
+	}
+	if l.loadFile(f, -1) == noFileLoaded {
+		return nil, nil
+	}
+	for {
+		if key, val := base.SeekPrefixLT(l.iter, prefix, key); key != nil {
+			return l.verify(key, val)
+		}
+		// As in SeekPrefixGE, a key with the prefix does not necessarily
+		// precede the current file, but a synthetic boundary key is generated
+		// if the file has range tombstones.
+		if l.rangeDelIterPtr != nil && *l.rangeDelIterPtr != nil {
+			if l.tableOpts.LowerBound != nil {
+				l.syntheticBoundary.UserKey = l.tableOpts.LowerBound
+				l.syntheticBoundary.Trailer = InternalKeyRangeDeleteSentinel
+				l.smallestBoundary = &l.syntheticBoundary
+				if l.isSyntheticIterBoundsKey != nil {
+					*l.isSyntheticIterBoundsKey = true
+				}
+				return l.verify(l.smallestBoundary, nil)
+			}
+			l.syntheticBoundary = l.iterFile.Smallest
+			l.syntheticBoundary.SetKind(InternalKeyKindRangeDelete)
+			l.smallestBoundary = &l.syntheticBoundary
+			return l.verify(l.smallestBoundary, nil)
+		}
+		// Keys with the prefix only precede the current file if its smallest
+		// key has the prefix.
+		if l.cmpPrefix(l.iterFile.Smallest.UserKey, prefix) < 0 {
+			return nil, nil
+		}
+		if l.loadFile(l.files.Prev(), -1) == noFileLoaded {
+			return nil, nil
+		}
+	}
+}
+
+// cmpPrefix compares the prefix of the user key with the given prefix.
+func (l *levelIter) cmpPrefix(userKey, prefix []byte) int {
+	return l.cmp(userKey[:l.split(userKey)], prefix)
+}
+
 func (l *levelIter) First() (*InternalKey, []byte) {
 	l.err = nil // clear cached iteration error
 	if l.isSyntheticIterBoundsKey != nil {
@@ -733,6 +817,7 @@ func (l *levelIter) Close() error {
 		l.stats.Merge(l.iter.Stats())
 		l.err = l.iter.Close()
 		l.iter = nil
+		l.iterRangeFiltered = false
 	}
 	if l.rangeDelIterPtr != nil {
 		if t := l.rangeDelIterCopy; t != nil {
@@ -754,9 +839,10 @@ func (l *levelIter) SetBounds(lower, upper []byte) {
 
 	// Update tableOpts.{Lower,Upper}Bound in case the new boundaries fall within
 	// the boundaries of the current table.
-	if l.initTableBounds(l.iterFile) != 0 {
-		// The table does not overlap the bounds. Close() will set levelIter.err if
-		// an error occurs.
+	if l.initTableBounds(l.iterFile) != 0 || l.iterRangeFiltered {
+		// The table does not overlap the bounds, or the table was excluded by
+		// its range filter for the previous bounds. Close() will set
+		// levelIter.err if an error occurs.
 		_ = l.Close()
 		return
 	}
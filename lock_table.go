@@ -0,0 +1,348 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// LockMode is the mode in which a transaction locks a key or key span.
+type LockMode int8
+
+const (
+	// LockShared locks a key or key span for reading. Any number of
+	// transactions may hold overlapping shared locks.
+	LockShared LockMode = iota
+	// LockExclusive locks a key or key span for writing. A transaction holding
+	// an exclusive lock excludes every other transaction from holding an
+	// overlapping lock of either mode.
+	LockExclusive
+)
+
+// String implements fmt.Stringer.
+func (m LockMode) String() string {
+	switch m {
+	case LockShared:
+		return "shared"
+	case LockExclusive:
+		return "exclusive"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrLockTimeout is returned by Txn.Lock and Txn.LockRange when a lock could
+// not be acquired within the transaction's lock timeout.
+var ErrLockTimeout = errors.New("pebble: lock wait timed out")
+
+// ErrDeadlock is returned by Txn.Lock and Txn.LockRange when waiting for a
+// lock would deadlock, because a holder of the lock is itself waiting, directly
+// or indirectly, for a lock held by the requesting transaction.
+var ErrDeadlock = errors.New("pebble: deadlock detected")
+
+// lockTable holds the locks acquired by the transactions of a DB. Locks are
+// held on user keys and on spans of user keys. A request for a lock which
+// conflicts with a lock held by another transaction is queued until the
+// conflicting locks are released, the request times out, the DB is closed, or
+// the wait is found to close a cycle in the waits-for graph.
+//
+// Queued requests are granted in the order in which they were made: a request
+// which conflicts with an earlier queued request also waits for it, even if
+// the lock could be granted immediately. Shared lockers therefore cannot
+// starve a waiting exclusive locker. A waiter's waitingFor edges point to the
+// owners of the held locks and earlier queued requests which conflict with its
+// request. As locks are granted and released, the edges are recomputed, and
+// only ever shrink, so a cycle is detected by the last of its transactions to
+// queue a request.
+type lockTable struct {
+	cmp Compare
+	mu  sync.Mutex
+	// points holds the locks on individual keys, indexed by key.
+	points map[string][]*heldLock
+	// spans holds the locks on key spans.
+	spans []*heldLock
+	// queue holds the requests waiting to be granted, in the order in which
+	// they were made.
+	queue []*lockRequest
+	// closed is closed when the DB is closed, failing the requests waiting to
+	// be granted.
+	closed <-chan struct{}
+}
+
+// lockOwner is the holder of a set of locks in a lockTable, typically a
+// transaction. The fields are protected by lockTable.mu.
+type lockOwner struct {
+	held []*heldLock
+	// waitingFor holds the owners of the locks and queued requests which
+	// conflict with the lock the owner is waiting to acquire, if any.
+	waitingFor []*lockOwner
+}
+
+// heldLock is a lock held on the key start, or if end is non-nil, the span
+// [start, end).
+type heldLock struct {
+	owner      *lockOwner
+	start, end []byte
+	mode       LockMode
+}
+
+// lockRequest is a request for a lock queued in a lockTable. granted is
+// closed once the lock is granted and held by its owner.
+type lockRequest struct {
+	heldLock
+	granted chan struct{}
+}
+
+func (lt *lockTable) init(cmp Compare, closed <-chan struct{}) {
+	lt.cmp = cmp
+	lt.points = make(map[string][]*heldLock)
+	lt.closed = closed
+}
+
+// acquire acquires a lock for o on the key start, or if end is non-nil, the
+// span [start, end). A timeout of zero waits indefinitely, or until the DB is
+// closed, in which case ErrClosed is returned.
+func (lt *lockTable) acquire(
+	o *lockOwner, start, end []byte, mode LockMode, timeout time.Duration,
+) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.holdsLocked(o, start, end, mode) {
+		return nil
+	}
+	req := &lockRequest{heldLock: heldLock{owner: o, start: append([]byte(nil), start...), mode: mode}}
+	if end != nil {
+		req.end = append([]byte(nil), end...)
+	}
+	blockers := lt.blockersLocked(req, lt.queue)
+	if len(blockers) == 0 {
+		lt.grantLocked(req)
+		return nil
+	}
+	if lt.reachesLocked(blockers, o) {
+		return ErrDeadlock
+	}
+	o.waitingFor = blockers
+	req.granted = make(chan struct{})
+	lt.queue = append(lt.queue, req)
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	lt.mu.Unlock()
+	var err error
+	select {
+	case <-req.granted:
+	case <-timer:
+		err = ErrLockTimeout
+	case <-lt.closed:
+		err = ErrClosed
+	}
+	lt.mu.Lock()
+
+	select {
+	case <-req.granted:
+		// The lock was granted, possibly concurrently with the timeout.
+		return nil
+	default:
+	}
+	// Withdraw the request. Later requests which were only waiting for it may
+	// now be granted.
+	for i := range lt.queue {
+		if lt.queue[i] == req {
+			lt.queue = append(lt.queue[:i], lt.queue[i+1:]...)
+			break
+		}
+	}
+	o.waitingFor = nil
+	lt.grantQueuedLocked()
+	return err
+}
+
+// release releases all of the locks held by o, granting any queued requests
+// which are no longer blocked.
+func (lt *lockTable) release(o *lockOwner) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if len(o.held) == 0 {
+		return
+	}
+	for _, h := range o.held {
+		if h.end == nil {
+			key := string(h.start)
+			lt.points[key] = removeHeldLock(lt.points[key], h)
+			if len(lt.points[key]) == 0 {
+				delete(lt.points, key)
+			}
+		} else {
+			lt.spans = removeHeldLock(lt.spans, h)
+		}
+	}
+	o.held = nil
+	lt.grantQueuedLocked()
+}
+
+// grantQueuedLocked grants, in queue order, the queued requests which
+// conflict with neither a held lock nor an earlier queued request, and
+// recomputes the waitingFor edges of the requests which remain queued.
+func (lt *lockTable) grantQueuedLocked() {
+	var queue []*lockRequest
+	for _, req := range lt.queue {
+		if blockers := lt.blockersLocked(req, queue); len(blockers) > 0 {
+			req.owner.waitingFor = blockers
+			queue = append(queue, req)
+			continue
+		}
+		req.owner.waitingFor = nil
+		lt.grantLocked(req)
+		close(req.granted)
+	}
+	lt.queue = queue
+}
+
+// grantLocked records the lock requested by req as held by its owner.
+func (lt *lockTable) grantLocked(req *lockRequest) {
+	h := &req.heldLock
+	if h.end == nil {
+		lt.points[string(h.start)] = append(lt.points[string(h.start)], h)
+	} else {
+		lt.spans = append(lt.spans, h)
+	}
+	h.owner.held = append(h.owner.held, h)
+}
+
+// holdsLocked returns true if o already holds a lock in at least the given
+// mode covering the key start, or if end is non-nil, the span [start, end).
+func (lt *lockTable) holdsLocked(o *lockOwner, start, end []byte, mode LockMode) bool {
+	for _, h := range o.held {
+		if h.mode < mode {
+			continue
+		}
+		if h.end == nil {
+			if end == nil && lt.cmp(h.start, start) == 0 {
+				return true
+			}
+		} else if lt.cmp(h.start, start) <= 0 &&
+			((end == nil && lt.cmp(start, h.end) < 0) || (end != nil && lt.cmp(end, h.end) <= 0)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockersLocked returns the owners, other than the owner of req, of the held
+// locks and of the earlier queued requests which conflict with req.
+func (lt *lockTable) blockersLocked(req *lockRequest, earlier []*lockRequest) []*lockOwner {
+	blockers := lt.conflictsLocked(req.owner, req.start, req.end, req.mode)
+	for _, q := range earlier {
+		if q.owner == req.owner || (req.mode == LockShared && q.mode == LockShared) ||
+			!lt.overlaps(&q.heldLock, req.start, req.end) {
+			continue
+		}
+		blockers = appendOwner(blockers, q.owner)
+	}
+	return blockers
+}
+
+// conflictsLocked returns the owners, other than o, of the held locks which
+// conflict with a lock in the given mode on the key start, or if end is
+// non-nil, the span [start, end).
+func (lt *lockTable) conflictsLocked(
+	o *lockOwner, start, end []byte, mode LockMode,
+) []*lockOwner {
+	var blockers []*lockOwner
+	add := func(h *heldLock) {
+		if h.owner == o || (mode == LockShared && h.mode == LockShared) {
+			return
+		}
+		blockers = appendOwner(blockers, h.owner)
+	}
+
+	if end == nil {
+		for _, h := range lt.points[string(start)] {
+			add(h)
+		}
+		for _, h := range lt.spans {
+			if lt.overlaps(h, start, end) {
+				add(h)
+			}
+		}
+		return blockers
+	}
+	for key, held := range lt.points {
+		if lt.cmp(start, []byte(key)) <= 0 && lt.cmp([]byte(key), end) < 0 {
+			for _, h := range held {
+				add(h)
+			}
+		}
+	}
+	for _, h := range lt.spans {
+		if lt.overlaps(h, start, end) {
+			add(h)
+		}
+	}
+	return blockers
+}
+
+// overlaps returns true if the lock h overlaps the key start, or if end is
+// non-nil, the span [start, end).
+func (lt *lockTable) overlaps(h *heldLock, start, end []byte) bool {
+	switch {
+	case h.end == nil && end == nil:
+		return lt.cmp(h.start, start) == 0
+	case h.end == nil:
+		return lt.cmp(start, h.start) <= 0 && lt.cmp(h.start, end) < 0
+	case end == nil:
+		return lt.cmp(h.start, start) <= 0 && lt.cmp(start, h.end) < 0
+	default:
+		return lt.cmp(h.start, end) < 0 && lt.cmp(start, h.end) < 0
+	}
+}
+
+func appendOwner(owners []*lockOwner, o *lockOwner) []*lockOwner {
+	for _, b := range owners {
+		if b == o {
+			return owners
+		}
+	}
+	return append(owners, o)
+}
+
+// reachesLocked returns true if target is reachable from any of the owners in
+// the waits-for graph.
+func (lt *lockTable) reachesLocked(owners []*lockOwner, target *lockOwner) bool {
+	visited := make(map[*lockOwner]struct{})
+	stack := append([]*lockOwner(nil), owners...)
+	for len(stack) > 0 {
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if o == target {
+			return true
+		}
+		if _, ok := visited[o]; ok {
+			continue
+		}
+		visited[o] = struct{}{}
+		stack = append(stack, o.waitingFor...)
+	}
+	return false
+}
+
+func removeHeldLock(held []*heldLock, h *heldLock) []*heldLock {
+	for i := range held {
+		if held[i] == h {
+			held[i] = held[len(held)-1]
+			held[len(held)-1] = nil
+			return held[:len(held)-1]
+		}
+	}
+	return held
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockTableCompatibility(t *testing.T) {
+	testCases := []struct {
+		name       string
+		held       [2][]byte
+		heldMode   LockMode
+		req        [2][]byte
+		reqMode    LockMode
+		compatible bool
+	}{
+		{"shared-shared", [2][]byte{[]byte("a")}, LockShared, [2][]byte{[]byte("a")}, LockShared, true},
+		{"shared-exclusive", [2][]byte{[]byte("a")}, LockShared, [2][]byte{[]byte("a")}, LockExclusive, false},
+		{"exclusive-shared", [2][]byte{[]byte("a")}, LockExclusive, [2][]byte{[]byte("a")}, LockShared, false},
+		{"exclusive-other-key", [2][]byte{[]byte("a")}, LockExclusive, [2][]byte{[]byte("b")}, LockExclusive, true},
+		{"span-covers-key", [2][]byte{[]byte("a"), []byte("c")}, LockExclusive, [2][]byte{[]byte("b")}, LockShared, false},
+		{"span-excludes-end", [2][]byte{[]byte("a"), []byte("c")}, LockExclusive, [2][]byte{[]byte("c")}, LockExclusive, true},
+		{"key-within-span", [2][]byte{[]byte("b")}, LockExclusive, [2][]byte{[]byte("a"), []byte("c")}, LockShared, false},
+		{"spans-overlap", [2][]byte{[]byte("a"), []byte("c")}, LockShared, [2][]byte{[]byte("b"), []byte("d")}, LockExclusive, false},
+		{"spans-abut", [2][]byte{[]byte("a"), []byte("c")}, LockExclusive, [2][]byte{[]byte("c"), []byte("d")}, LockExclusive, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var lt lockTable
+			lt.init(DefaultComparer.Compare, nil)
+			var a, b lockOwner
+			require.NoError(t, lt.acquire(&a, tc.held[0], tc.held[1], tc.heldMode, 0))
+			err := lt.acquire(&b, tc.req[0], tc.req[1], tc.reqMode, time.Millisecond)
+			if tc.compatible {
+				require.NoError(t, err)
+			} else {
+				require.True(t, errors.Is(err, ErrLockTimeout), "%v", err)
+			}
+
+			// Once the lock is released, the request is always granted.
+			lt.release(&a)
+			require.NoError(t, lt.acquire(&b, tc.req[0], tc.req[1], tc.reqMode, time.Millisecond))
+			lt.release(&b)
+			require.Empty(t, lt.points)
+			require.Empty(t, lt.spans)
+		})
+	}
+}
+
+func TestLockTableWait(t *testing.T) {
+	var lt lockTable
+	lt.init(DefaultComparer.Compare, nil)
+	var a, b lockOwner
+	require.NoError(t, lt.acquire(&a, []byte("k"), nil, LockExclusive, 0))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- lt.acquire(&b, []byte("k"), nil, LockExclusive, 0)
+	}()
+	select {
+	case err := <-errCh:
+		t.Fatalf("lock acquired while held: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	lt.release(&a)
+	require.NoError(t, <-errCh)
+	lt.release(&b)
+}
+
+func TestLockTableDeadlock(t *testing.T) {
+	var lt lockTable
+	lt.init(DefaultComparer.Compare, nil)
+
+	// a holds "x" and waits for "y", held by b. b requesting "x" would
+	// deadlock.
+	var a, b lockOwner
+	require.NoError(t, lt.acquire(&a, []byte("x"), nil, LockExclusive, 0))
+	require.NoError(t, lt.acquire(&b, []byte("y"), nil, LockExclusive, 0))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- lt.acquire(&a, []byte("y"), nil, LockExclusive, 0)
+	}()
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, func() error {
+		lt.mu.Lock()
+		defer lt.mu.Unlock()
+		if len(a.waitingFor) == 0 {
+			return errors.New("a not yet waiting")
+		}
+		return nil
+	}))
+	require.True(t, errors.Is(lt.acquire(&b, []byte("x"), nil, LockShared, 0), ErrDeadlock))
+
+	// Once b gives up its locks, a acquires "y".
+	lt.release(&b)
+	require.NoError(t, <-errCh)
+	lt.release(&a)
+
+	// Two shared holders of a key which both upgrade to an exclusive lock
+	// deadlock.
+	require.NoError(t, lt.acquire(&a, []byte("k"), nil, LockShared, 0))
+	require.NoError(t, lt.acquire(&b, []byte("k"), nil, LockShared, 0))
+	go func() {
+		errCh <- lt.acquire(&a, []byte("k"), nil, LockExclusive, 0)
+	}()
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, func() error {
+		lt.mu.Lock()
+		defer lt.mu.Unlock()
+		if len(a.waitingFor) == 0 {
+			return errors.New("a not yet waiting")
+		}
+		return nil
+	}))
+	require.True(t, errors.Is(lt.acquire(&b, []byte("k"), nil, LockExclusive, 0), ErrDeadlock))
+	lt.release(&b)
+	require.NoError(t, <-errCh)
+	lt.release(&a)
+}
+
+func TestLockTableFIFO(t *testing.T) {
+	var lt lockTable
+	lt.init(DefaultComparer.Compare, nil)
+
+	// a holds a shared lock and b waits for an exclusive lock. A later shared
+	// request from c queues behind b rather than starving it.
+	var a, b, c lockOwner
+	require.NoError(t, lt.acquire(&a, []byte("k"), nil, LockShared, 0))
+	bCh := make(chan error, 1)
+	go func() {
+		bCh <- lt.acquire(&b, []byte("k"), nil, LockExclusive, 0)
+	}()
+	waiting := func(o *lockOwner) func() error {
+		return func() error {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+			if len(o.waitingFor) == 0 {
+				return errors.New("not yet waiting")
+			}
+			return nil
+		}
+	}
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, waiting(&b)))
+	require.True(t, errors.Is(lt.acquire(&c, []byte("k"), nil, LockShared, time.Millisecond), ErrLockTimeout))
+
+	cCh := make(chan error, 1)
+	go func() {
+		cCh <- lt.acquire(&c, []byte("k"), nil, LockShared, 0)
+	}()
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, waiting(&c)))
+	lt.mu.Lock()
+	require.Equal(t, []*lockOwner{&b}, c.waitingFor)
+	lt.mu.Unlock()
+
+	// Releasing a grants b, and c continues to wait for b.
+	lt.release(&a)
+	require.NoError(t, <-bCh)
+	select {
+	case err := <-cCh:
+		t.Fatalf("shared lock acquired while exclusively held: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	lt.release(&b)
+	require.NoError(t, <-cCh)
+	lt.release(&c)
+	require.Empty(t, lt.queue)
+	require.Empty(t, lt.points)
+}
+
+func TestLockTableClose(t *testing.T) {
+	closed := make(chan struct{})
+	var lt lockTable
+	lt.init(DefaultComparer.Compare, closed)
+	var a, b lockOwner
+	require.NoError(t, lt.acquire(&a, []byte("k"), nil, LockExclusive, 0))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- lt.acquire(&b, []byte("k"), nil, LockExclusive, 0)
+	}()
+	select {
+	case err := <-errCh:
+		t.Fatalf("lock acquired while held: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(closed)
+	require.True(t, errors.Is(<-errCh, ErrClosed))
+	lt.mu.Lock()
+	require.Empty(t, lt.queue)
+	require.Empty(t, b.waitingFor)
+	lt.mu.Unlock()
+	lt.release(&a)
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"runtime/debug"
+	"sync"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
@@ -227,10 +228,16 @@ type mergingIter struct {
 	// when mergingIter is a child of Iterator and the mergingIter is processing
 	// range tombstones.
 	elideRangeTombstones bool
+
+	// concurrentSeeks positions the levelIters concurrently during absolute
+	// positioning operations. See IterOptions.ConcurrentLevelSeeks.
+	concurrentSeeks bool
+	seekWG          sync.WaitGroup
 }
 
 // mergingIter implements the base.InternalIterator interface.
 var _ base.InternalIterator = (*mergingIter)(nil)
+var _ base.PrefixLTSeeker = (*mergingIter)(nil)
 
 // newMergingIter returns an iterator that merges its input. Walking the
 // resultant iterator will return all key/value pairs of all input iterators
@@ -262,6 +269,7 @@ func (m *mergingIter) init(
 	if opts != nil {
 		m.lower = opts.LowerBound
 		m.upper = opts.UpperBound
+		m.concurrentSeeks = opts.ConcurrentLevelSeeks
 	}
 	m.snapshot = InternalKeySeqNumMax
 	m.levels = levels
@@ -809,6 +817,13 @@ func (m *mergingIter) findPrevEntry() (*InternalKey, []byte) {
 		m.addItemStats(item)
 		if m.isPrevEntryDeleted(item) {
 			m.stats.PointsCoveredByRangeTombstones++
+			// For reverse prefix iteration, stop if we are before the prefix.
+			// See the comment in findNextEntry.
+			if m.prefix != nil {
+				if n := m.split(item.key.UserKey); m.heap.cmp(item.key.UserKey[:n], m.prefix) < 0 {
+					return nil, nil
+				}
+			}
 			continue
 		}
 		if item.key.Visible(m.snapshot) &&
@@ -821,6 +836,36 @@ func (m *mergingIter) findPrevEntry() (*InternalKey, []byte) {
 }
 
 // Seeks levels >= level to >= key. Additionally uses range tombstones to extend the seeks.
+// positionLevels calls position for each of the levels >= level. If
+// concurrent seeks are enabled, the levelIters, which may read from sstables,
+// are positioned concurrently on separate goroutines, so that a cold
+// positioning operation waits on the slowest read rather than the sum of the
+// reads. The remaining levels are positioned on the calling goroutine. The
+// levelIters then invoke the user's table and block property filters
+// concurrently; IterOptions.ConcurrentLevelSeeks documents that these must be
+// thread-safe.
+func (m *mergingIter) positionLevels(level int, position func(l *mergingIterLevel)) {
+	if !m.concurrentSeeks {
+		for ; level < len(m.levels); level++ {
+			position(&m.levels[level])
+		}
+		return
+	}
+	for ; level < len(m.levels); level++ {
+		l := &m.levels[level]
+		if _, ok := l.iter.(*levelIter); !ok {
+			position(l)
+			continue
+		}
+		m.seekWG.Add(1)
+		go func() {
+			defer m.seekWG.Done()
+			position(l)
+		}()
+	}
+	m.seekWG.Wait()
+}
+
 func (m *mergingIter) seekGE(key []byte, level int, trySeekUsingNext bool) {
 	// When seeking, we can use tombstones to adjust the key we seek to on each
 	// level. Consider the series of range tombstones:
@@ -842,6 +887,22 @@ func (m *mergingIter) seekGE(key []byte, level int, trySeekUsingNext bool) {
 	// TODO(peter,rangedel): In addition to the above we can delay seeking a
 	// level (and any lower levels) when the current iterator position is
 	// contained within a range tombstone at a higher level.
+	//
+	// Concurrent seeks forgo the adjustment, as each level is sought before
+	// the tombstones of the levels above it are known. Keys deleted by those
+	// tombstones are then skipped by findNextEntry.
+	if m.concurrentSeeks {
+		prefix := m.prefix
+		m.positionLevels(level, func(l *mergingIterLevel) {
+			if prefix != nil {
+				l.iterKey, l.iterValue = l.iter.SeekPrefixGE(prefix, key, trySeekUsingNext)
+			} else {
+				l.iterKey, l.iterValue = l.iter.SeekGE(key, trySeekUsingNext)
+			}
+		})
+		m.initMinHeap()
+		return
+	}
 
 	for ; level < len(m.levels); level++ {
 		if invariants.Enabled && m.lower != nil && m.heap.cmp(key, m.lower) < 0 {
@@ -924,17 +985,33 @@ func (m *mergingIter) SeekPrefixGE(
 }
 
 // Seeks levels >= level to < key. Additionally uses range tombstones to extend the seeks.
+// In prefix iteration mode, the levels are sought with SeekPrefixLT.
 func (m *mergingIter) seekLT(key []byte, level int) {
 	// See the comment in seekGE regarding using tombstones to adjust the seek
 	// target per level.
-	m.prefix = nil
+	if m.concurrentSeeks {
+		prefix := m.prefix
+		m.positionLevels(level, func(l *mergingIterLevel) {
+			if prefix != nil {
+				l.iterKey, l.iterValue = base.SeekPrefixLT(l.iter, prefix, key)
+			} else {
+				l.iterKey, l.iterValue = l.iter.SeekLT(key)
+			}
+		})
+		m.initMaxHeap()
+		return
+	}
 	for ; level < len(m.levels); level++ {
 		if invariants.Enabled && m.upper != nil && m.heap.cmp(key, m.upper) > 0 {
 			m.logger.Fatalf("mergingIter: upper bound violation: %s > %s\n%s", key, m.upper, debug.Stack())
 		}
 
 		l := &m.levels[level]
-		l.iterKey, l.iterValue = l.iter.SeekLT(key)
+		if m.prefix != nil {
+			l.iterKey, l.iterValue = base.SeekPrefixLT(l.iter, m.prefix, key)
+		} else {
+			l.iterKey, l.iterValue = l.iter.SeekLT(key)
+		}
 
 		if rangeDelIter := l.rangeDelIter; rangeDelIter != nil {
 			// The level has a range-del iterator. Find the tombstone containing
@@ -993,6 +1070,23 @@ func (m *mergingIter) SeekLT(key []byte) (*InternalKey, []byte) {
 	return m.findPrevEntry()
 }
 
+// SeekPrefixLT implements base.PrefixLTSeeker. Note that SeekPrefixLT only
+// checks the lower bound. It is up to the caller to ensure that key is less
+// than the upper bound.
+func (m *mergingIter) SeekPrefixLT(prefix, key []byte) (*InternalKey, []byte) {
+	m.err = nil // clear cached iteration error
+	m.prefix = prefix
+	if key == nil {
+		m.positionLevels(0, func(l *mergingIterLevel) {
+			l.iterKey, l.iterValue = base.SeekPrefixLT(l.iter, prefix, nil)
+		})
+		m.initMaxHeap()
+	} else {
+		m.seekLT(key, 0 /* start level */)
+	}
+	return m.findPrevEntry()
+}
+
 // First implements base.InternalIterator.First. Note that First only checks
 // the upper bound. It is up to the caller to ensure that key is greater than
 // or equal to the lower bound (e.g. via a call to SeekGE(lower)).
@@ -1000,10 +1094,9 @@ func (m *mergingIter) First() (*InternalKey, []byte) {
 	m.err = nil // clear cached iteration error
 	m.prefix = nil
 	m.heap.items = m.heap.items[:0]
-	for i := range m.levels {
-		l := &m.levels[i]
+	m.positionLevels(0, func(l *mergingIterLevel) {
 		l.iterKey, l.iterValue = l.iter.First()
-	}
+	})
 	m.initMinHeap()
 	return m.findNextEntry()
 }
@@ -1014,10 +1107,9 @@ func (m *mergingIter) First() (*InternalKey, []byte) {
 func (m *mergingIter) Last() (*InternalKey, []byte) {
 	m.err = nil // clear cached iteration error
 	m.prefix = nil
-	for i := range m.levels {
-		l := &m.levels[i]
+	m.positionLevels(0, func(l *mergingIterLevel) {
 		l.iterKey, l.iterValue = l.iter.Last()
-	}
+	})
 	m.initMaxHeap()
 	return m.findPrevEntry()
 }
@@ -1028,6 +1120,10 @@ func (m *mergingIter) Next() (*InternalKey, []byte) {
 	}
 
 	if m.dir != 1 {
+		if m.prefix != nil {
+			m.err = errors.New("pebble: unsupported forward iteration after SeekPrefixLT")
+			return nil, nil
+		}
 		m.switchToMinHeap()
 		return m.findNextEntry()
 	}
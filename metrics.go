@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/humanize"
@@ -127,13 +128,15 @@ type Metrics struct {
 
 	Compact struct {
 		// The total number of compactions, and per-compaction type counts.
-		Count            int64
-		DefaultCount     int64
-		DeleteOnlyCount  int64
-		ElisionOnlyCount int64
-		MoveCount        int64
-		ReadCount        int64
-		RewriteCount     int64
+		Count                 int64
+		DefaultCount          int64
+		DeleteOnlyCount       int64
+		ElisionOnlyCount      int64
+		MoveCount             int64
+		ReadCount             int64
+		RewriteCount          int64
+		TombstoneDensityCount int64
+		PeriodicCount         int64
 		// An estimate of the number of bytes that need to be compacted for the LSM
 		// to reach a stable state.
 		EstimatedDebt uint64
@@ -147,6 +150,25 @@ type Metrics struct {
 		// compaction. Such files are compacted in a rewrite compaction
 		// when no other compactions are picked.
 		MarkedFiles int
+		// ConcurrencyLimit is the maximum number of concurrent compactions. It
+		// is MaxConcurrentCompactions unless it is being adjusted by the
+		// controller enabled by Options.Experimental.AdaptiveCompactions.
+		ConcurrencyLimit int
+		// RateLimit is the compaction byte rate limit, in bytes per second,
+		// imposed by the adaptive compaction controller. It is zero if
+		// compactions are not rate limited.
+		RateLimit uint64
+		// DiskWriteLatency and ForegroundWriteRate are the average latency of
+		// disk writes, excluding syncs, and the rate of foreground writes, in
+		// bytes per second, observed by the adaptive compaction controller
+		// during its last interval. They are zero if the controller is not enabled.
+		DiskWriteLatency    time.Duration
+		ForegroundWriteRate uint64
+		// DiskSyncLatency is the average latency of the syncs of the tables
+		// written by flushes and compactions, observed by the adaptive
+		// compaction controller during its last interval. It is zero if the
+		// controller is not enabled.
+		DiskSyncLatency time.Duration
 	}
 
 	Flush struct {
@@ -189,6 +211,9 @@ type Metrics struct {
 		ZombieSize uint64
 		// The count of zombie tables.
 		ZombieCount int64
+		// The count of tables that the background scrubber found to be corrupt
+		// and that have not yet been deleted.
+		CorruptCount int64
 	}
 
 	TableCache CacheMetrics
@@ -380,13 +405,15 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		humanize.IEC.Int64(m.Compact.InProgressBytes),
 		redact.Safe(m.Compact.NumInProgress),
 		redact.SafeString(""))
-	w.Printf("  ctype %9d %7d %7d %7d %7d %7d  (default, delete, elision, move, read, rewrite)\n",
+	w.Printf("  ctype %9d %7d %7d %7d %7d %7d %7d %7d  (default, delete, elision, move, read, rewrite, tombstone, periodic)\n",
 		redact.Safe(m.Compact.DefaultCount),
 		redact.Safe(m.Compact.DeleteOnlyCount),
 		redact.Safe(m.Compact.ElisionOnlyCount),
 		redact.Safe(m.Compact.MoveCount),
 		redact.Safe(m.Compact.ReadCount),
-		redact.Safe(m.Compact.RewriteCount))
+		redact.Safe(m.Compact.RewriteCount),
+		redact.Safe(m.Compact.TombstoneDensityCount),
+		redact.Safe(m.Compact.PeriodicCount))
 	w.Printf(" memtbl %9d %7s\n",
 		redact.Safe(m.MemTable.Count),
 		humanize.IEC.Uint64(m.MemTable.Size))
@@ -23,6 +23,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/manual"
 	"github.com/cockroachdb/pebble/internal/rate"
 	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
 
@@ -125,6 +126,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		apply:         d.commitApply,
 		write:         d.commitWrite,
 	})
+	d.locks.init(d.cmp, d.closedCh)
 	d.compactionLimiter = rate.NewLimiter(
 		rate.Limit(d.opts.private.minCompactionRate),
 		d.opts.private.minCompactionRate)
@@ -134,6 +136,15 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	d.deletionLimiter = rate.NewLimiter(
 		rate.Limit(d.opts.Experimental.MinDeletionRate),
 		d.opts.Experimental.MinDeletionRate)
+	d.scrubLimiter = rate.NewLimiter(
+		rate.Limit(d.opts.Experimental.ScrubRate),
+		d.opts.Experimental.ScrubRate)
+	if d.opts.Experimental.AdaptiveCompactions {
+		d.compactionController = newCompactionController(d.opts)
+	}
+	if d.opts.Experimental.CompressionConcurrency > 0 {
+		d.compressionPool = sstable.NewCompressionPool(d.opts.Experimental.CompressionConcurrency)
+	}
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
 	if d.mu.mem.nextSize > initialMemTableSize {
@@ -447,9 +458,20 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 	d.mu.tableStats.cond.L = &d.mu.Mutex
 	d.mu.tableValidation.cond.L = &d.mu.Mutex
+	d.mu.scrub.cond.L = &d.mu.Mutex
 	if !d.opts.ReadOnly && !d.opts.private.disableTableStats {
 		d.maybeCollectTableStatsLocked()
 	}
+	if d.opts.Experimental.ScrubInterval > 0 {
+		go d.scrubLoop()
+	}
+	if d.opts.Experimental.PeriodicCompactionAge > 0 && !d.opts.ReadOnly {
+		go d.periodicCompactionLoop()
+	}
+	if d.opts.Experimental.VersionRetention > 0 {
+		d.sampleSeqNumLocked()
+		go d.seqNumSampleLoop()
+	}
 	d.calculateDiskAvailableBytes()
 
 	d.maybeScheduleFlush()
@@ -58,6 +58,9 @@ type BlockPropertyCollector = sstable.BlockPropertyCollector
 // BlockPropertyFilter exports the sstable.BlockPropertyFilter type.
 type BlockPropertyFilter = base.BlockPropertyFilter
 
+// BlockPropertySuffixFilter exports the sstable.BlockPropertySuffixFilter type.
+type BlockPropertySuffixFilter = sstable.BlockPropertySuffixFilter
+
 // IterKeyType configures which types of keys an iterator should surface.
 type IterKeyType int8
 
@@ -91,17 +94,29 @@ type IterOptions struct {
 	// TableFilter can be used to filter the tables that are scanned during
 	// iteration based on the user properties. Return true to scan the table and
 	// false to skip scanning. This function must be thread-safe since the same
-	// function can be used by multiple iterators, if the iterator is cloned.
+	// function can be used by multiple iterators, if the iterator is cloned,
+	// and by the concurrent seeks of a single iterator's levels, if
+	// ConcurrentLevelSeeks is set.
 	TableFilter func(userProps map[string]string) bool
 	// PointKeyFilters can be used to avoid scanning tables and blocks in tables
 	// when iterating over point keys. It is requires that this slice is sorted in
 	// increasing order of the BlockPropertyFilter.ShortID. This slice represents
 	// an intersection across all filters, i.e., all filters must indicate that the
-	// block is relevant.
+	// block is relevant. The filters must be thread-safe, as the levels of the
+	// LSM may consult them concurrently if ConcurrentLevelSeeks is set.
+	// Filters that implement BlockPropertySuffixFilter additionally skip the
+	// individual keys of columnar data blocks whose suffixes do not intersect
+	// the filter.
 	PointKeyFilters []BlockPropertyFilter
 	// RangeKeyFilters can be usefd to avoid scanning tables and blocks in tables
 	// when iterating over range keys. The same requirements that apply to
-	// PointKeyFilters apply here too.
+	// PointKeyFilters apply here too. Range keys in batches and memtables are
+	// filtered individually: a range key is surfaced only if every filter
+	// intersects the property computed for the range key alone by the
+	// collector of Options.BlockPropertyCollectors with the filter's name. A
+	// filter whose collector tracks range keys' suffixes thereby filters range
+	// keys by suffix interval wherever they reside. Filters without a
+	// corresponding collector do not filter individual range keys.
 	RangeKeyFilters []BlockPropertyFilter
 	// KeyTypes configures which types of keys to iterate over: point keys,
 	// range keys, or both.
@@ -139,6 +154,40 @@ type IterOptions struct {
 	// weight than creating an iterator, so we have opted to support this
 	// iterator option.
 	OnlyReadGuaranteedDurable bool
+	// ReadAtSeqNum, if non-zero, reads the state of the DB as of the given
+	// visible sequence number, such as one returned by DB.SeqNumAt, rather
+	// than the current state. Versions of keys overwritten before the oldest
+	// sequence number retained by Options.Experimental.VersionRetention, or by
+	// an open Snapshot, may have been dropped by compactions, so reads at an
+	// older sequence number may observe a newer version of a key or none at
+	// all. ReadAtSeqNum is not supported for batches or snapshots, and cannot
+	// be changed by Iterator.SetOptions.
+	ReadAtSeqNum uint64
+	// PrefetchBlocks, if positive, configures explicit read-ahead for long
+	// sequential scans. Once the iterator has read consecutive data blocks of
+	// an sstable in the forward direction, it reads up to PrefetchBlocks of
+	// the data blocks that follow into the block cache asynchronously, so that
+	// the scan does not wait on each block read in turn. The reads are bounded
+	// by Options.Experimental.MaxConcurrentPrefetches across the DB. Scans on
+	// high-latency storage benefit most, at the cost of reading blocks the
+	// iterator may never reach if the scan ends early.
+	PrefetchBlocks int
+	// ConcurrentLevelSeeks, if true, positions the iterators over each level
+	// of the LSM concurrently, on separate goroutines, during the absolute
+	// positioning operations First, Last, SeekGE, SeekPrefixGE, SeekLT and
+	// SeekPrefixLT, and during the reseeks of lower levels past the keys
+	// deleted by a range deletion. A positioning operation over cold data then performs the reads
+	// of each level in parallel, and waits on the slowest rather than their
+	// sum. Concurrent seeks add goroutine overhead to positioning operations
+	// over data in the block cache, and forgo the use of range deletions at
+	// higher levels to skip over the keys they delete at lower levels while
+	// seeking, so they are best suited to the first positioning of scans over
+	// cold data.
+	//
+	// The levels invoke TableFilter, PointKeyFilters and the Comparer's
+	// functions from the goroutines performing their seeks, so these must be
+	// safe for concurrent use when ConcurrentLevelSeeks is set.
+	ConcurrentLevelSeeks bool
 	// Internal options.
 	logger Logger
 
@@ -162,6 +211,13 @@ func (o *IterOptions) GetUpperBound() []byte {
 	return o.UpperBound
 }
 
+func (o *IterOptions) readAtSeqNum() uint64 {
+	if o == nil {
+		return 0
+	}
+	return o.ReadAtSeqNum
+}
+
 func (o *IterOptions) pointKeys() bool {
 	if o == nil {
 		return true
@@ -176,6 +232,10 @@ func (o *IterOptions) rangeKeys() bool {
 	return o.KeyTypes == IterKeyTypeRangesOnly || o.KeyTypes == IterKeyTypePointsAndRanges
 }
 
+func (m *RangeKeyMasking) enabled() bool {
+	return m.Suffix != nil || m.Mask != nil
+}
+
 func (o *IterOptions) getLogger() Logger {
 	if o == nil || o.logger == nil {
 		return DefaultLogger
@@ -184,7 +244,7 @@ func (o *IterOptions) getLogger() Logger {
 }
 
 // RangeKeyMasking configures automatic hiding of point keys by range keys. A
-// non-nil Suffix enables range-key masking. When enabled, range keys with
+// non-nil Suffix or Mask enables range-key masking. When enabled, range keys with
 // suffixes ≤ Suffix behave as masks. All point keys that are contained within a
 // masking range key's bounds and have suffixes less than the range key's suffix
 // are automatically skipped.
@@ -196,13 +256,26 @@ func (o *IterOptions) getLogger() Logger {
 //
 // then the point key is elided.
 //
+// Mask generalizes the comparison of the point key's and range key's suffixes,
+// for example to mask point keys only if they're also more recent than a
+// garbage collection threshold.
+//
 // Range-key masking may only be used when iterating over both point keys and
 // range keys with IterKeyTypePointsAndRanges.
 type RangeKeyMasking struct {
 	// Suffix configures which range keys may mask point keys. Only range keys
 	// that are defined at suffixes less than or equal to Suffix will mask point
-	// keys.
+	// keys. If nil and Mask is non-nil, all range keys with suffixes may mask
+	// point keys.
 	Suffix []byte
+	// Mask, if non-nil, replaces the comparison of suffixes that determines
+	// whether a range key that may mask point keys masks a point key it
+	// covers. The point key is masked if Mask returns true for the point key's
+	// suffix and any of the range keys covering it. Point keys without a
+	// suffix are never masked. Mask must not retain or modify its arguments,
+	// and may be called from the goroutines of any iterator constructed with
+	// these options.
+	Mask func(pointSuffix []byte, rangeKey RangeKeyData) bool
 
 	// TODO(jackson): Add fields necessary for constructing and updating block
 	// property collectors.
@@ -266,6 +339,16 @@ type LevelOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// DataBlockHashIndex adds a hash index to each data block, which speeds up
+	// point lookups within a data block by locating the restart interval
+	// containing a key without binary searching the block's restart points.
+	// The hash index requires FormatColumnarBlocks or later, and is ignored by
+	// earlier format major versions. It is also ignored unless the Comparer's
+	// Equal is bytes.Equal. See sstable.WriterOptions for details.
+	//
+	// The default value is false.
+	DataBlockHashIndex bool
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
@@ -293,6 +376,16 @@ type LevelOptions struct {
 	// The default value is the value of BlockSize.
 	IndexBlockSize int
 
+	// RangeFilterPrefixLength enables a per-sstable range filter when
+	// positive. The range filter allows bounded iterators to skip sstables
+	// that contain no point keys within the iterator's bounds without reading
+	// the sstables' index blocks. The range filter is ignored unless the
+	// Comparer's Compare is bytes.Compare. See sstable.WriterOptions for
+	// details.
+	//
+	// The default value of 0 disables the range filter.
+	RangeFilterPrefixLength int
+
 	// The target file size for the level.
 	TargetFileSize int64
 }
@@ -402,12 +495,74 @@ type Options struct {
 		// concurrency slots as determined by the two options is chosen.
 		CompactionDebtConcurrency int
 
+		// AdaptiveCompactions enables a controller which adjusts the compaction
+		// concurrency limit and a compaction byte rate limit once per second,
+		// based on the latency of disk writes, the rate of foreground writes
+		// and the compaction debt. While the average latency of disk writes
+		// exceeds TargetDiskWriteLatency, the controller lowers the concurrency
+		// limit towards a single compaction and halves the byte rate of
+		// compactions, but never below the rate of foreground writes. When
+		// latency recovers, the limits are raised again towards
+		// MaxConcurrentCompactions and an unlimited rate. When compaction debt
+		// threatens write stalls, compactions run without limits regardless of
+		// disk latency. The decisions are exposed in Metrics.Compact.
+		//
+		// Disk write latency is only observed when the FS was wrapped by
+		// vfs.WithDiskHealthChecks, as the default FS is. It excludes the
+		// latency of syncs, so that stalls of WAL syncs do not throttle
+		// compactions. The latency of the syncs of the tables written by
+		// flushes and compactions, which includes the periodic syncs of
+		// BytesPerSync, is observed separately for any FS, and throttles
+		// compactions while it exceeds TargetDiskSyncLatency.
+		//
+		// The default value of false uses the fixed MaxConcurrentCompactions
+		// limit.
+		AdaptiveCompactions bool
+
+		// CompactionPicker, if set, picks automatic compactions in place of the
+		// default score-based leveled compaction strategy. Manual compactions,
+		// delete-only compactions and low-priority compactions which reclaim
+		// disk space are still picked by the DB. See CompactionPicker.
+		//
+		// The default value of nil uses the leveled compaction strategy.
+		CompactionPicker CompactionPicker
+
+		// CompressionConcurrency is the maximum number of goroutines used to
+		// compress sstable data blocks across all flushes and compactions of
+		// the DB. When positive, data blocks are compressed and written
+		// concurrently with the flush or compaction producing them, which
+		// allows a large compaction to use several cores when compression is
+		// CPU-bound. A data block is compressed by the flush or compaction's
+		// own goroutine when all CompressionConcurrency goroutines are busy.
+		//
+		// The default value of 0 disables parallel compression.
+		CompressionConcurrency int
+
 		// DeleteRangeFlushDelay configures how long the database should wait
 		// before forcing a flush of a memtable that contains a range
 		// deletion. Disk space cannot be reclaimed until the range deletion
 		// is flushed. No automatic flush occurs if zero.
 		DeleteRangeFlushDelay time.Duration
 
+		// MaxConcurrentPrefetches is the maximum number of data blocks read
+		// concurrently into the block cache ahead of iterators configured with
+		// IterOptions.PrefetchBlocks. Prefetches beyond the limit are dropped.
+		//
+		// The default value is 8.
+		MaxConcurrentPrefetches int
+
+		// MaxSubcompactions is the maximum number of subcompactions a single
+		// compaction may be split into. A compaction into a level below L0 is
+		// partitioned at the boundaries of the tables in the level beneath its
+		// output level (or of its output level, when compacting into the
+		// bottommost level) into key ranges of similar size, which are
+		// compacted concurrently on separate goroutines. The subcompactions
+		// occupy a single slot of MaxConcurrentCompactions, and their outputs
+		// are installed in a single version edit.
+		//
+		// The default value of 0 (or 1) disables subcompactions.
+		MaxSubcompactions int
+
 		// MinDeletionRate is the minimum number of bytes per second that would
 		// be deleted. Deletion pacing is used to slow down deletions when
 		// compactions finish up or readers close, and newly-obsolete files need
@@ -419,6 +574,29 @@ type Options struct {
 		// deletion pacing, which is also the default.
 		MinDeletionRate int
 
+		// PeriodicCompactionAge, if positive, triggers a compaction of any table
+		// created more than PeriodicCompactionAge ago, even if the scores of the
+		// levels do not warrant a compaction. Tables in cold regions of the
+		// keyspace may otherwise never be compacted again, so their deletions
+		// never reach the bottommost level and they are never rewritten in a
+		// newer table format. A table is compacted into the level beneath,
+		// or, in the bottommost level, rewritten in place. Periodic compactions
+		// run at the lowest priority of automatic compactions. Tables created
+		// by versions of Pebble that did not record creation times are never
+		// considered.
+		//
+		// The default value of 0 disables periodic compactions.
+		PeriodicCompactionAge time.Duration
+
+		// RangeDeletionCoverageThreshold, if positive, triggers a compaction of
+		// any table below L0 whose range deletions are estimated to delete at
+		// least this multiple of the table's own size from the levels beneath
+		// it, even if the scores of the levels do not warrant a compaction. See
+		// TombstoneDensityThreshold.
+		//
+		// The default value of 0 disables range-deletion-triggered compactions.
+		RangeDeletionCoverageThreshold float64
+
 		// RangeKeys enables the experimental use of range keys, stored in an
 		// in-memory nondurable arena. This option is only intended to be
 		// temporary, to allow the Pebble metamorphic tests to reuse the
@@ -455,6 +633,23 @@ type Options struct {
 		// gets multiplied with a constant of 1 << 16 to yield 1 << 20 (1MB).
 		ReadSamplingMultiplier int64
 
+		// ScrubInterval enables the background scrubber, which verifies the
+		// block checksums of every live sstable, reading each block from disk
+		// rather than from the block cache. The scrubber finds latent disk
+		// corruption in infrequently read sstables before the corruption is
+		// encountered by a read. A pass over all live sstables begins every
+		// ScrubInterval, or immediately after the previous pass if it took
+		// longer than ScrubInterval. Corrupt sstables are reported through
+		// EventListener.TableCorrupted.
+		//
+		// The default value of 0 disables the scrubber.
+		ScrubInterval time.Duration
+
+		// ScrubRate is the maximum number of bytes per second read by the
+		// background scrubber. Setting this to 0 disables pacing of the
+		// scrubber, which is also the default.
+		ScrubRate int
+
 		// TableCacheShards is the number of shards per table cache.
 		// Reducing the value can reduce the number of idle goroutines per DB
 		// instance which can be useful in scenarios with a lot of DB instances
@@ -465,6 +660,31 @@ type Options struct {
 		// limited by runtime.GOMAXPROCS.
 		TableCacheShards int
 
+		// TargetDiskSyncLatency is the average latency of the syncs of tables
+		// written by flushes and compactions above which the
+		// AdaptiveCompactions controller throttles compactions. The default
+		// value is 50ms.
+		TargetDiskSyncLatency time.Duration
+
+		// TargetDiskWriteLatency is the average latency of disk writes,
+		// excluding syncs, above which the AdaptiveCompactions controller
+		// throttles compactions. The default value is 10ms.
+		TargetDiskWriteLatency time.Duration
+
+		// TombstoneDensityThreshold, if positive, triggers a compaction of any
+		// table below L0 with at least a thousand deletion tombstones which make
+		// up at least this fraction of its entries, even if the scores of the
+		// levels do not warrant a compaction. Workloads such as queues, which
+		// delete the keys they have consumed, leave dense regions of tombstones
+		// that iterators must step over one by one. Compacting a dense table
+		// into the level beneath drops the keys its tombstones delete, and
+		// compacting it within the bottommost level elides the tombstones
+		// themselves, once no open snapshot requires them.
+		//
+		// The default value of 0 disables tombstone-density-triggered
+		// compactions.
+		TombstoneDensityThreshold float64
+
 		// KeyValidationFunc is a function to validate a user key in an SSTable.
 		//
 		// Currently, this function is used to validate the smallest and largest
@@ -483,6 +703,18 @@ type Options struct {
 		//
 		// By default, this value is false.
 		ValidateOnIngest bool
+
+		// VersionRetention, if positive, retains every version of every key
+		// written within the last VersionRetention, as if a snapshot were open at
+		// every sequence number since then. Iterators may then read the state
+		// of the DB at any point within the retention window through
+		// IterOptions.ReadAtSeqNum and DB.SeqNumAt, without creating a Snapshot
+		// ahead of time. The visible sequence number is sampled periodically to
+		// map times to sequence numbers, so slightly more than VersionRetention
+		// is retained.
+		//
+		// The default value of 0 disables version retention.
+		VersionRetention time.Duration
 	}
 
 	// Filters is a map from filter policy name to filter policy. It is used for
@@ -712,6 +944,12 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Experimental.CompactionDebtConcurrency <= 0 {
 		o.Experimental.CompactionDebtConcurrency = 1 << 30 // 1 GB
 	}
+	if o.Experimental.TargetDiskSyncLatency <= 0 {
+		o.Experimental.TargetDiskSyncLatency = 50 * time.Millisecond
+	}
+	if o.Experimental.TargetDiskWriteLatency <= 0 {
+		o.Experimental.TargetDiskWriteLatency = 10 * time.Millisecond
+	}
 	if o.Experimental.KeyValidationFunc == nil {
 		o.Experimental.KeyValidationFunc = func([]byte) error { return nil }
 	}
@@ -789,6 +1027,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.FlushSplitBytes <= 0 {
 		o.FlushSplitBytes = 2 * o.Levels[0].TargetFileSize
 	}
+	if o.Experimental.MaxConcurrentPrefetches <= 0 {
+		o.Experimental.MaxConcurrentPrefetches = 8
+	}
 	if o.Experimental.ReadCompactionRate == 0 {
 		o.Experimental.ReadCompactionRate = 16000
 	}
@@ -867,11 +1108,13 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  pebble_version=0.1\n")
 	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "[Options]\n")
+	fmt.Fprintf(&buf, "  adaptive_compactions=%t\n", o.Experimental.AdaptiveCompactions)
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
+	fmt.Fprintf(&buf, "  compression_concurrency=%d\n", o.Experimental.CompressionConcurrency)
 	fmt.Fprintf(&buf, "  delete_range_flush_delay=%s\n", o.Experimental.DeleteRangeFlushDelay)
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
 	fmt.Fprintf(&buf, "  flush_split_bytes=%d\n", o.FlushSplitBytes)
@@ -881,16 +1124,22 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  l0_stop_writes_threshold=%d\n", o.L0StopWritesThreshold)
 	fmt.Fprintf(&buf, "  lbase_max_bytes=%d\n", o.LBaseMaxBytes)
 	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions)
+	fmt.Fprintf(&buf, "  max_concurrent_prefetches=%d\n", o.Experimental.MaxConcurrentPrefetches)
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	fmt.Fprintf(&buf, "  max_subcompactions=%d\n", o.Experimental.MaxSubcompactions)
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  min_compaction_rate=%d\n", o.private.minCompactionRate)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.Experimental.MinDeletionRate)
 	fmt.Fprintf(&buf, "  min_flush_rate=%d\n", o.private.minFlushRate)
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
+	fmt.Fprintf(&buf, "  periodic_compaction_age=%s\n", o.Experimental.PeriodicCompactionAge)
+	fmt.Fprintf(&buf, "  range_deletion_coverage_threshold=%g\n", o.Experimental.RangeDeletionCoverageThreshold)
 	fmt.Fprintf(&buf, "  read_compaction_rate=%d\n", o.Experimental.ReadCompactionRate)
 	fmt.Fprintf(&buf, "  read_sampling_multiplier=%d\n", o.Experimental.ReadSamplingMultiplier)
+	fmt.Fprintf(&buf, "  scrub_interval=%s\n", o.Experimental.ScrubInterval)
+	fmt.Fprintf(&buf, "  scrub_rate=%d\n", o.Experimental.ScrubRate)
 	fmt.Fprintf(&buf, "  strict_wal_tail=%t\n", o.private.strictWALTail)
 	fmt.Fprintf(&buf, "  table_cache_shards=%d\n", o.Experimental.TableCacheShards)
 	fmt.Fprintf(&buf, "  table_property_collectors=[")
@@ -903,7 +1152,11 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "%s", o.TablePropertyCollectors[i]().Name())
 	}
 	fmt.Fprintf(&buf, "]\n")
+	fmt.Fprintf(&buf, "  target_disk_sync_latency=%s\n", o.Experimental.TargetDiskSyncLatency)
+	fmt.Fprintf(&buf, "  target_disk_write_latency=%s\n", o.Experimental.TargetDiskWriteLatency)
+	fmt.Fprintf(&buf, "  tombstone_density_threshold=%g\n", o.Experimental.TombstoneDensityThreshold)
 	fmt.Fprintf(&buf, "  validate_on_ingest=%t\n", o.Experimental.ValidateOnIngest)
+	fmt.Fprintf(&buf, "  version_retention=%s\n", o.Experimental.VersionRetention)
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
 	fmt.Fprintf(&buf, "  wal_bytes_per_sync=%d\n", o.WALBytesPerSync)
 
@@ -914,9 +1167,11 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "  block_restart_interval=%d\n", l.BlockRestartInterval)
 		fmt.Fprintf(&buf, "  block_size=%d\n", l.BlockSize)
 		fmt.Fprintf(&buf, "  compression=%s\n", l.Compression)
+		fmt.Fprintf(&buf, "  data_block_hash_index=%t\n", l.DataBlockHashIndex)
 		fmt.Fprintf(&buf, "  filter_policy=%s\n", filterPolicyName(l.FilterPolicy))
 		fmt.Fprintf(&buf, "  filter_type=%s\n", l.FilterType)
 		fmt.Fprintf(&buf, "  index_block_size=%d\n", l.IndexBlockSize)
+		fmt.Fprintf(&buf, "  range_filter_prefix_length=%d\n", l.RangeFilterPrefixLength)
 		fmt.Fprintf(&buf, "  target_file_size=%d\n", l.TargetFileSize)
 	}
 
@@ -1008,6 +1263,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 		case section == "Options":
 			var err error
 			switch key {
+			case "adaptive_compactions":
+				o.Experimental.AdaptiveCompactions, err = strconv.ParseBool(value)
 			case "bytes_per_sync":
 				o.BytesPerSync, err = strconv.Atoi(value)
 			case "cache_size":
@@ -1043,6 +1300,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "compaction_debt_concurrency":
 				o.Experimental.CompactionDebtConcurrency, err = strconv.Atoi(value)
+			case "compression_concurrency":
+				o.Experimental.CompressionConcurrency, err = strconv.Atoi(value)
 			case "delete_range_flush_delay":
 				o.Experimental.DeleteRangeFlushDelay, err = time.ParseDuration(value)
 			case "disable_wal":
@@ -1078,6 +1337,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "max_concurrent_prefetches":
+				o.Experimental.MaxConcurrentPrefetches, err = strconv.Atoi(value)
+			case "max_subcompactions":
+				o.Experimental.MaxSubcompactions, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
@@ -1101,10 +1364,18 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Merger, err = hooks.NewMerger(value)
 					}
 				}
+			case "periodic_compaction_age":
+				o.Experimental.PeriodicCompactionAge, err = time.ParseDuration(value)
+			case "range_deletion_coverage_threshold":
+				o.Experimental.RangeDeletionCoverageThreshold, err = strconv.ParseFloat(value, 64)
 			case "read_compaction_rate":
 				o.Experimental.ReadCompactionRate, err = strconv.ParseInt(value, 10, 64)
 			case "read_sampling_multiplier":
 				o.Experimental.ReadSamplingMultiplier, err = strconv.ParseInt(value, 10, 64)
+			case "scrub_interval":
+				o.Experimental.ScrubInterval, err = time.ParseDuration(value)
+			case "scrub_rate":
+				o.Experimental.ScrubRate, err = strconv.Atoi(value)
 			case "table_cache_shards":
 				o.Experimental.TableCacheShards, err = strconv.Atoi(value)
 			case "table_format":
@@ -1116,8 +1387,16 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "table_property_collectors":
 				// TODO(peter): set o.TablePropertyCollectors
+			case "target_disk_sync_latency":
+				o.Experimental.TargetDiskSyncLatency, err = time.ParseDuration(value)
+			case "target_disk_write_latency":
+				o.Experimental.TargetDiskWriteLatency, err = time.ParseDuration(value)
+			case "tombstone_density_threshold":
+				o.Experimental.TombstoneDensityThreshold, err = strconv.ParseFloat(value, 64)
 			case "validate_on_ingest":
 				o.Experimental.ValidateOnIngest, err = strconv.ParseBool(value)
+			case "version_retention":
+				o.Experimental.VersionRetention, err = time.ParseDuration(value)
 			case "wal_dir":
 				o.WALDir = value
 			case "wal_bytes_per_sync":
@@ -1179,8 +1458,12 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				default:
 					return errors.Errorf("pebble: unknown filter type: %q", errors.Safe(value))
 				}
+			case "data_block_hash_index":
+				l.DataBlockHashIndex, err = strconv.ParseBool(value)
 			case "index_block_size":
 				l.IndexBlockSize, err = strconv.Atoi(value)
+			case "range_filter_prefix_length":
+				l.RangeFilterPrefixLength, err = strconv.Atoi(value)
 			case "target_file_size":
 				l.TargetFileSize, err = strconv.ParseInt(value, 10, 64)
 			default:
@@ -1303,8 +1586,10 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 	writerOpts.BlockSize = levelOpts.BlockSize
 	writerOpts.BlockSizeThreshold = levelOpts.BlockSizeThreshold
 	writerOpts.Compression = levelOpts.Compression
+	writerOpts.DataBlockHashIndex = levelOpts.DataBlockHashIndex
 	writerOpts.FilterPolicy = levelOpts.FilterPolicy
 	writerOpts.FilterType = levelOpts.FilterType
 	writerOpts.IndexBlockSize = levelOpts.IndexBlockSize
+	writerOpts.RangeFilterPrefixLength = levelOpts.RangeFilterPrefixLength
 	return writerOpts
 }
@@ -69,11 +69,13 @@ func TestOptionsString(t *testing.T) {
   pebble_version=0.1
 
 [Options]
+  adaptive_compactions=false
   bytes_per_sync=524288
   cache_size=8388608
   cleaner=delete
   compaction_debt_concurrency=1073741824
   comparer=leveldb.BytewiseComparator
+  compression_concurrency=0
   delete_range_flush_delay=0s
   disable_wal=false
   flush_split_bytes=4194304
@@ -83,20 +85,30 @@ func TestOptionsString(t *testing.T) {
   l0_stop_writes_threshold=12
   lbase_max_bytes=67108864
   max_concurrent_compactions=1
+  max_concurrent_prefetches=8
   max_manifest_file_size=134217728
   max_open_files=1000
+  max_subcompactions=0
   mem_table_size=4194304
   mem_table_stop_writes_threshold=2
   min_compaction_rate=4194304
   min_deletion_rate=0
   min_flush_rate=1048576
   merger=pebble.concatenate
+  periodic_compaction_age=0s
+  range_deletion_coverage_threshold=0
   read_compaction_rate=16000
   read_sampling_multiplier=16
+  scrub_interval=0s
+  scrub_rate=0
   strict_wal_tail=true
   table_cache_shards=8
   table_property_collectors=[]
+  target_disk_sync_latency=50ms
+  target_disk_write_latency=10ms
+  tombstone_density_threshold=0
   validate_on_ingest=false
+  version_retention=0s
   wal_dir=
   wal_bytes_per_sync=0
 
@@ -104,9 +116,11 @@ func TestOptionsString(t *testing.T) {
   block_restart_interval=16
   block_size=4096
   compression=Snappy
+  data_block_hash_index=false
   filter_policy=none
   filter_type=table
   index_block_size=4096
+  range_filter_prefix_length=0
   target_file_size=2097152
 `
 
@@ -222,10 +236,13 @@ func TestOptionsParse(t *testing.T) {
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
 			opts.Experimental.CompactionDebtConcurrency = 100
+			opts.Experimental.CompressionConcurrency = 2
 			opts.Experimental.DeleteRangeFlushDelay = 10 * time.Second
 			opts.Experimental.MinDeletionRate = 200
 			opts.Experimental.ReadCompactionRate = 300
 			opts.Experimental.ReadSamplingMultiplier = 400
+			opts.Experimental.ScrubInterval = time.Hour
+			opts.Experimental.ScrubRate = 600
 			opts.Experimental.TableCacheShards = 500
 			opts.EnsureDefaults()
 			str := opts.String()
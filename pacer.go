@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"math"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -326,6 +327,123 @@ func (p *deletionPacer) maybeThrottle(bytesToDelete uint64) error {
 	return p.limit(bytesToDelete, p.getInfo())
 }
 
+// scrubPacer rate limits the reads performed by the background scrubber.
+// Verifying the checksums of live sstables is never urgent, so unlike the
+// flush and compaction pacers, the rate limit is always applied. A scrubPacer
+// stops waiting for the rate limiter once closedCh is closed, so that a paced
+// scrubber does not delay DB.Close.
+type scrubPacer struct {
+	limiter  limiter
+	closedCh <-chan struct{}
+}
+
+func newScrubPacer(limiter limiter, closedCh <-chan struct{}) *scrubPacer {
+	return &scrubPacer{
+		limiter:  limiter,
+		closedCh: closedCh,
+	}
+}
+
+// maybeThrottle slows down the scrubber's read of the next bytesToRead bytes
+// to match opts.Experimental.ScrubRate. It returns ErrClosed if closedCh is
+// closed while waiting.
+func (p *scrubPacer) maybeThrottle(bytesToRead uint64) error {
+	burst := uint64(p.limiter.Burst())
+	for {
+		amount := bytesToRead
+		if amount > burst {
+			amount = burst
+		}
+		d := p.limiter.DelayN(time.Now(), int(amount))
+		if d == rate.InfDuration {
+			return errors.Errorf("pacing failed")
+		}
+		if err := p.wait(d); err != nil {
+			return err
+		}
+		bytesToRead -= amount
+		if bytesToRead == 0 {
+			return nil
+		}
+	}
+}
+
+func (p *scrubPacer) wait(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-p.closedCh:
+		return ErrClosed
+	case <-t.C:
+		return nil
+	}
+}
+
+// adaptiveCompactionPacer rate limits a compaction to the byte rate chosen by
+// the compaction controller. Compactions are not throttled while the
+// controller has lifted the rate limit.
+type adaptiveCompactionPacer struct {
+	compactionInternalPacer
+	controller *compactionController
+	// refresh prompts the controller to update its decisions if an update is
+	// due. It requires grabbing DB.mu, so it is only called once every 1000
+	// iterations.
+	refresh func()
+}
+
+func newAdaptiveCompactionPacer(
+	controller *compactionController, refresh func(),
+) *adaptiveCompactionPacer {
+	return &adaptiveCompactionPacer{
+		compactionInternalPacer: compactionInternalPacer{
+			limiter:           controller.limiter,
+			slowdownThreshold: math.MaxUint64,
+		},
+		controller: controller,
+		refresh:    refresh,
+	}
+}
+
+// maybeThrottle slows down the compaction to match the byte rate limit of the
+// compaction controller, if any.
+func (p *adaptiveCompactionPacer) maybeThrottle(bytesIterated uint64) error {
+	if bytesIterated == 0 {
+		return nil
+	}
+	if p.iterCount == 0 {
+		p.refresh()
+		p.iterCount = 1000
+	}
+	p.iterCount--
+
+	compactAmount := bytesIterated - p.prevBytesIterated
+	p.prevBytesIterated = bytesIterated
+	if p.controller.rateLimit() == 0 {
+		return nil
+	}
+	return p.limit(compactAmount, 0)
+}
+
+// newSubcompactionPacer returns a pacer for one of the subcompactions of a
+// compaction paced by p. A pacer tracks the bytes iterated by a single
+// compaction loop, so each subcompaction requires its own, which shares the
+// rate limiter of p.
+func newSubcompactionPacer(p pacer) pacer {
+	switch p := p.(type) {
+	case *compactionPacer:
+		return newCompactionPacer(p.env)
+	case *adaptiveCompactionPacer:
+		return newAdaptiveCompactionPacer(p.controller, p.refresh)
+	default:
+		// Flushes, paced by flushPacer, are never partitioned, and noopPacer
+		// is stateless.
+		return p
+	}
+}
+
 type noopPacer struct{}
 
 func (p *noopPacer) maybeThrottle(_ uint64) error {
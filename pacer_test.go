@@ -160,6 +160,14 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 						return err.Error()
 					}
 
+					return mockLimiter.buf.String()
+				case "scrub":
+					scrubPacer := newScrubPacer(&mockLimiter, nil /* closedCh */)
+					err := scrubPacer.maybeThrottle(bytesIterated)
+					if err != nil {
+						return err.Error()
+					}
+
 					return mockLimiter.buf.String()
 				default:
 					return fmt.Sprintf("unknown command: %s", d.Cmd)
@@ -170,3 +178,32 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 			}
 		})
 }
+
+type mockDelayLimiter struct {
+	delay time.Duration
+}
+
+func (m *mockDelayLimiter) DelayN(now time.Time, n int) time.Duration {
+	return m.delay
+}
+
+func (m *mockDelayLimiter) AllowN(now time.Time, n int) bool {
+	return true
+}
+
+func (m *mockDelayLimiter) Burst() int {
+	return 10
+}
+
+func TestScrubPacerClosed(t *testing.T) {
+	closedCh := make(chan struct{})
+	pacer := newScrubPacer(&mockDelayLimiter{delay: time.Hour}, closedCh)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- pacer.maybeThrottle(25)
+	}()
+	close(closedCh)
+	if err := <-errCh; err != ErrClosed {
+		t.Fatalf("expected %v, but found %v", ErrClosed, err)
+	}
+}
@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"bytes"
 	"sync"
 
 	"github.com/cockroachdb/errors"
@@ -133,8 +134,195 @@ func (d *DB) newRangeKeyIter(
 	if len(frags) > 0 {
 		iters = append(iters, keyspan.NewIter(d.cmp, frags))
 	}
+	filter := newRangeKeyFilter(opts.RangeKeyFilters, d.opts.BlockPropertyCollectors)
 	it.rangeKeyIter = rangekey.InitUserIteration(
-		d.cmp, seqNum, &it.alloc.merging, &it.alloc.defraging, iters...,
+		d.cmp, seqNum, filter, &it.alloc.merging, &it.alloc.defraging, iters...,
 	)
 	return it.rangeKeyIter
 }
+
+// newRangeKeyFilter returns a rangekey.Filter that applies the range key block
+// property filters to individual range keys, or nil if none of the filters
+// apply. Range keys in batches and memtables, and those held in the in-memory
+// range keys arena, aren't annotated with block properties, so each range key
+// is added on its own to a new instance of the block property collector with
+// the filter's name, and is surfaced only if the table property computed by
+// every collector intersects its filter. This matches the filtering of an
+// sstable containing only the range key. Filters without a corresponding
+// collector in collectors are ignored, like filters of properties missing
+// from an sstable.
+func newRangeKeyFilter(
+	filters []BlockPropertyFilter, collectors []func() BlockPropertyCollector,
+) rangekey.Filter {
+	if len(filters) == 0 || len(collectors) == 0 {
+		return nil
+	}
+	names := make(map[string]func() BlockPropertyCollector, len(collectors))
+	for _, newCollector := range collectors {
+		names[newCollector().Name()] = newCollector
+	}
+	var fcs []rangeKeyFilterCollector
+	for _, f := range filters {
+		if newCollector, ok := names[f.Name()]; ok {
+			fcs = append(fcs, rangeKeyFilterCollector{filter: f, newCollector: newCollector})
+		}
+	}
+	if len(fcs) == 0 {
+		return nil
+	}
+	f := &rangeKeyFilter{fcs: fcs}
+	return f.filter
+}
+
+// rangeKeyFilterCollector pairs a range key block property filter with the
+// constructor of the collector with the filter's name.
+type rangeKeyFilterCollector struct {
+	filter       BlockPropertyFilter
+	newCollector func() BlockPropertyCollector
+}
+
+// rangeKeyFilter implements the rangekey.Filter returned by
+// newRangeKeyFilter. Each iterator constructs its own filter, so the filter
+// reuses its buffers across calls.
+type rangeKeyFilter struct {
+	fcs   []rangeKeyFilterCollector
+	value []byte
+	prop  []byte
+	// start and end hold the bounds of the span last filtered, and results
+	// the outcome of filtering each of its range keys. The same span is
+	// transformed repeatedly as the iterator changes direction and is
+	// defragmented, so results are reused while the span is unchanged. The
+	// bounds, suffixes and values are copied into buf.
+	start, end []byte
+	results    []rangeKeyFilterResult
+	buf        []byte
+}
+
+// rangeKeyFilterResult records the outcome of filtering a range key.
+type rangeKeyFilterResult struct {
+	trailer       uint64
+	suffix, value []byte
+	ok            bool
+}
+
+func (f *rangeKeyFilter) filter(start, end []byte, k keyspan.Key) (bool, error) {
+	if len(f.results) > 0 && bytes.Equal(f.start, start) && bytes.Equal(f.end, end) {
+		for i := range f.results {
+			r := &f.results[i]
+			if r.trailer == k.Trailer && bytes.Equal(r.suffix, k.Suffix) && bytes.Equal(r.value, k.Value) {
+				return r.ok, nil
+			}
+		}
+	} else {
+		f.results = f.results[:0]
+		f.buf = append(f.buf[:0], start...)
+		f.buf = append(f.buf, end...)
+		f.start, f.end = f.buf[:len(start)], f.buf[len(start):]
+	}
+
+	ok, err := f.intersects(start, end, k)
+	if err != nil {
+		return false, err
+	}
+	// Appending to buf leaves the slices into its previous contents intact,
+	// even if it's reallocated.
+	n := len(f.buf)
+	f.buf = append(f.buf, k.Suffix...)
+	f.buf = append(f.buf, k.Value...)
+	f.results = append(f.results, rangeKeyFilterResult{
+		trailer: k.Trailer,
+		suffix:  f.buf[n : n+len(k.Suffix)],
+		value:   f.buf[n+len(k.Suffix):],
+		ok:      ok,
+	})
+	return ok, nil
+}
+
+// intersects returns true if every filter intersects the property its
+// collector computes for the range key alone.
+func (f *rangeKeyFilter) intersects(start, end []byte, k keyspan.Key) (bool, error) {
+	suffixValues := [1]rangekey.SuffixValue{{Suffix: k.Suffix, Value: k.Value}}
+	n := rangekey.EncodedSetValueLen(end, suffixValues[:])
+	if cap(f.value) < n {
+		f.value = make([]byte, n)
+	}
+	f.value = f.value[:n]
+	rangekey.EncodeSetValue(f.value, end, suffixValues[:])
+	key := base.InternalKey{UserKey: start, Trailer: k.Trailer}
+	for _, fc := range f.fcs {
+		// Collectors can't be reset, so each range key is added to a new one.
+		c := fc.newCollector()
+		if err := c.Add(key, f.value); err != nil {
+			return false, err
+		}
+		var err error
+		if f.prop, err = c.FinishTable(f.prop[:0]); err != nil {
+			return false, err
+		}
+		if ok, err := fc.filter.Intersects(f.prop); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// rangeKeyMasking implements IterOptions.RangeKeyMasking, configuring a
+// keyspan.SuffixMask for the interleaving iterator. Only range keys with suffixes, and with
+// suffixes ≥ RangeKeyMasking.Suffix if it's non-nil, may mask point keys, and
+// point keys without suffixes are never masked.
+//
+// Without a RangeKeyMasking.Mask predicate, a point key is masked if its suffix
+// is greater than the suffix of a range key covering it that may mask point
+// keys. Consider the following rendering, where suffixes with higher integers
+// sort before suffixes with lower integers, and RangeKeyMasking.Suffix is @7:
+//
+//          ^
+//       @9 |        •―――――――――――――――○ [e,m)@9
+//     s  8 |                      • l@8
+//     u  7 |------------------------------------ @7 RangeKeyMasking.Suffix
+//     f  6 |      [h,q)@6 •―――――――――――――――――○
+//     f  5 |              • h@5
+//     f  4 |                          • n@4
+//     i  3 |          •―――――――――――○ [f,l)@3
+//     x  2 |  • b@2
+//        1 |
+//        0 |___________________________________
+//           a b c d e f g h i j k l m n o p q
+//
+// An iterator scanning the entire keyspace observes point keys b@2 and l@8.
+// The range keys [h,q)@6 and [f,l)@3 serve as masks, because cmp(@6,@7) ≥ 0
+// and cmp(@3,@7) ≥ 0. The range key [e,m)@9 does not serve as a mask, because
+// cmp(@9,@7) < 0. Although point l@8 falls within the user key bounds of
+// [h,q)@6, since cmp(@6,@8) ≥ 0, l@8 is unmasked.
+type rangeKeyMasking struct {
+	keyspan.SuffixMask
+	opts *RangeKeyMasking
+	// mask adapts opts.Mask to the predicate of the SuffixMask. It's retained
+	// across calls to init with the same opts to avoid allocating.
+	mask func(pointSuffix []byte, k *keyspan.Key) bool
+}
+
+// init initializes the masking of point keys as configured by opts, returning
+// the SpanMask for the interleaving iterator, or nil if masking is disabled.
+func (m *rangeKeyMasking) init(
+	cmp base.Compare, split base.Split, opts *RangeKeyMasking,
+) keyspan.SpanMask {
+	if m.opts != opts {
+		m.mask = nil
+	}
+	m.opts = opts
+	if !opts.enabled() {
+		return nil
+	}
+	var mask func(pointSuffix []byte, k *keyspan.Key) bool
+	if opts.Mask != nil {
+		if m.mask == nil {
+			m.mask = func(pointSuffix []byte, k *keyspan.Key) bool {
+				return opts.Mask(pointSuffix, RangeKeyData{Suffix: k.Suffix, Value: k.Value})
+			}
+		}
+		mask = m.mask
+	}
+	m.SuffixMask.Init(cmp, split, opts.Suffix, mask)
+	return &m.SuffixMask
+}
@@ -10,8 +10,12 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -149,3 +153,225 @@ func TestRangeKeys(t *testing.T) {
 		require.NoError(t, d.Close())
 	}
 }
+
+func TestRangeKeyMaskingPredicate(t *testing.T) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatRangeKeys,
+	}
+	opts.Experimental.RangeKeys = new(RangeKeysArena)
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a@3", "b@5", "c@8", "d@2", "e", "g@5"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+	}
+	require.NoError(t, d.Experimental().RangeKeySet([]byte("a"), []byte("f"), []byte("@6"), nil, nil))
+
+	timestamp := func(suffix []byte) int {
+		ts, err := strconv.Atoi(string(suffix[1:]))
+		require.NoError(t, err)
+		return ts
+	}
+	// Mask point keys older than the range key, but more recent than a
+	// garbage collection threshold.
+	const gcThreshold = 3
+	mask := func(pointSuffix []byte, rangeKey RangeKeyData) bool {
+		pointTS := timestamp(pointSuffix)
+		return pointTS < timestamp(rangeKey.Suffix) && pointTS > gcThreshold
+	}
+
+	// scan returns the point keys observed by scanning forward and backward.
+	scan := func(iter *Iterator) (forward, backward []string) {
+		for valid := iter.First(); valid; valid = iter.Next() {
+			if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+				forward = append(forward, string(iter.Key()))
+			}
+		}
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+				backward = append([]string{string(iter.Key())}, backward...)
+			}
+		}
+		return forward, backward
+	}
+
+	testCases := []struct {
+		masking  RangeKeyMasking
+		expected []string
+	}{
+		// Without masking, all point keys are visible.
+		{RangeKeyMasking{}, []string{"a@3", "b@5", "c@8", "d@2", "e", "g@5"}},
+		// The suffix threshold masks all older point keys within the range
+		// key's bounds.
+		{RangeKeyMasking{Suffix: []byte("@9")}, []string{"c@8", "e", "g@5"}},
+		// The predicate retains the point keys at or below the GC threshold.
+		{RangeKeyMasking{Mask: mask}, []string{"a@3", "c@8", "d@2", "e", "g@5"}},
+		// The suffix threshold still determines which range keys may mask.
+		{RangeKeyMasking{Suffix: []byte("@9"), Mask: mask}, []string{"a@3", "c@8", "d@2", "e", "g@5"}},
+		{RangeKeyMasking{Suffix: []byte("@5"), Mask: mask}, []string{"a@3", "b@5", "c@8", "d@2", "e", "g@5"}},
+	}
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	defer func() { require.NoError(t, iter.Close()) }()
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			iterOpts := &IterOptions{KeyTypes: IterKeyTypePointsAndRanges, RangeKeyMasking: tc.masking}
+			newIter := d.NewIter(iterOpts)
+			forward, backward := scan(newIter)
+			require.NoError(t, newIter.Close())
+			require.Equal(t, tc.expected, forward)
+			require.Equal(t, tc.expected, backward)
+
+			// Changing the masking options of an existing iterator.
+			iter.SetOptions(iterOpts)
+			forward, backward = scan(iter)
+			require.Equal(t, tc.expected, forward)
+			require.Equal(t, tc.expected, backward)
+		})
+	}
+}
+
+// rangeKeySuffixCollector is a sstable.DataBlockIntervalCollector collecting
+// the interval of the timestamps of range keys' testkeys suffixes.
+type rangeKeySuffixCollector struct {
+	lower, upper uint64
+}
+
+func (c *rangeKeySuffixCollector) Add(key InternalKey, value []byte) error {
+	s, err := rangekey.Decode(key, value, nil)
+	if err != nil {
+		return err
+	}
+	for _, k := range s.Keys {
+		if len(k.Suffix) == 0 {
+			continue
+		}
+		ts, err := strconv.ParseUint(string(k.Suffix[1:]), 10, 64)
+		if err != nil {
+			return err
+		}
+		if c.lower == c.upper {
+			c.lower, c.upper = ts, ts+1
+			continue
+		}
+		if ts < c.lower {
+			c.lower = ts
+		}
+		if ts+1 > c.upper {
+			c.upper = ts + 1
+		}
+	}
+	return nil
+}
+
+func (c *rangeKeySuffixCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	lower, upper = c.lower, c.upper
+	c.lower, c.upper = 0, 0
+	return lower, upper, nil
+}
+
+func TestRangeKeyFiltersInMemory(t *testing.T) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatRangeKeys,
+		BlockPropertyCollectors: []func() BlockPropertyCollector{
+			func() BlockPropertyCollector {
+				return sstable.NewBlockIntervalCollector("ts", nil, &rangeKeySuffixCollector{})
+			},
+		},
+	}
+	opts.Experimental.RangeKeys = new(RangeKeysArena)
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write range keys to the range keys arena (by flushing), the memtable and
+	// an indexed batch.
+	require.NoError(t, d.Experimental().RangeKeySet([]byte("a"), []byte("c"), []byte("@2"), []byte("x"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Experimental().RangeKeySet([]byte("b"), []byte("d"), []byte("@5"), []byte("y"), nil))
+	b := d.NewIndexedBatch()
+	defer func() { require.NoError(t, b.Close()) }()
+	require.NoError(t, b.Experimental().RangeKeySet([]byte("c"), []byte("e"), []byte("@9"), []byte("z"), nil))
+
+	// scan returns the range keys observed by iter, closing iter.
+	scan := func(iter *Iterator) string {
+		var buf bytes.Buffer
+		for valid := iter.First(); valid; valid = iter.Next() {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&buf, "[%s, %s)", start, end)
+			for _, rk := range iter.RangeKeys() {
+				fmt.Fprintf(&buf, " %s=%s", rk.Suffix, rk.Value)
+			}
+			fmt.Fprintln(&buf)
+		}
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+	newIter := func(filters ...BlockPropertyFilter) *Iterator {
+		return b.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly, RangeKeyFilters: filters})
+	}
+
+	require.Equal(t, `[a, b) @2=x
+[b, c) @5=y @2=x
+[c, d) @9=z @5=y
+[d, e) @9=z
+`, scan(newIter()))
+	require.Equal(t, `[b, d) @5=y
+`, scan(newIter(sstable.NewBlockIntervalFilter("ts", 4, 7))))
+	require.Equal(t, `[a, c) @2=x
+`, scan(newIter(sstable.NewBlockIntervalFilter("ts", 0, 3))))
+	// Filters without a corresponding collector don't filter range keys.
+	require.Equal(t, scan(newIter()), scan(newIter(sstable.NewBlockIntervalFilter("unknown", 0, 3))))
+
+	// Clones filter range keys too, and changing the filters of an existing
+	// iterator takes effect.
+	iter := newIter(sstable.NewBlockIntervalFilter("ts", 9, 10))
+	clone, err := iter.Clone()
+	require.NoError(t, err)
+	require.Equal(t, `[c, e) @9=z
+`, scan(clone))
+	iter.SetOptions(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+	require.Equal(t, scan(newIter()), scan(iter))
+}
+
+func TestRangeKeyFilterReuse(t *testing.T) {
+	var collectors int
+	filter := newRangeKeyFilter(
+		[]BlockPropertyFilter{sstable.NewBlockIntervalFilter("ts", 4, 7)},
+		[]func() BlockPropertyCollector{func() BlockPropertyCollector {
+			collectors++
+			return sstable.NewBlockIntervalCollector("ts", nil, &rangeKeySuffixCollector{})
+		}},
+	)
+	keys := []keyspan.Key{
+		{Trailer: base.MakeTrailer(2, base.InternalKeyKindRangeKeySet), Suffix: []byte("@5"), Value: []byte("y")},
+		{Trailer: base.MakeTrailer(1, base.InternalKeyKindRangeKeySet), Suffix: []byte("@2"), Value: []byte("x")},
+	}
+	check := func(start, end string) {
+		for i, want := range []bool{true, false} {
+			ok, err := filter([]byte(start), []byte(end), keys[i])
+			require.NoError(t, err)
+			require.Equal(t, want, ok)
+		}
+	}
+
+	// Filtering a span's keys constructs a collector for each key.
+	collectors = 0
+	check("b", "c")
+	require.Equal(t, 2, collectors)
+	// Filtering the same span again reuses the results without allocating.
+	start, end := []byte("b"), []byte("c")
+	require.Zero(t, testing.AllocsPerRun(10, func() {
+		for i := range keys {
+			_, _ = filter(start, end, keys[i])
+		}
+	}))
+	require.Equal(t, 2, collectors)
+	// Filtering another span filters its keys anew.
+	check("c", "d")
+	require.Equal(t, 4, collectors)
+}
@@ -76,6 +76,22 @@ func (d *DB) loadReadState() *readState {
 	return state
 }
 
+// loadSnapshotReadState returns the readState to read at the given snapshot,
+// which may be nil. This is the current readState, unless the snapshot is the
+// snapshot of an EventuallyFileOnlySnapshot which has transitioned to reading
+// from a pinned version. The returned readState must be unreferenced when the
+// caller is finished with it.
+func (d *DB) loadSnapshotReadState(s *Snapshot) *readState {
+	d.readState.RLock()
+	state := d.readState.val
+	if s != nil && s.fileOnlyReadState != nil {
+		state = s.fileOnlyReadState
+	}
+	state.ref()
+	d.readState.RUnlock()
+	return state
+}
+
 // updateReadStateLocked creates a new readState from the current version and
 // list of memtables. Requires DB.mu is held. If checker is not nil, it is
 // called after installing the new readState. If atomicFunc is not nil, it is
@@ -0,0 +1,173 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// The background scrubber finds latent corruption in sstables which are read
+// infrequently, before the corruption is encountered by a user read. When
+// Options.Experimental.ScrubInterval is set, a scrubber goroutine periodically
+// walks every live sstable, reading each block from disk and verifying its
+// checksum. Reads are paced by a scrubPacer when Options.Experimental.ScrubRate
+// is set.
+//
+// A corrupt sstable is reported through EventListener.TableCorrupted, which is
+// where the application decides how to handle it: reads and compactions of
+// the sstable are unaffected by the scrubber. The scrubber remembers corrupt
+// sstables in memory until they are deleted, so that subsequent passes skip
+// them rather than reporting them again. The corrupt sstables are exposed
+// through DB.CorruptTables and Metrics.Table.CorruptCount. The set is not
+// persisted, so a corrupt sstable is reported again after the DB is reopened.
+//
+// The scrubber does not hold a reference to a version for the duration of a
+// pass, which would prevent obsolete sstables from being deleted. Instead, the
+// list of live sstables is collected when the pass begins, and each sstable
+// is verified to still be live before it is scrubbed.
+
+// scrubLoop runs a pass of the scrubber every ScrubInterval, until the DB is
+// closed.
+func (d *DB) scrubLoop() {
+	timer := time.NewTimer(d.opts.Experimental.ScrubInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(d.opts.Experimental.ScrubInterval)
+		d.scrub()
+	}
+}
+
+// scrub runs a single pass of the scrubber over all live sstables.
+func (d *DB) scrub() {
+	d.mu.Lock()
+	// NB: Close waits for a running pass of the scrubber to complete, so a
+	// pass must not begin once the DB has been closed.
+	if d.closed.Load() != nil || d.mu.scrub.scrubbing {
+		d.mu.Unlock()
+		return
+	}
+	d.mu.scrub.scrubbing = true
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	var files []newFileEntry
+	vers := d.mu.versions.currentVersion()
+	for level := range vers.Levels {
+		iter := vers.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if _, ok := d.mu.scrub.corrupt[f.FileNum]; !ok {
+				files = append(files, newFileEntry{Level: level, Meta: f})
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.mu.scrub.scrubbing = false
+		d.mu.scrub.cond.Broadcast()
+	}()
+
+	pacer := (pacer)(nilPacer)
+	if d.opts.Experimental.ScrubRate > 0 {
+		pacer = newScrubPacer(d.scrubLimiter, d.closedCh)
+	}
+	validateOpts := sstable.ValidateOptions{
+		Mode: sstable.VerifyFromDisk,
+		Throttle: func(blockLen uint64) error {
+			// Stop promptly if the DB is closed, since Close waits for the
+			// scrubber.
+			if err := d.closed.Load(); err != nil {
+				return err.(error)
+			}
+			return pacer.maybeThrottle(blockLen)
+		},
+	}
+
+	for _, f := range files {
+		if d.closed.Load() != nil {
+			return
+		}
+		err := d.scrubTable(f, validateOpts)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrClosed):
+			return
+		case errors.Is(err, base.ErrCorruption):
+			d.mu.Lock()
+			d.markCorruptLocked(f.Meta.FileNum)
+			d.mu.Unlock()
+			d.opts.EventListener.TableCorrupted(TableCorruptionInfo{
+				JobID:   jobID,
+				Level:   f.Level,
+				Path:    base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, f.Meta.FileNum),
+				FileNum: f.Meta.FileNum,
+				Err:     err,
+			})
+		default:
+			d.opts.EventListener.BackgroundError(err)
+		}
+	}
+}
+
+// markCorruptLocked records that the scrubber found the sstable to be corrupt.
+// The record is removed when the sstable is deleted, in
+// doDeleteObsoleteFiles. d.mu must be held when calling this.
+func (d *DB) markCorruptLocked(fileNum FileNum) {
+	if d.mu.scrub.corrupt == nil {
+		d.mu.scrub.corrupt = make(map[FileNum]struct{})
+	}
+	d.mu.scrub.corrupt[fileNum] = struct{}{}
+}
+
+// CorruptTables returns the file numbers of the sstables that the background
+// scrubber found to be corrupt, in increasing order. An sstable remains in the
+// returned set until it is deleted, or until the DB is reopened.
+func (d *DB) CorruptTables() []FileNum {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fileNums := make([]FileNum, 0, len(d.mu.scrub.corrupt))
+	for fileNum := range d.mu.scrub.corrupt {
+		fileNums = append(fileNums, fileNum)
+	}
+	sort.Slice(fileNums, func(i, j int) bool { return fileNums[i] < fileNums[j] })
+	return fileNums
+}
+
+// scrubTable verifies the block checksums of the sstable, if it's still live.
+func (d *DB) scrubTable(f newFileEntry, o sstable.ValidateOptions) error {
+	// The reference to the read state prevents the sstable from being deleted
+	// while it's being scrubbed.
+	rs := d.loadReadState()
+	defer rs.unref()
+	if !versionContainsFile(rs.current, d.cmp, f) {
+		return nil
+	}
+	return d.tableCache.withReader(f.Meta, func(r *sstable.Reader) error {
+		return r.ValidateBlockChecksumsWithOptions(o)
+	})
+}
+
+// versionContainsFile returns true if the file is present in the version,
+// either at the level it was added to, or at a lower level it has since been
+// moved to.
+func versionContainsFile(v *version, cmp Compare, f newFileEntry) bool {
+	for level := f.Level; level < numLevels; level++ {
+		if v.Contains(level, cmp, f.Meta) {
+			return true
+		}
+	}
+	return false
+}
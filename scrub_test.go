@@ -0,0 +1,162 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrub(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var corruptions []TableCorruptionInfo
+	opts := &Options{
+		FS: vfs.Default,
+		EventListener: EventListener{
+			TableCorrupted: func(info TableCorruptionInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				corruptions = append(corruptions, info)
+			},
+		},
+	}
+	// The scrubber is run manually by the test.
+	opts.Experimental.ScrubInterval = time.Hour
+	opts.Experimental.ScrubRate = 1 << 20
+	d, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		require.NoError(t, d.Set(k, k, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("z"), []byte("z"), nil))
+	require.NoError(t, d.Flush())
+
+	var files []*fileMetadata
+	d.mu.Lock()
+	iter := d.mu.versions.currentVersion().Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		files = append(files, f)
+	}
+	d.mu.Unlock()
+	require.Equal(t, 2, len(files))
+	corrupt := files[0]
+	if corrupt.Size < files[1].Size {
+		corrupt = files[1]
+	}
+
+	// Read every key, which loads all of the blocks into the block cache.
+	dbIter := d.NewIter(nil)
+	for valid := dbIter.First(); valid; valid = dbIter.Next() {
+	}
+	require.NoError(t, dbIter.Close())
+
+	d.scrub()
+	require.Empty(t, corruptions)
+
+	// Corrupt the first data block of the larger table. The corruption is
+	// detected by the scrubber, even though the block is present in the
+	// block cache.
+	path := base.MakeFilepath(vfs.Default, dir, fileTypeTable, corrupt.FileNum)
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	d.scrub()
+	require.Equal(t, 1, len(corruptions))
+	require.Equal(t, corrupt.FileNum, corruptions[0].FileNum)
+	require.Equal(t, path, corruptions[0].Path)
+	require.Equal(t, 0, corruptions[0].Level)
+	require.True(t, errors.Is(corruptions[0].Err, base.ErrCorruption))
+	require.Equal(t, []FileNum{corrupt.FileNum}, d.CorruptTables())
+	require.EqualValues(t, 1, d.Metrics().Table.CorruptCount)
+
+	// Corrupted tables are not scrubbed again, but are remembered.
+	d.scrub()
+	require.Equal(t, 1, len(corruptions))
+	require.Equal(t, []FileNum{corrupt.FileNum}, d.CorruptTables())
+	require.EqualValues(t, 1, d.Metrics().Table.CorruptCount)
+}
+
+func TestScrubForgetsDeletedTables(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.ScrubInterval = time.Hour
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	l0 := d.mu.versions.currentVersion().Levels[0].Iter()
+	fileNum := l0.First().FileNum
+	d.markCorruptLocked(fileNum)
+	d.mu.Unlock()
+	require.Equal(t, []FileNum{fileNum}, d.CorruptTables())
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+
+	// The corrupt table is remembered until it's deleted, even though it's no
+	// longer live once it's compacted.
+	iter := d.NewIter(nil)
+	require.True(t, iter.First())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false /* parallelize */))
+	d.scrub()
+	require.Equal(t, []FileNum{fileNum}, d.CorruptTables())
+	require.NoError(t, iter.Close())
+	// Wait for the obsolete table to be deleted.
+	d.mu.Lock()
+	d.deleteObsoleteFiles(d.mu.nextJobID, true /* waitForOngoing */)
+	d.mu.Unlock()
+	require.Empty(t, d.CorruptTables())
+	require.EqualValues(t, 0, d.Metrics().Table.CorruptCount)
+}
+
+func TestScrubInterval(t *testing.T) {
+	corrupted := make(chan TableCorruptionInfo, 1)
+	opts := &Options{
+		FS: vfs.Default,
+		EventListener: EventListener{
+			TableCorrupted: func(info TableCorruptionInfo) {
+				corrupted <- info
+			},
+		},
+	}
+	opts.Experimental.ScrubInterval = time.Millisecond
+	dir := t.TempDir()
+	d, err := Open(dir, opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+
+	d.mu.Lock()
+	iter := d.mu.versions.currentVersion().Levels[0].Iter()
+	fileNum := iter.First().FileNum
+	d.mu.Unlock()
+	path := base.MakeFilepath(vfs.Default, dir, fileTypeTable, fileNum)
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	info := <-corrupted
+	require.Equal(t, fileNum, info.FileNum)
+	require.NoError(t, d.Close())
+}
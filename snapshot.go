@@ -5,8 +5,12 @@
 package pebble
 
 import (
+	"context"
 	"io"
 	"math"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
 )
 
 // Snapshot provides a read-only point-in-time view of the DB state.
@@ -20,6 +24,12 @@ type Snapshot struct {
 
 	// The next/prev link for the snapshotList doubly-linked list of snapshots.
 	prev, next *Snapshot
+
+	// fileOnlyReadState is the readState pinned by the snapshot once the
+	// EventuallyFileOnlySnapshot it belongs to has transitioned to a file-only
+	// snapshot, at which point the snapshot is no longer in the list. It is set
+	// while holding both DB.mu and DB.readState.
+	fileOnlyReadState *readState
 }
 
 var _ Reader = (*Snapshot)(nil)
@@ -69,6 +79,359 @@ func (s *Snapshot) Close() error {
 	return nil
 }
 
+// KeyRange is the span of user keys [Start, End). A nil Start or End leaves
+// the span unbounded.
+type KeyRange struct {
+	Start, End []byte
+}
+
+// EventuallyFileOnlySnapshot is a read-only point-in-time view of the keys in
+// a set of key ranges of the DB state, which does not pin the state of the DB
+// for longer than necessary.
+//
+// An EventuallyFileOnlySnapshot initially behaves as a Snapshot, preventing
+// compactions from dropping keys which are visible to it. Once every key
+// visible to it within its key ranges has been flushed to sstables, it
+// transitions to a file-only snapshot, which references the sstables of the
+// DB's version at the time of the transition rather than a sequence number.
+// From then on, compactions drop obsolete keys as if the snapshot did not
+// exist, at the cost of the disk space of the sstables referenced by the
+// snapshot, which are not deleted until the snapshot is closed.
+//
+// The snapshot transitions after the flush of the memtables holding keys in
+// its key ranges, which may be forced with WaitForFileOnly. Reads must lie
+// within the snapshot's key ranges.
+type EventuallyFileOnlySnapshot struct {
+	db        *DB
+	keyRanges []KeyRange
+	// snap is the snapshot read at. Once the snapshot transitions, it is
+	// removed from the DB's list of snapshots, and reads from its pinned
+	// readState.
+	snap *Snapshot
+	// transitioned is closed when the snapshot transitions to a file-only
+	// snapshot.
+	transitioned chan struct{}
+	// blockingMem is the oldest memtable found to hold keys visible to the
+	// snapshot within its key ranges, if any. The snapshot cannot transition
+	// while it remains queued. Protected by DB.mu.
+	blockingMem *flushableEntry
+}
+
+var _ Reader = (*EventuallyFileOnlySnapshot)(nil)
+
+// NewEventuallyFileOnlySnapshot returns a point-in-time view of the keys in the
+// given key ranges of the current DB state, which transitions to a file-only
+// snapshot once the keys it reads have been flushed. No key ranges snapshots
+// the entire keyspace. See EventuallyFileOnlySnapshot.
+func (d *DB) NewEventuallyFileOnlySnapshot(keyRanges []KeyRange) *EventuallyFileOnlySnapshot {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	e := &EventuallyFileOnlySnapshot{
+		db:           d,
+		transitioned: make(chan struct{}),
+	}
+	for _, kr := range keyRanges {
+		e.keyRanges = append(e.keyRanges, KeyRange{
+			Start: append([]byte(nil), kr.Start...),
+			End:   append([]byte(nil), kr.End...),
+		})
+	}
+
+	d.mu.Lock()
+	e.snap = &Snapshot{
+		db:     d,
+		seqNum: atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum),
+	}
+	d.mu.snapshots.pushBack(e.snap)
+	d.mu.fileOnlySnapshots = append(d.mu.fileOnlySnapshots, e)
+	d.mu.Unlock()
+	d.maybeTransitionFileOnlySnapshots()
+	return e
+}
+
+// Get gets the value for the given key. It returns ErrNotFound if the
+// snapshot does not contain the key, and an error if the key lies outside of
+// the snapshot's key ranges.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (e *EventuallyFileOnlySnapshot) Get(key []byte) ([]byte, io.Closer, error) {
+	if e.db == nil {
+		panic(ErrClosed)
+	}
+	if !e.containsSpan(key, key, true /* inclusive */) {
+		return nil, nil, errors.Errorf("pebble: key %s outside of snapshot key ranges",
+			e.db.opts.Comparer.FormatKey(key))
+	}
+	return e.db.getInternal(key, nil /* batch */, e.snap)
+}
+
+// NewIter returns an iterator that is unpositioned (Iterator.Valid() will
+// return false). The iterator can be positioned via a call to SeekGE,
+// SeekLT, First or Last. If the iterator's bounds do not lie within one of the
+// snapshot's key ranges, the returned iterator is never valid and Error
+// returns an error.
+func (e *EventuallyFileOnlySnapshot) NewIter(o *IterOptions) *Iterator {
+	if e.db == nil {
+		panic(ErrClosed)
+	}
+	if !e.containsSpan(o.GetLowerBound(), o.GetUpperBound(), false /* inclusive */) {
+		return e.db.newErrorIterator(errors.New("pebble: iterator bounds outside of snapshot key ranges"))
+	}
+	return e.db.newIterInternal(nil /* batch */, e.snap, o)
+}
+
+// WaitForFileOnly flushes the memtables holding keys visible to the snapshot
+// within its key ranges, if it has not yet transitioned to a file-only
+// snapshot, and waits for it to transition or for the context to be done.
+func (e *EventuallyFileOnlySnapshot) WaitForFileOnly(ctx context.Context) error {
+	if e.db == nil {
+		panic(ErrClosed)
+	}
+	// The memtables blocking the snapshot may have been flushed without the
+	// snapshot transitioning, in which case there is nothing left to flush.
+	e.db.maybeTransitionFileOnlySnapshots()
+	select {
+	case <-e.transitioned:
+		return nil
+	default:
+	}
+	if _, err := e.db.AsyncFlush(); err != nil {
+		return err
+	}
+	select {
+	case <-e.transitioned:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the snapshot, releasing its resources. Close must be called.
+// Failure to do so will result in a large leak of resources on disk due to the
+// entries or sstables the snapshot is preventing from being deleted.
+func (e *EventuallyFileOnlySnapshot) Close() error {
+	if e.db == nil {
+		panic(ErrClosed)
+	}
+	d := e.db
+	e.db = nil
+
+	d.mu.Lock()
+	rs := e.snap.fileOnlyReadState
+	if rs == nil {
+		for i := range d.mu.fileOnlySnapshots {
+			if d.mu.fileOnlySnapshots[i] == e {
+				d.mu.fileOnlySnapshots = append(d.mu.fileOnlySnapshots[:i], d.mu.fileOnlySnapshots[i+1:]...)
+				break
+			}
+		}
+		d.mu.Unlock()
+		return e.snap.Close()
+	}
+	d.mu.Unlock()
+	e.snap.db = nil
+	rs.unref()
+	return nil
+}
+
+// containsSpan returns true if the span between start and end lies within one
+// of the snapshot's key ranges. The span is [start, end] if inclusive, and
+// [start, end) otherwise. A nil start or end leaves the span unbounded.
+func (e *EventuallyFileOnlySnapshot) containsSpan(start, end []byte, inclusive bool) bool {
+	if len(e.keyRanges) == 0 {
+		return true
+	}
+	cmp := e.db.cmp
+	for _, kr := range e.keyRanges {
+		if kr.Start != nil && (start == nil || cmp(start, kr.Start) < 0) {
+			continue
+		}
+		switch {
+		case kr.End == nil:
+			return true
+		case end == nil:
+		case inclusive && cmp(end, kr.End) < 0:
+			return true
+		case !inclusive && cmp(end, kr.End) <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+// maybeTransitionFileOnlySnapshots transitions the eventually file-only
+// snapshots whose keys have been flushed to file-only snapshots. A snapshot
+// transitions once none of the memtables holding keys visible to it hold keys
+// within its key ranges. The memtables are scanned without holding DB.mu.
+// Requires DB.mu is not held.
+func (d *DB) maybeTransitionFileOnlySnapshots() {
+	for {
+		d.mu.Lock()
+		var check []*EventuallyFileOnlySnapshot
+		for _, e := range d.mu.fileOnlySnapshots {
+			// The keys visible to the snapshot are immutable, so a memtable
+			// found to hold any within its key ranges continues to until it
+			// is flushed. Memtables are flushed in order, so the memtables
+			// queued before it have already been found not to.
+			if e.blockingMem == nil || !d.memtableQueuedLocked(e.blockingMem) {
+				check = append(check, e)
+			}
+		}
+		d.mu.Unlock()
+		if len(check) == 0 {
+			return
+		}
+
+		// A readState's memtables hold every key missing from its version, so
+		// a snapshot whose key ranges do not overlap the memtables may read
+		// from the version.
+		rs := d.loadReadState()
+		blocking := make([]*flushableEntry, len(check))
+		for i, e := range check {
+			blocking[i] = overlappingMemtable(d.cmp, rs.memtables, e.keyRanges, e.snap.seqNum)
+		}
+
+		d.mu.Lock()
+		retry := d.transitionFileOnlySnapshotsLocked(rs, check, blocking)
+		d.mu.Unlock()
+		rs.unref()
+		if !retry {
+			return
+		}
+	}
+}
+
+// transitionFileOnlySnapshotsLocked transitions the snapshots which were found
+// not to be blocked by any of the memtables of rs to file-only snapshots
+// reading from the version of rs, and records the memtables blocking the
+// others. It returns true if a blocking memtable was flushed while the
+// memtables were scanned, in which case the flush may not have observed the
+// snapshot, and the snapshots must be checked again. Requires DB.mu is held.
+func (d *DB) transitionFileOnlySnapshotsLocked(
+	rs *readState, check []*EventuallyFileOnlySnapshot, blocking []*flushableEntry,
+) (retry bool) {
+	earliest := d.mu.snapshots.earliest()
+	pending := d.mu.fileOnlySnapshots[:0]
+	for _, e := range d.mu.fileOnlySnapshots {
+		i := 0
+		for i < len(check) && check[i] != e {
+			i++
+		}
+		if i == len(check) {
+			// The snapshot was already blocked, or was created after the
+			// memtables were scanned.
+			pending = append(pending, e)
+			continue
+		}
+		if blocking[i] != nil {
+			e.blockingMem = blocking[i]
+			if !d.memtableQueuedLocked(e.blockingMem) {
+				retry = true
+			}
+			pending = append(pending, e)
+			continue
+		}
+		fileOnly := &readState{
+			db:      d,
+			refcnt:  1,
+			current: rs.current,
+		}
+		fileOnly.current.Ref()
+		d.readState.Lock()
+		e.snap.fileOnlyReadState = fileOnly
+		d.readState.Unlock()
+		d.mu.snapshots.remove(e.snap)
+		close(e.transitioned)
+	}
+	for i := len(pending); i < len(d.mu.fileOnlySnapshots); i++ {
+		d.mu.fileOnlySnapshots[i] = nil
+	}
+	d.mu.fileOnlySnapshots = pending
+
+	// If a transitioned snapshot was the earliest snapshot, we might be able
+	// to reclaim disk space by dropping obsolete records that were pinned by
+	// it.
+	if e := d.mu.snapshots.earliest(); e > earliest {
+		d.maybeScheduleCompactionPicker(pickElisionOnly)
+	}
+	return retry
+}
+
+// memtableQueuedLocked returns true if the memtable has not yet been flushed.
+// Requires DB.mu is held.
+func (d *DB) memtableQueuedLocked(mem *flushableEntry) bool {
+	for _, m := range d.mu.mem.queue {
+		if m == mem {
+			return true
+		}
+	}
+	return false
+}
+
+// overlappingMemtable returns the oldest of the memtables holding a point key
+// or range deletion within the key ranges with a sequence number less than
+// seqNum, or nil if there is none. No key ranges span the entire keyspace.
+func overlappingMemtable(
+	cmp Compare, memtables flushableList, keyRanges []KeyRange, seqNum uint64,
+) *flushableEntry {
+	if len(keyRanges) == 0 {
+		keyRanges = []KeyRange{{}}
+	}
+	for _, mem := range memtables {
+		// The memtables are ordered from oldest to newest, and hold the keys
+		// with sequence numbers of at least their logSeqNum.
+		if mem.logSeqNum >= seqNum {
+			break
+		}
+		if flushableOverlaps(cmp, mem, keyRanges, seqNum) {
+			return mem
+		}
+	}
+	return nil
+}
+
+// flushableOverlaps returns true if the flushable holds a point key or range
+// deletion within the key ranges with a sequence number less than seqNum.
+func flushableOverlaps(cmp Compare, mem flushable, keyRanges []KeyRange, seqNum uint64) bool {
+	iter := mem.newIter(nil)
+	defer iter.Close()
+	for _, kr := range keyRanges {
+		var k *InternalKey
+		if kr.Start == nil {
+			k, _ = iter.First()
+		} else {
+			k, _ = iter.SeekGE(kr.Start, false /* trySeekUsingNext */)
+		}
+		for ; k != nil && (kr.End == nil || cmp(k.UserKey, kr.End) < 0); k, _ = iter.Next() {
+			if k.SeqNum() < seqNum {
+				return true
+			}
+		}
+	}
+
+	rangeDelIter := mem.newRangeDelIter(nil)
+	if rangeDelIter == nil {
+		return false
+	}
+	defer rangeDelIter.Close()
+	for s := rangeDelIter.First(); s.Valid(); s = rangeDelIter.Next() {
+		if s.Empty() || s.SmallestSeqNum() >= seqNum {
+			continue
+		}
+		for _, kr := range keyRanges {
+			if (kr.End == nil || cmp(s.Start, kr.End) < 0) &&
+				(kr.Start == nil || cmp(kr.Start, s.End) < 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type snapshotList struct {
 	root Snapshot
 }
@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -348,3 +349,122 @@ func TestNewSnapshotRace(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, d.Close())
 }
+
+func TestEventuallyFileOnlySnapshot(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(r Reader, key string) string {
+		v, closer, err := r.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	transitioned := func(e *EventuallyFileOnlySnapshot) bool {
+		select {
+		case <-e.transitioned:
+			return true
+		default:
+			return false
+		}
+	}
+	snapshotCount := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.snapshots.count()
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("x"), []byte("1"), nil))
+
+	// A snapshot of keys held in the memtable waits for a flush.
+	e := d.NewEventuallyFileOnlySnapshot([]KeyRange{{Start: []byte("a"), End: []byte("c")}})
+	require.False(t, transitioned(e))
+	require.Equal(t, 1, snapshotCount())
+	d.mu.Lock()
+	require.Equal(t, d.mu.mem.mutable, e.blockingMem.flushable)
+	d.mu.Unlock()
+
+	// A snapshot of key ranges the memtable holds no keys within, or only keys
+	// written after the snapshot, transitions immediately.
+	require.NoError(t, d.Set([]byte("m"), []byte("1"), nil))
+	e2 := d.NewEventuallyFileOnlySnapshot([]KeyRange{{Start: []byte("b"), End: []byte("c")}})
+	require.True(t, transitioned(e2))
+	require.NoError(t, e2.Close())
+	require.Equal(t, 1, snapshotCount())
+
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.Equal(t, "1", get(e, "a"))
+	require.NoError(t, d.Flush())
+	require.True(t, transitioned(e))
+	require.Equal(t, 0, snapshotCount())
+
+	// The file-only snapshot continues to read the state at its creation
+	// through compactions, which no longer preserve the overwritten key.
+	require.NoError(t, d.Set([]byte("a"), []byte("3"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	require.Equal(t, "1", get(e, "a"))
+	require.Equal(t, "3", get(d, "a"))
+	iter := e.NewIter(&IterOptions{LowerBound: []byte("a"), UpperBound: []byte("c")})
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a=1"}, keys)
+
+	// Reads outside of the snapshot's key ranges are rejected.
+	_, _, err = e.Get([]byte("x"))
+	require.Error(t, err)
+	iter = e.NewIter(nil)
+	require.Error(t, iter.Error())
+	require.False(t, iter.First())
+	require.Error(t, iter.Error())
+	require.False(t, iter.SeekLT([]byte("b")))
+	require.Error(t, iter.Error())
+	require.Error(t, iter.Close())
+	require.NoError(t, e.Close())
+
+	// WaitForFileOnly forces the flush.
+	require.NoError(t, d.Set([]byte("a"), []byte("4"), nil))
+	e = d.NewEventuallyFileOnlySnapshot(nil)
+	require.False(t, transitioned(e))
+	require.NoError(t, e.WaitForFileOnly(context.Background()))
+	require.True(t, transitioned(e))
+	require.Equal(t, "4", get(e, "a"))
+	require.Equal(t, "1", get(e, "x"))
+	require.NoError(t, e.Close())
+
+	// A snapshot whose blocking memtable is flushed without the flush
+	// observing the snapshot, as when the flush races with the scan of the
+	// memtables, still transitions. A scan which found a memtable since
+	// flushed is retried, and WaitForFileOnly transitions the snapshot
+	// without requiring another flush.
+	require.NoError(t, d.Set([]byte("a"), []byte("5"), nil))
+	e = d.NewEventuallyFileOnlySnapshot(nil)
+	require.False(t, transitioned(e))
+	d.mu.Lock()
+	blockingMem := e.blockingMem
+	d.mu.fileOnlySnapshots = nil
+	d.mu.Unlock()
+	require.NoError(t, d.Flush())
+	require.False(t, transitioned(e))
+	d.mu.Lock()
+	d.mu.fileOnlySnapshots = []*EventuallyFileOnlySnapshot{e}
+	rs := d.loadReadState()
+	require.True(t, d.transitionFileOnlySnapshotsLocked(rs,
+		[]*EventuallyFileOnlySnapshot{e}, []*flushableEntry{blockingMem}))
+	d.mu.Unlock()
+	rs.unref()
+	require.False(t, transitioned(e))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, e.WaitForFileOnly(ctx))
+	require.True(t, transitioned(e))
+	require.Equal(t, "5", get(e, "a"))
+	require.NoError(t, e.Close())
+}
@@ -35,16 +35,32 @@ type blockWriter struct {
 	curValue        []byte
 	prevKey         []byte
 	tmp             [4]byte
+	// hashIndex is true if the block should be written with a hash index. See
+	// block_hash_index.go.
+	hashIndex        bool
+	hashIndexEntries []blockHashIndexEntry
+	// columnar is true if the block should be written as a columnar data
+	// block, in which case split divides user keys into prefixes and suffixes
+	// and cols accumulates the columns. See columnar_block.go.
+	columnar bool
+	split    Split
+	cols     columnarBlockColumns
 }
 
 func (w *blockWriter) clear() {
 	*w = blockWriter{
-		buf:      w.buf[:0],
-		restarts: w.restarts[:0],
-		curKey:   w.curKey[:0],
-		curValue: w.curValue[:0],
-		prevKey:  w.prevKey[:0],
+		buf:              w.buf[:0],
+		restarts:         w.restarts[:0],
+		curKey:           w.curKey[:0],
+		curValue:         w.curValue[:0],
+		prevKey:          w.prevKey[:0],
+		hashIndex:        w.hashIndex,
+		hashIndexEntries: w.hashIndexEntries[:0],
+		columnar:         w.columnar,
+		split:            w.split,
+		cols:             w.cols,
 	}
+	w.cols.reset()
 }
 
 func (w *blockWriter) store(keySize int, value []byte) {
@@ -134,6 +150,10 @@ func (w *blockWriter) store(keySize int, value []byte) {
 }
 
 func (w *blockWriter) add(key InternalKey, value []byte) {
+	if w.columnar {
+		w.addColumnar(key, value)
+		return
+	}
 	w.curKey, w.prevKey = w.prevKey, w.curKey
 
 	size := key.Size()
@@ -144,6 +164,9 @@ func (w *blockWriter) add(key InternalKey, value []byte) {
 	key.Encode(w.curKey)
 
 	w.store(size, value)
+	if w.hashIndex {
+		w.addHashIndexEntry(key.UserKey)
+	}
 }
 
 func (w *blockWriter) finish() []byte {
@@ -157,12 +180,21 @@ func (w *blockWriter) finish() []byte {
 			w.restarts = append(w.restarts, 0)
 		}
 	}
+	var numRestarts uint32
+	if w.columnar {
+		w.finishColumns()
+		numRestarts |= blockColumnarFlag
+	}
 	tmp4 := w.tmp[:4]
 	for _, x := range w.restarts {
 		binary.LittleEndian.PutUint32(tmp4, x)
 		w.buf = append(w.buf, tmp4...)
 	}
-	binary.LittleEndian.PutUint32(tmp4, uint32(len(w.restarts)))
+	numRestarts |= uint32(len(w.restarts))
+	if w.hashIndex && w.finishHashIndex() {
+		numRestarts |= blockHashIndexFlag
+	}
+	binary.LittleEndian.PutUint32(tmp4, numRestarts)
 	w.buf = append(w.buf, tmp4...)
 	result := w.buf
 
@@ -171,6 +203,7 @@ func (w *blockWriter) finish() []byte {
 	w.nextRestart = 0
 	w.buf = w.buf[:0]
 	w.restarts = w.restarts[:0]
+	w.hashIndexEntries = w.hashIndexEntries[:0]
 	return result
 }
 
@@ -180,7 +213,14 @@ func (w *blockWriter) finish() []byte {
 const emptyBlockSize = 4
 
 func (w *blockWriter) estimatedSize() int {
-	return len(w.buf) + 4*len(w.restarts) + emptyBlockSize
+	size := len(w.buf) + 4*len(w.restarts) + emptyBlockSize
+	if w.columnar {
+		size += w.cols.size()
+	}
+	if len(w.hashIndexEntries) > 0 {
+		size += blockHashIndexNumBuckets(len(w.hashIndexEntries)) + 2
+	}
+	return size
 }
 
 type blockEntry struct {
@@ -238,6 +278,9 @@ type blockIter struct {
 	globalSeqNum uint64
 	ptr          unsafe.Pointer
 	data         []byte
+	// hashIndex holds the buckets of the block's hash index, or nil if the
+	// block does not have a hash index. See block_hash_index.go.
+	hashIndex []byte
 	// key contains the raw key the iterator is currently pointed at. This may
 	// point directly to data stored in the block (for a key which has no prefix
 	// compression), to fullKey (for a prefix compressed key), or to a slice of
@@ -274,6 +317,16 @@ type blockIter struct {
 	// The first key in the block. This is used by the caller to set bounds
 	// for block iteration for already loaded blocks.
 	firstKey InternalKey
+	// columnar is true if the block is a columnar data block, in which case
+	// col holds its columns, offset and nextOffset are the indexes of the
+	// current and next rows, and restarts is the number of rows. See
+	// columnar_block.go.
+	columnar bool
+	col      columnarBlock
+	// suffixFilters are evaluated on the suffix of each row of a columnar
+	// block, and rows that do not intersect them are skipped. They are ignored
+	// for row-oriented blocks.
+	suffixFilters []BlockPropertySuffixFilter
 }
 
 // blockIter implements the base.InternalIterator interface.
@@ -289,12 +342,26 @@ func (i *blockIter) String() string {
 }
 
 func (i *blockIter) init(cmp Compare, block block, globalSeqNum uint64) error {
-	numRestarts := int32(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	v := binary.LittleEndian.Uint32(block[len(block)-4:])
+	numRestarts := int32(v &^ (blockHashIndexFlag | blockColumnarFlag))
 	if numRestarts == 0 {
 		return base.CorruptionErrorf("pebble/table: invalid table (block has no restart points)")
 	}
+	end := int32(len(block)) - 4
+	i.hashIndex = nil
+	if v&blockHashIndexFlag != 0 {
+		if end < 2 {
+			return base.CorruptionErrorf("pebble/table: invalid table (corrupt block hash index)")
+		}
+		numBuckets := int32(binary.LittleEndian.Uint16(block[end-2:]))
+		end -= 2 + numBuckets
+		if numBuckets == 0 || end < 4*numRestarts {
+			return base.CorruptionErrorf("pebble/table: invalid table (corrupt block hash index)")
+		}
+		i.hashIndex = block[end : end+numBuckets]
+	}
 	i.cmp = cmp
-	i.restarts = int32(len(block)) - 4*(1+numRestarts)
+	i.restarts = end - 4*numRestarts
 	i.numRestarts = numRestarts
 	i.globalSeqNum = globalSeqNum
 	i.ptr = unsafe.Pointer(&block[0])
@@ -302,6 +369,11 @@ func (i *blockIter) init(cmp Compare, block block, globalSeqNum uint64) error {
 	i.fullKey = i.fullKey[:0]
 	i.val = nil
 	i.clearCache()
+	i.columnar = false
+	if v&blockColumnarFlag != 0 {
+		i.firstKey = InternalKey{}
+		return i.initColumnar(block, numRestarts, end)
+	}
 	if i.restarts > 0 {
 		if err := i.readFirstKey(); err != nil {
 			return err
@@ -326,6 +398,8 @@ func (i *blockIter) invalidate() {
 	i.restarts = 0
 	i.numRestarts = 0
 	i.data = nil
+	i.hashIndex = nil
+	i.columnar = false
 }
 
 // isDataInvalidated returns true when the blockIter has been invalidated
@@ -341,6 +415,7 @@ func (i *blockIter) resetForReuse() blockIter {
 		cached:    i.cached[:0],
 		cachedBuf: i.cachedBuf[:0],
 		data:      nil,
+		col:       columnarBlock{firstKeyBuf: i.col.firstKeyBuf[:0]},
 	}
 }
 
@@ -535,8 +610,15 @@ func (i *blockIter) cacheEntry() {
 // SeekGE implements internalIterator.SeekGE, as documented in the pebble
 // package.
 func (i *blockIter) SeekGE(key []byte, trySeekUsingNext bool) (*InternalKey, []byte) {
+	if i.columnar {
+		return i.colSeekGE(key)
+	}
 	i.clearCache()
 
+	if i.hashIndex != nil && i.seekGEUsingHashIndex(key) {
+		return &i.ikey, i.val
+	}
+
 	ikey := base.MakeSearchKey(key)
 
 	// Find the index of the smallest restart point whose key is > the key
@@ -648,6 +730,9 @@ func (i *blockIter) SeekPrefixGE(
 // SeekLT implements internalIterator.SeekLT, as documented in the pebble
 // package.
 func (i *blockIter) SeekLT(key []byte) (*InternalKey, []byte) {
+	if i.columnar {
+		return i.colSeekLT(key)
+	}
 	i.clearCache()
 
 	ikey := base.MakeSearchKey(key)
@@ -785,6 +870,9 @@ func (i *blockIter) SeekLT(key []byte) (*InternalKey, []byte) {
 // First implements internalIterator.First, as documented in the pebble
 // package.
 func (i *blockIter) First() (*InternalKey, []byte) {
+	if i.columnar {
+		return i.colFirst()
+	}
 	i.offset = 0
 	if !i.valid() {
 		return nil, nil
@@ -797,6 +885,9 @@ func (i *blockIter) First() (*InternalKey, []byte) {
 
 // Last implements internalIterator.Last, as documented in the pebble package.
 func (i *blockIter) Last() (*InternalKey, []byte) {
+	if i.columnar {
+		return i.colLast()
+	}
 	// Seek forward from the last restart point.
 	i.offset = int32(binary.LittleEndian.Uint32(i.data[i.restarts+4*(i.numRestarts-1):]))
 	if !i.valid() {
@@ -819,6 +910,9 @@ func (i *blockIter) Last() (*InternalKey, []byte) {
 // Next implements internalIterator.Next, as documented in the pebble
 // package.
 func (i *blockIter) Next() (*InternalKey, []byte) {
+	if i.columnar {
+		return i.colNext()
+	}
 	if len(i.cachedBuf) > 0 {
 		// We're switching from reverse iteration to forward iteration. We need to
 		// populate i.fullKey with the current key we're positioned at so that
@@ -856,6 +950,9 @@ func (i *blockIter) Next() (*InternalKey, []byte) {
 // Prev implements internalIterator.Prev, as documented in the pebble
 // package.
 func (i *blockIter) Prev() (*InternalKey, []byte) {
+	if i.columnar {
+		return i.colPrev()
+	}
 	if n := len(i.cached) - 1; n >= 0 {
 		i.nextOffset = i.offset
 		e := &i.cached[n]
@@ -959,6 +1056,33 @@ func (i *blockIter) valid() bool {
 	return i.offset >= 0 && i.offset < i.restarts
 }
 
+// readRestart positions the iterator at the entry at the given restart point,
+// ignoring the cache and any suffix filters.
+func (i *blockIter) readRestart(r int32) {
+	if i.columnar {
+		i.colReadRestart(r)
+		return
+	}
+	i.offset = int32(binary.LittleEndian.Uint32(i.data[i.restarts+4*r:]))
+	i.readEntry()
+	i.decodeInternalKey(i.key)
+}
+
+// readNext advances the iterator to the next entry, ignoring the cache and any
+// suffix filters. It returns false if the iterator is exhausted.
+func (i *blockIter) readNext() bool {
+	if i.columnar {
+		return i.colReadNext()
+	}
+	i.offset = i.nextOffset
+	if i.offset >= i.restarts {
+		return false
+	}
+	i.readEntry()
+	i.decodeInternalKey(i.key)
+	return true
+}
+
 // fragmentBlockIter wraps a blockIter, implementing the
 // keyspan.FragmentIterator interface. It's used for reading range deletion and
 // range key blocks.
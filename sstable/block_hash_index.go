@@ -0,0 +1,164 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// A data block may optionally be followed by a hash index that maps user keys
+// to the restart interval containing the first entry for the user key. A
+// SeekGE for a user key present in the block is then able to jump directly to
+// the right restart interval, rather than binary searching the restart points
+// which requires decoding and comparing O(log(restarts)) keys.
+//
+// The hash index is placed between the restart points and the trailing
+// restart count:
+//
+//   [entries] [restart points] [buckets] [num buckets (uint16)] [num restarts (uint32)]
+//
+// The presence of the hash index is signaled by setting the high bit of the
+// num restarts field, which is why a hash index may only be added to blocks
+// in formats that readers without hash index support are unable to read
+// (TableFormatPebblev3 and later). Each bucket is a single byte holding the
+// index of a restart point, or one of the blockHashIndexEmpty and
+// blockHashIndexCollision sentinels. Blocks with more restart points than can
+// be represented in a bucket are written without a hash index.
+//
+// The hash index is only consulted for positive lookups: if the bucket for a
+// key is empty, holds a collision or points to a restart interval that does
+// not contain the key, blockIter.SeekGE falls back to binary searching the
+// restart points.
+//
+// The hash index hashes the bytes of user keys, so it is only written by
+// Writers whose Comparer considers exactly the byte-equal user keys to be
+// equal (see equalIsBytewise).
+//
+// This is similar to RocksDB's data block hash index.
+
+const (
+	// blockHashIndexFlag is set in the num restarts field of a block that has
+	// a hash index.
+	blockHashIndexFlag = 1 << 31
+	// blockHashIndexEmpty is the value of a bucket to which no key hashes.
+	blockHashIndexEmpty = 255
+	// blockHashIndexCollision is the value of a bucket to which keys in
+	// different restart intervals hash.
+	blockHashIndexCollision = 254
+	// blockHashIndexMaxRestarts is the maximum number of restart points that a
+	// block may have in order to have a hash index.
+	blockHashIndexMaxRestarts = blockHashIndexCollision
+)
+
+type blockHashIndexEntry struct {
+	hash    uint64
+	restart int
+}
+
+func blockHashIndexHash(userKey []byte) uint64 {
+	return xxhash.Sum64(userKey)
+}
+
+// equalIsBytewise returns true if equal only considers user keys with
+// identical bytes to be equal, which is required for a hash index over the
+// bytes of the user keys to locate every user key equal to a sought key. A nil
+// Equal defaults to bytes.Equal.
+func equalIsBytewise(equal base.Equal) bool {
+	return equal == nil || reflect.ValueOf(equal).Pointer() == reflect.ValueOf(bytes.Equal).Pointer()
+}
+
+// blockHashIndexNumBuckets returns the number of buckets to use for a hash
+// index containing n keys. The number of buckets targets a 75% utilization.
+func blockHashIndexNumBuckets(n int) int {
+	b := n*4/3 + 1
+	if b > math.MaxUint16 {
+		b = math.MaxUint16
+	}
+	return b
+}
+
+// addHashIndexEntry records the user key of the entry that was just added to
+// the block. Only the first entry for each user key is recorded.
+func (w *blockWriter) addHashIndexEntry(userKey []byte) {
+	if w.nEntries > 1 && bytes.Equal(w.prevKey[:len(w.prevKey)-8], userKey) {
+		return
+	}
+	w.hashIndexEntries = append(w.hashIndexEntries, blockHashIndexEntry{
+		hash:    blockHashIndexHash(userKey),
+		restart: len(w.restarts) - 1,
+	})
+}
+
+// finishHashIndex appends the hash index to the block, which must already
+// contain the restart points. It returns false if the block is unable to have
+// a hash index.
+func (w *blockWriter) finishHashIndex() bool {
+	if len(w.hashIndexEntries) == 0 || len(w.restarts) > blockHashIndexMaxRestarts {
+		return false
+	}
+	n := blockHashIndexNumBuckets(len(w.hashIndexEntries))
+	start := len(w.buf)
+	for j := 0; j < n; j++ {
+		w.buf = append(w.buf, blockHashIndexEmpty)
+	}
+	buckets := w.buf[start:]
+	for _, e := range w.hashIndexEntries {
+		b := &buckets[e.hash%uint64(n)]
+		switch {
+		case *b == blockHashIndexEmpty:
+			*b = byte(e.restart)
+		case int(*b) != e.restart:
+			*b = blockHashIndexCollision
+		}
+	}
+	var tmp [2]byte
+	binary.LittleEndian.PutUint16(tmp[:], uint16(n))
+	w.buf = append(w.buf, tmp[:]...)
+	return true
+}
+
+// seekGEUsingHashIndex positions the iterator at the first entry with a user
+// key greater than or equal to key, using the block's hash index. It returns
+// false if the hash index is unable to locate such an entry, in which case the
+// iterator's position is undefined.
+//
+// The bucket for a key absent from the block may hold the restart interval of
+// another key, so the restart interval is only scanned if key sorts between
+// the first keys of the interval and of the following interval. A lookup that
+// misses therefore costs at most two key comparisons in addition to the
+// binary search of the restart points.
+func (i *blockIter) seekGEUsingHashIndex(key []byte) bool {
+	b := int32(i.hashIndex[blockHashIndexHash(key)%uint64(len(i.hashIndex))])
+	if b >= i.numRestarts {
+		// The bucket is either empty or holds a collision.
+		return false
+	}
+	if b+1 < i.numRestarts {
+		i.readRestart(b + 1)
+		if i.cmp(i.ikey.UserKey, key) < 0 {
+			return false
+		}
+	}
+	i.readRestart(b)
+	c := i.cmp(i.ikey.UserKey, key)
+	if c > 0 {
+		return false
+	}
+	// The scan ends at the latest at the first entry of the following restart
+	// interval, which has a user key greater than or equal to key.
+	for c < 0 {
+		if !i.readNext() {
+			return false
+		}
+		c = i.cmp(i.ikey.UserKey, key)
+	}
+	return true
+}
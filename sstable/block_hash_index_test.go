@@ -0,0 +1,235 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestBlockHashIndex(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	rng := rand.New(rand.NewSource(seed))
+	t.Logf("seed: %d", seed)
+
+	ks := testkeys.Alpha(3)
+	for _, restartInterval := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("restart-interval=%d", restartInterval), func(t *testing.T) {
+			// Build identical blocks with and without a hash index. Some user
+			// keys have several entries, which may span restart intervals.
+			plain := blockWriter{restartInterval: restartInterval}
+			hashed := blockWriter{restartInterval: restartInterval, hashIndex: true}
+			var userKeys [][]byte
+			for i := 0; i < ks.Count() && len(userKeys) < 200; i += 1 + rng.Intn(10) {
+				k := testkeys.Key(ks, i)
+				userKeys = append(userKeys, k)
+				for seqNum := uint64(rng.Intn(3) + 1); seqNum > 0; seqNum-- {
+					ikey := base.MakeInternalKey(k, seqNum, InternalKeyKindSet)
+					plain.add(ikey, k)
+					hashed.add(ikey, k)
+				}
+			}
+			plainIter, err := newBlockIter(bytes.Compare, plain.finish())
+			require.NoError(t, err)
+			require.Nil(t, plainIter.hashIndex)
+			hashedIter, err := newBlockIter(bytes.Compare, hashed.finish())
+			require.NoError(t, err)
+			if plainIter.numRestarts <= blockHashIndexMaxRestarts {
+				require.NotNil(t, hashedIter.hashIndex)
+			} else {
+				require.Nil(t, hashedIter.hashIndex)
+			}
+
+			check := func(key []byte) {
+				pk, pv := plainIter.SeekGE(key, false /* trySeekUsingNext */)
+				hk, hv := hashedIter.SeekGE(key, false /* trySeekUsingNext */)
+				require.Equal(t, pk == nil, hk == nil, "%q", key)
+				if pk != nil {
+					require.Equal(t, pk.String(), hk.String(), "%q", key)
+					require.Equal(t, pv, hv)
+				}
+				// The iterator must be usable after the seek.
+				pk, _ = plainIter.Next()
+				hk, _ = hashedIter.Next()
+				require.Equal(t, pk == nil, hk == nil, "%q", key)
+				if pk != nil {
+					require.Equal(t, pk.String(), hk.String(), "%q", key)
+				}
+			}
+			// Seek to keys present in the block, and to keys absent from the
+			// block.
+			for _, k := range userKeys {
+				check(k)
+			}
+			for i := 0; i < 500; i++ {
+				check(testkeys.Key(ks, rng.Intn(ks.Count())))
+			}
+		})
+	}
+}
+
+func TestBlockHashIndexTable(t *testing.T) {
+	ks := testkeys.Alpha(2)
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev3} {
+		for _, parallelism := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s,parallelism=%t", format, parallelism), func(t *testing.T) {
+				testBlockHashIndexTable(t, ks, format, parallelism)
+			})
+		}
+	}
+}
+
+func testBlockHashIndexTable(t *testing.T, ks testkeys.Keyspace, format TableFormat, parallelism bool) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{
+		DataBlockHashIndex: true,
+		TableFormat:        format,
+		Parallelism:        parallelism,
+	})
+	for i := 0; i < ks.Count(); i += 2 {
+		k := testkeys.Key(ks, i)
+		require.NoError(t, w.Set(k, k))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewMemReader(f.Data(), ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	require.NoError(t, err)
+	defer iter.Close()
+	for i := 0; i < ks.Count(); i++ {
+		k := testkeys.Key(ks, i)
+		key, value := iter.SeekGE(k, false /* trySeekUsingNext */)
+		if i%2 == 0 {
+			require.NotNil(t, key)
+			require.Equal(t, k, key.UserKey)
+			require.Equal(t, k, value)
+		} else if key != nil {
+			require.Equal(t, testkeys.Key(ks, i+1), key.UserKey)
+		}
+	}
+	// The hash index is only written for TableFormatPebblev3 and later, and
+	// must be present in every data block.
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Greater(t, len(l.Data), 1)
+	for _, bh := range l.Data {
+		h, _, err := r.readBlock(bh.BlockHandle, nil /* transform */, nil /* readaheadState */)
+		require.NoError(t, err)
+		bIter, err := newBlockIter(r.Compare, h.Get())
+		require.NoError(t, err)
+		require.Equal(t, format >= TableFormatPebblev3, bIter.hashIndex != nil, "block at %d", bh.Offset)
+		h.Release()
+	}
+}
+
+func TestBlockHashIndexNonBytewiseComparer(t *testing.T) {
+	// A case-insensitive comparer considers user keys with different bytes to
+	// be equal, so tables written with it must not have a hash index.
+	lower := func(b []byte) []byte { return bytes.ToLower(b) }
+	comparer := *base.DefaultComparer
+	comparer.Compare = func(a, b []byte) int { return bytes.Compare(lower(a), lower(b)) }
+	comparer.Equal = bytes.EqualFold
+	comparer.Name = "case-insensitive"
+	require.False(t, equalIsBytewise(comparer.Equal))
+	require.True(t, equalIsBytewise(bytes.Equal))
+	require.True(t, equalIsBytewise(nil))
+
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{
+		BlockSize:          64,
+		Comparer:           &comparer,
+		DataBlockHashIndex: true,
+		TableFormat:        TableFormatPebblev3,
+	})
+	ks := testkeys.Alpha(2)
+	for i := 0; i < ks.Count(); i++ {
+		k := testkeys.Key(ks, i)
+		require.NoError(t, w.Set(k, k))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewMemReader(f.Data(), ReaderOptions{Comparer: &comparer})
+	require.NoError(t, err)
+	defer r.Close()
+
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Greater(t, len(l.Data), 1)
+	for _, bh := range l.Data {
+		h, _, err := r.readBlock(bh.BlockHandle, nil /* transform */, nil /* readaheadState */)
+		require.NoError(t, err)
+		bIter, err := newBlockIter(r.Compare, h.Get())
+		require.NoError(t, err)
+		require.Nil(t, bIter.hashIndex, "block at %d", bh.Offset)
+		h.Release()
+	}
+
+	// Seeks for keys that differ from the keys in the table only in case find
+	// the equal keys.
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	require.NoError(t, err)
+	defer iter.Close()
+	for i := 0; i < ks.Count(); i++ {
+		k := testkeys.Key(ks, i)
+		key, value := iter.SeekGE(bytes.ToUpper(k), false /* trySeekUsingNext */)
+		require.NotNil(t, key)
+		require.Equal(t, k, key.UserKey)
+		require.Equal(t, k, value)
+	}
+}
+
+func BenchmarkBlockHashIndexSeekGE(b *testing.B) {
+	const blockSize = 32 << 10
+
+	for _, hashIndex := range []bool{false, true} {
+		w := &blockWriter{
+			restartInterval: 16,
+			hashIndex:       hashIndex,
+		}
+		var ikey InternalKey
+		var hits, misses [][]byte
+		for i := 0; w.estimatedSize() < blockSize; i++ {
+			key := []byte(fmt.Sprintf("%05d", i))
+			hits = append(hits, key)
+			// The misses sort between the keys in the block, so that the
+			// fallback binary search is not short-circuited.
+			misses = append(misses, []byte(fmt.Sprintf("%05d.5", i)))
+			ikey.UserKey = key
+			w.add(ikey, nil)
+		}
+		block := w.finish()
+
+		for _, lookup := range []struct {
+			name string
+			keys [][]byte
+		}{{"hit", hits}, {"miss", misses}} {
+			b.Run(fmt.Sprintf("hash-index=%t/%s", hashIndex, lookup.name), func(b *testing.B) {
+				it, err := newBlockIter(bytes.Compare, block)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if hashIndex != (it.hashIndex != nil) {
+					b.Fatal("unexpected hash index")
+				}
+				rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					it.SeekGE(lookup.keys[rng.Intn(len(lookup.keys))], false /* trySeekUsingNext */)
+				}
+			})
+		}
+	}
+}
@@ -112,6 +112,24 @@ type SuffixReplaceableBlockCollector interface {
 // be thread-safe.
 type BlockPropertyFilter = base.BlockPropertyFilter
 
+// BlockPropertySuffixFilter is a BlockPropertyFilter that is additionally
+// able to evaluate the key suffix (as determined by Comparer.Split) of an
+// individual key. Within the columnar data blocks of TableFormatPebblev3 and
+// later, keys with a non-empty suffix for which SuffixIntersects returns false
+// are skipped without being decoded. Like block-level filtering, this is a
+// hint: keys that do not match may still be returned, for example from tables
+// with row-oriented data blocks.
+//
+// SuffixIntersects must be consistent with Intersects, in that the property
+// collected over a set of keys must intersect the filter if any of the keys'
+// suffixes does.
+type BlockPropertySuffixFilter interface {
+	BlockPropertyFilter
+	// SuffixIntersects returns true if the key suffix intersects with the set
+	// in the filter.
+	SuffixIntersects(suffix []byte) bool
+}
+
 // BlockIntervalCollector is a helper implementation of BlockPropertyCollector
 // for users who want to represent a set of the form [lower,upper) where both
 // lower and upper are uint64, and lower <= upper.
@@ -454,6 +472,8 @@ type BlockPropertiesFilterer struct {
 	// has two filters, corresponding to shortIDs 2, 0, this would be:
 	// len(shortIDToFiltersIndex)==3, 0=>1, 1=>-1, 2=>0.
 	shortIDToFiltersIndex []int
+	// suffixFilters holds the filters that implement BlockPropertySuffixFilter.
+	suffixFilters []BlockPropertySuffixFilter
 }
 
 var blockPropertiesFiltererPool = sync.Pool{
@@ -466,13 +486,25 @@ var blockPropertiesFiltererPool = sync.Pool{
 // initialization, call IntersectsUserPropsAndFinishInit.
 func NewBlockPropertiesFilterer(filters []BlockPropertyFilter) *BlockPropertiesFilterer {
 	filterer := blockPropertiesFiltererPool.Get().(*BlockPropertiesFilterer)
-	*filterer = BlockPropertiesFilterer{filters: filters}
+	*filterer = BlockPropertiesFilterer{
+		filters:       filters,
+		suffixFilters: filterer.suffixFilters[:0],
+	}
+	for _, f := range filters {
+		if sf, ok := f.(BlockPropertySuffixFilter); ok {
+			filterer.suffixFilters = append(filterer.suffixFilters, sf)
+		}
+	}
 	return filterer
 }
 
 func releaseBlockPropertiesFilterer(filterer *BlockPropertiesFilterer) {
+	for i := range filterer.suffixFilters {
+		filterer.suffixFilters[i] = nil
+	}
 	*filterer = BlockPropertiesFilterer{
 		shortIDToFiltersIndex: filterer.shortIDToFiltersIndex[:0],
+		suffixFilters:         filterer.suffixFilters[:0],
 	}
 	blockPropertiesFiltererPool.Put(filterer)
 }
@@ -0,0 +1,601 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// Beginning with TableFormatPebblev3, data blocks are columnar. Rather than
+// interleaving keys and values as in the row-oriented block format, a
+// columnar block splits every user key into its prefix (as determined by
+// Comparer.Split) and suffix, and stores the prefixes, suffixes, trailers and
+// values of the rows in the block in separate columns:
+//
+//   [value data] [suffix data] [prefix column] [suffix ends] [value ends]
+//   [trailers] [header] [restart points] [hash index] [num restarts (uint32)]
+//
+// The prefix column holds, for each row, the prefix of the row's user key
+// encoded relative to the prefix of the previous row:
+//
+//   shared (uvarint), unshared (uvarint), unshared prefix bytes
+//
+// Every restartInterval rows the prefix is encoded in full (shared is 0), and
+// the restart points hold the offsets of these rows within the prefix column.
+// For heavily versioned data consecutive rows share the same prefix, and the
+// prefix of such a row is encoded in two bytes independent of its length.
+//
+// The suffix and value data columns hold the concatenated suffixes and values
+// of the rows. The suffix ends and value ends columns hold a fixed-width
+// little-endian uint32 per row, which is the end offset of the row's suffix
+// (value) within the suffix (value) data column; a row's data begins where the
+// previous row's ends. The trailers column holds the fixed-width 8-byte
+// little-endian trailer (sequence number and kind) of each row. The suffix,
+// value and trailer of any row are therefore located without decoding the
+// other rows.
+//
+// The header is a fixed-width sequence of little-endian uint32s:
+//
+//   number of rows, restart interval, suffix data offset, prefix column
+//   offset, ends offset
+//
+// The value data always begins at offset 0. The trailing restart count is
+// shared with the row-oriented format: blockColumnarFlag is set in it to
+// signal the columnar encoding, and blockHashIndexFlag signals a hash index
+// (see block_hash_index.go), which maps user keys to restart points exactly as
+// it does for row-oriented blocks.
+//
+// A blockIter positioned within a columnar block only decodes the prefix
+// column when the prefix changes, which removes the redundant prefix decoding
+// that dominates iteration over heavily versioned data. It also evaluates any
+// BlockPropertySuffixFilters on the suffix of each row, skipping rows that do
+// not match without materializing their keys or values.
+
+const (
+	// blockColumnarFlag is set in the num restarts field of a columnar data
+	// block.
+	blockColumnarFlag = 1 << 30
+	// columnarBlockHeaderLen is the length of the header of a columnar data
+	// block.
+	columnarBlockHeaderLen = 5 * 4
+)
+
+// columnarBlockColumns accumulates the columns of a columnar data block that
+// are not written directly to blockWriter.buf, which holds the value data.
+type columnarBlockColumns struct {
+	prefixes   []byte
+	suffixes   []byte
+	suffixEnds []byte
+	valueEnds  []byte
+	trailers   []byte
+	// prefixLen is the length of the prefix of blockWriter.curKey, and
+	// prevPrefixLen is the length of the prefix of blockWriter.prevKey.
+	prefixLen     int
+	prevPrefixLen int
+}
+
+func (c *columnarBlockColumns) reset() {
+	*c = columnarBlockColumns{
+		prefixes:   c.prefixes[:0],
+		suffixes:   c.suffixes[:0],
+		suffixEnds: c.suffixEnds[:0],
+		valueEnds:  c.valueEnds[:0],
+		trailers:   c.trailers[:0],
+	}
+}
+
+func (c *columnarBlockColumns) size() int {
+	return len(c.prefixes) + len(c.suffixes) + len(c.suffixEnds) + len(c.valueEnds) +
+		len(c.trailers) + columnarBlockHeaderLen
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// addColumnar adds a row to a columnar data block.
+func (w *blockWriter) addColumnar(key InternalKey, value []byte) {
+	w.curKey, w.prevKey = w.prevKey, w.curKey
+
+	size := key.Size()
+	if cap(w.curKey) < size {
+		w.curKey = make([]byte, 0, size*2)
+	}
+	w.curKey = w.curKey[:size]
+	key.Encode(w.curKey)
+
+	c := &w.cols
+	c.prevPrefixLen = c.prefixLen
+	c.prefixLen = len(key.UserKey)
+	if w.split != nil {
+		c.prefixLen = w.split(key.UserKey)
+	}
+	prefix := key.UserKey[:c.prefixLen]
+
+	shared := 0
+	if w.nEntries == w.nextRestart {
+		w.nextRestart = w.nEntries + w.restartInterval
+		w.restarts = append(w.restarts, uint32(len(c.prefixes)))
+	} else {
+		shared = base.SharedPrefixLen(prefix, w.prevKey[:c.prevPrefixLen])
+	}
+	var tmp [2 * binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(tmp[:], uint64(shared))
+	n += binary.PutUvarint(tmp[n:], uint64(len(prefix)-shared))
+	c.prefixes = append(c.prefixes, tmp[:n]...)
+	c.prefixes = append(c.prefixes, prefix[shared:]...)
+
+	c.suffixes = append(c.suffixes, key.UserKey[c.prefixLen:]...)
+	c.suffixEnds = appendUint32(c.suffixEnds, uint32(len(c.suffixes)))
+	w.buf = append(w.buf, value...)
+	w.curValue = w.buf[len(w.buf)-len(value):]
+	c.valueEnds = appendUint32(c.valueEnds, uint32(len(w.buf)))
+	binary.LittleEndian.PutUint64(tmp[:8], key.Trailer)
+	c.trailers = append(c.trailers, tmp[:8]...)
+
+	w.nEntries++
+	if w.hashIndex {
+		w.addHashIndexEntry(key.UserKey)
+	}
+}
+
+// finishColumns appends the suffix data, the prefix column, the fixed-width
+// columns and the header to the value data in w.buf. The restart points
+// follow.
+func (w *blockWriter) finishColumns() {
+	c := &w.cols
+	suffixesOffset := len(w.buf)
+	w.buf = append(w.buf, c.suffixes...)
+	prefixesOffset := len(w.buf)
+	w.buf = append(w.buf, c.prefixes...)
+	endsOffset := len(w.buf)
+	w.buf = append(w.buf, c.suffixEnds...)
+	w.buf = append(w.buf, c.valueEnds...)
+	w.buf = append(w.buf, c.trailers...)
+	w.buf = appendUint32(w.buf, uint32(w.nEntries))
+	w.buf = appendUint32(w.buf, uint32(w.restartInterval))
+	w.buf = appendUint32(w.buf, uint32(suffixesOffset))
+	w.buf = appendUint32(w.buf, uint32(prefixesOffset))
+	w.buf = appendUint32(w.buf, uint32(endsOffset))
+	c.reset()
+}
+
+// columnarBlock holds the columns of a columnar data block, along with the
+// position of a blockIter within the prefix column.
+type columnarBlock struct {
+	nRows           int32
+	restartInterval int32
+	// restarts is the offset of the restart points within the block.
+	restarts   int32
+	values     []byte
+	suffixes   []byte
+	prefixes   []byte
+	suffixEnds []byte
+	valueEnds  []byte
+	trailers   []byte
+	// prefixOffset is the offset within the prefix column of the current row,
+	// and nextPrefixOffset is the offset of the next row. The prefix of the
+	// current row is stored in blockIter.fullKey[:prefixLen].
+	prefixOffset     int32
+	nextPrefixOffset int32
+	prefixLen        int32
+	// prefixChanged is false if the prefix of the current row is known to
+	// equal that of the previous row.
+	prefixChanged bool
+	// firstKeyBuf backs blockIter.firstKey.
+	firstKeyBuf []byte
+}
+
+func (c *columnarBlock) suffix(row int32) []byte {
+	var start uint32
+	if row > 0 {
+		start = binary.LittleEndian.Uint32(c.suffixEnds[4*(row-1):])
+	}
+	return c.suffixes[start:binary.LittleEndian.Uint32(c.suffixEnds[4*row:])]
+}
+
+func (c *columnarBlock) value(row int32) []byte {
+	var start uint32
+	if row > 0 {
+		start = binary.LittleEndian.Uint32(c.valueEnds[4*(row-1):])
+	}
+	return c.values[start:binary.LittleEndian.Uint32(c.valueEnds[4*row:])]
+}
+
+// initColumnar initializes the iterator for a columnar block whose restart
+// points end at end.
+func (i *blockIter) initColumnar(block block, numRestarts, end int32) error {
+	restarts := end - 4*numRestarts
+	header := restarts - columnarBlockHeaderLen
+	if header < 0 {
+		return base.CorruptionErrorf("pebble/table: invalid table (corrupt columnar block)")
+	}
+	nRows := binary.LittleEndian.Uint32(block[header:])
+	restartInterval := binary.LittleEndian.Uint32(block[header+4:])
+	suffixesOffset := binary.LittleEndian.Uint32(block[header+8:])
+	prefixesOffset := binary.LittleEndian.Uint32(block[header+12:])
+	endsOffset := binary.LittleEndian.Uint32(block[header+16:])
+	if restartInterval == 0 || suffixesOffset > prefixesOffset || prefixesOffset > endsOffset ||
+		uint64(endsOffset)+16*uint64(nRows) != uint64(header) ||
+		uint64(numRestarts) != (uint64(nRows)+uint64(restartInterval)-1)/uint64(restartInterval) &&
+			(nRows != 0 || numRestarts != 1) {
+		return base.CorruptionErrorf("pebble/table: invalid table (corrupt columnar block)")
+	}
+	ends := block[endsOffset:header]
+	c := &i.col
+	*c = columnarBlock{
+		nRows:           int32(nRows),
+		restartInterval: int32(restartInterval),
+		restarts:        restarts,
+		values:          block[:suffixesOffset],
+		suffixes:        block[suffixesOffset:prefixesOffset],
+		prefixes:        block[prefixesOffset:endsOffset],
+		suffixEnds:      ends[:4*nRows],
+		valueEnds:       ends[4*nRows : 8*nRows],
+		trailers:        ends[8*nRows:],
+		firstKeyBuf:     c.firstKeyBuf[:0],
+	}
+	i.columnar = true
+	i.restarts = int32(nRows)
+	if nRows == 0 {
+		return nil
+	}
+	if binary.LittleEndian.Uint32(c.suffixEnds[4*(nRows-1):]) != uint32(len(c.suffixes)) ||
+		binary.LittleEndian.Uint32(c.valueEnds[4*(nRows-1):]) != uint32(len(c.values)) {
+		return base.CorruptionErrorf("pebble/table: invalid table (corrupt columnar block)")
+	}
+	shared, n := binary.Uvarint(c.prefixes)
+	if n <= 0 || shared != 0 {
+		return base.CorruptionErrorf("pebble/table: invalid firstKey in block")
+	}
+	unshared, m := binary.Uvarint(c.prefixes[n:])
+	if m <= 0 || uint64(n+m)+unshared > uint64(len(c.prefixes)) {
+		return base.CorruptionErrorf("pebble/table: invalid firstKey in block")
+	}
+	c.firstKeyBuf = append(c.firstKeyBuf, c.prefixes[n+m:n+m+int(unshared)]...)
+	c.firstKeyBuf = append(c.firstKeyBuf, c.suffix(0)...)
+	i.firstKey.UserKey = c.firstKeyBuf
+	i.firstKey.Trailer = binary.LittleEndian.Uint64(c.trailers)
+	if i.globalSeqNum != 0 {
+		i.firstKey.SetSeqNum(i.globalSeqNum)
+	}
+	return nil
+}
+
+// colDecodePrefix decodes the prefix of the row at the given offset within
+// the prefix column into i.fullKey, which must hold the prefix of the
+// preceding row unless the row is a restart point.
+func (i *blockIter) colDecodePrefix(offset int32) {
+	c := &i.col
+	p := c.prefixes[offset:]
+	shared, n := binary.Uvarint(p)
+	unshared, m := binary.Uvarint(p[n:])
+	c.prefixChanged = unshared != 0 || int32(shared) != c.prefixLen
+	i.fullKey = append(i.fullKey[:shared], p[n+m:n+m+int(unshared)]...)
+	c.prefixLen = int32(len(i.fullKey))
+	c.prefixOffset = offset
+	c.nextPrefixOffset = offset + int32(n+m) + int32(unshared)
+}
+
+// colMaterialize sets i.ikey and i.val from the current row, whose prefix
+// must have been decoded.
+func (i *blockIter) colMaterialize() {
+	c := &i.col
+	row := i.offset
+	i.fullKey = append(i.fullKey[:c.prefixLen], c.suffix(row)...)
+	i.ikey.UserKey = i.fullKey[:len(i.fullKey):len(i.fullKey)]
+	i.ikey.Trailer = binary.LittleEndian.Uint64(c.trailers[8*row:])
+	if i.globalSeqNum != 0 {
+		i.ikey.SetSeqNum(i.globalSeqNum)
+	}
+	i.val = c.value(row)
+}
+
+// colPasses returns true if the suffix of the given row intersects all of the
+// iterator's suffix filters. Rows without a suffix are never filtered.
+func (i *blockIter) colPasses(row int32) bool {
+	if len(i.suffixFilters) == 0 {
+		return true
+	}
+	suffix := i.col.suffix(row)
+	if len(suffix) == 0 {
+		return true
+	}
+	for _, f := range i.suffixFilters {
+		if !f.SuffixIntersects(suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// colReadRestart positions the iterator at the row at the given restart
+// point, ignoring the suffix filters.
+func (i *blockIter) colReadRestart(r int32) {
+	i.offset = r * i.col.restartInterval
+	i.colDecodePrefix(int32(binary.LittleEndian.Uint32(i.data[i.col.restarts+4*r:])))
+	i.nextOffset = i.offset + 1
+	i.colMaterialize()
+}
+
+// colReadNextPrefix advances the iterator to the next row, decoding only its
+// prefix. It returns false if the iterator is exhausted.
+func (i *blockIter) colReadNextPrefix() bool {
+	i.offset = i.nextOffset
+	if i.offset >= i.col.nRows {
+		i.offset = i.col.nRows
+		i.nextOffset = i.col.nRows
+		return false
+	}
+	i.colDecodePrefix(i.col.nextPrefixOffset)
+	i.nextOffset = i.offset + 1
+	return true
+}
+
+// colReadNext advances the iterator to the next row, ignoring the suffix
+// filters. It returns false if the iterator is exhausted.
+func (i *blockIter) colReadNext() bool {
+	if !i.colReadNextPrefix() {
+		return false
+	}
+	i.colMaterialize()
+	return true
+}
+
+// colSeekRow positions the iterator at the given row by decoding the prefixes
+// forward from the row's restart point, caching the prefixes of the rows
+// preceding it for reverse iteration.
+func (i *blockIter) colSeekRow(row int32) {
+	i.clearCache()
+	r := row / i.col.restartInterval
+	i.offset = r * i.col.restartInterval
+	i.colDecodePrefix(int32(binary.LittleEndian.Uint32(i.data[i.col.restarts+4*r:])))
+	for i.offset < row {
+		i.colCacheRow()
+		i.offset++
+		i.colDecodePrefix(i.col.nextPrefixOffset)
+	}
+	i.nextOffset = i.offset + 1
+}
+
+// colCacheRow caches the prefix of the current row. Consecutive rows with the
+// same prefix share the cached prefix bytes.
+func (i *blockIter) colCacheRow() {
+	c := &i.col
+	if n := len(i.cached); n > 0 && !c.prefixChanged {
+		e := i.cached[n-1]
+		e.offset = c.prefixOffset
+		i.cached = append(i.cached, e)
+		return
+	}
+	i.cached = append(i.cached, blockEntry{
+		offset:   c.prefixOffset,
+		keyStart: int32(len(i.cachedBuf)),
+		keyEnd:   int32(len(i.cachedBuf)) + c.prefixLen,
+	})
+	i.cachedBuf = append(i.cachedBuf, i.fullKey[:c.prefixLen]...)
+}
+
+// colSettleForward returns the current row if it passes the suffix filters,
+// and otherwise the next row that does.
+func (i *blockIter) colSettleForward() (*InternalKey, []byte) {
+	if i.colPasses(i.offset) {
+		return &i.ikey, i.val
+	}
+	return i.colNext()
+}
+
+func (i *blockIter) colSeekGE(key []byte) (*InternalKey, []byte) {
+	i.clearCache()
+	if i.col.nRows == 0 {
+		i.offset, i.nextOffset = 0, 0
+		return nil, nil
+	}
+	if i.hashIndex != nil && i.seekGEUsingHashIndex(key) {
+		return i.colSettleForward()
+	}
+
+	// Find the index of the smallest restart point whose key is > the key
+	// sought, and scan forward from the preceding restart point.
+	ikey := base.MakeSearchKey(key)
+	index, upper := int32(0), i.numRestarts
+	for index < upper {
+		h := int32(uint(index+upper) >> 1) // avoid overflow when computing h
+		i.colReadRestart(h)
+		if base.InternalCompare(i.cmp, ikey, i.ikey) >= 0 {
+			index = h + 1
+		} else {
+			upper = h
+		}
+	}
+	if index > 0 {
+		index--
+	}
+	i.colReadRestart(index)
+	for base.InternalCompare(i.cmp, i.ikey, ikey) < 0 {
+		if !i.colReadNext() {
+			return nil, nil
+		}
+	}
+	return i.colSettleForward()
+}
+
+func (i *blockIter) colSeekLT(key []byte) (*InternalKey, []byte) {
+	i.clearCache()
+
+	// Find the index of the smallest restart point whose key is >= the key
+	// sought.
+	ikey := base.MakeSearchKey(key)
+	index, upper := int32(0), i.numRestarts
+	if i.col.nRows == 0 {
+		upper = 0
+	}
+	for index < upper {
+		h := int32(uint(index+upper) >> 1) // avoid overflow when computing h
+		i.colReadRestart(h)
+		if base.InternalCompare(i.cmp, ikey, i.ikey) > 0 {
+			index = h + 1
+		} else {
+			upper = h
+		}
+	}
+	if index == 0 {
+		// All keys in this block are larger than the key sought.
+		i.offset, i.nextOffset = -1, 0
+		i.col.nextPrefixOffset = 0
+		return nil, nil
+	}
+
+	// Iterate from the preceding restart point to the first row >= the key
+	// sought, caching the prefixes along the way, and then back up.
+	end := index * i.col.restartInterval
+	if end > i.col.nRows {
+		end = i.col.nRows
+	}
+	i.colReadRestart(index - 1)
+	for {
+		if i.cmp(i.ikey.UserKey, ikey.UserKey) >= 0 {
+			// The first row is always < the key sought, so at least one row has
+			// been cached.
+			return i.colPrev()
+		}
+		if i.nextOffset >= end {
+			break
+		}
+		i.colCacheRow()
+		i.colReadNext()
+	}
+	if i.colPasses(i.offset) {
+		return &i.ikey, i.val
+	}
+	return i.colPrev()
+}
+
+func (i *blockIter) colFirst() (*InternalKey, []byte) {
+	i.clearCache()
+	i.nextOffset = 0
+	i.col.nextPrefixOffset = 0
+	return i.colNext()
+}
+
+func (i *blockIter) colLast() (*InternalKey, []byte) {
+	if i.col.nRows == 0 {
+		i.clearCache()
+		i.offset, i.nextOffset = -1, 0
+		i.col.nextPrefixOffset = 0
+		return nil, nil
+	}
+	i.colSeekRow(i.col.nRows - 1)
+	if i.colPasses(i.offset) {
+		i.colMaterialize()
+		return &i.ikey, i.val
+	}
+	return i.colPrev()
+}
+
+func (i *blockIter) colNext() (*InternalKey, []byte) {
+	i.clearCache()
+	for i.colReadNextPrefix() {
+		if i.colPasses(i.offset) {
+			i.colMaterialize()
+			return &i.ikey, i.val
+		}
+	}
+	return nil, nil
+}
+
+func (i *blockIter) colPrev() (*InternalKey, []byte) {
+	c := &i.col
+	for {
+		if n := len(i.cached) - 1; n >= 0 {
+			e := i.cached[n]
+			i.cached = i.cached[:n]
+			i.fullKey = append(i.fullKey[:0], i.cachedBuf[e.keyStart:e.keyEnd]...)
+			c.prefixLen = e.keyEnd - e.keyStart
+			c.prefixChanged = true
+			c.nextPrefixOffset = c.prefixOffset
+			c.prefixOffset = e.offset
+			i.nextOffset = i.offset
+			i.offset--
+		} else if i.offset <= 0 {
+			i.offset, i.nextOffset = -1, 0
+			c.nextPrefixOffset = 0
+			return nil, nil
+		} else {
+			i.colSeekRow(i.offset - 1)
+		}
+		if i.colPasses(i.offset) {
+			i.colMaterialize()
+			return &i.ikey, i.val
+		}
+	}
+}
+
+// describeColumnarBlock writes a description of the rows and columns of a
+// columnar block at the given offset within the sstable to w. See
+// Layout.Describe.
+func describeColumnarBlock(
+	w io.Writer,
+	blockOffset uint64,
+	iter *blockIter,
+	cmp Compare,
+	fmtRecord func(key *base.InternalKey, value []byte),
+) {
+	c := &iter.col
+	suffixesOffset := uint64(len(c.values))
+	prefixesOffset := suffixesOffset + uint64(len(c.suffixes))
+	endsOffset := prefixesOffset + uint64(len(c.prefixes))
+
+	var lastKey InternalKey
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		// The format of the numbers in the row line is:
+		//
+		//   (prefix [<shared>] + <unshared>, suffix <suffix>, value <value>)
+		//
+		// <shared>   is the number of prefix bytes shared with the previous row.
+		// <unshared> is the number of unshared prefix bytes.
+		// <suffix>   is the number of suffix bytes.
+		// <value>    is the number of value bytes.
+		p := c.prefixes[c.prefixOffset:]
+		shared, n := binary.Uvarint(p)
+		unshared, _ := binary.Uvarint(p[n:])
+		fmt.Fprintf(w, "%10d    row %d (prefix [%d] + %d, suffix %d, value %d)",
+			blockOffset+prefixesOffset+uint64(c.prefixOffset), iter.offset,
+			shared, unshared, len(c.suffix(iter.offset)), len(value))
+		if iter.offset%c.restartInterval == 0 {
+			fmt.Fprintf(w, " [restart]\n")
+		} else {
+			fmt.Fprintf(w, "\n")
+		}
+		if fmtRecord != nil {
+			fmt.Fprintf(w, "              ")
+			fmtRecord(key, value)
+		}
+		if base.InternalCompare(cmp, lastKey, *key) >= 0 {
+			fmt.Fprintf(w, "              WARNING: OUT OF ORDER KEYS!\n")
+		}
+		lastKey.Trailer = key.Trailer
+		lastKey.UserKey = append(lastKey.UserKey[:0], key.UserKey...)
+	}
+
+	nRows := uint64(c.nRows)
+	fmt.Fprintf(w, "%10d    [value data (%d)]\n", blockOffset, suffixesOffset)
+	fmt.Fprintf(w, "%10d    [suffix data (%d)]\n", blockOffset+suffixesOffset, len(c.suffixes))
+	fmt.Fprintf(w, "%10d    [prefix column (%d)]\n", blockOffset+prefixesOffset, len(c.prefixes))
+	fmt.Fprintf(w, "%10d    [suffix ends (%d)]\n", blockOffset+endsOffset, 4*nRows)
+	fmt.Fprintf(w, "%10d    [value ends (%d)]\n", blockOffset+endsOffset+4*nRows, 4*nRows)
+	fmt.Fprintf(w, "%10d    [trailers (%d)]\n", blockOffset+endsOffset+8*nRows, 8*nRows)
+	fmt.Fprintf(w, "%10d    [header rows=%d restart-interval=%d]\n",
+		blockOffset+endsOffset+16*nRows, c.nRows, c.restartInterval)
+	for j := int32(0); j < iter.numRestarts; j++ {
+		offset := binary.LittleEndian.Uint32(iter.data[c.restarts+4*j:])
+		fmt.Fprintf(w, "%10d    [restart %d]\n",
+			blockOffset+uint64(c.restarts+4*j), blockOffset+prefixesOffset+uint64(offset))
+	}
+}
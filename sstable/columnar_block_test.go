@@ -0,0 +1,295 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+// suffixRangeFilter is a BlockPropertySuffixFilter that matches testkeys
+// suffixes with timestamps in [lower, upper).
+type suffixRangeFilter struct {
+	lower, upper int
+}
+
+var _ BlockPropertySuffixFilter = suffixRangeFilter{}
+
+func (f suffixRangeFilter) Name() string { return "suffix-range" }
+
+func (f suffixRangeFilter) Intersects(prop []byte) (bool, error) { return true, nil }
+
+func (f suffixRangeFilter) SuffixIntersects(suffix []byte) bool {
+	ts, err := strconv.Atoi(string(suffix[1:]))
+	if err != nil {
+		panic(err)
+	}
+	return f.lower <= ts && ts < f.upper
+}
+
+type testKV struct {
+	key   InternalKey
+	value []byte
+}
+
+// randomVersionedKVs returns sorted key/value pairs for a random subset of
+// the user key prefixes of ks, each with a random number of versions. Some
+// versions have no suffix, and some user keys have several entries.
+func randomVersionedKVs(rng *rand.Rand, ks testkeys.Keyspace, n int) []testKV {
+	var kvs []testKV
+	for i := 0; i < ks.Count() && len(kvs) < n; i += 1 + rng.Intn(5) {
+		for v := rng.Intn(6); v >= 0; v-- {
+			var userKey []byte
+			if ts := rng.Intn(10); ts == 0 {
+				userKey = testkeys.Key(ks, i)
+			} else {
+				userKey = testkeys.KeyAt(ks, i, ts)
+			}
+			for seqNum := uint64(rng.Intn(2) + 1); seqNum > 0; seqNum-- {
+				kvs = append(kvs, testKV{
+					key:   base.MakeInternalKey(userKey, seqNum, InternalKeyKindSet),
+					value: []byte(fmt.Sprintf("%s#%d", userKey, seqNum)),
+				})
+			}
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return base.InternalCompare(testkeys.Comparer.Compare, kvs[i].key, kvs[j].key) < 0
+	})
+	// Remove duplicate internal keys.
+	out := kvs[:0]
+	for _, kv := range kvs {
+		if len(out) == 0 || base.InternalCompare(testkeys.Comparer.Compare, out[len(out)-1].key, kv.key) != 0 {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+func TestColumnarBlockIter(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	rng := rand.New(rand.NewSource(seed))
+	t.Logf("seed: %d", seed)
+
+	cmp := testkeys.Comparer.Compare
+	ks := testkeys.Alpha(2)
+	for _, restartInterval := range []int{1, 4, 16} {
+		for _, hashIndex := range []bool{false, true} {
+			for _, filter := range []bool{false, true} {
+				name := fmt.Sprintf("restart-interval=%d,hash-index=%t,filter=%t",
+					restartInterval, hashIndex, filter)
+				t.Run(name, func(t *testing.T) {
+					kvs := randomVersionedKVs(rng, ks, 300)
+					w := blockWriter{
+						restartInterval: restartInterval,
+						hashIndex:       hashIndex,
+						columnar:        true,
+						split:           testkeys.Comparer.Split,
+					}
+					for _, kv := range kvs {
+						w.add(kv.key, kv.value)
+					}
+					iter, err := newBlockIter(cmp, w.finish())
+					require.NoError(t, err)
+					require.True(t, iter.columnar)
+					require.Equal(t, kvs[0].key.String(), iter.firstKey.String())
+
+					// The expected rows are those whose suffixes intersect the
+					// filter. Rows without a suffix are never filtered.
+					expected := kvs
+					if filter {
+						f := suffixRangeFilter{lower: 3, upper: 7}
+						iter.suffixFilters = []BlockPropertySuffixFilter{f}
+						expected = nil
+						for _, kv := range kvs {
+							si := testkeys.Comparer.Split(kv.key.UserKey)
+							if si == len(kv.key.UserKey) || f.SuffixIntersects(kv.key.UserKey[si:]) {
+								expected = append(expected, kv)
+							}
+						}
+					}
+
+					// pos is the position of the iterator within expected.
+					pos := -1
+					var ops []string
+					check := func(key *InternalKey, value []byte) {
+						if pos < 0 || pos >= len(expected) {
+							require.Nil(t, key, "%s", strings.Join(ops, " "))
+							return
+						}
+						require.NotNil(t, key, "%s", strings.Join(ops, " "))
+						require.Equal(t, expected[pos].key.String(), key.String(), "%s", strings.Join(ops, " "))
+						require.Equal(t, expected[pos].value, value)
+					}
+					randKey := func() []byte {
+						if rng.Intn(2) == 0 {
+							return kvs[rng.Intn(len(kvs))].key.UserKey
+						}
+						return testkeys.KeyAt(ks, rng.Intn(ks.Count()), rng.Intn(10))
+					}
+					for i := 0; i < 2000; i++ {
+						switch rng.Intn(6) {
+						case 0:
+							ops = append(ops[:0], "first")
+							pos = 0
+							check(iter.First())
+						case 1:
+							ops = append(ops[:0], "last")
+							pos = len(expected) - 1
+							check(iter.Last())
+						case 2:
+							ops = append(ops, "next")
+							if pos < len(expected) {
+								pos++
+							}
+							check(iter.Next())
+						case 3:
+							ops = append(ops, "prev")
+							if pos >= 0 {
+								pos--
+							}
+							check(iter.Prev())
+						case 4:
+							k := randKey()
+							ops = append(ops[:0], fmt.Sprintf("seek-ge(%s)", k))
+							pos = sort.Search(len(expected), func(j int) bool {
+								return cmp(expected[j].key.UserKey, k) >= 0
+							})
+							check(iter.SeekGE(k, false /* trySeekUsingNext */))
+						case 5:
+							k := randKey()
+							ops = append(ops[:0], fmt.Sprintf("seek-lt(%s)", k))
+							pos = sort.Search(len(expected), func(j int) bool {
+								return cmp(expected[j].key.UserKey, k) >= 0
+							}) - 1
+							check(iter.SeekLT(k))
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestColumnarBlockEmpty(t *testing.T) {
+	w := blockWriter{restartInterval: 16, columnar: true}
+	iter, err := newBlockIter(bytes.Compare, w.finish())
+	require.NoError(t, err)
+	require.True(t, iter.columnar)
+	require.Nil(t, iter.firstKey.UserKey)
+	for _, fn := range []func() (*InternalKey, []byte){
+		iter.First,
+		iter.Last,
+		iter.Next,
+		iter.Prev,
+		func() (*InternalKey, []byte) { return iter.SeekGE([]byte("a"), false /* trySeekUsingNext */) },
+		func() (*InternalKey, []byte) { return iter.SeekLT([]byte("a")) },
+	} {
+		key, _ := fn()
+		require.Nil(t, key)
+	}
+}
+
+func TestColumnarBlockCorruption(t *testing.T) {
+	w := blockWriter{restartInterval: 2, columnar: true, split: testkeys.Comparer.Split}
+	for _, k := range []string{"a@2", "a@1", "b@1"} {
+		w.add(base.MakeInternalKey([]byte(k), 1, InternalKeyKindSet), []byte(k))
+	}
+	block := w.finish()
+	_, err := newBlockIter(testkeys.Comparer.Compare, block)
+	require.NoError(t, err)
+
+	// Corrupt the row count in the header, which precedes the two restart
+	// points and the restart count.
+	corrupt := append([]byte(nil), block...)
+	corrupt[len(corrupt)-4-2*4-columnarBlockHeaderLen]++
+	_, err = newBlockIter(testkeys.Comparer.Compare, corrupt)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+}
+
+func TestColumnarBlockTable(t *testing.T) {
+	ks := testkeys.Alpha(2)
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev3} {
+		t.Run(format.String(), func(t *testing.T) {
+			f := &memFile{}
+			w := NewWriter(f, WriterOptions{
+				BlockSize:   512,
+				Comparer:    testkeys.Comparer,
+				TableFormat: format,
+			})
+			var expected [][]byte
+			for i := 0; i < ks.Count(); i++ {
+				// The unsuffixed key sorts before the versions, which sort in
+				// descending timestamp order.
+				for j := 0; j < 10; j++ {
+					ts := (10 - j) % 10
+					k := testkeys.KeyAt(ks, i, ts)
+					if ts == 0 {
+						k = testkeys.Key(ks, i)
+					}
+					require.NoError(t, w.Set(k, k))
+					if ts == 0 || (ts >= 3 && ts < 7) {
+						expected = append(expected, k)
+					}
+				}
+			}
+			require.NoError(t, w.Close())
+
+			r, err := NewMemReader(f.Data(), ReaderOptions{Comparer: testkeys.Comparer})
+			require.NoError(t, err)
+			defer r.Close()
+
+			// Data blocks are columnar beginning with TableFormatPebblev3.
+			l, err := r.Layout()
+			require.NoError(t, err)
+			require.Greater(t, len(l.Data), 1)
+			for _, bh := range l.Data {
+				h, _, err := r.readBlock(bh.BlockHandle, nil /* transform */, nil /* readaheadState */)
+				require.NoError(t, err)
+				bIter, err := newBlockIter(r.Compare, h.Get())
+				require.NoError(t, err)
+				require.Equal(t, format >= TableFormatPebblev3, bIter.columnar, "block at %d", bh.Offset)
+				h.Release()
+			}
+
+			filterer := NewBlockPropertiesFilterer([]BlockPropertyFilter{
+				suffixRangeFilter{lower: 3, upper: 7},
+			})
+			ok, err := filterer.IntersectsUserPropsAndFinishInit(r.Properties.UserProperties)
+			require.NoError(t, err)
+			require.True(t, ok)
+			iter, err := r.NewIterWithBlockPropertyFilters(nil /* lower */, nil /* upper */, filterer)
+			require.NoError(t, err)
+			defer iter.Close()
+
+			var forward, backward [][]byte
+			for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+				forward = append(forward, append([]byte(nil), key.UserKey...))
+			}
+			for key, _ := iter.Last(); key != nil; key, _ = iter.Prev() {
+				backward = append([][]byte{append([]byte(nil), key.UserKey...)}, backward...)
+			}
+			require.Equal(t, forward, backward)
+			if format >= TableFormatPebblev3 {
+				// Rows of columnar blocks are filtered by their suffixes.
+				require.Equal(t, expected, forward)
+			} else {
+				// Suffix filters are a hint that row-oriented blocks ignore.
+				require.Equal(t, 10*ks.Count(), len(forward))
+			}
+		})
+	}
+}
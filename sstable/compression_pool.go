@@ -0,0 +1,62 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import "runtime"
+
+// CompressionPool bounds the number of goroutines used to compress data blocks
+// on behalf of Writers which have WriterOptions.Parallelism enabled. A single
+// CompressionPool may be shared by any number of Writers, in which case it
+// bounds the CPU used for block compression across all of them.
+//
+// When every worker in the pool is busy, a Writer compresses its next data
+// block on the Writer client goroutine instead of waiting for a worker. This
+// keeps a Writer making progress when the pool is saturated by other Writers,
+// and limits the number of compressed blocks waiting to be written.
+type CompressionPool struct {
+	// sem holds one token for every block being compressed by the pool.
+	sem chan struct{}
+}
+
+// NewCompressionPool returns a CompressionPool which compresses at most
+// workers data blocks concurrently. Values less than 1 are treated as 1.
+func NewCompressionPool(workers int) *CompressionPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &CompressionPool{sem: make(chan struct{}, workers)}
+}
+
+// Workers returns the maximum number of data blocks the pool compresses
+// concurrently.
+func (p *CompressionPool) Workers() int {
+	return cap(p.sem)
+}
+
+// defaultCompressionPool is used by Writers which enable parallelism without
+// specifying a CompressionPool.
+var defaultCompressionPool = NewCompressionPool(runtime.GOMAXPROCS(0))
+
+// compress compresses and checksums task.buf, and signals task.compressionDone
+// once it's finished. The compression is performed by a pool goroutine if one
+// is available, and synchronously otherwise.
+//
+// Only task.buf and task.compressionDone may be accessed by the pool, which
+// allows the Writer client goroutine to continue populating the remaining
+// fields of the task.
+func (p *CompressionPool) compress(task *writeTask, compression Compression) {
+	buf, done := task.buf, task.compressionDone
+	select {
+	case p.sem <- struct{}{}:
+		go func() {
+			buf.compressAndChecksum(compression)
+			<-p.sem
+			done <- true
+		}()
+	default:
+		buf.compressAndChecksum(compression)
+		done <- true
+	}
+}
@@ -166,6 +166,26 @@ type WriterOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// CompressionPool bounds the number of data blocks compressed concurrently
+	// when Parallelism is enabled. A CompressionPool may be shared by many
+	// Writers. It is ignored if Parallelism is disabled.
+	//
+	// The default value uses a pool, shared by all Writers within the process,
+	// with one worker per logical CPU, as limited by runtime.GOMAXPROCS.
+	CompressionPool *CompressionPool
+
+	// DataBlockHashIndex adds a hash index to each data block, which allows a
+	// seek to a key present in a data block to locate the key's restart
+	// interval without binary searching the block's restart points. The hash
+	// index adds roughly one byte per distinct user key to each data block. It
+	// requires TableFormatPebblev3 or later, and is ignored for earlier table
+	// formats. Blocks with more than 254 restart points are written without a
+	// hash index. The hash index hashes the bytes of user keys, so it is only
+	// written if the Comparer's Equal is bytes.Equal (or nil).
+	//
+	// The default value is false.
+	DataBlockHashIndex bool
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
@@ -198,6 +218,28 @@ type WriterOptions struct {
 	// with the value stored in the sstable when it was written.
 	MergerName string
 
+	// Parallelism allows the Writer to compress data blocks, using the workers
+	// of the CompressionPool, and to write data blocks to disk, concurrently
+	// with the Writer client goroutine adding keys to the sstable. The data
+	// blocks written are identical to those written without parallelism,
+	// though the partitioning of a two-level index may differ. An error
+	// encountered while writing a data block is returned by Writer.Close.
+	//
+	// The default value is false.
+	Parallelism bool
+
+	// RangeFilterPrefixLength enables the range filter when positive. The range
+	// filter records the distinct key prefixes (as determined by
+	// Comparer.Split), truncated to RangeFilterPrefixLength bytes, which allows
+	// a reader to determine that a table contains no point keys within a range
+	// without reading the table's index block. Smaller values produce a smaller
+	// filter that is less effective at excluding ranges. The range filter
+	// requires that keys are ordered bytewise, so it is only written if the
+	// Comparer's Compare is bytes.Compare.
+	//
+	// The default value of 0 disables the range filter.
+	RangeFilterPrefixLength int
+
 	// TableFormat specifies the format version for writing sstables. The default
 	// is TableFormatRocksDBv2 which creates RocksDB compatible sstables. Use
 	// TableFormatLevelDB to create LevelDB compatible sstable which can be used
@@ -0,0 +1,206 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import "sync"
+
+// minSequentialBlocksForPrefetch is the number of adjacent data blocks an
+// iterator must load consecutively before it begins prefetching. A single
+// seek may load two adjacent blocks, when the sought key follows every key of
+// the block the index points to, so two loads are not evidence of a scan.
+const minSequentialBlocksForPrefetch = 3
+
+// PrefetchPool bounds the number of goroutines reading data blocks into the
+// block cache ahead of iterators performing sequential scans. A PrefetchPool
+// may be shared by the iterators of many Readers.
+type PrefetchPool struct {
+	sem chan struct{}
+}
+
+// NewPrefetchPool returns a PrefetchPool which reads at most concurrency
+// blocks at a time.
+func NewPrefetchPool(concurrency int) *PrefetchPool {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &PrefetchPool{sem: make(chan struct{}, concurrency)}
+}
+
+// tryGo runs fn on a new goroutine, unless the pool is already running as
+// many goroutines as it allows, in which case it returns false. Prefetches are
+// best-effort, so they are dropped rather than queued when the pool is
+// saturated.
+func (p *PrefetchPool) tryGo(fn func()) bool {
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-p.sem }()
+		fn()
+	}()
+	return true
+}
+
+// PrefetchOptions configures the explicit read-ahead of data blocks by an
+// iterator. Once an iterator has loaded a few adjacent data blocks in the
+// forward direction, it reads up to Blocks of the data blocks that follow into the
+// block cache asynchronously, through Pool. Reverse iteration and seeks do not
+// trigger prefetching. The zero value disables prefetching.
+type PrefetchOptions struct {
+	// Blocks is the number of data blocks to read ahead of the iterator.
+	Blocks int
+	// Pool bounds the number of concurrent asynchronous block reads.
+	Pool *PrefetchPool
+}
+
+// prefetchState tracks the explicit read-ahead of data blocks for an
+// iterator configured with PrefetchOptions.
+type prefetchState struct {
+	opts PrefetchOptions
+	// seqReads is the number of consecutively loaded data blocks that were
+	// adjacent in the file.
+	seqReads int
+	// prevEnd is the offset following the last loaded data block.
+	prevEnd uint64
+	// scheduledEnd is the offset following the last data block scheduled for
+	// prefetching, and ahead the number of scheduled blocks the iterator has
+	// not yet loaded.
+	scheduledEnd uint64
+	ahead        int
+	// index is used to scan the index block ahead of the iterator's position,
+	// without disturbing the iterator's own index iterator.
+	index blockIter
+	// wg tracks the in-flight block reads, which must complete before the
+	// iterator releases its Reader.
+	wg *sync.WaitGroup
+	// inflight holds a channel for each in-flight block read, indexed by the
+	// block's offset, which is closed once the block is in the block cache.
+	// The iterator waits for an in-flight read of a block it loads rather than
+	// reading the block a second time.
+	inflight struct {
+		sync.Mutex
+		m map[uint64]chan struct{}
+	}
+}
+
+// maybePrefetch records the load of the data block bh at the current index
+// position, and schedules the asynchronous read of the data blocks that
+// follow it once the iterator is reading sequentially.
+func (i *singleLevelIterator) maybePrefetch(bh BlockHandle) {
+	ps := &i.prefetch
+	if ps.opts.Blocks <= 0 || ps.opts.Pool == nil {
+		return
+	}
+	if bh.Offset == ps.prevEnd {
+		ps.seqReads++
+		if ps.ahead > 0 && bh.Offset < ps.scheduledEnd {
+			ps.ahead--
+		}
+	} else {
+		ps.seqReads = 1
+		ps.scheduledEnd = 0
+		ps.ahead = 0
+	}
+	ps.prevEnd = bh.Offset + bh.Length + blockTrailerLen
+	// Top up the scheduled blocks once fewer than half of them remain ahead
+	// of the iterator.
+	if ps.seqReads < minSequentialBlocksForPrefetch || ps.ahead > ps.opts.Blocks/2 {
+		return
+	}
+	start := ps.prevEnd
+	if ps.scheduledEnd > start {
+		start = ps.scheduledEnd
+	}
+	if err := ps.index.init(i.cmp, i.index.cacheHandle.Get(), i.reader.Properties.GlobalSeqNum); err != nil {
+		return
+	}
+	if ps.wg == nil {
+		ps.wg = &sync.WaitGroup{}
+	}
+	r, wg := i.reader, ps.wg
+	key, val := ps.index.SeekGE(i.index.Key().UserKey, false /* trySeekUsingNext */)
+	for ; key != nil && ps.ahead < ps.opts.Blocks; key, val = ps.index.Next() {
+		bhp, err := decodeBlockHandleWithProperties(val)
+		if err != nil {
+			break
+		}
+		if bhp.Offset >= start {
+			intersects := true
+			if i.bpfs != nil {
+				if intersects, err = i.bpfs.intersects(bhp.Props); err != nil {
+					break
+				}
+			}
+			if intersects {
+				next := bhp.BlockHandle
+				done := ps.addInflight(next.Offset)
+				wg.Add(1)
+				if !ps.opts.Pool.tryGo(func() {
+					defer wg.Done()
+					if h, _, err := r.readBlock(next, nil /* transform */, nil /* readaheadState */); err == nil {
+						h.Release()
+					}
+					ps.removeInflight(next.Offset, done)
+				}) {
+					ps.removeInflight(next.Offset, done)
+					wg.Done()
+					break
+				}
+				ps.ahead++
+			}
+			ps.scheduledEnd = bhp.Offset + bhp.Length + blockTrailerLen
+		}
+		// The index key is greater than or equal to every key in its block,
+		// so no block that follows holds keys within the upper bound.
+		if i.upper != nil && i.cmp(key.UserKey, i.upper) >= 0 {
+			break
+		}
+	}
+}
+
+// addInflight records the read of the block at offset as in flight, returning
+// the channel to close once it completes.
+func (ps *prefetchState) addInflight(offset uint64) chan struct{} {
+	done := make(chan struct{})
+	ps.inflight.Lock()
+	defer ps.inflight.Unlock()
+	if ps.inflight.m == nil {
+		ps.inflight.m = make(map[uint64]chan struct{})
+	}
+	ps.inflight.m[offset] = done
+	return done
+}
+
+// removeInflight records the completion of the read of the block at offset.
+func (ps *prefetchState) removeInflight(offset uint64, done chan struct{}) {
+	ps.inflight.Lock()
+	defer ps.inflight.Unlock()
+	delete(ps.inflight.m, offset)
+	close(done)
+}
+
+// waitForBlock waits for the in-flight read of the block at offset, if any,
+// so that the iterator loads the block from the block cache rather than
+// reading it again.
+func (ps *prefetchState) waitForBlock(offset uint64) {
+	if ps.wg == nil {
+		return
+	}
+	ps.inflight.Lock()
+	done := ps.inflight.m[offset]
+	ps.inflight.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// waitForPrefetches waits for the iterator's in-flight block reads.
+func (ps *prefetchState) waitForPrefetches() {
+	if ps.wg != nil {
+		ps.wg.Wait()
+	}
+}
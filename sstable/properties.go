@@ -130,6 +130,9 @@ type Properties struct {
 	// A comma separated list of names of the property collectors used in this
 	// table.
 	PropertyCollectorNames string `prop:"rocksdb.property.collectors"`
+	// The number of bytes of each key prefix retained in the range filter. Zero
+	// if the table does not have a range filter.
+	RangeFilterPrefixLength uint64 `prop:"pebble.range-filter.prefix-length"`
 	// Total raw key size.
 	RawKeySize uint64 `prop:"rocksdb.raw.key.size"`
 	// Total raw rangekey key size.
@@ -348,6 +351,9 @@ func (p *Properties) save(w *rawBlockWriter) {
 	if p.PropertyCollectorNames != "" {
 		p.saveString(m, unsafe.Offsetof(p.PropertyCollectorNames), p.PropertyCollectorNames)
 	}
+	if p.RangeFilterPrefixLength > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.RangeFilterPrefixLength), p.RangeFilterPrefixLength)
+	}
 	p.saveUvarint(m, unsafe.Offsetof(p.RawKeySize), p.RawKeySize)
 	p.saveUvarint(m, unsafe.Offsetof(p.RawValueSize), p.RawValueSize)
 	p.saveBool(m, unsafe.Offsetof(p.WholeKeyFiltering), p.WholeKeyFiltering)
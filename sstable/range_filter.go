@@ -0,0 +1,144 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+)
+
+// The range filter is an optional meta block that allows a reader to
+// determine, without consulting the index block, whether a table may contain
+// any point key within a range [lower, upper).
+//
+// The filter stores the sorted set of distinct truncated key prefixes that
+// appear in the table. A key's prefix is determined by Comparer.Split (or is
+// the entire key if Split is nil), and is truncated to at most
+// WriterOptions.RangeFilterPrefixLength bytes. Truncation loses precision, but
+// never correctness: every key k within [lower, upper) has a truncated prefix
+// t such that trunc(prefix(lower)) <= t <= trunc(prefix(upper)), so the
+// absence of such a t in the filter proves that the table contains no key in
+// the range. This reasoning relies on keys being ordered bytewise, so the
+// range filter is only written by Writers whose Comparer.Compare is
+// bytes.Compare (see compareIsBytewise). Truncated prefixes do not preserve
+// the order of other comparers, and a filter consulted with such a comparer
+// could exclude ranges that contain keys.
+//
+// This is similar in spirit to the SuRF-Base variant of a succinct range
+// filter, with the trie replaced by a prefix-compressed block, which is
+// cheaper to build and read with the existing block machinery.
+
+// rangeFilterRestartInterval is the restart interval of the range filter
+// block. The block is only ever searched with SeekGE, so a moderately sparse
+// restart interval trades little CPU for a smaller block.
+const rangeFilterRestartInterval = 16
+
+type rangeFilterWriter struct {
+	split        Split
+	prefixLength int
+	block        rawBlockWriter
+	// last holds the most recently added truncated prefix. Keys are added in
+	// sorted order, so deduplicating against the previous entry is sufficient
+	// to produce a set.
+	last []byte
+}
+
+func newRangeFilterWriter(split Split, prefixLength int) *rangeFilterWriter {
+	w := &rangeFilterWriter{
+		split:        split,
+		prefixLength: prefixLength,
+	}
+	w.block.restartInterval = rangeFilterRestartInterval
+	return w
+}
+
+func (w *rangeFilterWriter) addKey(key []byte) {
+	t := truncateRangeFilterKey(w.split, w.prefixLength, key)
+	if w.block.nEntries > 0 && bytes.Equal(t, w.last) {
+		return
+	}
+	w.last = append(w.last[:0], t...)
+	w.block.add(InternalKey{UserKey: w.last}, nil)
+}
+
+// finish returns the contents of the range filter block, or nil if no keys
+// were added to the filter.
+func (w *rangeFilterWriter) finish() []byte {
+	if w.block.nEntries == 0 {
+		return nil
+	}
+	return w.block.finish()
+}
+
+// compareIsBytewise returns true if compare is bytes.Compare.
+func compareIsBytewise(compare base.Compare) bool {
+	return reflect.ValueOf(compare).Pointer() == reflect.ValueOf(bytes.Compare).Pointer()
+}
+
+func truncateRangeFilterKey(split Split, prefixLength int, key []byte) []byte {
+	if split != nil {
+		key = key[:split(key)]
+	}
+	if len(key) > prefixLength {
+		key = key[:prefixLength]
+	}
+	return key
+}
+
+func (r *Reader) readRangeFilter() (cache.Handle, error) {
+	h, _, err :=
+		r.readBlock(r.rangeFilterBH, nil /* transform */, nil /* readaheadState */)
+	return h, err
+}
+
+// HasRangeFilter returns true if the table was written with a range filter.
+func (r *Reader) HasRangeFilter() bool {
+	return r.rangeFilterBH.Length > 0
+}
+
+// MayContainRange returns false if the table is known to contain no point keys
+// within [lower, upper). A nil lower or upper bound leaves the range unbounded
+// in that direction. If the table does not have a range filter, or the filter
+// is unable to exclude the range, MayContainRange returns true. Note that range
+// deletions and range keys are not covered by the range filter.
+func (r *Reader) MayContainRange(lower, upper []byte) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	if r.rangeFilterBH.Length == 0 || (lower == nil && upper == nil) {
+		return true, nil
+	}
+	h, err := r.readRangeFilter()
+	if err != nil {
+		return false, err
+	}
+	defer h.Release()
+
+	i, err := newRawBlockIter(bytes.Compare, h.Get())
+	if err != nil {
+		return false, err
+	}
+	prefixLength := int(r.Properties.RangeFilterPrefixLength)
+	var valid bool
+	if lower == nil {
+		valid = i.First()
+	} else {
+		valid = i.SeekGE(truncateRangeFilterKey(r.Split, prefixLength, lower))
+	}
+	mayContain := valid
+	if valid && upper != nil {
+		// The truncated prefix of upper is inclusive: a key less than upper
+		// may share upper's truncated prefix.
+		t := truncateRangeFilterKey(r.Split, prefixLength, upper)
+		mayContain = bytes.Compare(i.Key().UserKey, t) <= 0
+	}
+	if err := i.Close(); err != nil {
+		return false, err
+	}
+	return mayContain, nil
+}
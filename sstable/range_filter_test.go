@@ -0,0 +1,204 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestRangeFilter(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	rng := rand.New(rand.NewSource(seed))
+	t.Logf("seed: %d", seed)
+
+	randKey := func() []byte {
+		const letters = "abcdefgh"
+		b := make([]byte, 1+rng.Intn(5))
+		for i := range b {
+			b[i] = letters[rng.Intn(len(letters))]
+		}
+		return b
+	}
+
+	// The range filter is only written for bytewise comparers, so order the
+	// MVCC keys bytewise while splitting them as testkeys does.
+	cmp := *base.DefaultComparer
+	cmp.Split = testkeys.Comparer.Split
+	cmp.Name = "bytewise-mvcc"
+
+	for _, prefixLength := range []int{1, 2, 3, 8} {
+		t.Run(fmt.Sprintf("prefix-length=%d", prefixLength), func(t *testing.T) {
+			// Generate a set of MVCC keys. Each key prefix is written at a few
+			// timestamps, which must all collapse into a single filter entry.
+			prefixes := make(map[string]struct{})
+			for i := 0; i < 50; i++ {
+				prefixes[string(randKey())] = struct{}{}
+			}
+			var keys [][]byte
+			for p := range prefixes {
+				for ts := 1; ts <= 3; ts++ {
+					keys = append(keys, []byte(fmt.Sprintf("%s@%d", p, ts)))
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return cmp.Compare(keys[i], keys[j]) < 0
+			})
+
+			f := &memFile{}
+			w := NewWriter(f, WriterOptions{
+				Comparer:                &cmp,
+				RangeFilterPrefixLength: prefixLength,
+				TableFormat:             TableFormatPebblev2,
+			})
+			for _, k := range keys {
+				require.NoError(t, w.Set(k, nil))
+			}
+			require.NoError(t, w.Close())
+
+			r, err := NewMemReader(f.Data(), ReaderOptions{Comparer: &cmp})
+			require.NoError(t, err)
+			defer r.Close()
+			require.True(t, r.HasRangeFilter())
+			require.EqualValues(t, prefixLength, r.Properties.RangeFilterPrefixLength)
+
+			contains := func(lower, upper []byte) bool {
+				for _, k := range keys {
+					if (lower == nil || cmp.Compare(k, lower) >= 0) &&
+						(upper == nil || cmp.Compare(k, upper) < 0) {
+						return true
+					}
+				}
+				return false
+			}
+
+			var excluded int
+			for i := 0; i < 1000; i++ {
+				var lower, upper []byte
+				if rng.Intn(10) > 0 {
+					lower = randKey()
+				}
+				if rng.Intn(10) > 0 {
+					upper = randKey()
+				}
+				if lower != nil && upper != nil && bytes.Compare(lower, upper) > 0 {
+					lower, upper = upper, lower
+				}
+				mayContain, err := r.MayContainRange(lower, upper)
+				require.NoError(t, err)
+				if contains(lower, upper) {
+					// The range filter must never produce a false negative.
+					require.Truef(t, mayContain, "[%q, %q)", lower, upper)
+				} else if !mayContain {
+					excluded++
+				}
+			}
+			t.Logf("excluded %d ranges", excluded)
+		})
+	}
+}
+
+func TestRangeFilterDisabled(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{})
+	require.NoError(t, w.Set([]byte("a"), nil))
+	require.NoError(t, w.Close())
+
+	r, err := NewMemReader(f.Data(), ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+	require.False(t, r.HasRangeFilter())
+	mayContain, err := r.MayContainRange([]byte("b"), []byte("c"))
+	require.NoError(t, err)
+	require.True(t, mayContain)
+}
+
+func TestRangeFilterCustomComparer(t *testing.T) {
+	// Order keys by length before their bytes. Truncated prefixes do not
+	// preserve this order: the range ["z", "ab") contains "aa", but a filter
+	// of 1-byte prefixes holding "a" and "b" would exclude it.
+	cmp := &Comparer{
+		Compare: func(a, b []byte) int {
+			if len(a) != len(b) {
+				if len(a) < len(b) {
+					return -1
+				}
+				return +1
+			}
+			return bytes.Compare(a, b)
+		},
+		Equal:          bytes.Equal,
+		AbbreviatedKey: func(key []byte) uint64 { return 0 },
+		FormatKey:      base.DefaultFormatter,
+		Separator:      func(dst, a, b []byte) []byte { return append(dst, a...) },
+		Successor:      func(dst, a []byte) []byte { return append(dst, a...) },
+		Name:           "length-first",
+	}
+
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{
+		Comparer:                cmp,
+		RangeFilterPrefixLength: 1,
+	})
+	for _, k := range []string{"b", "aa", "ac"} {
+		require.NoError(t, w.Set([]byte(k), nil))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewMemReader(f.Data(), ReaderOptions{Comparer: cmp})
+	require.NoError(t, err)
+	defer r.Close()
+	require.False(t, r.HasRangeFilter())
+	mayContain, err := r.MayContainRange([]byte("z"), []byte("ab"))
+	require.NoError(t, err)
+	require.True(t, mayContain)
+}
+
+func TestRangeFilterExcludesGap(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{RangeFilterPrefixLength: 2})
+	for _, k := range []string{"apple", "apricot", "zebra", "zucchini"} {
+		require.NoError(t, w.Set([]byte(k), nil))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewMemReader(f.Data(), ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	testCases := []struct {
+		lower, upper string
+		expected     bool
+	}{
+		{"a", "b", true},
+		{"b", "y", false},
+		{"aq", "zd", false},
+		{"aq", "ze", true},
+		{"apz", "b", true},
+		{"zv", "", false},
+		{"", "ap", true},
+		{"", "ao", false},
+	}
+	for _, tc := range testCases {
+		var lower, upper []byte
+		if tc.lower != "" {
+			lower = []byte(tc.lower)
+		}
+		if tc.upper != "" {
+			upper = []byte(tc.upper)
+		}
+		mayContain, err := r.MayContainRange(lower, upper)
+		require.NoError(t, err)
+		require.Equalf(t, tc.expected, mayContain, "[%s, %s)", tc.lower, tc.upper)
+	}
+}
@@ -118,6 +118,7 @@ type singleLevelIterator struct {
 	index      blockIter
 	data       blockIter
 	dataRS     readaheadState
+	prefetch   prefetchState
 	// dataBH refers to the last data block that the iterator considered
 	// loading. It may not actually have loaded the block, due to an error or
 	// because it was considered irrelevant.
@@ -214,6 +215,8 @@ type singleLevelIterator struct {
 
 // singleLevelIterator implements the base.InternalIterator interface.
 var _ base.InternalIterator = (*singleLevelIterator)(nil)
+var _ base.PrefixLTSeeker = (*singleLevelIterator)(nil)
+var _ base.PrefixLTSeeker = (*twoLevelIterator)(nil)
 
 var singleLevelIterPool = sync.Pool{
 	New: func() interface{} {
@@ -274,6 +277,9 @@ func (i *singleLevelIterator) init(
 	i.lower = lower
 	i.upper = upper
 	i.bpfs = filterer
+	if filterer != nil {
+		i.data.suffixFilters = filterer.suffixFilters
+	}
 	i.reader = r
 	i.cmp = r.Compare
 	err = i.index.initHandle(i.cmp, indexH, r.Properties.GlobalSeqNum)
@@ -379,6 +385,7 @@ func (i *singleLevelIterator) loadBlock() loadBlockResult {
 			return loadBlockIrrelevant
 		}
 	}
+	i.prefetch.waitForBlock(i.dataBH.Offset)
 	block, err := i.readBlockWithStats(i.dataBH, &i.dataRS)
 	if err != nil {
 		i.err = err
@@ -390,6 +397,7 @@ func (i *singleLevelIterator) loadBlock() loadBlockResult {
 		i.data.invalidate()
 		return loadBlockFailed
 	}
+	i.maybePrefetch(i.dataBH)
 	i.initBounds()
 	return loadBlockOK
 }
@@ -486,7 +494,13 @@ func (i *singleLevelIterator) recordOffset() uint64 {
 		//   in the block.
 		// - i.dataBH.Offset is the offset of the block in the sstable before
 		//   decompression.
-		offset += (uint64(i.data.nextOffset) * i.dataBH.Length) / uint64(len(i.data.data))
+		pos := uint64(i.data.nextOffset)
+		if i.data.columnar {
+			// i.data.nextOffset is the index of the next row in a columnar
+			// block. Assume that the rows are of equal size.
+			pos = pos * uint64(len(i.data.data)) / uint64(i.data.col.nRows)
+		}
+		offset += (pos * i.dataBH.Length) / uint64(len(i.data.data))
 	} else {
 		// Last entry in the block must increment bytes iterated by the size of the block trailer
 		// and restart points.
@@ -668,6 +682,42 @@ func (i *singleLevelIterator) seekPrefixGE(
 	return k, value
 }
 
+// SeekPrefixLT implements base.PrefixLTSeeker. It returns (nil, nil) if the
+// table's prefix bloom filter excludes the prefix, and otherwise positions the
+// iterator as SeekLT, or Last if key is nil.
+func (i *singleLevelIterator) SeekPrefixLT(prefix, key []byte) (*InternalKey, []byte) {
+	if !i.mayContainPrefix(prefix) {
+		return nil, nil
+	}
+	if key == nil {
+		return i.Last()
+	}
+	return i.SeekLT(key)
+}
+
+// mayContainPrefix returns false if the table's prefix bloom filter excludes
+// the prefix, invalidating the iterator. The filter is not consulted by
+// subsequent calls to SeekPrefixGE with trySeekUsingNext.
+func (i *singleLevelIterator) mayContainPrefix(prefix []byte) bool {
+	i.err = nil // clear cached iteration error
+	i.lastBloomFilterMatched = false
+	if i.reader.tableFilter == nil {
+		return true
+	}
+	var dataH cache.Handle
+	dataH, i.err = i.reader.readFilter()
+	if i.err != nil {
+		i.data.invalidate()
+		return false
+	}
+	mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
+	dataH.Release()
+	if !mayContain {
+		i.data.invalidate()
+	}
+	return mayContain
+}
+
 // SeekLT implements internalIterator.SeekLT, as documented in the pebble
 // package. Note that SeekLT only checks the lower bound. It is up to the
 // caller to ensure that key is less than the upper bound.
@@ -1018,6 +1068,9 @@ func firstError(err0, err1 error) error {
 // Close implements internalIterator.Close, as documented in the pebble
 // package.
 func (i *singleLevelIterator) Close() error {
+	// The asynchronous block reads must complete before the close hook
+	// releases the Reader.
+	i.prefetch.waitForPrefetches()
 	var err error
 	if i.closeHook != nil {
 		err = firstError(err, i.closeHook(i))
@@ -1102,8 +1155,14 @@ func (i *compactionIterator) String() string {
 	return i.reader.fileNum.String()
 }
 
+// SeekGE implements internalIterator.SeekGE. It's used by subcompactions to
+// position the iterator at the start of their key range. The bytes preceding
+// the seek position are not counted as iterated.
 func (i *compactionIterator) SeekGE(key []byte, trySeekUsingNext bool) (*InternalKey, []byte) {
-	panic("pebble: SeekGE unimplemented")
+	i.err = nil // clear cached iteration error
+	k, v := i.singleLevelIterator.SeekGE(key, false /* trySeekUsingNext */)
+	i.prevOffset = i.recordOffset()
+	return k, v
 }
 
 func (i *compactionIterator) SeekPrefixGE(
@@ -1112,6 +1171,10 @@ func (i *compactionIterator) SeekPrefixGE(
 	panic("pebble: SeekPrefixGE unimplemented")
 }
 
+func (i *compactionIterator) SeekPrefixLT(prefix, key []byte) (*InternalKey, []byte) {
+	panic("pebble: SeekPrefixLT unimplemented")
+}
+
 func (i *compactionIterator) SeekLT(key []byte) (*InternalKey, []byte) {
 	panic("pebble: SeekLT unimplemented")
 }
@@ -1236,6 +1299,9 @@ func (i *twoLevelIterator) init(
 	i.lower = lower
 	i.upper = upper
 	i.bpfs = filterer
+	if filterer != nil {
+		i.data.suffixFilters = filterer.suffixFilters
+	}
 	i.reader = r
 	i.cmp = r.Compare
 	err = i.topLevelIndex.initHandle(i.cmp, topLevelIndexH, r.Properties.GlobalSeqNum)
@@ -1410,6 +1476,19 @@ func (i *twoLevelIterator) SeekPrefixGE(
 	return i.skipForward()
 }
 
+// SeekPrefixLT implements base.PrefixLTSeeker. It returns (nil, nil) if the
+// table's prefix bloom filter excludes the prefix, and otherwise positions the
+// iterator as SeekLT, or Last if key is nil.
+func (i *twoLevelIterator) SeekPrefixLT(prefix, key []byte) (*InternalKey, []byte) {
+	if !i.mayContainPrefix(prefix) {
+		return nil, nil
+	}
+	if key == nil {
+		return i.Last()
+	}
+	return i.SeekLT(key)
+}
+
 // SeekLT implements internalIterator.SeekLT, as documented in the pebble
 // package. Note that SeekLT only checks the lower bound. It is up to the
 // caller to ensure that key is less than the upper bound.
@@ -1666,6 +1745,9 @@ func (i *twoLevelIterator) skipBackward() (*InternalKey, []byte) {
 // Close implements internalIterator.Close, as documented in the pebble
 // package.
 func (i *twoLevelIterator) Close() error {
+	// The asynchronous block reads must complete before the close hook
+	// releases the Reader.
+	i.prefetch.waitForPrefetches()
 	var err error
 	if i.closeHook != nil {
 		err = firstError(err, i.closeHook(i))
@@ -1704,10 +1786,16 @@ func (i *twoLevelCompactionIterator) Close() error {
 	return i.twoLevelIterator.Close()
 }
 
+// SeekGE implements internalIterator.SeekGE. It's used by subcompactions to
+// position the iterator at the start of their key range. The bytes preceding
+// the seek position are not counted as iterated.
 func (i *twoLevelCompactionIterator) SeekGE(
 	key []byte, trySeekUsingNext bool,
 ) (*InternalKey, []byte) {
-	panic("pebble: SeekGE unimplemented")
+	i.err = nil // clear cached iteration error
+	k, v := i.twoLevelIterator.SeekGE(key, false /* trySeekUsingNext */)
+	i.prevOffset = i.recordOffset()
+	return k, v
 }
 
 func (i *twoLevelCompactionIterator) SeekPrefixGE(
@@ -1716,6 +1804,12 @@ func (i *twoLevelCompactionIterator) SeekPrefixGE(
 	panic("pebble: SeekPrefixGE unimplemented")
 }
 
+func (i *twoLevelCompactionIterator) SeekPrefixLT(
+	prefix, key []byte,
+) (*InternalKey, []byte) {
+	panic("pebble: SeekPrefixLT unimplemented")
+}
+
 func (i *twoLevelCompactionIterator) SeekLT(key []byte) (*InternalKey, []byte) {
 	panic("pebble: SeekLT unimplemented")
 }
@@ -2092,6 +2186,7 @@ type Reader struct {
 	filterBH          BlockHandle
 	rangeDelBH        BlockHandle
 	rangeKeyBH        BlockHandle
+	rangeFilterBH     BlockHandle
 	rangeDelTransform blockTransform
 	propertiesBH      BlockHandle
 	metaIndexBH       BlockHandle
@@ -2135,6 +2230,16 @@ func (r *Reader) Close() error {
 // itself and returns a nil iterator.
 func (r *Reader) NewIterWithBlockPropertyFilters(
 	lower, upper []byte, filterer *BlockPropertiesFilterer,
+) (Iterator, error) {
+	return r.NewIterWithPrefetch(lower, upper, filterer, PrefetchOptions{})
+}
+
+// NewIterWithPrefetch is like NewIterWithBlockPropertyFilters, but returns an
+// iterator which reads data blocks ahead of sequential scans into the block
+// cache, as configured by prefetch. If an error occurs, NewIterWithPrefetch
+// cleans up after itself and returns a nil iterator.
+func (r *Reader) NewIterWithPrefetch(
+	lower, upper []byte, filterer *BlockPropertiesFilterer, prefetch PrefetchOptions,
 ) (Iterator, error) {
 	// NB: pebble.tableCache wraps the returned iterator with one which performs
 	// reference counting on the Reader, preventing the Reader from being closed
@@ -2145,6 +2250,7 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 		if err != nil {
 			return nil, err
 		}
+		i.prefetch.opts = prefetch
 		return i, nil
 	}
 
@@ -2153,6 +2259,7 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 	if err != nil {
 		return nil, err
 	}
+	i.prefetch.opts = prefetch
 	return i, nil
 }
 
@@ -2465,6 +2572,13 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		r.rangeKeyBH = bh
 	}
 
+	if bh, ok := meta[metaRangeFilterName]; ok {
+		if r.Properties.RangeFilterPrefixLength == 0 {
+			return base.CorruptionErrorf("pebble/table: range filter without a prefix length")
+		}
+		r.rangeFilterBH = bh
+	}
+
 	for name, fp := range r.opts.Filters {
 		types := []struct {
 			ftype  FilterType
@@ -2502,13 +2616,14 @@ func (r *Reader) Layout() (*Layout, error) {
 	}
 
 	l := &Layout{
-		Data:       make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
-		Filter:     r.filterBH,
-		RangeDel:   r.rangeDelBH,
-		RangeKey:   r.rangeKeyBH,
-		Properties: r.propertiesBH,
-		MetaIndex:  r.metaIndexBH,
-		Footer:     r.footerBH,
+		Data:        make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
+		Filter:      r.filterBH,
+		RangeDel:    r.rangeDelBH,
+		RangeKey:    r.rangeKeyBH,
+		RangeFilter: r.rangeFilterBH,
+		Properties:  r.propertiesBH,
+		MetaIndex:   r.metaIndexBH,
+		Footer:      r.footerBH,
 	}
 
 	indexH, err := r.readIndex()
@@ -2579,8 +2694,55 @@ func (r *Reader) Layout() (*Layout, error) {
 	return l, nil
 }
 
+// ChecksumVerificationMode specifies how the checksums of an sstable's blocks
+// are verified by Reader.ValidateBlockChecksumsWithOptions.
+type ChecksumVerificationMode int8
+
+const (
+	// VerifyThroughCache loads each block through the block cache, which
+	// verifies the checksum of every block that is read from the file. Blocks
+	// already present in the block cache are not read from the file, so
+	// corruption of the file's copy of those blocks is not detected. Blocks
+	// read from the file are added to the block cache.
+	VerifyThroughCache ChecksumVerificationMode = iota
+	// VerifyFromDisk reads every block from the file, bypassing the block
+	// cache, and verifies its checksum. Blocks are neither decompressed nor
+	// added to the block cache, which avoids evicting blocks in use by
+	// foreground reads when verifying infrequently read sstables.
+	VerifyFromDisk
+)
+
+// String implements fmt.Stringer.
+func (m ChecksumVerificationMode) String() string {
+	switch m {
+	case VerifyThroughCache:
+		return "through-cache"
+	case VerifyFromDisk:
+		return "from-disk"
+	default:
+		return fmt.Sprintf("unknown(%d)", int8(m))
+	}
+}
+
+// ValidateOptions configures Reader.ValidateBlockChecksumsWithOptions.
+type ValidateOptions struct {
+	// Mode specifies how block checksums are verified.
+	Mode ChecksumVerificationMode
+	// Throttle, if non-nil, is called with the length in bytes of each block
+	// before the block is read. Throttle may block to pace the validation of
+	// the sstable. An error returned by Throttle stops the validation, and is
+	// returned by ValidateBlockChecksumsWithOptions.
+	Throttle func(blockLen uint64) error
+}
+
 // ValidateBlockChecksums validates the checksums for each block in the SSTable.
 func (r *Reader) ValidateBlockChecksums() error {
+	return r.ValidateBlockChecksumsWithOptions(ValidateOptions{})
+}
+
+// ValidateBlockChecksumsWithOptions validates the checksums for each block in
+// the SSTable using the specified options.
+func (r *Reader) ValidateBlockChecksumsWithOptions(o ValidateOptions) error {
 	// Pre-compute the BlockHandles for the underlying file.
 	l, err := r.Layout()
 	if err != nil {
@@ -2594,7 +2756,7 @@ func (r *Reader) ValidateBlockChecksums() error {
 		blocks[i] = l.Data[i].BlockHandle
 	}
 	blocks = append(blocks, l.Index...)
-	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.RangeFilter, l.Properties, l.MetaIndex)
 
 	// Sorting by offset ensures we are performing a sequential scan of the
 	// file.
@@ -2607,18 +2769,41 @@ func (r *Reader) ValidateBlockChecksums() error {
 	blockRS := &readaheadState{
 		size: initialReadaheadSize,
 	}
+	var buf []byte
 	for _, bh := range blocks {
 		// Certain blocks may not be present, in which case we skip them.
 		if bh.Length == 0 {
 			continue
 		}
+		if o.Throttle != nil {
+			if err := o.Throttle(bh.Length + blockTrailerLen); err != nil {
+				return err
+			}
+		}
 
-		// Read the block, which validates the checksum.
-		h, _, err := r.readBlock(bh, nil /* transform */, blockRS)
-		if err != nil {
-			return err
+		switch o.Mode {
+		case VerifyThroughCache:
+			// Read the block, which validates the checksum.
+			h, _, err := r.readBlock(bh, nil /* transform */, blockRS)
+			if err != nil {
+				return err
+			}
+			h.Release()
+		case VerifyFromDisk:
+			n := int(bh.Length + blockTrailerLen)
+			if cap(buf) < n {
+				buf = make([]byte, n)
+			}
+			buf = buf[:n]
+			if _, err := r.file.ReadAt(buf, int64(bh.Offset)); err != nil {
+				return err
+			}
+			if err := checkChecksum(r.checksumType, buf, bh, r.fileNum); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("pebble/table: unknown checksum verification mode: %s", o.Mode)
 		}
-		h.Release()
 	}
 
 	return nil
@@ -2736,6 +2921,113 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	return endBH.Offset + endBH.Length + blockTrailerLen - startBH.Offset, nil
 }
 
+// SampleKeys returns a key for each of the ascending fractions in [0, 1),
+// approximating the key found at that fraction of the data overlapping the
+// range `[start, end]`, as estimated by EstimateDiskUsage. The key returned for
+// a fraction is the index separator of the data block holding the byte at that
+// offset of the range's data, which is greater than or equal to every key in
+// the block, limited to end. The keys are distributed in proportion to the
+// sizes of the data blocks, which approximates the distribution of the table's
+// keys when they are of similar sizes. Assumes there is at least partial
+// overlap, as for EstimateDiskUsage.
+func (r *Reader) SampleKeys(start, end []byte, fractions []float64) ([][]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(fractions) == 0 {
+		return nil, nil
+	}
+	total, err := r.EstimateDiskUsage(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	indexH, err := r.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer indexH.Release()
+
+	keys := make([][]byte, 0, len(fractions))
+	var startOffset uint64
+	var sep []byte
+	var started bool
+	// visit assigns the separator of the data block at the index entry to the
+	// fractions falling within the block, returning true once no fraction
+	// remains to be assigned.
+	visit := func(val []byte, key *InternalKey) (bool, error) {
+		bh, err := decodeBlockHandleWithProperties(val)
+		if err != nil {
+			return true, errCorruptIndexEntry
+		}
+		if !started {
+			startOffset, started = bh.Offset, true
+		}
+		blockEnd := float64(bh.Offset + bh.Length + blockTrailerLen - startOffset)
+		pastEnd := r.Compare(key.UserKey, end) >= 0
+		if pastEnd {
+			sep = append(sep[:0], end...)
+		} else {
+			sep = append(sep[:0], key.UserKey...)
+		}
+		for len(keys) < len(fractions) && (pastEnd || fractions[len(keys)]*float64(total) < blockEnd) {
+			keys = append(keys, append([]byte(nil), sep...))
+		}
+		return pastEnd || len(keys) == len(fractions), nil
+	}
+	// visitIndex visits the entries of the index block iter from start.
+	visitIndex := func(iter *blockIter) (bool, error) {
+		for key, val := iter.SeekGE(start, false /* trySeekUsingNext */); key != nil; key, val = iter.Next() {
+			if done, err := visit(val, key); done || err != nil {
+				return true, err
+			}
+		}
+		return false, iter.Error()
+	}
+
+	iter, err := newBlockIter(r.Compare, indexH.Get())
+	if err != nil {
+		return nil, err
+	}
+	if r.Properties.IndexPartitions == 0 {
+		if _, err := visitIndex(iter); err != nil {
+			return nil, err
+		}
+	} else {
+		for key, val := iter.SeekGE(start, false /* trySeekUsingNext */); key != nil; key, val = iter.Next() {
+			bh, err := decodeBlockHandleWithProperties(val)
+			if err != nil {
+				return nil, errCorruptIndexEntry
+			}
+			idxBlock, _, err := r.readBlock(bh.BlockHandle, nil /* transform */, nil /* readaheadState */)
+			if err != nil {
+				return nil, err
+			}
+			idxIter, err := newBlockIter(r.Compare, idxBlock.Get())
+			var done bool
+			if err == nil {
+				done, err = visitIndex(idxIter)
+			}
+			idxBlock.Release()
+			if done || err != nil {
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+	}
+	// The range spans beyond this file. Assign the separator of the last data
+	// block to the remaining fractions.
+	for started && len(keys) < len(fractions) {
+		keys = append(keys, append([]byte(nil), sep...))
+	}
+	return keys, nil
+}
+
 // TableFormat returns the format version for the table.
 func (r *Reader) TableFormat() (TableFormat, error) {
 	if r.err != nil {
@@ -2839,15 +3131,16 @@ type Layout struct {
 	// ValidateBlockChecksums, which validates a static list of BlockHandles
 	// referenced in this struct.
 
-	Data       []BlockHandleWithProperties
-	Index      []BlockHandle
-	TopIndex   BlockHandle
-	Filter     BlockHandle
-	RangeDel   BlockHandle
-	RangeKey   BlockHandle
-	Properties BlockHandle
-	MetaIndex  BlockHandle
-	Footer     BlockHandle
+	Data        []BlockHandleWithProperties
+	Index       []BlockHandle
+	TopIndex    BlockHandle
+	Filter      BlockHandle
+	RangeDel    BlockHandle
+	RangeKey    BlockHandle
+	RangeFilter BlockHandle
+	Properties  BlockHandle
+	MetaIndex   BlockHandle
+	Footer      BlockHandle
 }
 
 // Describe returns a description of the layout. If the verbose parameter is
@@ -2879,6 +3172,9 @@ func (l *Layout) Describe(
 	if l.RangeKey.Length != 0 {
 		blocks = append(blocks, block{l.RangeKey, "range-key"})
 	}
+	if l.RangeFilter.Length != 0 {
+		blocks = append(blocks, block{l.RangeFilter, "range-filter"})
+	}
 	if l.Properties.Length != 0 {
 		blocks = append(blocks, block{l.Properties, "properties"})
 	}
@@ -2992,6 +3288,11 @@ func (l *Layout) Describe(
 		switch b.name {
 		case "data", "range-del", "range-key":
 			iter, _ := newBlockIter(r.Compare, h.Get())
+			if iter.columnar {
+				describeColumnarBlock(w, b.Offset, iter, r.Compare, fmtRecord)
+				formatTrailer()
+				break
+			}
 			for key, value := iter.First(); key != nil; key, value = iter.Next() {
 				ptr := unsafe.Pointer(uintptr(iter.ptr) + uintptr(iter.offset))
 				shared, ptr := decodeVarint(ptr)
@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -870,6 +871,9 @@ func TestValidateBlockChecksums(t *testing.T) {
 		err = r.ValidateBlockChecksums()
 		require.Error(t, err)
 		require.Regexp(t, `checksum mismatch`, err.Error())
+		err = r.ValidateBlockChecksumsWithOptions(ValidateOptions{Mode: VerifyFromDisk})
+		require.Error(t, err)
+		require.Regexp(t, `checksum mismatch`, err.Error())
 	}
 
 	for _, tc := range testCases {
@@ -886,6 +890,60 @@ func TestValidateBlockChecksums(t *testing.T) {
 	}
 }
 
+func TestValidateBlockChecksumsFromDisk(t *testing.T) {
+	// Use a real file, which may be corrupted while it's open.
+	filename := filepath.Join(t.TempDir(), "test.sst")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	w := NewWriter(f, WriterOptions{BlockSize: 64, TableFormat: TableFormatPebblev3})
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		require.NoError(t, w.Set(k, k))
+	}
+	require.NoError(t, w.Close())
+
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	f, err = os.OpenFile(filename, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	r, err := NewReader(f, ReaderOptions{Cache: c})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	// Validating through the cache populates the cache with every block.
+	require.NoError(t, r.ValidateBlockChecksums())
+
+	// Corrupt the first data block.
+	layout, err := r.Layout()
+	require.NoError(t, err)
+	bh := layout.Data[0].BlockHandle
+	_, err = f.WriteAt([]byte{0xff, 0xff}, int64(bh.Offset))
+	require.NoError(t, err)
+
+	// The corruption is not detected when the cached block is used, but is
+	// detected when reading from disk.
+	require.NoError(t, r.ValidateBlockChecksums())
+	var throttled uint64
+	err = r.ValidateBlockChecksumsWithOptions(ValidateOptions{
+		Mode: VerifyFromDisk,
+		Throttle: func(n uint64) error {
+			throttled += n
+			return nil
+		},
+	})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.Equal(t, bh.Length+blockTrailerLen, throttled)
+
+	// An error returned by the throttle stops validation.
+	throttleErr := errors.New("throttled")
+	err = r.ValidateBlockChecksumsWithOptions(ValidateOptions{
+		Mode:     VerifyFromDisk,
+		Throttle: func(uint64) error { return throttleErr },
+	})
+	require.True(t, errors.Is(err, throttleErr))
+}
+
 func TestReader_TableFormat(t *testing.T) {
 	test := func(t *testing.T, want TableFormat) {
 		fs := vfs.NewMem()
@@ -1128,3 +1186,174 @@ func BenchmarkLayout(b *testing.B) {
 	b.StopTimer()
 	r.Close()
 }
+
+func TestIteratorPrefetch(t *testing.T) {
+	for _, indexBlockSize := range []int{4096, math.MaxInt32} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			r := buildTestTable(t, 1e4, 100, indexBlockSize, NoCompression)
+			defer func() { require.NoError(t, r.Close()) }()
+			layout, err := r.Layout()
+			require.NoError(t, err)
+			countCached := func() (n int) {
+				for _, bhp := range layout.Data {
+					h := r.opts.Cache.Get(r.cacheID, r.fileNum, bhp.Offset)
+					if h.Get() != nil {
+						n++
+					}
+					h.Release()
+				}
+				return n
+			}
+			newIter := func(blocks int) Iterator {
+				iter, err := r.NewIterWithPrefetch(nil, nil, nil, PrefetchOptions{
+					Blocks: blocks,
+					Pool:   NewPrefetchPool(4),
+				})
+				require.NoError(t, err)
+				return iter
+			}
+
+			// Seeks alone load the same blocks with and without prefetching.
+			seek := func(iter Iterator) {
+				seekKey := make([]byte, 8)
+				for j := uint64(5000); j < 10000; j += 1000 {
+					binary.BigEndian.PutUint64(seekKey, j)
+					key, _ := iter.SeekGE(seekKey, false /* trySeekUsingNext */)
+					require.NotNil(t, key)
+				}
+				require.NoError(t, iter.Close())
+			}
+			seek(newIter(0))
+			n := countCached()
+			seek(newIter(4))
+			require.Equal(t, n, countCached())
+
+			// Scan through the fourth data block. The third block's load
+			// prefetches the four blocks that follow it.
+			iter := newIter(4)
+			dataBH := func() BlockHandle {
+				switch i := iter.(type) {
+				case *singleLevelIterator:
+					return i.dataBH
+				case *twoLevelIterator:
+					return i.dataBH
+				}
+				t.Fatalf("unknown iterator type: %T", iter)
+				return BlockHandle{}
+			}
+			for key, _ := iter.First(); dataBH() != layout.Data[3].BlockHandle; key, _ = iter.Next() {
+				require.NotNil(t, key)
+			}
+			require.NoError(t, iter.Close())
+			require.Equal(t, n+7, countCached())
+		})
+	}
+}
+
+// countingFile counts the reads of each offset of a file, delaying each read
+// so that prefetches are still in flight when the iterator reaches them.
+type countingFile struct {
+	ReadableFile
+	mu    sync.Mutex
+	reads map[int64]int
+}
+
+func (f *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	f.reads[off]++
+	f.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return f.ReadableFile.ReadAt(p, off)
+}
+
+func TestIteratorPrefetchInFlight(t *testing.T) {
+	mem := vfs.NewMem()
+	f0, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f0, WriterOptions{BlockSize: 100})
+	for i := uint64(0); i < 1000; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		require.NoError(t, w.Set(key, make([]byte, i%100)))
+	}
+	require.NoError(t, w.Close())
+
+	f1, err := mem.Open("test")
+	require.NoError(t, err)
+	f := &countingFile{ReadableFile: f1, reads: make(map[int64]int)}
+	c := cache.New(128 << 20)
+	defer c.Unref()
+	r, err := NewReader(f, ReaderOptions{Cache: c})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	layout, err := r.Layout()
+	require.NoError(t, err)
+
+	iter, err := r.NewIterWithPrefetch(nil, nil, nil, PrefetchOptions{
+		Blocks: 4,
+		Pool:   NewPrefetchPool(4),
+	})
+	require.NoError(t, err)
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+	}
+	require.NoError(t, iter.Close())
+
+	// The iterator waits for the prefetch of a block it reaches rather than
+	// reading the block again.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, bhp := range layout.Data {
+		require.Equal(t, 1, f.reads[int64(bhp.Offset)], "block at offset %d", bhp.Offset)
+	}
+}
+
+func TestReaderSampleKeys(t *testing.T) {
+	for _, indexBlockSize := range []int{4096, math.MaxInt32} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			r := buildTestTable(t, 1e4, 100, indexBlockSize, NoCompression)
+			defer func() { require.NoError(t, r.Close()) }()
+
+			encode := func(v uint64) []byte {
+				k := make([]byte, 8)
+				binary.BigEndian.PutUint64(k, v)
+				return k
+			}
+			// Separators may be shortened, so pad them when decoding.
+			decode := func(k []byte) uint64 {
+				buf := make([]byte, 8)
+				copy(buf, k)
+				return binary.BigEndian.Uint64(buf)
+			}
+			fractions := []float64{0, 0.1, 0.25, 0.5, 0.5, 0.75, 0.999}
+			testCases := []struct {
+				start, end uint64
+			}{
+				{0, 1e4},
+				{2000, 3000},
+				{9990, 2e4},
+			}
+			for _, tc := range testCases {
+				keys, err := r.SampleKeys(encode(tc.start), encode(tc.end), fractions)
+				require.NoError(t, err)
+				require.Len(t, keys, len(fractions))
+				last := tc.end
+				if last > 1e4 {
+					last = 1e4
+				}
+				for i, k := range keys {
+					v := decode(k)
+					require.LessOrEqual(t, tc.start, v)
+					require.LessOrEqual(t, v, tc.end)
+					if v == tc.end {
+						// The separator of the file's last data block follows
+						// its last key, and is limited to the end of the range.
+						continue
+					}
+					// Each data block holds a handful of keys.
+					expected := float64(tc.start) + fractions[i]*float64(last-tc.start)
+					require.InDeltaf(t, expected, float64(v), 20, "fraction %.3f", fractions[i])
+				}
+			}
+		})
+	}
+}
@@ -107,6 +107,7 @@ func rewriteBlocks(
 	restartInterval int,
 	checksumType ChecksumType,
 	compression Compression,
+	columnar, hashIndex bool,
 	input []BlockHandleWithProperties,
 	output []blockWithSpan,
 	totalWorkers, worker int,
@@ -115,6 +116,9 @@ func rewriteBlocks(
 ) error {
 	bw := blockWriter{
 		restartInterval: restartInterval,
+		hashIndex:       hashIndex,
+		columnar:        columnar,
+		split:           split,
 	}
 	buf := blockBuf{checksummer: checksummer{checksumType: checksumType}}
 	if checksumType == ChecksumTypeXXHash {
@@ -228,6 +232,8 @@ func rewriteDataBlocksToWriter(
 				w.dataBlockBuf.dataBlock.restartInterval,
 				w.blockBuf.checksummer.checksumType,
 				w.compression,
+				w.dataBlockBuf.dataBlock.columnar,
+				w.dataBlockBuf.dataBlock.hashIndex,
 				data,
 				blocks,
 				concurrency,
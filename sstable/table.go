@@ -87,6 +87,7 @@ The table file format looks like:
 [index block] (for single level index)
 [meta rangedel block] (optional)
 [meta range key block] (optional)
+[meta range filter block] (optional)
 [meta properties block]
 [metaindex block]
 [footer]
@@ -131,6 +132,12 @@ uint32 values are the block offsets of each restart point. The final uint32
 value is P itself. Thus, when seeking for a particular key, one can use binary
 search to find the largest restart point whose key is <= the key sought.
 
+Beginning with TableFormatPebblev3, data blocks are instead encoded in a
+columnar format that stores the key prefixes, key suffixes, trailers and
+values of a block in separate columns. See columnar_block.go for details. A
+data block may also have a hash index between its restart points and the
+number of restart points. See block_hash_index.go for details.
+
 An index block is a block with N key/value entries. The i'th value is the
 encoded block handle of the i'th data block. The i'th key is a separator for
 i < N-1, and a successor for i == N-1. The separator between blocks i and i+1
@@ -182,10 +189,11 @@ const (
 	levelDBFormatVersion  = 0
 	rocksDBFormatVersion2 = 2
 
-	metaRangeKeyName   = "pebble.range_key"
-	metaPropertiesName = "rocksdb.properties"
-	metaRangeDelName   = "rocksdb.range_del"
-	metaRangeDelV2Name = "rocksdb.range_del2"
+	metaRangeKeyName    = "pebble.range_key"
+	metaRangeFilterName = "pebble.range_filter"
+	metaPropertiesName  = "rocksdb.properties"
+	metaRangeDelName    = "rocksdb.range_del"
+	metaRangeDelV2Name  = "rocksdb.range_del2"
 
 	// Index Types.
 	// A space efficient index block that is optimized for binary-search-based
@@ -420,7 +428,7 @@ func supportsTwoLevelIndex(format TableFormat) bool {
 	switch format {
 	case TableFormatLevelDB:
 		return false
-	case TableFormatRocksDBv2, TableFormatPebblev1, TableFormatPebblev2:
+	case TableFormatRocksDBv2, TableFormatPebblev1, TableFormatPebblev2, TableFormatPebblev3:
 		return true
 	default:
 		panic("sstable: unspecified table format version")
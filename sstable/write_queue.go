@@ -114,7 +114,8 @@ func (w *writeQueue) runWorker() {
 	w.wg.Done()
 }
 
-//lint:ignore U1000 - Will be used in a future pr.
+// add schedules the writeTask to be performed by the writeQueue goroutine once
+// the task's block has been compressed. add blocks if the writeQueue is full.
 func (w *writeQueue) add(task *writeTask) {
 	w.tasks <- task
 }
@@ -143,6 +143,7 @@ type Writer struct {
 	cache                   *cache.Cache
 	restartInterval         int
 	checksumType            ChecksumType
+	dataBlockHashIndex      bool
 	// disableKeyOrderChecks disables the checks that keys are added to an
 	// sstable in order. It is intended for internal use only in the construction
 	// of invalid sstables for testing. See tool/make_test_sstables.go.
@@ -180,6 +181,9 @@ type Writer struct {
 	// nil, or the full keys otherwise.
 	filter          filterWriter
 	indexPartitions []indexBlockAndBlockProperties
+	// rangeFilter accumulates the range filter block, if enabled by
+	// WriterOptions.RangeFilterPrefixLength.
+	rangeFilter *rangeFilterWriter
 
 	// indexBlockAlloc is used to bulk-allocate byte slices used to store index
 	// blocks in indexPartitions. These live until the index finishes.
@@ -204,6 +208,12 @@ type Writer struct {
 	blockBuf blockBuf
 
 	coordination struct {
+		// parallelismEnabled indicates that data blocks are compressed by the
+		// compressionPool, and written to disk by the writeQueue goroutine,
+		// concurrently with the Writer client goroutine.
+		parallelismEnabled bool
+		compressionPool    *CompressionPool
+
 		// writeQueue is used to write data blocks to disk. The writeQueue is primarily
 		// used to maintain the order in which data blocks must be written to disk. For
 		// this reason, every single data block write must be done through the writeQueue.
@@ -311,8 +321,9 @@ var indexBlockBufPool = sync.Pool{
 
 const indexBlockRestartInterval = 1
 
-func newIndexBlockBuf() *indexBlockBuf {
+func newIndexBlockBuf(useMutex bool) *indexBlockBuf {
 	i := indexBlockBufPool.Get().(*indexBlockBuf)
+	i.size.useMutex = useMutex
 	i.restartInterval = indexBlockRestartInterval
 	i.block.restartInterval = indexBlockRestartInterval
 	i.size.estimate.init(emptyBlockSize)
@@ -517,6 +528,16 @@ func newDataBlockBuf(restartInterval int, checksumType ChecksumType) *dataBlockB
 	return d
 }
 
+// setFormat configures the encoding of the data block for the given table
+// format. Data blocks are columnar beginning with TableFormatPebblev3, in
+// which case split divides user keys into prefixes and suffixes. A hash index
+// is only added to the data block if the table format supports it.
+func (d *dataBlockBuf) setFormat(format TableFormat, split Split, hashIndex bool) {
+	d.dataBlock.columnar = format >= TableFormatPebblev3
+	d.dataBlock.split = split
+	d.dataBlock.hashIndex = hashIndex && format >= TableFormatPebblev3
+}
+
 func (d *dataBlockBuf) finish() {
 	d.uncompressed = d.dataBlock.finish()
 }
@@ -667,6 +688,9 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 	}
 
 	w.maybeAddToFilter(key.UserKey)
+	if w.rangeFilter != nil {
+		w.rangeFilter.addKey(key.UserKey)
+	}
 	w.dataBlockBuf.dataBlock.add(key, value)
 
 	w.meta.updateSeqNum(key.SeqNum())
@@ -1025,7 +1049,6 @@ func (w *Writer) flush(key InternalKey) error {
 	}
 
 	w.dataBlockBuf.finish()
-	w.dataBlockBuf.compressAndChecksum(w.compression)
 
 	// Determine if the index block should be flushed. Since we're accessing the
 	// dataBlockBuf.dataBlock.curKey here, we have to make sure that once we start
@@ -1050,7 +1073,7 @@ func (w *Writer) flush(key InternalKey) error {
 	var flushableIndexBlock *indexBlockBuf
 	if shouldFlushIndexBlock {
 		flushableIndexBlock = w.indexBlock
-		w.indexBlock = newIndexBlockBuf()
+		w.indexBlock = newIndexBlockBuf(w.coordination.parallelismEnabled)
 		// Call BlockPropertyCollector.FinishIndexBlock, since we've decided to
 		// flush the index block.
 		indexProps, err = w.finishIndexBlockProps()
@@ -1067,10 +1090,18 @@ func (w *Writer) flush(key InternalKey) error {
 
 	// Schedule a write.
 	writeTask := writeTaskPool.Get().(*writeTask)
-	// We're setting compressionDone to indicate that compression of this block
-	// has already been completed.
-	writeTask.compressionDone <- true
 	writeTask.buf = w.dataBlockBuf
+	if w.coordination.parallelismEnabled {
+		// The compressionPool signals compressionDone once the block has been
+		// compressed. The writeQueue goroutine waits for the signal before
+		// writing the block.
+		w.coordination.compressionPool.compress(writeTask, w.compression)
+	} else {
+		w.dataBlockBuf.compressAndChecksum(w.compression)
+		// We're setting compressionDone to indicate that compression of this
+		// block has already been completed.
+		writeTask.compressionDone <- true
+	}
 	writeTask.indexEntrySep = sep
 	writeTask.inflightSize = estimatedUncompressedSize
 	writeTask.currIndexBlock = w.indexBlock
@@ -1082,8 +1113,15 @@ func (w *Writer) flush(key InternalKey) error {
 	w.indexBlock.addInflight(writeTask.indexInflightSize)
 
 	w.dataBlockBuf = nil
-	err = w.coordination.writeQueue.addSync(writeTask)
+	if w.coordination.parallelismEnabled {
+		// Any error encountered while writing the block is returned by
+		// Writer.Close.
+		w.coordination.writeQueue.add(writeTask)
+	} else {
+		err = w.coordination.writeQueue.addSync(writeTask)
+	}
 	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf.setFormat(w.tableFormat, w.split, w.dataBlockHashIndex)
 
 	return err
 }
@@ -1225,7 +1263,7 @@ func (w *Writer) addIndexEntrySync(
 	var err error
 	if shouldFlush {
 		flushableIndexBlock = w.indexBlock
-		w.indexBlock = newIndexBlockBuf()
+		w.indexBlock = newIndexBlockBuf(w.coordination.parallelismEnabled)
 
 		// Call BlockPropertyCollector.FinishIndexBlock, since we've decided to
 		// flush the index block.
@@ -1547,6 +1585,12 @@ func (w *Writer) Close() (err error) {
 		// property, though it doesn't include the trailer in the filter size
 		// property.
 		w.props.IndexSize = uint64(w.indexBlock.estimatedSize()) + blockTrailerLen
+		if w.coordination.parallelismEnabled {
+			// With parallelism, the index block's size estimate may still
+			// include the inflight size of entries that have since been added,
+			// so the size of the index block itself is used.
+			w.props.IndexSize = uint64(w.indexBlock.block.estimatedSize()) + blockTrailerLen
+		}
 		w.props.NumDataBlocks = uint64(w.indexBlock.block.nEntries)
 
 		// Write the single level index block.
@@ -1608,6 +1652,22 @@ func (w *Writer) Close() (err error) {
 		}
 	}
 
+	// Write the range filter block, and add its block handle to the metaindex
+	// block. The range filter block name sorts after the filter block name and
+	// before all other block names.
+	if w.rangeFilter != nil {
+		if b := w.rangeFilter.finish(); b != nil {
+			bh, err := w.writeBlock(b, NoCompression, &w.blockBuf)
+			if err != nil {
+				w.err = err
+				return w.err
+			}
+			n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+			metaindex.add(InternalKey{UserKey: []byte(metaRangeFilterName)}, w.blockBuf.tmp[:n])
+			w.props.RangeFilterPrefixLength = uint64(w.rangeFilter.prefixLength)
+		}
+	}
+
 	// Add the range key block handle to the metaindex block. Note that we add the
 	// block handle to the metaindex block before the other meta blocks as the
 	// metaindex block entries must be sorted, and the range key block name sorts
@@ -1825,7 +1885,7 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		cache:                   o.Cache,
 		restartInterval:         o.BlockRestartInterval,
 		checksumType:            o.Checksum,
-		indexBlock:              newIndexBlockBuf(),
+		dataBlockHashIndex:      o.DataBlockHashIndex && equalIsBytewise(o.Comparer.Equal),
 		rangeDelBlock: blockWriter{
 			restartInterval: 1,
 		},
@@ -1842,18 +1902,31 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 	}
 
 	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf.setFormat(w.tableFormat, w.split, w.dataBlockHashIndex)
 
 	w.blockBuf = blockBuf{
 		checksummer: checksummer{checksumType: o.Checksum},
 	}
 
+	w.coordination.parallelismEnabled = o.Parallelism
+	w.coordination.compressionPool = o.CompressionPool
+	if w.coordination.compressionPool == nil {
+		w.coordination.compressionPool = defaultCompressionPool
+	}
+	w.indexBlock = newIndexBlockBuf(w.coordination.parallelismEnabled)
+
 	// We only need to use a mutex when we decide to truly write blocks in parallel.
-	w.coordination.sizeEstimate.useMutex = false
+	w.coordination.sizeEstimate.useMutex = w.coordination.parallelismEnabled
 
-	// We're creating the writeQueue with a size of 0, because we won't be using
-	// the async queue until parallelism is enabled, and we do all block writes
-	// through writeQueue.addSync.
-	w.coordination.writeQueue = newWriteQueue(0, w)
+	// Without parallelism, the writeQueue is created with a size of 0, and all
+	// block writes are performed through writeQueue.addSync. With parallelism,
+	// the size of the writeQueue bounds the number of data blocks which are
+	// being compressed or waiting to be written to disk.
+	writeQueueSize := 0
+	if w.coordination.parallelismEnabled {
+		writeQueueSize = w.coordination.compressionPool.Workers()
+	}
+	w.coordination.writeQueue = newWriteQueue(writeQueueSize, w)
 
 	if f == nil {
 		w.err = errors.New("pebble: nil file")
@@ -1886,6 +1959,10 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		}
 	}
 
+	if o.RangeFilterPrefixLength > 0 && compareIsBytewise(o.Comparer.Compare) {
+		w.rangeFilter = newRangeFilterWriter(w.split, o.RangeFilterPrefixLength)
+	}
+
 	w.props.ColumnFamilyID = math.MaxInt32
 	w.props.ComparerName = o.Comparer.Name
 	w.props.CompressionName = o.Compression.String()
@@ -207,7 +207,7 @@ func TestClearDataBlockBuf(t *testing.T) {
 }
 
 func TestClearIndexBlockBuf(t *testing.T) {
-	i := newIndexBlockBuf()
+	i := newIndexBlockBuf(false /* useMutex */)
 	i.block.add(ikey("apple"), nil)
 	i.block.add(ikey("banana"), nil)
 	i.clear()
@@ -630,6 +630,117 @@ func TestWriterRace(t *testing.T) {
 	wg.Wait()
 }
 
+// TestWriterParallelism tests that Writers with parallelism enabled, sharing a
+// CompressionPool, produce sstables identical to those produced without
+// parallelism. The partitioning of a two-level index depends on the progress
+// of the writeQueue, so sstables with two-level indexes are compared by their
+// contents.
+func TestWriterParallelism(t *testing.T) {
+	ks := testkeys.Alpha(3)
+	keys := make([][]byte, ks.Count())
+	for ki := 0; ki < len(keys); ki++ {
+		keys[ki] = testkeys.Key(ks, ki)
+	}
+
+	write := func(t *testing.T, opts WriterOptions) []byte {
+		f := &memFile{}
+		w := NewWriter(f, opts)
+		for ki := 0; ki < len(keys); ki++ {
+			require.NoError(t, w.Set(keys[ki], keys[ki]))
+			// EstimatedSize may be called while data blocks are being
+			// compressed and written.
+			require.NotZero(t, w.EstimatedSize())
+		}
+		require.NoError(t, w.Close())
+		return f.Data()
+	}
+	contents := func(t *testing.T, data []byte) []string {
+		r, err := NewMemReader(data, ReaderOptions{Comparer: testkeys.Comparer})
+		require.NoError(t, err)
+		defer r.Close()
+		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+		require.NoError(t, err)
+		defer iter.Close()
+		res := []string{fmt.Sprintf("data-size=%d", r.Properties.DataSize)}
+		for k, v := iter.First(); k != nil; k, v = iter.Next() {
+			res = append(res, fmt.Sprintf("%s:%s", k, v))
+		}
+		return res
+	}
+
+	pool := NewCompressionPool(2)
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev3} {
+		for _, compression := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
+			for _, twoLevelIndex := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s,%s,two-level=%t", format, compression, twoLevelIndex), func(t *testing.T) {
+					opts := WriterOptions{
+						BlockSize:      256,
+						Comparer:       testkeys.Comparer,
+						Compression:    compression,
+						IndexBlockSize: 1 << 20,
+						TableFormat:    format,
+						BlockPropertyCollectors: []func() BlockPropertyCollector{
+							func() BlockPropertyCollector { return &testBlockPropCollector{} },
+						},
+					}
+					if twoLevelIndex {
+						opts.IndexBlockSize = 512
+					}
+					expected := write(t, opts)
+
+					opts.Parallelism = true
+					opts.CompressionPool = pool
+					var wg sync.WaitGroup
+					for i := 0; i < 8; i++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							data := write(t, opts)
+							if twoLevelIndex {
+								require.Equal(t, contents(t, expected), contents(t, data))
+							} else {
+								require.Equal(t, expected, data)
+							}
+						}()
+					}
+					wg.Wait()
+				})
+			}
+		}
+	}
+}
+
+type failingWriteFile struct {
+	memFile
+	failAfter int
+}
+
+func (f *failingWriteFile) Write(p []byte) (int, error) {
+	if f.failAfter <= 0 {
+		return 0, errors.New("injected write error")
+	}
+	f.failAfter--
+	return f.memFile.Write(p)
+}
+
+// TestWriterParallelismError tests that an error encountered while writing a
+// data block asynchronously is returned by Writer.Close.
+func TestWriterParallelismError(t *testing.T) {
+	ks := testkeys.Alpha(2)
+	f := &failingWriteFile{failAfter: 3}
+	w := NewWriter(f, WriterOptions{
+		BlockSize:       64,
+		Comparer:        testkeys.Comparer,
+		Parallelism:     true,
+		CompressionPool: NewCompressionPool(1),
+	})
+	for ki := 0; ki < ks.Count(); ki++ {
+		k := testkeys.Key(ks, ki)
+		require.NoError(t, w.Set(k, k))
+	}
+	require.EqualError(t, w.Close(), "injected write error")
+}
+
 func BenchmarkWriter(b *testing.B) {
 	keys := make([][]byte, 1e6)
 	const keyLen = 24
@@ -649,31 +760,34 @@ func BenchmarkWriter(b *testing.B) {
 			for _, filter := range []bool{true, false} {
 				b.Run(fmt.Sprintf("filter=%t", filter), func(b *testing.B) {
 					for _, comp := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
-						b.Run(fmt.Sprintf("compression=%s", comp), func(b *testing.B) {
-							opts := WriterOptions{
-								BlockRestartInterval: 16,
-								BlockSize:            bs,
-								Compression:          comp,
-							}
-							if filter {
-								opts.FilterPolicy = bloom.FilterPolicy(10)
-							}
-							f := &discardFile{}
-							for i := 0; i < b.N; i++ {
-								f.wrote = 0
-								w := NewWriter(f, opts)
-
-								for j := range keys {
-									if err := w.Set(keys[j], keys[j]); err != nil {
+						for _, parallelism := range []bool{false, true} {
+							b.Run(fmt.Sprintf("compression=%s/parallelism=%t", comp, parallelism), func(b *testing.B) {
+								opts := WriterOptions{
+									BlockRestartInterval: 16,
+									BlockSize:            bs,
+									Compression:          comp,
+									Parallelism:          parallelism,
+								}
+								if filter {
+									opts.FilterPolicy = bloom.FilterPolicy(10)
+								}
+								f := &discardFile{}
+								for i := 0; i < b.N; i++ {
+									f.wrote = 0
+									w := NewWriter(f, opts)
+
+									for j := range keys {
+										if err := w.Set(keys[j], keys[j]); err != nil {
+											b.Fatal(err)
+										}
+									}
+									if err := w.Close(); err != nil {
 										b.Fatal(err)
 									}
+									b.SetBytes(int64(f.wrote))
 								}
-								if err := w.Close(); err != nil {
-									b.Fatal(err)
-								}
-								b.SetBytes(int64(f.wrote))
-							}
-						})
+							})
+						}
 					}
 				})
 			}
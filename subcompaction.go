@@ -0,0 +1,164 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// root returns the compaction that c is a subcompaction of, or c itself if c
+// is not a subcompaction. Cancellation and progress are tracked by the root
+// compaction.
+func (c *compaction) root() *compaction {
+	if c.parent != nil {
+		return c.parent
+	}
+	return c
+}
+
+// reportBytesIterated adds delta bytes consumed by c, which may be a
+// subcompaction, to the bytes iterated by its root compaction and to the
+// counters shared with the DB.
+func (c *compaction) reportBytesIterated(d *DB, delta uint64) {
+	if delta == 0 {
+		return
+	}
+	atomic.AddUint64(&c.root().atomic.bytesIterated, delta)
+	atomic.AddUint64(c.atomicBytesIterated, delta)
+	if c.kind != compactionKindFlush {
+		atomic.AddUint64(&d.atomic.compactionBytesIterated, delta)
+	}
+}
+
+// subcompactionSplitKeys returns the user keys at which the compaction should
+// be partitioned into at most n subcompactions, or nil if the compaction
+// should not be partitioned.
+//
+// The split keys are chosen among the smallest keys of the grandparent
+// tables, or of the output level tables if the compaction has no
+// grandparents, such that each subcompaction overlaps a similar number of
+// bytes of those tables. Flushes and compactions into L0 are never
+// partitioned.
+func (c *compaction) subcompactionSplitKeys(n int) [][]byte {
+	if n <= 1 || len(c.flushing) != 0 || c.outputLevel.level == 0 {
+		return nil
+	}
+	files := c.grandparents
+	if files.Empty() {
+		files = c.outputLevel.files
+	}
+	total := files.SizeSum()
+	if total == 0 {
+		return nil
+	}
+	target := total / uint64(n)
+
+	var splitKeys [][]byte
+	var size uint64
+	iter := files.Iter()
+	for f := iter.First(); f != nil && len(splitKeys) < n-1; f = iter.Next() {
+		// Split before f once the next multiple of the target size falls
+		// before the midpoint of f. A split key must fall strictly within the
+		// bounds of the compaction, and must be larger than the previous split
+		// key.
+		k := f.Smallest.UserKey
+		if size+f.Size/2 >= target*uint64(len(splitKeys)+1) &&
+			c.cmp(k, c.smallest.UserKey) > 0 && c.cmp(k, c.largest.UserKey) < 0 &&
+			(len(splitKeys) == 0 || c.cmp(k, splitKeys[len(splitKeys)-1]) > 0) {
+			splitKeys = append(splitKeys, k)
+		}
+		size += f.Size
+	}
+	return splitKeys
+}
+
+// newSubcompactions partitions the compaction at the provided split keys,
+// returning one subcompaction per key range. Each subcompaction shares the
+// inputs and configuration of c, but runs with its own input iterator and
+// compaction state.
+func (c *compaction) newSubcompactions(splitKeys [][]byte) []*compaction {
+	subs := make([]*compaction, 0, len(splitKeys)+1)
+	var lower []byte
+	for i := 0; i <= len(splitKeys); i++ {
+		var upper []byte
+		if i < len(splitKeys) {
+			upper = splitKeys[i]
+		}
+		sub := &compaction{}
+		*sub = *c
+		sub.atomic.bytesIterated = 0
+		sub.atomic.cancelled = 0
+		sub.parent = c
+		sub.bounds.lower, sub.bounds.upper = lower, upper
+		sub.bytesIterated = 0
+		sub.bytesWritten = 0
+		sub.rangeDelFrag = keyspan.Fragmenter{}
+		sub.rangeDelIter = keyspan.InternalIteratorShim{}
+		sub.closers = nil
+		sub.elideTombstoneIndex = 0
+		sub.metrics = nil
+		subs = append(subs, sub)
+		lower = upper
+	}
+	return subs
+}
+
+// truncateToBounds truncates the span to the bounds of a subcompaction. The
+// range tombstones of a span which straddles a split key are written to the
+// outputs of both subcompactions.
+func (c *compaction) truncateToBounds(s keyspan.Span) keyspan.Span {
+	if c.bounds.lower != nil && c.cmp(s.Start, c.bounds.lower) < 0 {
+		s.Start = c.bounds.lower
+	}
+	if c.bounds.upper != nil && c.cmp(s.End, c.bounds.upper) > 0 {
+		s.End = c.bounds.upper
+	}
+	return s
+}
+
+// subcompactionIter wraps the input iterator of a subcompaction, limiting
+// iteration to the user keys within [lower, upper). First seeks to lower, so
+// that a range deletion covering lower is returned even if it begins before
+// lower. The key of such a range deletion is truncated to lower, and its span
+// is truncated by truncateToBounds.
+//
+// Only First and Next are used by compactionIter.
+type subcompactionIter struct {
+	internalIterator
+	cmp          Compare
+	lower, upper []byte
+	key          InternalKey
+}
+
+// First implements internalIterator.First.
+func (i *subcompactionIter) First() (*InternalKey, []byte) {
+	if i.lower == nil {
+		return i.checkUpper(i.internalIterator.First())
+	}
+	key, val := i.internalIterator.SeekGE(i.lower, false /* trySeekUsingNext */)
+	if key != nil && i.cmp(key.UserKey, i.lower) < 0 {
+		i.key = *key
+		i.key.UserKey = i.lower
+		key = &i.key
+	}
+	return i.checkUpper(key, val)
+}
+
+// Next implements internalIterator.Next.
+func (i *subcompactionIter) Next() (*InternalKey, []byte) {
+	return i.checkUpper(i.internalIterator.Next())
+}
+
+func (i *subcompactionIter) checkUpper(key *InternalKey, val []byte) (*InternalKey, []byte) {
+	if key != nil && i.upper != nil && i.cmp(key.UserKey, i.upper) >= 0 {
+		return nil, nil
+	}
+	return key, val
+}
+
+var _ base.InternalIterator = (*subcompactionIter)(nil)
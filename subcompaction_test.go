@@ -0,0 +1,128 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubcompactions(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%04d", i)) }
+
+	// run populates L6 with four tables, and then compacts an L0 table
+	// overwriting keys across all of them, including a range deletion which
+	// straddles two table boundaries. It returns the number of tables in L6
+	// after the compaction, and the contents of the DB. If adaptive is set,
+	// compactions are paced by the adaptive compaction controller.
+	run := func(t *testing.T, maxSubcompactions int, adaptive bool) (int, string) {
+		opts := &Options{
+			FS:                          vfs.NewMem(),
+			DisableAutomaticCompactions: true,
+		}
+		opts.Experimental.MaxSubcompactions = maxSubcompactions
+		opts.Experimental.AdaptiveCompactions = adaptive
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		for r := 0; r < 4; r++ {
+			for i := r * 250; i < (r+1)*250; i++ {
+				require.NoError(t, d.Set(key(i), []byte("a"), nil))
+			}
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact(key(r*250), key((r+1)*250-1), false))
+		}
+		d.mu.Lock()
+		n := d.mu.versions.currentVersion().Levels[numLevels-1].Len()
+		d.mu.Unlock()
+		require.Equal(t, 4, n)
+
+		require.NoError(t, d.DeleteRange(key(200), key(600), nil))
+		for i := 0; i < 1000; i += 2 {
+			require.NoError(t, d.Set(key(i), []byte("b"), nil))
+		}
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Compact(key(0), key(999), false))
+
+		d.mu.Lock()
+		v := d.mu.versions.currentVersion()
+		l0, l6 := v.Levels[0].Len(), v.Levels[numLevels-1].Len()
+		d.mu.Unlock()
+		require.Equal(t, 0, l0)
+
+		var buf strings.Builder
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s=%s\n", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return l6, buf.String()
+	}
+
+	l6, expected := run(t, 1, false)
+	require.Equal(t, 1, l6)
+	require.Equal(t, 800, strings.Count(expected, "\n"))
+
+	// The compaction into L6 is partitioned before each of the last three
+	// L6 tables, producing one output table per subcompaction. Paced
+	// compactions are partitioned too.
+	for _, adaptive := range []bool{false, true} {
+		l6, contents := run(t, 4, adaptive)
+		require.Equal(t, 4, l6)
+		require.Equal(t, expected, contents)
+	}
+}
+
+func TestSubcompactionSplitKeys(t *testing.T) {
+	opts := (*Options)(nil).EnsureDefaults()
+	newFile := func(fileNum FileNum, smallest, largest string, size uint64) *fileMetadata {
+		return (&fileMetadata{FileNum: fileNum, Size: size}).ExtendPointKeyBounds(opts.Comparer.Compare,
+			base.MakeInternalKey([]byte(smallest), 1, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(largest), 1, InternalKeyKindSet))
+	}
+	var levels [numLevels][]*fileMetadata
+	levels[5] = []*fileMetadata{newFile(1, "a", "z", 100)}
+	levels[6] = []*fileMetadata{
+		newFile(2, "a", "c", 10),
+		newFile(3, "d", "f", 10),
+		newFile(4, "g", "i", 10),
+		newFile(5, "j", "l", 10),
+	}
+	v := newVersion(opts, levels)
+
+	pc := newPickedCompaction(opts, v, 5, 6, 5)
+	pc.startLevel.files = v.Levels[5].Slice()
+	pc.outputLevel.files = v.Levels[6].Slice()
+	pc.smallest, pc.largest = manifest.KeyRange(opts.Comparer.Compare,
+		pc.startLevel.files.Iter(), pc.outputLevel.files.Iter())
+	c := newCompaction(pc, opts, new(uint64))
+
+	testCases := []struct {
+		n        int
+		expected []string
+	}{
+		{1, nil},
+		{2, []string{"g"}},
+		{4, []string{"d", "g", "j"}},
+		// There are only enough output tables for four subcompactions.
+		{8, []string{"d", "g", "j"}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.n), func(t *testing.T) {
+			var splitKeys []string
+			for _, k := range c.subcompactionSplitKeys(tc.n) {
+				splitKeys = append(splitKeys, string(k))
+			}
+			require.Equal(t, tc.expected, splitKeys)
+		})
+	}
+}
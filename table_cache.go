@@ -26,6 +26,12 @@ import (
 )
 
 var emptyIter = &errorIter{err: nil}
+
+// rangeFilteredIter is returned in place of a table's point iterator when the
+// table's range filter determined that the table contains no point keys within
+// the iterator bounds. Unlike emptyIter, whether a table is filtered depends on
+// the bounds, so a levelIter must reopen the table if its bounds change.
+var rangeFilteredIter = &errorIter{err: nil}
 var emptyKeyspanIter = &errorKeyspanIter{err: nil}
 
 var tableCacheLabels = pprof.Labels("pebble", "table-cache")
@@ -52,6 +58,7 @@ type tableCacheOpts struct {
 	fs            vfs.FS
 	opts          sstable.ReaderOptions
 	filterMetrics *FilterMetrics
+	prefetchPool  *sstable.PrefetchPool
 }
 
 // tableCacheContainer contains the table cache and
@@ -89,6 +96,7 @@ func newTableCacheContainer(
 	t.dbOpts.fs = fs
 	t.dbOpts.opts = opts.MakeReaderOptions()
 	t.dbOpts.filterMetrics = &FilterMetrics{}
+	t.dbOpts.prefetchPool = sstable.NewPrefetchPool(opts.Experimental.MaxConcurrentPrefetches)
 	t.dbOpts.atomic.iterCount = new(int32)
 	return t
 }
@@ -362,13 +370,38 @@ func (c *tableCacheShard) newIters(
 		// using a singleton is fine.
 		return emptyIter, nil, err
 	}
+	if opts != nil && bytesIterated == nil {
+		var mayContain bool
+		mayContain, err = v.reader.MayContainRange(opts.LowerBound, opts.UpperBound)
+		if err != nil {
+			c.unrefValue(v)
+			return nil, nil, err
+		}
+		if !mayContain {
+			// The table's range deletions must still be surfaced, as they may
+			// delete keys within the bounds in lower levels.
+			rangeDelIter, err := v.reader.NewRawRangeDelIter()
+			c.unrefValue(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			if rangeDelIter != nil {
+				return rangeFilteredIter, rangeDelIter, nil
+			}
+			return rangeFilteredIter, nil, nil
+		}
+	}
 
 	var iter sstable.Iterator
 	if bytesIterated != nil {
 		iter, err = v.reader.NewCompactionIter(bytesIterated)
 	} else {
-		iter, err = v.reader.NewIterWithBlockPropertyFilters(
-			opts.GetLowerBound(), opts.GetUpperBound(), filterer)
+		var prefetch sstable.PrefetchOptions
+		if opts != nil && opts.PrefetchBlocks > 0 {
+			prefetch = sstable.PrefetchOptions{Blocks: opts.PrefetchBlocks, Pool: dbOpts.prefetchPool}
+		}
+		iter, err = v.reader.NewIterWithPrefetch(
+			opts.GetLowerBound(), opts.GetUpperBound(), filterer, prefetch)
 	}
 	if err != nil {
 		c.unrefValue(v)
@@ -119,10 +119,15 @@ func (d *DB) collectTableStats() {
 		})
 	}
 
+	// Loaded stats may make a table eligible for a compaction, either by
+	// inflating its compensated size or by revealing it to be dense with
+	// tombstones.
 	maybeCompact := false
+	tombstoneDensity := newTombstoneDensityAnnotator(d.opts)
 	for _, c := range collected {
 		c.fileMetadata.Stats = c.TableStats
-		maybeCompact = maybeCompact || c.fileMetadata.Stats.RangeDeletionsBytesEstimate > 0
+		maybeCompact = maybeCompact || c.fileMetadata.Stats.RangeDeletionsBytesEstimate > 0 ||
+			tombstoneDensity.dense(c.fileMetadata)
 	}
 	d.mu.tableStats.cond.Broadcast()
 	d.maybeCollectTableStatsLocked()
@@ -252,6 +257,7 @@ func (d *DB) loadTableStats(
 	err := d.tableCache.withReader(meta, func(r *sstable.Reader) (err error) {
 		stats.NumEntries = r.Properties.NumEntries
 		stats.NumDeletions = r.Properties.NumDeletions
+		setTimeWindowStats(&stats, &r.Properties)
 		if r.Properties.NumPointDeletions() > 0 {
 			// TODO(jackson): If the file has a wide keyspace, the average
 			// value size beneath the entire file might not be representative
@@ -513,6 +519,7 @@ func maybeSetStatsFromProperties(meta *fileMetadata, props *sstable.Properties)
 		PointDeletionsBytesEstimate: pointEstimate,
 		RangeDeletionsBytesEstimate: 0,
 	}
+	setTimeWindowStats(&meta.Stats, props)
 	return true
 }
 
@@ -0,0 +1,528 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// ErrTxnConflict is returned by Txn.Commit when a key read by the transaction
+// was written after the transaction began.
+var ErrTxnConflict = errors.New("pebble: transaction conflict")
+
+// errTxnRevalidate is returned by the conflict check within the commit
+// pipeline when sstables which may hold conflicting writes were added since
+// the transaction's previous check. Reading them would delay every write
+// sequenced after the transaction, so the check is repeated outside of the
+// commit pipeline instead.
+var errTxnRevalidate = errors.New("pebble: transaction must be revalidated")
+
+// Txn is an optimistic transaction. A Txn reads from a consistent snapshot of
+// the DB taken when the transaction began, overlaid with its own writes, and
+// buffers its writes in an indexed batch. The keys and key spans it reads are
+// recorded, and Commit applies the writes atomically only if none of them
+// were written after the transaction began. Otherwise Commit returns
+// ErrTxnConflict, and the transaction may be retried.
+//
+// Conflicts are detected against every write to the DB, whether or not it was
+// made through a transaction. Only reads conflict: a transaction which writes
+// a key without reading it does not conflict with other writes of the key.
+//
+// A transaction may additionally lock keys and key spans with Lock and
+// LockRange, to serialize read-modify-write cycles on contended keys rather
+// than retrying them on conflict. Locks are held until the transaction's
+// writes are published, or the transaction is closed.
+//
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	db    *DB
+	batch *Batch
+	snap  *Snapshot
+	reads []txnRead
+	opts  TxnOptions
+	locks lockOwner
+}
+
+// TxnOptions configures a transaction begun through DB.NewTxnWithOptions.
+type TxnOptions struct {
+	// LockTimeout is the maximum duration for which Txn.Lock and Txn.LockRange
+	// wait to acquire a lock held by another transaction before returning
+	// ErrLockTimeout. The default value of 0 waits indefinitely, or until the
+	// DB is closed.
+	LockTimeout time.Duration
+}
+
+var _ Reader = (*Txn)(nil)
+
+// txnRead is a key or key span read by a transaction. For a point read, start
+// holds the key read. Otherwise the read spans [start, end), where a nil
+// start or end leaves the span unbounded.
+type txnRead struct {
+	start, end []byte
+	point      bool
+}
+
+// NewTxn begins an optimistic transaction. The transaction must be committed
+// with Txn.Commit or abandoned with Txn.Close.
+func (d *DB) NewTxn() *Txn {
+	return d.NewTxnWithOptions(TxnOptions{})
+}
+
+// NewTxnWithOptions begins an optimistic transaction configured by the given
+// options. The transaction must be committed with Txn.Commit or abandoned with
+// Txn.Close.
+func (d *DB) NewTxnWithOptions(opts TxnOptions) *Txn {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	return &Txn{
+		db:    d,
+		batch: d.NewIndexedBatch(),
+		snap:  d.NewSnapshot(),
+		opts:  opts,
+	}
+}
+
+// Lock locks the given key in the given mode, waiting for any conflicting
+// locks held by other transactions to be released. Waiting requests are
+// granted in the order in which they were made, so a request also waits for
+// earlier conflicting requests. It returns ErrLockTimeout if the lock is not
+// acquired within TxnOptions.LockTimeout, ErrDeadlock if waiting for the lock
+// would deadlock, and ErrClosed if the DB is closed while waiting. In each case
+// the transaction continues to hold its other locks, and should usually be
+// closed.
+//
+// If the transaction has not yet read anything, acquiring a lock advances the
+// transaction's snapshot to the current state of the DB. A transaction which
+// locks the keys it reads before reading them therefore observes the writes
+// of every transaction which previously held the locks, and does not conflict
+// with them on commit.
+//
+// It is safe to modify the contents of the arguments after Lock returns.
+func (t *Txn) Lock(key []byte, mode LockMode) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.lock(key, nil, mode)
+}
+
+// LockRange locks the key span [start, end) in the given mode. See Lock.
+//
+// It is safe to modify the contents of the arguments after LockRange returns.
+func (t *Txn) LockRange(start, end []byte, mode LockMode) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.lock(start, end, mode)
+}
+
+func (t *Txn) lock(start, end []byte, mode LockMode) error {
+	if err := t.db.locks.acquire(&t.locks, start, end, mode, t.opts.LockTimeout); err != nil {
+		return err
+	}
+	if len(t.reads) == 0 {
+		if err := t.snap.Close(); err != nil {
+			return err
+		}
+		t.snap = t.db.NewSnapshot()
+	}
+	return nil
+}
+
+// Get gets the value for the given key, as of the beginning of the
+// transaction and including the transaction's own writes. It returns
+// ErrNotFound if the key does not exist. The key is recorded as read.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (t *Txn) Get(key []byte) ([]byte, io.Closer, error) {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	t.reads = append(t.reads, txnRead{start: append([]byte(nil), key...), point: true})
+	return t.db.getInternal(key, t.batch, t.snap)
+}
+
+// NewIter returns an iterator over the DB as of the beginning of the
+// transaction, including the transaction's own writes. The iterator is
+// unpositioned (Iterator.Valid() will return false). The span between the
+// iterator's bounds, or the entire keyspace if it is unbounded, is recorded
+// as read.
+//
+// Reads outside of the bounds the iterator is created with, after a call to
+// Iterator.SetBounds or Iterator.SetOptions, are not recorded.
+func (t *Txn) NewIter(o *IterOptions) *Iterator {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	r := txnRead{}
+	if lower := o.GetLowerBound(); lower != nil {
+		r.start = append([]byte(nil), lower...)
+	}
+	if upper := o.GetUpperBound(); upper != nil {
+		r.end = append([]byte(nil), upper...)
+	}
+	t.reads = append(t.reads, r)
+	return t.db.newIterInternal(t.batch, t.snap, o)
+}
+
+// Apply the operations contained in the batch to the transaction.
+//
+// It is safe to modify the contents of the arguments after Apply returns.
+func (t *Txn) Apply(batch *Batch, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.Apply(batch, opts)
+}
+
+// Set sets the value for the given key within the transaction.
+//
+// It is safe to modify the contents of the arguments after Set returns.
+func (t *Txn) Set(key, value []byte, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.Set(key, value, opts)
+}
+
+// Merge merges the value for the given key within the transaction.
+//
+// It is safe to modify the contents of the arguments after Merge returns.
+func (t *Txn) Merge(key, value []byte, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.Merge(key, value, opts)
+}
+
+// Delete deletes the value for the given key within the transaction.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (t *Txn) Delete(key []byte, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.Delete(key, opts)
+}
+
+// SingleDelete adds an action to the transaction that single deletes the
+// entry for key. See Writer.SingleDelete for more details on the semantics of
+// SingleDelete.
+//
+// It is safe to modify the contents of the arguments after SingleDelete
+// returns.
+func (t *Txn) SingleDelete(key []byte, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.SingleDelete(key, opts)
+}
+
+// DeleteRange deletes all of the keys (and values) in the range [start,end)
+// within the transaction.
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns.
+func (t *Txn) DeleteRange(start, end []byte, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.DeleteRange(start, end, opts)
+}
+
+// LogData adds the specified to the transaction. The data will be written to
+// the WAL when the transaction commits, but not added to memtables or
+// sstables.
+//
+// It is safe to modify the contents of the arguments after LogData returns.
+func (t *Txn) LogData(data []byte, opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	return t.batch.LogData(data, opts)
+}
+
+// Commit applies the transaction's writes to the DB atomically, unless a key
+// or key span read by the transaction was written after the transaction
+// began, in which case the writes are discarded and ErrTxnConflict is
+// returned. A transaction without writes commits trivially, since its reads
+// were served from a consistent snapshot.
+//
+// Conflicts are checked twice: once without blocking other writes, and once
+// more within the commit pipeline, where the check observes every write
+// sequenced before the transaction's. The second check only considers the
+// writes made since the first, and delays the writes sequenced after the
+// transaction, so it only reads the memtables. If a flush or ingestion added
+// sstables overlapping the transaction's reads in the meantime, both checks
+// are repeated. The cost of a check is proportional to the amount of data
+// within the transaction's reads in the memtables, and in the sstables
+// holding writes newer than the start of the check: a transaction with wide
+// range reads is expensive to commit.
+//
+// The transaction's locks are released once its writes are visible, or when
+// the transaction closes if it does not commit any writes. Commit closes the
+// transaction, whether or not it succeeds.
+func (t *Txn) Commit(opts *WriteOptions) error {
+	if t.db == nil {
+		panic(ErrClosed)
+	}
+	defer t.Close()
+	if t.batch.Empty() {
+		return nil
+	}
+
+	d := t.db
+	if len(t.locks.held) > 0 {
+		t.batch.onPublish = func() { d.locks.release(&t.locks) }
+	}
+	for since := t.snap.seqNum; ; {
+		checked := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+		conflict, err := d.writtenSince(t.reads, since, true /* readTables */)
+		if err != nil {
+			return err
+		}
+		if conflict {
+			return ErrTxnConflict
+		}
+		if len(t.reads) > 0 {
+			t.batch.validate = func() error {
+				conflict, err := d.writtenSince(t.reads, checked, false /* readTables */)
+				if err == nil && conflict {
+					err = ErrTxnConflict
+				}
+				return err
+			}
+		}
+		if err := d.Apply(t.batch, opts); err != errTxnRevalidate {
+			return err
+		}
+		since = checked
+	}
+}
+
+// Close abandons the transaction if it has not been committed, discarding its
+// writes, and releases its locks and resources. It is valid to call Close
+// after Commit.
+func (t *Txn) Close() error {
+	if t.db == nil {
+		return nil
+	}
+	t.db.locks.release(&t.locks)
+	t.db = nil
+	t.reads = nil
+	err := t.snap.Close()
+	if err2 := t.batch.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// writtenSince returns true if any of the keys or key spans read overlaps a
+// write with a sequence number of at least seqNum. Only the memtables, and the
+// sstables overlapping the reads which hold writes with sequence numbers of at
+// least seqNum, are read. If readTables is false, no sstables are read, and
+// errTxnRevalidate is returned if any sstable may hold a conflicting write.
+func (d *DB) writtenSince(reads []txnRead, seqNum uint64, readTables bool) (bool, error) {
+	if len(reads) == 0 {
+		return false, nil
+	}
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	memtables := readState.memtables
+	for i := range memtables {
+		// The memtable holds the writes with sequence numbers in
+		// [memtables[i].logSeqNum, memtables[i+1].logSeqNum).
+		if i+1 < len(memtables) && memtables[i+1].logSeqNum <= seqNum {
+			continue
+		}
+		mem := memtables[i]
+		conflict, err := txnReadsConflict(d.cmp, reads, seqNum, mem.newIter(nil),
+			mem.newRangeDelIter(nil), mem.newRangeKeyIter(nil))
+		if conflict || err != nil {
+			return conflict, err
+		}
+	}
+
+	// Range keys are held in the range key arena, rather than sstables.
+	if d.opts.Experimental.RangeKeys != nil {
+		d.maybeInitializeRangeKeys()
+		if frags := d.rangeKeys.fragCache.get(); len(frags) > 0 {
+			conflict, err := txnReadsConflict(d.cmp, reads, seqNum, nil, keyspan.NewIter(d.cmp, frags))
+			if conflict || err != nil {
+				return conflict, err
+			}
+		}
+	}
+
+	files := txnOverlappingTables(d.cmp, readState.current, reads, seqNum)
+	if len(files) > 0 && !readTables {
+		return false, errTxnRevalidate
+	}
+	for _, f := range files {
+		pointIter, rangeDelIter, err := d.newIters(f, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		conflict, err := txnReadsConflict(d.cmp, reads, seqNum, pointIter, rangeDelIter)
+		if conflict || err != nil {
+			return conflict, err
+		}
+	}
+	return false, nil
+}
+
+// txnOverlappingTables returns the tables of v which overlap any of the keys
+// or key spans read, and hold writes with sequence numbers of at least seqNum.
+// The tables of each level below L0 are found by seeking to each read.
+func txnOverlappingTables(cmp Compare, v *version, reads []txnRead, seqNum uint64) []*fileMetadata {
+	var files []*fileMetadata
+	for level := range v.Levels {
+		n := len(files)
+		iter := v.Levels[level].Iter()
+		if level == 0 {
+			// L0 tables are not ordered by key, and may overlap one another.
+			for f := iter.First(); f != nil; f = iter.Next() {
+				if f.LargestSeqNum >= seqNum && txnReadsOverlap(cmp, reads, f.Smallest.UserKey, f.Largest.UserKey) {
+					files = append(files, f)
+				}
+			}
+			continue
+		}
+		for _, r := range reads {
+			var f *fileMetadata
+			if r.start == nil {
+				f = iter.First()
+			} else {
+				f = iter.SeekGE(cmp, r.start)
+			}
+			for ; f != nil; f = iter.Next() {
+				if r.point && cmp(f.Smallest.UserKey, r.start) > 0 {
+					break
+				} else if !r.point && r.end != nil && cmp(f.Smallest.UserKey, r.end) >= 0 {
+					break
+				}
+				if f.LargestSeqNum < seqNum {
+					continue
+				}
+				// Several reads may overlap the same table.
+				dup := false
+				for _, g := range files[n:] {
+					if g == f {
+						dup = true
+						break
+					}
+				}
+				if !dup {
+					files = append(files, f)
+				}
+			}
+		}
+	}
+	return files
+}
+
+// txnReadsConflict returns true if any of the keys or key spans read overlaps
+// a point key surfaced by iter or a span surfaced by spanIters with a
+// sequence number of at least seqNum. It closes the iterators, any of which
+// may be nil.
+func txnReadsConflict(
+	cmp Compare,
+	reads []txnRead,
+	seqNum uint64,
+	iter internalIterator,
+	spanIters ...keyspan.FragmentIterator,
+) (conflict bool, err error) {
+	defer func() {
+		if iter != nil {
+			err = firstError(err, iter.Close())
+		}
+		for _, spanIter := range spanIters {
+			if spanIter != nil {
+				err = firstError(err, spanIter.Close())
+			}
+		}
+		if err != nil {
+			conflict = false
+		}
+	}()
+
+	if iter != nil {
+		for _, r := range reads {
+			var k *InternalKey
+			if r.start == nil {
+				k, _ = iter.First()
+			} else {
+				k, _ = iter.SeekGE(r.start, false /* trySeekUsingNext */)
+			}
+			for ; k != nil; k, _ = iter.Next() {
+				if r.point && cmp(k.UserKey, r.start) != 0 {
+					break
+				} else if !r.point && r.end != nil && cmp(k.UserKey, r.end) >= 0 {
+					break
+				}
+				if k.SeqNum() >= seqNum {
+					return true, nil
+				}
+			}
+			if err := iter.Error(); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	for _, spanIter := range spanIters {
+		if spanIter == nil {
+			continue
+		}
+		for s := spanIter.First(); s.Valid(); s = spanIter.Next() {
+			if s.Empty() || s.LargestSeqNum() < seqNum {
+				continue
+			}
+			for _, r := range reads {
+				if r.overlapsSpan(cmp, s.Start, s.End) {
+					return true, nil
+				}
+			}
+		}
+		if err := spanIter.Error(); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// txnReadsOverlap returns true if any of the keys or key spans read overlaps
+// the inclusive range [smallest, largest].
+func txnReadsOverlap(cmp Compare, reads []txnRead, smallest, largest []byte) bool {
+	for _, r := range reads {
+		if r.point {
+			if cmp(smallest, r.start) <= 0 && cmp(r.start, largest) <= 0 {
+				return true
+			}
+		} else if (r.start == nil || cmp(r.start, largest) <= 0) &&
+			(r.end == nil || cmp(smallest, r.end) < 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapsSpan returns true if the read overlaps the span [start, end).
+func (r txnRead) overlapsSpan(cmp Compare, start, end []byte) bool {
+	if r.point {
+		return cmp(start, r.start) <= 0 && cmp(r.start, end) < 0
+	}
+	return (r.start == nil || cmp(r.start, end) < 0) &&
+		(r.end == nil || cmp(start, r.end) < 0)
+}
@@ -0,0 +1,485 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxn(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(r Reader, key string) string {
+		v, closer, err := r.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	txn := d.NewTxn()
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, txn.Set([]byte("b"), []byte("1"), nil))
+
+	// The transaction reads from its snapshot, overlaid with its own writes.
+	require.Equal(t, "1", get(txn, "a"))
+	require.Equal(t, "1", get(txn, "b"))
+	require.Equal(t, "<not found>", get(d, "b"))
+
+	iter := txn.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a=1", "b=1"}, keys)
+
+	// The transaction read "a", which was written since it began.
+	require.True(t, errors.Is(txn.Commit(nil), ErrTxnConflict))
+	require.Equal(t, "<not found>", get(d, "b"))
+	require.NoError(t, txn.Close())
+
+	// A transaction without conflicts commits its writes.
+	txn = d.NewTxn()
+	require.Equal(t, "2", get(txn, "a"))
+	require.NoError(t, txn.Set([]byte("a"), []byte("3"), nil))
+	require.NoError(t, txn.Commit(nil))
+	require.Equal(t, "3", get(d, "a"))
+
+	// A read-only transaction always commits.
+	txn = d.NewTxn()
+	require.Equal(t, "3", get(txn, "a"))
+	require.NoError(t, d.Set([]byte("a"), []byte("4"), nil))
+	require.NoError(t, txn.Commit(nil))
+}
+
+func TestTxnConflicts(t *testing.T) {
+	testCases := []struct {
+		name string
+		// read performs the transaction's reads.
+		read func(t *testing.T, txn *Txn)
+		// write performs a write to the DB after the transaction began.
+		write    func(d *DB) error
+		conflict bool
+	}{
+		{
+			name: "point-write-same-key",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				return d.Set([]byte("c"), nil, nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "point-write-other-key",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				return d.Set([]byte("d"), nil, nil)
+			},
+			conflict: false,
+		},
+		{
+			name: "point-delete",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				return d.Delete([]byte("c"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "point-range-delete",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				return d.DeleteRange([]byte("a"), []byte("e"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "point-range-delete-excludes-key",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				return d.DeleteRange([]byte("a"), []byte("c"), nil)
+			},
+			conflict: false,
+		},
+		{
+			name: "span-write-within",
+			read: txnScan("b", "e"),
+			write: func(d *DB) error {
+				return d.Set([]byte("d"), nil, nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "span-write-at-upper-bound",
+			read: txnScan("b", "e"),
+			write: func(d *DB) error {
+				return d.Set([]byte("e"), nil, nil)
+			},
+			conflict: false,
+		},
+		{
+			name: "span-range-delete-overlapping",
+			read: txnScan("b", "e"),
+			write: func(d *DB) error {
+				return d.DeleteRange([]byte("d1"), []byte("z"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "unbounded-span",
+			read: txnScan("", ""),
+			write: func(d *DB) error {
+				return d.Set([]byte("z"), nil, nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "point-write-flushed",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				if err := d.Set([]byte("c"), nil, nil); err != nil {
+					return err
+				}
+				return d.Flush()
+			},
+			conflict: true,
+		},
+		{
+			name: "point-write-compacted",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				if err := d.Set([]byte("c"), nil, nil); err != nil {
+					return err
+				}
+				return d.Compact([]byte("a"), []byte("z"), false)
+			},
+			conflict: true,
+		},
+		{
+			name: "span-write-flushed-other-key",
+			read: txnScan("b", "e"),
+			write: func(d *DB) error {
+				if err := d.Set([]byte("f"), nil, nil); err != nil {
+					return err
+				}
+				return d.Flush()
+			},
+			conflict: false,
+		},
+		{
+			name: "point-ingest",
+			read: txnGet("c"),
+			write: func(d *DB) error {
+				f, err := d.opts.FS.Create("ext")
+				if err != nil {
+					return err
+				}
+				w := sstable.NewWriter(f, sstable.WriterOptions{})
+				if err := w.Set([]byte("c"), nil); err != nil {
+					return err
+				}
+				if err := w.Close(); err != nil {
+					return err
+				}
+				return d.Ingest([]string{"ext"})
+			},
+			conflict: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Open("", &Options{FS: vfs.NewMem()})
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			// Write the keys read by the transactions to sstables, so that
+			// the tables' sequence numbers precede the transaction.
+			for _, k := range []string{"a", "c", "d"} {
+				require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+			}
+			require.NoError(t, d.Flush())
+
+			txn := d.NewTxn()
+			tc.read(t, txn)
+			require.NoError(t, txn.Set([]byte("x"), nil, nil))
+			require.NoError(t, tc.write(d))
+
+			err = txn.Commit(nil)
+			if tc.conflict {
+				require.True(t, errors.Is(err, ErrTxnConflict), "%v", err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTxnConcurrentTransactions(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Two transactions read and write the same key. The first to commit wins.
+	txn1, txn2 := d.NewTxn(), d.NewTxn()
+	for _, txn := range []*Txn{txn1, txn2} {
+		txnGet("k")(t, txn)
+		require.NoError(t, txn.Set([]byte("k"), nil, nil))
+	}
+	require.NoError(t, txn1.Commit(nil))
+	require.True(t, errors.Is(txn2.Commit(nil), ErrTxnConflict))
+}
+
+func TestTxnConcurrentPlainWrites(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Transactions append "t" to a key by reading and rewriting it, while
+	// plain merges, which concatenate by default, append "m". A merge which
+	// lands between a transaction's read and its commit must cause the
+	// transaction to conflict, rather than be overwritten.
+	const txns, merges = 1000, 1000
+	var committed int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < txns; i++ {
+			txn := d.NewTxn()
+			v, closer, err := txn.Get([]byte("k"))
+			if errors.Is(err, ErrNotFound) {
+				v, err = nil, nil
+			} else if err == nil {
+				v = append([]byte(nil), v...)
+				require.NoError(t, closer.Close())
+			}
+			require.NoError(t, err)
+			require.NoError(t, txn.Set([]byte("k"), append(v, 't'), nil))
+			if err := txn.Commit(nil); err == nil {
+				atomic.AddInt64(&committed, 1)
+			} else {
+				require.True(t, errors.Is(err, ErrTxnConflict), "%v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < merges; i++ {
+			require.NoError(t, d.Merge([]byte("k"), []byte("m"), nil))
+		}
+	}()
+	wg.Wait()
+
+	v, closer, err := d.Get([]byte("k"))
+	require.NoError(t, err)
+	defer closer.Close()
+	require.Equal(t, int(atomic.LoadInt64(&committed)), bytes.Count(v, []byte("t")))
+	require.Equal(t, merges, bytes.Count(v, []byte("m")))
+}
+
+func TestTxnCommitValidation(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	txn := d.NewTxn()
+	txnGet("k")(t, txn)
+	require.NoError(t, txn.Set([]byte("x"), nil, nil))
+
+	// A batch is validated once the batches sequenced before it are visible.
+	// A batch rejected by its validation is not committed.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("y"), nil, nil))
+	b.validate = func() error {
+		logSeqNum := atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum)
+		require.Equal(t, logSeqNum, atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum))
+		return errors.New("rejected")
+	}
+	logSeqNum := atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum)
+	require.EqualError(t, d.Apply(b, nil), "rejected")
+	// The rejected batch was not sequenced, and the pipeline remains usable.
+	require.Equal(t, logSeqNum, atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum))
+
+	// A write made after the transaction's first conflict check is observed
+	// by the check within the commit pipeline, which only considers the
+	// writes made since the first.
+	conflict, err := d.writtenSince(txn.reads, txn.snap.seqNum, true /* readTables */)
+	require.NoError(t, err)
+	require.False(t, conflict)
+	checked := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	require.NoError(t, d.Set([]byte("k"), nil, nil))
+	conflict, err = d.writtenSince(txn.reads, checked, false /* readTables */)
+	require.NoError(t, err)
+	require.True(t, conflict)
+	require.NoError(t, txn.Close())
+}
+
+func TestTxnWrittenSinceTables(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write tables to L6 and L0 holding writes older than the check.
+	for _, k := range []string{"a", "m", "x"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Compact([]byte(k), []byte(k+"\x00"), false /* parallelize */))
+	}
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Flush())
+	// As in a transaction, the snapshot keeps compactions from zeroing the
+	// sequence numbers of later writes.
+	snap := d.NewSnapshot()
+	defer snap.Close()
+	seqNum := snap.seqNum
+	reads := []txnRead{
+		{start: []byte("m"), point: true},
+		{start: []byte("w"), end: []byte("z")},
+	}
+	tables := func() []*fileMetadata {
+		readState := d.loadReadState()
+		defer readState.unref()
+		return txnOverlappingTables(d.cmp, readState.current, reads, seqNum)
+	}
+	require.Empty(t, tables())
+	conflict, err := d.writtenSince(reads, seqNum, false /* readTables */)
+	require.NoError(t, err)
+	require.False(t, conflict)
+
+	// A newer table which does not overlap the reads is not considered.
+	require.NoError(t, d.Set([]byte("c"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Empty(t, tables())
+
+	// A newer table which overlaps a read, but holds no write of a key read,
+	// must be read, which requires revalidation outside of the commit
+	// pipeline.
+	require.NoError(t, d.Set([]byte("l"), nil, nil))
+	require.NoError(t, d.Set([]byte("n"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 1, len(tables()))
+	_, err = d.writtenSince(reads, seqNum, false /* readTables */)
+	require.Equal(t, errTxnRevalidate, err)
+	conflict, err = d.writtenSince(reads, seqNum, true /* readTables */)
+	require.NoError(t, err)
+	require.False(t, conflict)
+
+	// Once compacted into L6, only the tables overlapping the reads are
+	// found, by seeking to each read.
+	require.NoError(t, d.Set([]byte("y"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	for _, f := range tables() {
+		require.True(t, txnReadsOverlap(d.cmp, reads, f.Smallest.UserKey, f.Largest.UserKey))
+	}
+	conflict, err = d.writtenSince(reads, seqNum, true /* readTables */)
+	require.NoError(t, err)
+	require.True(t, conflict)
+}
+
+func TestTxnLocking(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Concurrent read-modify-write cycles serialized by an exclusive lock
+	// never conflict.
+	const goroutines, increments = 4, 50
+	var wg sync.WaitGroup
+	errCh := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				txn := d.NewTxn()
+				if err := txn.Lock([]byte("counter"), LockExclusive); err != nil {
+					errCh <- err
+					return
+				}
+				var n int
+				v, closer, err := txn.Get([]byte("counter"))
+				if err == nil {
+					n, err = strconv.Atoi(string(v))
+					closer.Close()
+				} else if errors.Is(err, ErrNotFound) {
+					err = nil
+				}
+				if err == nil {
+					err = txn.Set([]byte("counter"), []byte(strconv.Itoa(n+1)), nil)
+				}
+				if err == nil {
+					err = txn.Commit(nil)
+				}
+				if err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+	v, closer, err := d.Get([]byte("counter"))
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(goroutines*increments), string(v))
+	require.NoError(t, closer.Close())
+
+	// A lock held by an open transaction times out other transactions, and is
+	// released when the transaction is closed.
+	txn1 := d.NewTxn()
+	require.NoError(t, txn1.LockRange([]byte("a"), []byte("c"), LockExclusive))
+	txn2 := d.NewTxnWithOptions(TxnOptions{LockTimeout: time.Millisecond})
+	require.True(t, errors.Is(txn2.Lock([]byte("b"), LockShared), ErrLockTimeout))
+	require.NoError(t, txn1.Close())
+	require.NoError(t, txn2.Lock([]byte("b"), LockShared))
+	require.NoError(t, txn2.Close())
+}
+
+func txnGet(key string) func(t *testing.T, txn *Txn) {
+	return func(t *testing.T, txn *Txn) {
+		_, closer, err := txn.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return
+		}
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+}
+
+func txnScan(lower, upper string) func(t *testing.T, txn *Txn) {
+	return func(t *testing.T, txn *Txn) {
+		o := &IterOptions{}
+		if lower != "" {
+			o.LowerBound = []byte(lower)
+		}
+		if upper != "" {
+			o.UpperBound = []byte(upper)
+		}
+		iter := txn.NewIter(o)
+		for valid := iter.First(); valid; valid = iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// seqNumSample records the visible sequence number at a point in time.
+type seqNumSample struct {
+	time   time.Time
+	seqNum uint64
+}
+
+// seqNumTimeline maps points in time to visible sequence numbers for
+// Options.Experimental.VersionRetention. It holds samples of the visible
+// sequence number in ascending order of time, back to the latest sample taken
+// before the retention window.
+type seqNumTimeline struct {
+	samples []seqNumSample
+}
+
+// add records a sample, and drops the samples which are no longer needed to
+// map the times within the retention window ending at the sample.
+func (tl *seqNumTimeline) add(now time.Time, seqNum uint64, retention time.Duration) {
+	tl.samples = append(tl.samples, seqNumSample{time: now, seqNum: seqNum})
+	cutoff := now.Add(-retention)
+	i := sort.Search(len(tl.samples), func(i int) bool {
+		return tl.samples[i].time.After(cutoff)
+	})
+	if i > 1 {
+		n := copy(tl.samples, tl.samples[i-1:])
+		tl.samples = tl.samples[:n]
+	}
+}
+
+// at returns the sequence number of the latest sample taken at or before t.
+// It returns false if every sample was taken after t.
+func (tl *seqNumTimeline) at(t time.Time) (uint64, bool) {
+	i := sort.Search(len(tl.samples), func(i int) bool {
+		return tl.samples[i].time.After(t)
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return tl.samples[i-1].seqNum, true
+}
+
+// SeqNumAt returns the visible sequence number at which to read the state of
+// the DB as of the given time through IterOptions.ReadAtSeqNum. The visible
+// sequence number is sampled periodically, so the state read may precede t by
+// up to the sampling interval of a hundredth of
+// Options.Experimental.VersionRetention, and at most a second.
+//
+// SeqNumAt returns an error if version retention is disabled, or if t lies
+// outside of the retention window, or before the DB was opened.
+func (d *DB) SeqNumAt(t time.Time) (uint64, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	retention := d.opts.Experimental.VersionRetention
+	if retention <= 0 {
+		return 0, errors.New("pebble: version retention is disabled")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.Before(d.timeNow().Add(-retention)) {
+		return 0, errors.Errorf("pebble: %s precedes the version retention window", t)
+	}
+	seqNum, ok := d.mu.seqNumTimeline.at(t)
+	if !ok {
+		return 0, errors.Errorf("pebble: %s precedes the opening of the DB", t)
+	}
+	return seqNum, nil
+}
+
+// versionRetentionHorizonLocked returns the oldest sequence number whose
+// versions must be retained for Options.Experimental.VersionRetention, or
+// zero if version retention is disabled. Requires DB.mu is held.
+func (d *DB) versionRetentionHorizonLocked() uint64 {
+	retention := d.opts.Experimental.VersionRetention
+	if retention <= 0 {
+		return 0
+	}
+	seqNum, ok := d.mu.seqNumTimeline.at(d.timeNow().Add(-retention))
+	if !ok || seqNum == 0 {
+		// The DB was opened within the retention window, so the sequence
+		// numbers of versions written within it are unknown. Retain them all.
+		return 1
+	}
+	return seqNum
+}
+
+// sampleSeqNumLocked records the current visible sequence number in the
+// timeline. Requires DB.mu is held.
+func (d *DB) sampleSeqNumLocked() {
+	d.mu.seqNumTimeline.add(d.timeNow(),
+		atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum),
+		d.opts.Experimental.VersionRetention)
+}
+
+// seqNumSampleLoop periodically samples the visible sequence number while
+// Options.Experimental.VersionRetention is enabled.
+func (d *DB) seqNumSampleLoop() {
+	interval := d.opts.Experimental.VersionRetention / 100
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		d.sampleSeqNumLocked()
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeqNumTimeline(t *testing.T) {
+	base := time.Unix(1000, 0)
+	var tl seqNumTimeline
+	_, ok := tl.at(base)
+	require.False(t, ok)
+
+	for i := 0; i < 10; i++ {
+		tl.add(base.Add(time.Duration(i)*time.Second), uint64(10*i+1), 3*time.Second)
+	}
+	// The samples are retained back to the latest sample at or before the
+	// start of the retention window of [6s, 9s].
+	require.Len(t, tl.samples, 4)
+	for _, tc := range []struct {
+		at     time.Duration
+		seqNum uint64
+		ok     bool
+	}{
+		{5 * time.Second, 0, false},
+		{6 * time.Second, 61, true},
+		{6500 * time.Millisecond, 61, true},
+		{9 * time.Second, 91, true},
+		{time.Hour, 91, true},
+	} {
+		seqNum, ok := tl.at(base.Add(tc.at))
+		require.Equal(t, tc.ok, ok, "%s", tc.at)
+		require.Equal(t, tc.seqNum, seqNum, "%s", tc.at)
+	}
+}
+
+func TestVersionRetention(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.VersionRetention = time.Hour
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	base := time.Unix(1000, 0)
+	var nowNanos int64 = base.UnixNano()
+	advance := func(dur time.Duration) time.Time {
+		now := time.Unix(0, atomic.AddInt64(&nowNanos, int64(dur)))
+		d.mu.Lock()
+		d.sampleSeqNumLocked()
+		d.mu.Unlock()
+		return now
+	}
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return time.Unix(0, atomic.LoadInt64(&nowNanos)) }
+	d.mu.seqNumTimeline = seqNumTimeline{}
+	d.mu.Unlock()
+
+	readAt := func(seqNum uint64, key string) string {
+		iter := d.NewIter(&IterOptions{ReadAtSeqNum: seqNum})
+		defer func() { require.NoError(t, iter.Close()) }()
+		if !iter.SeekGE([]byte(key)) || string(iter.Key()) != key {
+			return "<not found>"
+		}
+		return string(iter.Value())
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	t0 := advance(time.Minute)
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("1"), nil))
+	t1 := advance(time.Minute)
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Set([]byte("a"), []byte("3"), nil))
+	advance(time.Minute)
+
+	// Compactions through to the bottommost level retain the versions within
+	// the retention window.
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	seqNum0, err := d.SeqNumAt(t0)
+	require.NoError(t, err)
+	seqNum1, err := d.SeqNumAt(t1)
+	require.NoError(t, err)
+	require.Equal(t, "1", readAt(seqNum0, "a"))
+	require.Equal(t, "<not found>", readAt(seqNum0, "b"))
+	require.Equal(t, "2", readAt(seqNum1, "a"))
+	require.Equal(t, "1", readAt(seqNum1, "b"))
+	require.Equal(t, "3", readAt(0, "a"))
+	require.Equal(t, "<not found>", readAt(0, "b"))
+
+	_, err = d.SeqNumAt(base.Add(-time.Second))
+	require.Error(t, err)
+
+	// Once the versions age out of the retention window, compactions drop
+	// them.
+	advance(2 * time.Hour)
+	_, err = d.SeqNumAt(t0)
+	require.Error(t, err)
+	require.NoError(t, d.CompactWithOptions(context.Background(), []byte("a"), []byte("z"),
+		CompactOptions{RewriteBottommost: true}))
+	require.NotEqual(t, "1", readAt(seqNum0, "a"))
+	require.Equal(t, "3", readAt(0, "a"))
+
+	require.Panics(t, func() { d.NewIter(&IterOptions{ReadAtSeqNum: 1 << 40}) })
+}
@@ -555,6 +555,14 @@ func (vs *versionSet) incrementCompactions(kind compactionKind) {
 	case compactionKindRewrite:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.RewriteCount++
+
+	case compactionKindTombstoneDensity:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.TombstoneDensityCount++
+
+	case compactionKindPeriodic:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.PeriodicCount++
 	}
 }
 
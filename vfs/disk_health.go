@@ -28,6 +28,7 @@ type diskHealthCheckingFile struct {
 	onSlowDisk        func(time.Duration)
 	diskSlowThreshold time.Duration
 	tickInterval      time.Duration
+	stats             *diskWriteStats
 
 	stopper        chan struct{}
 	lastWriteNanos int64
@@ -36,13 +37,17 @@ type diskHealthCheckingFile struct {
 // newDiskHealthCheckingFile instantiates a new diskHealthCheckingFile, with the
 // specified time threshold and event listener.
 func newDiskHealthCheckingFile(
-	file File, diskSlowThreshold time.Duration, onSlowDisk func(time.Duration),
+	file File,
+	diskSlowThreshold time.Duration,
+	onSlowDisk func(time.Duration),
+	stats *diskWriteStats,
 ) *diskHealthCheckingFile {
 	return &diskHealthCheckingFile{
 		File:              file,
 		onSlowDisk:        onSlowDisk,
 		diskSlowThreshold: diskSlowThreshold,
 		tickInterval:      defaultTickInterval,
+		stats:             stats,
 
 		stopper: make(chan struct{}),
 	}
@@ -102,32 +107,102 @@ func (d *diskHealthCheckingFile) Close() error {
 
 // Sync implements the io.Syncer interface.
 func (d *diskHealthCheckingFile) Sync() (err error) {
-	d.timeDiskOp(func() {
+	d.timeSyncOp(func() {
 		err = d.File.Sync()
 	})
 	return err
 }
 
 // timeDiskOp runs the specified closure and makes its timing visible to the
-// monitoring goroutine, in case it exceeds one of the slow disk durations.
+// monitoring goroutine, in case it exceeds one of the slow disk durations. Its
+// latency is recorded as that of a write.
 func (d *diskHealthCheckingFile) timeDiskOp(op func()) {
+	d.timeOp(op, false /* sync */)
+}
+
+// timeSyncOp is like timeDiskOp, but records the latency of the closure as
+// that of a sync.
+func (d *diskHealthCheckingFile) timeSyncOp(op func()) {
+	d.timeOp(op, true /* sync */)
+}
+
+func (d *diskHealthCheckingFile) timeOp(op func(), sync bool) {
 	if d == nil {
 		op()
 		return
 	}
 
-	atomic.StoreInt64(&d.lastWriteNanos, time.Now().UnixNano())
+	start := time.Now()
+	atomic.StoreInt64(&d.lastWriteNanos, start.UnixNano())
 	defer func() {
 		atomic.StoreInt64(&d.lastWriteNanos, 0)
+		if d.stats != nil {
+			d.stats.record(time.Since(start), sync)
+		}
 	}()
 	op()
 }
 
+// DiskWriteStats are cumulative statistics of the write and sync operations
+// performed on the files created through an FS returned by
+// WithDiskHealthChecks. The average latency of the operations performed
+// within an interval may be computed from the difference of two DiskWriteStats.
+//
+// Writes and syncs are accounted separately. The latency of a sync is
+// dominated by the data it flushes, and the frequent syncs of a write-ahead
+// log may stall independently of the bulk writes of other files.
+type DiskWriteStats struct {
+	// Count is the number of write operations.
+	Count uint64
+	// TotalLatency is the sum of the latencies of the write operations.
+	TotalLatency time.Duration
+	// SyncCount is the number of sync operations.
+	SyncCount uint64
+	// SyncTotalLatency is the sum of the latencies of the sync operations.
+	SyncTotalLatency time.Duration
+}
+
+// diskWriteStats accumulates DiskWriteStats. It is shared by all of the files
+// created by a diskHealthCheckingFS, and its fields are accessed atomically.
+type diskWriteStats struct {
+	count          uint64
+	totalNanos     int64
+	syncCount      uint64
+	syncTotalNanos int64
+}
+
+func (s *diskWriteStats) record(latency time.Duration, sync bool) {
+	if sync {
+		atomic.AddUint64(&s.syncCount, 1)
+		atomic.AddInt64(&s.syncTotalNanos, int64(latency))
+		return
+	}
+	atomic.AddUint64(&s.count, 1)
+	atomic.AddInt64(&s.totalNanos, int64(latency))
+}
+
+// GetDiskWriteStats returns the cumulative write statistics of fs, if fs was
+// returned by WithDiskHealthChecks with a non-zero diskSlowThreshold.
+// Otherwise, it returns false.
+func GetDiskWriteStats(fs FS) (DiskWriteStats, bool) {
+	d, ok := fs.(diskHealthCheckingFS)
+	if !ok || d.diskSlowThreshold == 0 {
+		return DiskWriteStats{}, false
+	}
+	return DiskWriteStats{
+		Count:            atomic.LoadUint64(&d.stats.count),
+		TotalLatency:     time.Duration(atomic.LoadInt64(&d.stats.totalNanos)),
+		SyncCount:        atomic.LoadUint64(&d.stats.syncCount),
+		SyncTotalLatency: time.Duration(atomic.LoadInt64(&d.stats.syncTotalNanos)),
+	}, true
+}
+
 type diskHealthCheckingFS struct {
 	FS
 
 	diskSlowThreshold time.Duration
 	onSlowDisk        func(string, time.Duration)
+	stats             *diskWriteStats
 }
 
 // WithDiskHealthChecks wraps an FS and ensures that all
@@ -141,6 +216,7 @@ func WithDiskHealthChecks(
 		FS:                fs,
 		diskSlowThreshold: diskSlowThreshold,
 		onSlowDisk:        onSlowDisk,
+		stats:             &diskWriteStats{},
 	}
 }
 
@@ -155,7 +231,7 @@ func (d diskHealthCheckingFS) Create(name string) (File, error) {
 	}
 	checkingFile := newDiskHealthCheckingFile(f, d.diskSlowThreshold, func(duration time.Duration) {
 		d.onSlowDisk(name, duration)
-	})
+	}, d.stats)
 	checkingFile.startTicker()
 	return WithFd(f, checkingFile), nil
 }
@@ -171,7 +247,7 @@ func (d diskHealthCheckingFS) ReuseForWrite(oldname, newname string) (File, erro
 	}
 	checkingFile := newDiskHealthCheckingFile(f, d.diskSlowThreshold, func(duration time.Duration) {
 		d.onSlowDisk(newname, duration)
-	})
+	}, d.stats)
 	checkingFile.startTicker()
 	return WithFd(f, checkingFile), nil
 }
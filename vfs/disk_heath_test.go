@@ -134,3 +134,28 @@ func TestDiskHealthChecking(t *testing.T) {
 		t.Fatal("disk stall detector did not detect slow disk operation")
 	}
 }
+
+func TestDiskWriteStats(t *testing.T) {
+	_, ok := GetDiskWriteStats(&mockFS{})
+	if ok {
+		t.Fatal("expected no stats for an FS without disk health checks")
+	}
+
+	fs := WithDiskHealthChecks(&mockFS{syncDuration: time.Millisecond}, time.Second,
+		func(string, time.Duration) {})
+	f, _ := fs.Create("test")
+	defer f.Close()
+	f.Write([]byte("foo"))
+	f.Sync()
+
+	stats, ok := GetDiskWriteStats(fs)
+	if !ok {
+		t.Fatal("expected stats for an FS with disk health checks")
+	}
+	if stats.Count != 1 || stats.SyncCount != 1 {
+		t.Fatalf("expected 1 write and 1 sync, found %d and %d", stats.Count, stats.SyncCount)
+	}
+	if stats.SyncTotalLatency < time.Millisecond {
+		t.Fatalf("expected a sync latency of at least 1ms, found %s", stats.SyncTotalLatency)
+	}
+}
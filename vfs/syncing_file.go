@@ -6,6 +6,7 @@ package vfs
 
 import (
 	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/errors"
 )
@@ -14,6 +15,10 @@ import (
 type SyncingFileOptions struct {
 	BytesPerSync    int
 	PreallocateSize int
+	// OnSync, if set, is called with the latency of every sync of the file:
+	// the full syncs, and the periodic syncs of BytesPerSync, which use
+	// sync_file_range where it is supported.
+	OnSync func(latency time.Duration)
 }
 
 type syncingFile struct {
@@ -36,6 +41,7 @@ type syncingFile struct {
 	syncData           func() error
 	syncTo             func(offset int64) error
 	timeDiskOp         func(op func())
+	timeSyncOp         func(op func())
 }
 
 // NewSyncingFile wraps a writable file and ensures that data is synced
@@ -61,13 +67,16 @@ func NewSyncingFile(f File, opts SyncingFileOptions) File {
 	}
 	type dhChecker interface {
 		timeDiskOp(op func())
+		timeSyncOp(op func())
 	}
 	if d, ok := f.(dhChecker); ok {
 		s.timeDiskOp = d.timeDiskOp
+		s.timeSyncOp = d.timeSyncOp
 	} else {
 		s.timeDiskOp = func(op func()) {
 			op()
 		}
+		s.timeSyncOp = s.timeDiskOp
 	}
 
 	s.init()
@@ -75,9 +84,31 @@ func NewSyncingFile(f File, opts SyncingFileOptions) File {
 	if s.syncData == nil {
 		s.syncData = s.File.Sync
 	}
+	if opts.OnSync != nil {
+		// Every sync ends in either syncData, or syncToRange if
+		// sync_file_range is used.
+		syncData := s.syncData
+		s.syncData = func() error {
+			return observeSync(opts.OnSync, syncData)
+		}
+		if s.useSyncRange {
+			syncTo := s.syncTo
+			s.syncTo = func(offset int64) error {
+				return observeSync(opts.OnSync, func() error { return syncTo(offset) })
+			}
+		}
+	}
 	return WithFd(f, s)
 }
 
+// observeSync runs sync and calls onSync with its latency.
+func observeSync(onSync func(time.Duration), sync func() error) error {
+	start := time.Now()
+	err := sync()
+	onSync(time.Since(start))
+	return err
+}
+
 // NB: syncingFile.Write is unsafe for concurrent use!
 func (f *syncingFile) Write(p []byte) (n int, err error) {
 	_ = f.preallocate(atomic.LoadInt64(&f.atomic.offset))
@@ -43,7 +43,7 @@ func (f *syncingFile) init() {
 	if f.fd == 0 {
 		return
 	}
-	f.timeDiskOp(func() {
+	f.timeSyncOp(func() {
 		f.useSyncRange = isSyncRangeSupported(f.fd)
 	})
 	if f.useSyncRange {
@@ -59,7 +59,7 @@ func (f *syncingFile) syncFdatasync() error {
 		return f.File.Sync()
 	}
 	var err error
-	f.timeDiskOp(func() {
+	f.timeSyncOp(func() {
 		err = syscall.Fdatasync(int(f.fd))
 	})
 	return err
@@ -90,7 +90,7 @@ func (f *syncingFile) syncToRange(offset int64) error {
 	// accumulate. Linux sometimes behaves poorly when a large amount of dirty
 	// data accumulates, impacting other I/O operations.
 	var err error
-	f.timeDiskOp(func() {
+	f.timeSyncOp(func() {
 		err = syscall.SyncFileRange(int(f.fd), 0, offset, write|waitBefore)
 	})
 	return err
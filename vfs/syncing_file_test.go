@@ -11,6 +11,7 @@ import (
 	"os"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -242,3 +243,28 @@ func BenchmarkSyncWrite(b *testing.B) {
 		}
 	})
 }
+
+func TestSyncingFileOnSync(t *testing.T) {
+	f, err := NewMem().Create("test")
+	require.NoError(t, err)
+
+	var syncs int
+	s := NewSyncingFile(f, SyncingFileOptions{
+		BytesPerSync: 8 << 10, /* 8 KB */
+		OnSync:       func(time.Duration) { syncs++ },
+	})
+	// The periodic syncs begin once 1 MB has been written. Without a file
+	// descriptor, they sync the entire file.
+	_, err = s.Write(make([]byte, 2<<20))
+	require.NoError(t, err)
+	require.Equal(t, 1, syncs)
+	_, err = s.Write(make([]byte, 1<<20+16<<10))
+	require.NoError(t, err)
+	require.Equal(t, 2, syncs)
+	// Close syncs the data written since the last sync.
+	_, err = s.Write(make([]byte, 4<<10))
+	require.NoError(t, err)
+	require.Equal(t, 2, syncs)
+	require.NoError(t, s.Close())
+	require.Equal(t, 3, syncs)
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/redact"
+)
+
+// WriteHealth describes how close the DB is to stalling writes. Unlike the
+// WriteStallBegin and WriteStallEnd events, which report stalls once they
+// have happened, WriteHealth is a continuous signal which allows an upper
+// layer to apply admission control, slowing down writers before writes stall.
+// See DB.WriteHealth.
+//
+// Each of the ratios below is 0 when there is no pressure from its source,
+// and reaches 1 when that source stalls writes, or, for the compaction debt,
+// when the debt saturates compaction concurrency. A ratio may exceed 1.
+type WriteHealth struct {
+	// L0Sublevels is the number of L0 sublevels, and L0Ratio is
+	// L0Sublevels / Options.L0StopWritesThreshold. Writes stall when L0Ratio
+	// reaches 1.
+	L0Sublevels int
+	L0Ratio     float64
+	// MemTableBytes is the number of bytes in use by the mutable memtable plus
+	// the number of bytes allocated by the queued immutable memtables and
+	// large batches. MemTableRatio is MemTableBytes relative to
+	// Options.MemTableStopWritesThreshold * Options.MemTableSize. Writes
+	// stall when MemTableRatio reaches 1 and the mutable memtable is full.
+	MemTableBytes uint64
+	MemTableRatio float64
+	// MemTableCount is the number of memtables and large batches queued for
+	// flushing, including the mutable memtable.
+	MemTableCount int
+	// CompactionDebt is the estimated number of bytes that need to be
+	// compacted for the LSM to reach a stable state, and CompactionDebtRatio
+	// is CompactionDebt relative to the debt at which every concurrent
+	// compaction slot is in use (Options.Experimental.CompactionDebtConcurrency
+	// * Options.MaxConcurrentCompactions). The compaction debt does not stall
+	// writes itself, but compaction debt which grows faster than compactions
+	// can retire it precedes an L0 stall.
+	CompactionDebt      uint64
+	CompactionDebtRatio float64
+	// Score is the highest of L0Ratio, MemTableRatio and CompactionDebtRatio,
+	// capped at 1. Upper layers may use it to decide how aggressively to
+	// throttle writes.
+	Score float64
+	// Stalled is true if writes are currently stalled.
+	Stalled bool
+}
+
+// String implements fmt.Stringer.
+func (h WriteHealth) String() string {
+	return redact.StringWithoutMarkers(h)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (h WriteHealth) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("score %.2f", redact.Safe(h.Score))
+	if h.Stalled {
+		w.Printf(" (stalled)")
+	}
+	w.Printf(": L0 %d sublevels (%.2f), memtables %d %s (%.2f), debt %s (%.2f)",
+		redact.Safe(h.L0Sublevels), redact.Safe(h.L0Ratio),
+		redact.Safe(h.MemTableCount), humanize.IEC.Uint64(h.MemTableBytes),
+		redact.Safe(h.MemTableRatio),
+		humanize.IEC.Uint64(h.CompactionDebt), redact.Safe(h.CompactionDebtRatio))
+}
+
+var _ fmt.Stringer = WriteHealth{}
+
+// WriteHealth returns the current write health of the DB. It is cheaper than
+// Metrics, and is intended to be polled frequently.
+func (d *DB) WriteHealth() WriteHealth {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var h WriteHealth
+	h.L0Sublevels = d.mu.versions.currentVersion().L0Sublevels.ReadAmplification()
+	h.L0Ratio = float64(h.L0Sublevels) / float64(d.opts.L0StopWritesThreshold)
+
+	// The memtable stall condition in makeRoomForWrite compares the total
+	// bytes allocated by the queue against the threshold when the mutable
+	// memtable is full. Counting only the bytes in use by the mutable
+	// memtable lets the ratio rise continuously as it fills.
+	queue := d.mu.mem.queue
+	h.MemTableCount = len(queue)
+	for i := range queue {
+		if i == len(queue)-1 {
+			h.MemTableBytes += queue[i].inuseBytes()
+		} else {
+			h.MemTableBytes += queue[i].totalBytes()
+		}
+	}
+	memTableStopBytes := uint64(d.opts.MemTableStopWritesThreshold) * uint64(d.opts.MemTableSize)
+	h.MemTableRatio = float64(h.MemTableBytes) / float64(memTableStopBytes)
+
+	h.CompactionDebt = d.mu.versions.picker.estimatedCompactionDebt(0)
+	debtSaturation := uint64(d.opts.Experimental.CompactionDebtConcurrency) *
+		uint64(d.opts.MaxConcurrentCompactions)
+	h.CompactionDebtRatio = float64(h.CompactionDebt) / float64(debtSaturation)
+
+	h.Stalled = d.mu.mem.stalledWriters > 0
+	h.Score = h.L0Ratio
+	if h.MemTableRatio > h.Score {
+		h.Score = h.MemTableRatio
+	}
+	if h.CompactionDebtRatio > h.Score {
+		h.Score = h.CompactionDebtRatio
+	}
+	if h.Score > 1 || h.Stalled {
+		h.Score = 1
+	}
+	return h
+}
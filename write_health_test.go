@@ -0,0 +1,86 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHealth(t *testing.T) {
+	stallBegin := make(chan struct{}, 1)
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		L0StopWritesThreshold:       4,
+		MemTableSize:                1 << 20,
+		EventListener: EventListener{
+			WriteStallBegin: func(WriteStallBeginInfo) {
+				stallBegin <- struct{}{}
+			},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	h := d.WriteHealth()
+	require.Equal(t, 0, h.L0Sublevels)
+	require.False(t, h.Stalled)
+	require.True(t, h.Score < 0.1, "%s", h)
+
+	// Each flush of overlapping keys adds an L0 sublevel, raising the score.
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, d.Set([]byte("a"), make([]byte, 100), nil))
+		require.NoError(t, d.Set([]byte("b"), make([]byte, 100), nil))
+		require.NoError(t, d.Flush())
+		h = d.WriteHealth()
+		require.Equal(t, i, h.L0Sublevels)
+		require.Equal(t, float64(i)/4, h.L0Ratio)
+		require.Equal(t, h.L0Ratio, h.Score)
+		require.False(t, h.Stalled)
+	}
+
+	// Filling the mutable memtable raises the memtable ratio.
+	require.NoError(t, d.Set([]byte("z"), make([]byte, 100<<10), nil))
+	h = d.WriteHealth()
+	require.Equal(t, 1, h.MemTableCount)
+	require.True(t, h.MemTableRatio > 0.04, "%s", h)
+
+	// A fourth sublevel stalls the next memtable rotation.
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 4, d.WriteHealth().L0Sublevels)
+	require.Equal(t, 1.0, d.WriteHealth().Score)
+	flushed := make(chan error)
+	go func() {
+		if err := d.Set([]byte("z"), nil, nil); err != nil {
+			flushed <- err
+			return
+		}
+		flushed <- d.Flush()
+	}()
+	select {
+	case <-stallBegin:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the write stall")
+	}
+	h = d.WriteHealth()
+	require.True(t, h.Stalled)
+	require.Equal(t, 1.0, h.Score)
+
+	// Compacting L0 clears the stall. The memtable holding z does not overlap
+	// the compaction, so the compaction does not wait for it to be flushed.
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	require.NoError(t, <-flushed)
+	h = d.WriteHealth()
+	require.False(t, h.Stalled)
+	require.Equal(t, 1, h.L0Sublevels)
+	require.Equal(t, 0.25, h.L0Ratio)
+	require.True(t, h.Score < 1, "%s", h)
+}